@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/agent"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "commands run on targets by the snapshotter",
+}
+
+var agentUploadCmd = &cobra.Command{
+	Use:   "upload",
+	Short: "archive a data dir and upload it to S3, reading the upload job as JSON from stdin",
+	Run: func(cmd *cobra.Command, args []string) {
+		// The snapshotter sends a SIGTERM when the upload is cancelled, the multipart upload is aborted then
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var job agent.Job
+		if err := json.NewDecoder(os.Stdin).Decode(&job); err != nil {
+			log.WithError(err).Fatal("failed to read upload job")
+		}
+		if _, err := agent.Run(ctx, &job, os.Stdout); err != nil {
+			log.WithError(err).Fatal("upload failed")
+		}
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(agentUploadCmd)
+	rootCmd.AddCommand(agentCmd)
+}
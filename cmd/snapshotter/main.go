@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ethpandaops/eth-snapshotter/internal/config"
 	"github.com/ethpandaops/eth-snapshotter/internal/server"
@@ -19,25 +23,58 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			log.WithError(err).Fatal("failed reading config")
 		}
+
+		// Cancel everything on SIGINT/SIGTERM so in-flight snapshots can be wound down cleanly
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
 		ss, err := snapshotter.Init(cfg)
 		if err != nil {
 			log.WithError(err).Fatal("failed to start")
 		}
+		defer func() {
+			if err := ss.Close(); err != nil {
+				log.WithError(err).Warn("failed to close snapshotter")
+			}
+		}()
+
+		// Recover from runs that were interrupted by a previous shutdown or crash
+		if err := ss.RecoverInterruptedRuns(ctx); err != nil {
+			log.WithError(err).Error("failed to recover interrupted snapshot runs")
+		}
 
 		// Initialize HTTP server
-		srv := server.New(cfg, ss.GetDB(), ss.GetStatus)
+		srv := server.New(cfg, ss.GetDB(), ss.GetStatus, ss, ss.Events())
 		go func() {
 			if err := srv.Start(); err != nil {
 				log.WithError(err).Fatal("failed to start HTTP server")
 			}
 		}()
 
-		// Start the cleanup routine
-		go ss.StartCleanupRoutine()
+		// Start deleting old snapshots, if enabled. The routine runs in the background, separately from the runs.
+		ss.StartCleanupRoutine(ctx)
 
-		// Start the snapshot routine
-		ss.StartPeriodicPolling()
+		// Compare the bucket with the database, if enabled
+		ss.StartReconcileRoutine(ctx)
 
+		// Start verifying uploaded snapshots, if enabled
+		ss.StartVerificationRoutine(ctx)
+
+		// Start copying uploaded snapshots to the replication destinations, if any
+		ss.StartReplicationRoutine(ctx)
+
+		// Keep the containers of the targets running, if enabled
+		ss.StartWatchdogRoutine(ctx)
+
+		// Start the snapshot routine. Blocks until the context is cancelled or run_once completes.
+		ss.StartPeriodicPolling(ctx)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Warn("failed to shut down HTTP server")
+		}
+		log.Info("snapshotter stopped")
 	},
 }
 
@@ -0,0 +1,226 @@
+// Package agent implements the upload agent. The snapshotter copies its own binary to SSH targets and runs
+// `snapshotter agent upload` there, which archives the data dir, compresses it and uploads it to S3 directly.
+// The job is read from stdin, progress and the resulting manifest are written to stdout.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rclone"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ArchiveName is the name of the archive uploaded under <upload_prefix>/<block_number>
+	ArchiveName = "snapshot.tar.zst"
+
+	// DefaultPartSize is the default size of the multipart upload parts
+	DefaultPartSize = 64 << 20
+	// DefaultConcurrency is the default number of parts uploaded in parallel
+	DefaultConcurrency = 4
+)
+
+// metadataFiles are uploaded next to the archive, in this order. They are dumped to the data dir by the
+// snapshotter before the upload, the manifest is written by the agent.
+var metadataFiles = []string{
+	"_snapshot_eth_getBlockByNumber.json",
+	"_snapshot_web3_clientVersion.json",
+	"_snapshot_metadata.json",
+	"_snapshot_manifest.json",
+}
+
+// Destination is the bucket snapshots are uploaded to
+type Destination struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// Job describes a snapshot upload. It is passed to the agent on stdin, so the credentials don't end up
+// on the command line of the target.
+type Job struct {
+	Destination  Destination `json:"destination"`
+	DataDir      string      `json:"data_dir"`
+	UploadPrefix string      `json:"upload_prefix"`
+	BlockNumber  uint64      `json:"block_number"`
+	// PartSize is the size of the multipart upload parts in bytes, 0 uses DefaultPartSize
+	PartSize int64 `json:"part_size"`
+	// Concurrency is the number of parts uploaded in parallel, 0 uses DefaultConcurrency
+	Concurrency int `json:"concurrency"`
+	// ACL is the canned ACL of the uploaded objects, if set
+	ACL string `json:"acl"`
+}
+
+// Key returns the object key of the archive
+func (j *Job) Key() string {
+	return path.Join(j.UploadPrefix, strconv.FormatUint(j.BlockNumber, 10), ArchiveName)
+}
+
+func (j *Job) validate() error {
+	switch {
+	case j.Destination.Bucket == "":
+		return fmt.Errorf("no bucket set")
+	case j.Destination.AccessKeyID == "" || j.Destination.SecretAccessKey == "":
+		return fmt.Errorf("no S3 credentials set")
+	case j.DataDir == "":
+		return fmt.Errorf("no data dir set")
+	case j.UploadPrefix == "":
+		return fmt.Errorf("no upload prefix set")
+	}
+	return nil
+}
+
+// newS3Client creates a client for the destination, using path style addressing like the snapshotter does
+func newS3Client(dest Destination) *s3.Client {
+	opts := s3.Options{
+		Region:       dest.Region,
+		Credentials:  credentials.NewStaticCredentialsProvider(dest.AccessKeyID, dest.SecretAccessKey, ""),
+		UsePathStyle: true,
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if dest.Endpoint != "" {
+		opts.BaseEndpoint = aws.String(dest.Endpoint)
+	}
+	return s3.New(opts)
+}
+
+// Run archives and uploads the data dir of the job. Progress is written to out after every uploaded part,
+// followed by the manifest of the archive on a line starting with rclone.ManifestMarker, the same way the
+// rclone uploader reports it.
+func Run(ctx context.Context, job *Job, out io.Writer) (*types.SnapshotManifest, error) {
+	if err := job.validate(); err != nil {
+		return nil, fmt.Errorf("invalid upload job: %w", err)
+	}
+	return run(ctx, newS3Client(job.Destination), job, out)
+}
+
+func run(ctx context.Context, client objectAPI, job *Job, out io.Writer) (*types.SnapshotManifest, error) {
+	for _, name := range metadataFiles[:3] {
+		if _, err := os.Stat(filepath.Join(job.DataDir, name)); err != nil {
+			return nil, fmt.Errorf("metadata file missing: %w", err)
+		}
+	}
+
+	total, err := dirSize(job.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data dir: %w", err)
+	}
+
+	var (
+		outMu    sync.Mutex
+		read     atomic.Int64
+		uploaded atomic.Int64
+	)
+	report := func() {
+		line, _ := json.Marshal(types.UploadProgress{
+			BytesRead:     read.Load(),
+			BytesTotal:    total,
+			BytesUploaded: uploaded.Load(),
+		})
+		outMu.Lock()
+		defer outMu.Unlock()
+		fmt.Fprintf(out, "%s%s\n", rclone.ProgressMarker, line)
+	}
+
+	log.WithFields(log.Fields{
+		"data_dir": job.DataDir,
+		"bucket":   job.Destination.Bucket,
+		"key":      job.Key(),
+		"size":     total,
+	}).Info("uploading snapshot")
+
+	pr, pw := io.Pipe()
+	// Unblocks the archive writer if the upload stops early
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(writeArchive(ctx, job.DataDir, pw, &read))
+	}()
+
+	hash := sha256.New()
+	u := &multipartUpload{
+		client:      client,
+		bucket:      job.Destination.Bucket,
+		key:         job.Key(),
+		partSize:    partSize(job.PartSize, total),
+		concurrency: job.Concurrency,
+		acl:         job.ACL,
+		onPart: func(size int64) {
+			uploaded.Add(size)
+			report()
+		},
+	}
+	size, err := u.upload(ctx, io.TeeReader(pr, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &types.SnapshotManifest{
+		BlockNumber: job.BlockNumber,
+		File:        ArchiveName,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Size:        size,
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(job.DataDir, "_snapshot_manifest.json"), append(manifestJSON, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	prefix := path.Dir(job.Key())
+	for _, name := range metadataFiles {
+		content, err := os.ReadFile(filepath.Join(job.DataDir, name))
+		if err != nil {
+			return nil, err
+		}
+		_, err = client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(job.Destination.Bucket),
+			Key:         aws.String(prefix + "/" + name),
+			Body:        bytes.NewReader(content),
+			ContentType: aws.String("application/json"),
+			ACL:         s3types.ObjectCannedACL(job.ACL),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+	}
+
+	outMu.Lock()
+	defer outMu.Unlock()
+	fmt.Fprintf(out, "%s%s\n", rclone.ManifestMarker, manifestJSON)
+	return manifest, nil
+}
+
+// partSize returns the configured part size, raised so that an archive as large as the data dir fits
+// into the maximum number of parts S3 allows
+func partSize(configured, total int64) int64 {
+	if configured <= 0 {
+		configured = DefaultPartSize
+	}
+	if minSize := total/(maxParts-1) + 1; configured < minSize {
+		return minSize
+	}
+	return configured
+}
@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rclone"
+	"github.com/klauspost/compress/zstd"
+)
+
+// fakeObjectAPI keeps uploaded objects and parts in memory
+type fakeObjectAPI struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	parts    map[int32][]byte
+	failPart int32
+	aborted  bool
+}
+
+func newFakeObjectAPI() *fakeObjectAPI {
+	return &fakeObjectAPI{objects: make(map[string][]byte), parts: make(map[int32][]byte)}
+}
+
+func (f *fakeObjectAPI) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeObjectAPI) CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (f *fakeObjectAPI) UploadPart(_ context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if *in.PartNumber == f.failPart {
+		return nil, errors.New("connection reset")
+	}
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.parts[*in.PartNumber] = body
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", *in.PartNumber))}, nil
+}
+
+func (f *fakeObjectAPI) CompleteMultipartUpload(_ context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var object []byte
+	for i, part := range in.MultipartUpload.Parts {
+		if *part.PartNumber != int32(i+1) || *part.ETag != fmt.Sprintf("etag-%d", i+1) {
+			return nil, fmt.Errorf("unexpected part %d at position %d", *part.PartNumber, i)
+		}
+		object = append(object, f.parts[*part.PartNumber]...)
+	}
+	f.objects[*in.Key] = object
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeObjectAPI) AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// writeDataDir creates a data dir with some random chain data, node keys and the snapshot metadata files
+func writeDataDir(t *testing.T) string {
+	dir := t.TempDir()
+	// Random data doesn't compress, so the archive spans several parts
+	chainData := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(chainData)
+	files := map[string]string{
+		"geth/chaindata/000001.ldb":           string(chainData),
+		"geth/chaindata/CURRENT":              "MANIFEST-000002\n",
+		"nodekey":                             "secret",
+		"key":                                 "secret",
+		"geth/chaindata/key":                  "not a node key",
+		"_snapshot_manifest.json":             `{"block_number":1}`,
+		"_snapshot_eth_getBlockByNumber.json": `{"result":{"number":"0x64"}}`,
+		"_snapshot_web3_clientVersion.json":   `{"result":"Geth/v1.15.0"}`,
+		"_snapshot_metadata.json":             `{"static":{}}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("geth/chaindata", filepath.Join(dir, "chaindata")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := writeDataDir(t)
+	api := newFakeObjectAPI()
+	job := &Job{
+		Destination:  Destination{Bucket: "snapshots"},
+		DataDir:      dir,
+		UploadPrefix: "hoodi/geth",
+		BlockNumber:  100,
+		PartSize:     4096,
+		Concurrency:  3,
+	}
+
+	var out bytes.Buffer
+	manifest, err := run(context.Background(), api, job, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := api.objects["hoodi/geth/100/snapshot.tar.zst"]
+	if len(api.parts) < 2 {
+		t.Fatalf("expected the archive to be uploaded in several parts, got %d", len(api.parts))
+	}
+	sum := sha256.Sum256(archive)
+	if manifest.SHA256 != hex.EncodeToString(sum[:]) || manifest.Size != int64(len(archive)) || manifest.BlockNumber != 100 {
+		t.Errorf("manifest %+v doesn't match the uploaded archive", manifest)
+	}
+
+	dec, err := zstd.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	tr := tar.NewReader(dec)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "./chaindata" && (hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "geth/chaindata") {
+			t.Errorf("expected symlink to be kept, got %+v", hdr)
+		}
+	}
+	sort.Strings(names)
+	expected := []string{
+		"./",
+		"./_snapshot_eth_getBlockByNumber.json",
+		"./_snapshot_metadata.json",
+		"./_snapshot_web3_clientVersion.json",
+		"./chaindata",
+		"./geth/",
+		"./geth/chaindata/",
+		"./geth/chaindata/000001.ldb",
+		"./geth/chaindata/CURRENT",
+		// Only the node keys at the top of the data dir are left out, like the rclone command does
+		"./geth/chaindata/key",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected archive entries\n got: %v\nwant: %v", names, expected)
+	}
+
+	for _, name := range metadataFiles {
+		if _, ok := api.objects["hoodi/geth/100/"+name]; !ok {
+			t.Errorf("expected %s to be uploaded next to the archive", name)
+		}
+	}
+
+	parsed, err := rclone.ParseManifest(out.String())
+	if err != nil || parsed == nil || *parsed != *manifest {
+		t.Errorf("expected manifest line in output, got %+v (%v) from:\n%s", parsed, err, out.String())
+	}
+	if strings.Count(out.String(), rclone.ProgressMarker) != len(api.parts) {
+		t.Errorf("expected a progress line per part in:\n%s", out.String())
+	}
+}
+
+func TestRunAbortsFailedUpload(t *testing.T) {
+	api := newFakeObjectAPI()
+	api.failPart = 2
+	job := &Job{
+		Destination:  Destination{Bucket: "snapshots"},
+		DataDir:      writeDataDir(t),
+		UploadPrefix: "hoodi/geth",
+		BlockNumber:  100,
+		PartSize:     4096,
+	}
+
+	if _, err := run(context.Background(), api, job, io.Discard); err == nil {
+		t.Fatal("expected upload to fail")
+	}
+	if !api.aborted {
+		t.Error("expected multipart upload to be aborted")
+	}
+	if _, ok := api.objects["hoodi/geth/100/_snapshot_manifest.json"]; ok {
+		t.Error("expected no manifest to be uploaded for a failed upload")
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	if size := partSize(0, 1<<30); size != DefaultPartSize {
+		t.Errorf("expected default part size, got %d", size)
+	}
+	total := int64(4) << 40
+	if size := partSize(0, total); size*maxParts < total {
+		t.Errorf("part size %d too small for %d bytes", size, total)
+	}
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// excludedFiles are left out of the archive if they are at the top of the data dir, like the rclone command
+// excludes them. They hold the identity of the node, and the manifest of a previous upload.
+var excludedFiles = map[string]bool{
+	"nodekey":                 true,
+	"key":                     true,
+	"discovery-secret":        true,
+	"_snapshot_manifest.json": true,
+}
+
+// archived reports whether an entry of the data dir, at rel relative to it, is written to the archive. Only
+// regular files, directories and symlinks are.
+func archived(rel string, d fs.DirEntry) bool {
+	if excludedFiles[filepath.ToSlash(rel)] && !d.IsDir() {
+		return false
+	}
+	return d.Type()&^(fs.ModeDir|fs.ModeSymlink) == 0
+}
+
+// dirSize returns the total size of the files in dir that are archived
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !archived(rel, d) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// writeArchive writes dir as a zstd compressed tar archive to w, with entries relative to dir as
+// `tar -C dir -cf - .` would name them. The bytes of file content archived are added to read.
+func writeArchive(ctx context.Context, dir string, w io.Writer, read *atomic.Int64) error {
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(enc)
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if !archived(rel, d) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if d.Type() == fs.ModeSymlink {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", p, err)
+		}
+		hdr.Name = "./" + filepath.ToSlash(rel)
+		if rel == "." {
+			hdr.Name = "."
+		}
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// The node is stopped, a file changing size while it is archived fails the upload
+		if _, err := io.Copy(countingWriter{w: tw, n: read}, f); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		enc.Close()
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
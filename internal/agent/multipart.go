@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxParts is the maximum number of parts of a multipart upload
+const maxParts = 10000
+
+// objectAPI is the part of the S3 API the agent uses
+type objectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// multipartUpload streams a reader of unknown length to a single object, uploading up to concurrency
+// parts in parallel. Each part in flight is buffered in memory.
+type multipartUpload struct {
+	client      objectAPI
+	bucket      string
+	key         string
+	partSize    int64
+	concurrency int
+	acl         string
+	// onPart is called with the size of every part once it is uploaded
+	onPart func(size int64)
+}
+
+// upload reads r until EOF and returns the size of the object. On failure or cancellation the
+// multipart upload is aborted, so no incomplete parts are left behind.
+func (u *multipartUpload) upload(ctx context.Context, r io.Reader) (int64, error) {
+	concurrency := u.concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	created, err := u.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(u.bucket),
+		Key:         aws.String(u.key),
+		ContentType: aws.String("application/zstd"),
+		ACL:         s3types.ObjectCannedACL(u.acl),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	var (
+		partsMu sync.Mutex
+		parts   []s3types.CompletedPart
+		size    int64
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	slots := make(chan struct{}, concurrency)
+
+	readErr := func() error {
+		for number := int32(1); ; number++ {
+			select {
+			case slots <- struct{}{}:
+			case <-gctx.Done():
+				return nil
+			}
+			if number > maxParts {
+				<-slots
+				return fmt.Errorf("archive exceeds %d parts of %d bytes", maxParts, u.partSize)
+			}
+
+			buf := make([]byte, u.partSize)
+			n, err := io.ReadFull(r, buf)
+			if errors.Is(err, io.EOF) && number > 1 {
+				<-slots
+				return nil
+			}
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+				<-slots
+				return err
+			}
+			size += int64(n)
+
+			g.Go(func() error {
+				defer func() { <-slots }()
+				out, err := u.client.UploadPart(gctx, &s3.UploadPartInput{
+					Bucket:        aws.String(u.bucket),
+					Key:           aws.String(u.key),
+					UploadId:      uploadID,
+					PartNumber:    aws.Int32(number),
+					Body:          bytes.NewReader(buf[:n]),
+					ContentLength: aws.Int64(int64(n)),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to upload part %d: %w", number, err)
+				}
+				partsMu.Lock()
+				parts = append(parts, s3types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+				partsMu.Unlock()
+				if u.onPart != nil {
+					u.onPart(int64(n))
+				}
+				return nil
+			})
+
+			if int64(n) < u.partSize {
+				return nil
+			}
+		}
+	}()
+
+	err = g.Wait()
+	if readErr != nil {
+		err = fmt.Errorf("failed to read archive: %w", readErr)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		u.abort(uploadID)
+		return 0, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	_, err = u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucket),
+		Key:             aws.String(u.key),
+		UploadId:        uploadID,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		u.abort(uploadID)
+		return 0, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return size, nil
+}
+
+// abort aborts the multipart upload, independently of the context of the upload which may be cancelled
+func (u *multipartUpload) abort(uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := u.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.bucket),
+		Key:      aws.String(u.key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.WithError(err).WithField("key", u.key).Error("failed to abort multipart upload")
+	}
+}
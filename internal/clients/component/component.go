@@ -0,0 +1,98 @@
+// Package component builds the shell commands that control the docker containers, compose services and
+// systemd units of a node
+package component
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+// Actions on a component
+const (
+	Stop    = "stop"
+	Start   = "start"
+	Restart = "restart"
+)
+
+// Command returns the shell command that runs action on the component. Components configured to be killed
+// are stopped without waiting for them to shut down. With sudo, systemd units are controlled with sudo, like
+// the other privileged commands on SSH targets.
+func Command(c config.ComponentConfig, action string, sudo bool) (string, error) {
+	switch action {
+	case Stop, Start, Restart:
+	default:
+		return "", fmt.Errorf("unknown action %q", action)
+	}
+	kill := c.Kill && action == Stop
+
+	switch c.Type {
+	case config.ComponentDocker:
+		if kill {
+			return fmt.Sprintf(`docker stop -t 0 "%s"`, c.Name), nil
+		}
+		return fmt.Sprintf(`docker %s "%s"`, action, c.Name), nil
+	case config.ComponentCompose:
+		args := []string{action}
+		if kill {
+			args = append(args, "-t", "0")
+		}
+		return fmt.Sprintf(`%s %s "%s"`, compose(c), strings.Join(args, " "), c.Name), nil
+	case config.ComponentSystemd:
+		systemctl := "systemctl"
+		if sudo {
+			systemctl = "sudo systemctl"
+		}
+		if kill {
+			// The unit is stopped after killing it so systemd doesn't restart it
+			return fmt.Sprintf(`%[1]s kill --signal=SIGKILL "%[2]s"; %[1]s stop "%[2]s"`, systemctl, c.Name), nil
+		}
+		return fmt.Sprintf(`%s %s "%s"`, systemctl, action, c.Name), nil
+	}
+	return "", fmt.Errorf("components of type %q are not controlled with shell commands", c.Type)
+}
+
+// RunningCommand returns the shell command whose output tells if the component is running, see Running
+func RunningCommand(c config.ComponentConfig) (string, error) {
+	switch c.Type {
+	case config.ComponentDocker:
+		return fmt.Sprintf(`docker inspect --format='{{.State.Running}}' "%s"`, c.Name), nil
+	case config.ComponentCompose:
+		return fmt.Sprintf(`%s ps --status running --quiet "%s"`, compose(c), c.Name), nil
+	case config.ComponentSystemd:
+		return fmt.Sprintf(`systemctl show --property=ActiveState --value "%s"`, c.Name), nil
+	}
+	return "", fmt.Errorf("components of type %q are not controlled with shell commands", c.Type)
+}
+
+// Running interprets the output of the RunningCommand of the component
+func Running(c config.ComponentConfig, out string) bool {
+	out = strings.TrimSpace(out)
+	switch c.Type {
+	case config.ComponentDocker:
+		return out == "true"
+	case config.ComponentCompose:
+		// The ids of the running containers of the service
+		return out != ""
+	case config.ComponentSystemd:
+		return out == "active" || out == "activating" || out == "reloading"
+	}
+	return false
+}
+
+// Shell reports whether the component is controlled with shell commands
+func Shell(c config.ComponentConfig) bool {
+	switch c.Type {
+	case config.ComponentDocker, config.ComponentCompose, config.ComponentSystemd:
+		return true
+	}
+	return false
+}
+
+func compose(c config.ComponentConfig) string {
+	if c.ComposeFile == "" {
+		return "docker compose"
+	}
+	return fmt.Sprintf(`docker compose -f "%s"`, c.ComposeFile)
+}
@@ -0,0 +1,60 @@
+package component
+
+import (
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		component config.ComponentConfig
+		action    string
+		sudo      bool
+		want      string
+	}{
+		{config.ComponentConfig{Name: "snooper-engine", Type: config.ComponentDocker, Kill: true}, Stop, false, `docker stop -t 0 "snooper-engine"`},
+		{config.ComponentConfig{Name: "snooper-engine", Type: config.ComponentDocker, Kill: true}, Start, false, `docker start "snooper-engine"`},
+		{config.ComponentConfig{Name: "beacon", Type: config.ComponentDocker}, Restart, false, `docker restart "beacon"`},
+		{config.ComponentConfig{Name: "geth", Type: config.ComponentCompose}, Stop, false, `docker compose stop "geth"`},
+		{config.ComponentConfig{Name: "geth", Type: config.ComponentCompose, ComposeFile: "/srv/node/compose.yaml", Kill: true}, Stop, false, `docker compose -f "/srv/node/compose.yaml" stop -t 0 "geth"`},
+		{config.ComponentConfig{Name: "nethermind.service", Type: config.ComponentSystemd}, Start, false, `systemctl start "nethermind.service"`},
+		{config.ComponentConfig{Name: "mev-boost", Type: config.ComponentSystemd, Kill: true}, Stop, false, `systemctl kill --signal=SIGKILL "mev-boost"; systemctl stop "mev-boost"`},
+		{config.ComponentConfig{Name: "nethermind.service", Type: config.ComponentSystemd}, Restart, true, `sudo systemctl restart "nethermind.service"`},
+		{config.ComponentConfig{Name: "mev-boost", Type: config.ComponentSystemd, Kill: true}, Stop, true, `sudo systemctl kill --signal=SIGKILL "mev-boost"; sudo systemctl stop "mev-boost"`},
+		{config.ComponentConfig{Name: "geth", Type: config.ComponentCompose}, Start, true, `docker compose start "geth"`},
+	}
+	for _, tt := range tests {
+		got, err := Command(tt.component, tt.action, tt.sudo)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error %v", tt.action, tt.component.Name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: expected %q, got %q", tt.action, tt.component.Name, tt.want, got)
+		}
+	}
+
+	if _, err := Command(config.ComponentConfig{Name: "statefulset/besu", Type: config.ComponentWorkload}, Stop, false); err == nil {
+		t.Error("expected workloads not to have shell commands")
+	}
+	if _, err := Command(config.ComponentConfig{Name: "geth", Type: config.ComponentDocker}, "pause", false); err == nil {
+		t.Error("expected unknown action to fail")
+	}
+}
+
+func TestRunning(t *testing.T) {
+	systemd := config.ComponentConfig{Name: "geth", Type: config.ComponentSystemd}
+	compose := config.ComponentConfig{Name: "geth", Type: config.ComponentCompose}
+	docker := config.ComponentConfig{Name: "geth", Type: config.ComponentDocker}
+
+	if !Running(systemd, "active\n") || Running(systemd, "failed\n") {
+		t.Error("unexpected systemd state")
+	}
+	if !Running(compose, "3f2a9c\n") || Running(compose, "\n") {
+		t.Error("unexpected compose state")
+	}
+	if !Running(docker, "true\n") || Running(docker, "false\n") {
+		t.Error("unexpected docker state")
+	}
+}
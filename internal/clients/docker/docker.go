@@ -0,0 +1,583 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/component"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rclone"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rpc"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// apiVersion is the Docker Engine API version used for all requests
+const apiVersion = "v1.41"
+
+// LocalClient controls a node running on the same host as the snapshotter through the Docker Engine API
+type LocalClient struct {
+	TargetConfig *config.LocalTargetConfig
+	RCloneConfig *config.RCloneConfig
+	httpClient   *http.Client
+	rpc          *rpc.Client
+}
+
+// containerInspect is the subset of the container inspect response we care about
+type containerInspect struct {
+	ID    string `json:"Id"`
+	State struct {
+		Status   string `json:"Status"`
+		Running  bool   `json:"Running"`
+		ExitCode int    `json:"ExitCode"`
+		Health   *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+}
+
+// NewLocalClient creates a client talking to the docker socket configured for the target
+func NewLocalClient(rcloneCfg *config.RCloneConfig, target *config.LocalTargetConfig) *LocalClient {
+	socket := target.DockerSocket
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	return &LocalClient{
+		TargetConfig: target,
+		RCloneConfig: rcloneCfg,
+		httpClient:   httpClient,
+		rpc:          rpc.New(nil, target.Endpoints.Execution, target.Endpoints.Beacon, target.Endpoints.Timeout()),
+	}
+}
+
+// Alias returns the alias of the target
+func (client *LocalClient) Alias() string {
+	return client.TargetConfig.Alias
+}
+
+// do sends a request to the Docker Engine API. The body, if any, is JSON encoded.
+func (client *LocalClient) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	u := "http://docker/" + apiVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return client.httpClient.Do(req)
+}
+
+// expect checks the response status code and closes the body, returning the docker error message if any
+func expect(resp *http.Response, codes ...int) error {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			return nil
+		}
+	}
+	msg := struct {
+		Message string `json:"message"`
+	}{}
+	body, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(body))
+	}
+	return fmt.Errorf("docker api returned %d: %s", resp.StatusCode, msg.Message)
+}
+
+func (client *LocalClient) GetSyncStatusCL(ctx context.Context) (*types.BeaconV1NodeSyncing, error) {
+	status, err := client.rpc.BeaconSyncStatus(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting CL sync status")
+		return nil, err
+	}
+	return status, nil
+}
+
+func (client *LocalClient) GetSyncStatusEL(ctx context.Context) (bool, error) {
+	syncing, progress, err := client.rpc.Syncing(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL sync status")
+		return true, err
+	}
+	if progress != nil {
+		log.WithFields(log.Fields{
+			"startingBlock": progress.StartingBlock,
+			"currentBlock":  progress.CurrentBlock,
+			"highestBlock":  progress.HighestBlock,
+		}).Warn("EL is syncing")
+	}
+	return syncing, nil
+}
+
+func (client *LocalClient) GetELBlockNumber(ctx context.Context) (string, error) {
+	block, err := client.rpc.BlockNumber(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL block")
+		return "", err
+	}
+	return block, nil
+}
+
+func (client *LocalClient) GetELChainID(ctx context.Context) (string, error) {
+	chainID, err := client.rpc.ChainID(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL chain id")
+		return "", err
+	}
+	return chainID, nil
+}
+
+func (client *LocalClient) DumpExecutionRPCRequestToFile(ctx context.Context, payload, filePath string) error {
+	out, err := client.rpc.CallRaw(ctx, payload)
+	if err != nil {
+		log.WithError(err).WithField("filePath", filePath).Error("failed to dump execution rpc response to file")
+		return err
+	}
+	if err := os.WriteFile(filePath, out, 0644); err != nil {
+		log.WithError(err).WithField("filePath", filePath).Error("failed to write execution rpc response to file")
+		return err
+	}
+	return nil
+}
+
+// inspectContainer returns the inspect information of a container
+func (client *LocalClient) inspectContainer(ctx context.Context, name string) (*containerInspect, error) {
+	resp, err := client.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expect(resp, http.StatusOK)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var inspect containerInspect
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return nil, fmt.Errorf("failed to decode container inspect response: %w", err)
+	}
+	return &inspect, nil
+}
+
+func (client *LocalClient) StopDockerContainer(ctx context.Context, name string, timeout *int) error {
+	log.WithFields(log.Fields{
+		"host":      client.TargetConfig.Alias,
+		"container": name,
+	}).Debug("stopping docker container")
+
+	query := url.Values{}
+	if timeout != nil {
+		query.Set("t", fmt.Sprintf("%d", *timeout))
+	}
+	resp, err := client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", query, nil)
+	if err == nil {
+		// 304 means the container was already stopped
+		err = expect(resp, http.StatusNoContent, http.StatusNotModified)
+	}
+	if err != nil {
+		log.WithError(err).WithField("container", name).Warn("failed to stop container")
+		return err
+	}
+	return nil
+}
+
+func (client *LocalClient) StartDockerContainer(ctx context.Context, name string) error {
+	log.WithFields(log.Fields{
+		"host":      client.TargetConfig.Alias,
+		"container": name,
+	}).Debug("starting docker container")
+
+	resp, err := client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil, nil)
+	if err == nil {
+		// 304 means the container was already running
+		err = expect(resp, http.StatusNoContent, http.StatusNotModified)
+	}
+	if err != nil {
+		log.WithError(err).WithField("container", name).Warn("failed to start container")
+		return err
+	}
+	return nil
+}
+
+// StopComponent stops a component of the node
+func (client *LocalClient) StopComponent(ctx context.Context, c config.ComponentConfig) error {
+	if c.Type != config.ComponentDocker {
+		return client.runComponent(ctx, c, component.Stop)
+	}
+	var timeout *int
+	if c.Kill {
+		timeout = new(int)
+	}
+	return client.StopDockerContainer(ctx, c.Name, timeout)
+}
+
+// StartComponent starts a component of the node
+func (client *LocalClient) StartComponent(ctx context.Context, c config.ComponentConfig) error {
+	if c.Type != config.ComponentDocker {
+		return client.runComponent(ctx, c, component.Start)
+	}
+	return client.StartDockerContainer(ctx, c.Name)
+}
+
+// RestartComponent restarts a component of the node
+func (client *LocalClient) RestartComponent(ctx context.Context, c config.ComponentConfig) error {
+	if c.Type != config.ComponentDocker {
+		return client.runComponent(ctx, c, component.Restart)
+	}
+	return client.RestartContainer(ctx, c.Name)
+}
+
+// runComponent controls compose services and systemd units with shell commands on the host
+func (client *LocalClient) runComponent(ctx context.Context, c config.ComponentConfig, action string) error {
+	cmd, err := component.Command(c, action, false)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"host":      client.TargetConfig.Alias,
+		"component": c.Name,
+		"action":    action,
+	}).Debug("controlling component")
+	out, err := exec.CommandContext(ctx, "sh", "-c", cmd).CombinedOutput()
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"component": c.Name,
+			"action":    action,
+			"output":    string(out),
+		}).Warn("failed to control component")
+		return fmt.Errorf("failed to %s %s: %w", action, c.Name, err)
+	}
+	return nil
+}
+
+// isComponentRunning reports whether the given component is currently running
+func (client *LocalClient) isComponentRunning(ctx context.Context, c config.ComponentConfig) (bool, error) {
+	if c.Type == config.ComponentDocker {
+		inspect, err := client.inspectContainer(ctx, c.Name)
+		if err != nil {
+			return false, err
+		}
+		return inspect.State.Running, nil
+	}
+	cmd, err := component.RunningCommand(c)
+	if err != nil {
+		return false, err
+	}
+	out, err := exec.CommandContext(ctx, "sh", "-c", cmd).Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", c.Name, err)
+	}
+	return component.Running(c, string(out)), nil
+}
+
+// EnsureContainersRunning starts the components of the node that are not running, except those left alone
+// during snapshots
+func (client *LocalClient) EnsureContainersRunning(ctx context.Context) error {
+	for _, c := range client.TargetConfig.Components {
+		if c.OnSnapshot == config.ComponentNone {
+			continue
+		}
+		running, err := client.isComponentRunning(ctx, c)
+		if err != nil {
+			return err
+		}
+		if running {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"host":      client.TargetConfig.Alias,
+			"component": c.Name,
+		}).Warn("component is not running, starting it")
+		if err := client.StartComponent(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InspectContainers returns the state of the docker container components of the node
+func (client *LocalClient) InspectContainers(ctx context.Context) ([]types.ContainerState, error) {
+	var states []types.ContainerState
+	for _, c := range client.TargetConfig.ComponentsOfType(config.ComponentDocker) {
+		inspect, err := client.inspectContainer(ctx, c.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect container %s: %w", c.Name, err)
+		}
+		cs := types.ContainerState{
+			Role:     c.Role,
+			Name:     c.Name,
+			Status:   inspect.State.Status,
+			Running:  inspect.State.Running,
+			ExitCode: inspect.State.ExitCode,
+		}
+		if inspect.State.Health != nil {
+			cs.Health = inspect.State.Health.Status
+		}
+		states = append(states, cs)
+	}
+	return states, nil
+}
+
+// StartContainer starts a stopped container of the node
+func (client *LocalClient) StartContainer(ctx context.Context, name string) error {
+	return client.StartDockerContainer(ctx, name)
+}
+
+// RestartContainer restarts a container of the node
+func (client *LocalClient) RestartContainer(ctx context.Context, name string) error {
+	resp, err := client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/restart", nil, nil)
+	if err == nil {
+		err = expect(resp, http.StatusNoContent)
+	}
+	if err != nil {
+		log.WithError(err).WithField("container", name).Warn("failed to restart container")
+		return err
+	}
+	return nil
+}
+
+// uploadContainerName returns the name of the rclone container used for uploads on this target
+func (client *LocalClient) uploadContainerName() string {
+	return "snapshotter-upload-" + client.TargetConfig.Alias
+}
+
+// removeContainer force removes a container, ignoring containers that don't exist
+func (client *LocalClient) removeContainer(ctx context.Context, name string) error {
+	resp, err := client.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(name), url.Values{"force": {"true"}}, nil)
+	if err != nil {
+		return err
+	}
+	return expect(resp, http.StatusNoContent, http.StatusNotFound)
+}
+
+// pullImage pulls the given image, blocking until the pull has finished
+func (client *LocalClient) pullImage(ctx context.Context, image string) error {
+	name, tag, _ := strings.Cut(image, ":")
+	query := url.Values{"fromImage": {name}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	resp, err := client.do(ctx, http.MethodPost, "/images/create", query, nil)
+	if err != nil {
+		return err
+	}
+	// The pull progress is streamed in the body, the pull is done when the body is fully read
+	return expect(resp, http.StatusOK)
+}
+
+// followLogs copies the output of a container to w until the container exits or the context is cancelled
+func (client *LocalClient) followLogs(ctx context.Context, id string, w io.Writer) {
+	resp, err := client.do(ctx, http.MethodGet, "/containers/"+id+"/logs", url.Values{
+		"stdout": {"true"},
+		"stderr": {"true"},
+		"follow": {"true"},
+	}, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	// The stream is multiplexed in frames with an 8 byte header holding the size of the frame
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			return
+		}
+		size := int64(header[4])<<24 | int64(header[5])<<16 | int64(header[6])<<8 | int64(header[7])
+		if _, err := io.CopyN(w, resp.Body, size); err != nil {
+			return
+		}
+	}
+}
+
+// containerLogs returns the last lines of output of a container
+func (client *LocalClient) containerLogs(ctx context.Context, id string) string {
+	resp, err := client.do(ctx, http.MethodGet, "/containers/"+id+"/logs", url.Values{
+		"stdout": {"true"},
+		"stderr": {"true"},
+		"tail":   {"50"},
+	}, nil)
+	if err != nil {
+		return ""
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	raw, _ := io.ReadAll(resp.Body)
+
+	// Strip the multiplexed stream headers (8 bytes per frame)
+	var out bytes.Buffer
+	for len(raw) >= 8 {
+		size := int(raw[4])<<24 | int(raw[5])<<16 | int(raw[6])<<8 | int(raw[7])
+		raw = raw[8:]
+		if size > len(raw) {
+			size = len(raw)
+		}
+		out.Write(raw[:size])
+		raw = raw[size:]
+	}
+	return out.String()
+}
+
+// UploadSnapshot uploads the data dir of the target by running an rclone container on the local docker host
+func (client *LocalClient) UploadSnapshot(ctx context.Context, srcDir, uploadPrefix string, blockNumber uint64, progress func(types.UploadProgress)) (*types.SnapshotManifest, error) {
+	// Get Docker image information for metadata
+	metadata := types.SnapshotMetadata{
+		Static: client.TargetConfig.Metadata,
+	}
+	if execution := client.TargetConfig.ExecutionComponent(); execution != nil && execution.Type == config.ComponentDocker {
+		inspect, err := client.inspectContainer(ctx, execution.Name)
+		if err == nil {
+			metadata.DockerImage = inspect.Config.Image
+		} else {
+			log.WithError(err).Warn("failed to get execution container image for metadata")
+		}
+	}
+
+	metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		log.WithError(err).Error("failed to marshal snapshot metadata")
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "_snapshot_metadata.json"), append(metadataJSON, '\n'), 0644); err != nil {
+		log.WithError(err).Error("failed to write snapshot metadata file")
+		return nil, err
+	}
+
+	rcloneCmd, err := rclone.BuildCommand(client.RCloneConfig, srcDir, uploadPrefix, blockNumber)
+	if err != nil {
+		log.WithError(err).Error("failed to build rclone command")
+		return nil, err
+	}
+
+	image := rclone.Image(client.RCloneConfig)
+	if err := client.pullImage(ctx, image); err != nil {
+		log.WithError(err).WithField("image", image).Error("failed to pull rclone image")
+		return nil, err
+	}
+
+	// Clean up any upload container left behind by a previous run
+	name := client.uploadContainerName()
+	if err := client.removeContainer(ctx, name); err != nil {
+		log.WithError(err).WithField("container", name).Warn("failed to remove stale upload container")
+	}
+
+	// The command template is written for a shell command line, so let a shell parse it
+	createReq := map[string]interface{}{
+		"Image":      image,
+		"Entrypoint": []string{"/bin/sh", "-c"},
+		"Cmd":        []string{rclone.Entrypoint(client.RCloneConfig) + " " + rcloneCmd},
+		"Env":        rclone.Env(client.RCloneConfig),
+		"HostConfig": map[string]interface{}{
+			"Binds": []string{srcDir + ":" + srcDir},
+		},
+	}
+	resp, err := client.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, createReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		err := expect(resp, http.StatusCreated)
+		log.WithError(err).Error("failed to create upload container")
+		return nil, err
+	}
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode container create response: %w", err)
+	}
+
+	// Always remove the upload container, even if the upload was cancelled
+	defer func() {
+		removeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := client.removeContainer(removeCtx, created.ID); err != nil {
+			log.WithError(err).WithField("container", name).Warn("failed to remove upload container")
+		}
+	}()
+
+	resp, err = client.do(ctx, http.MethodPost, "/containers/"+created.ID+"/start", nil, nil)
+	if err == nil {
+		err = expect(resp, http.StatusNoContent)
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to start upload container")
+		return nil, err
+	}
+	go client.followLogs(ctx, created.ID, rclone.NewProgressWriter(client.TargetConfig.Alias, progress))
+
+	resp, err = client.do(ctx, http.MethodPost, "/containers/"+created.ID+"/wait", nil, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.WithField("host", client.TargetConfig.Alias).Warn("upload cancelled, removing upload container")
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expect(resp, http.StatusOK)
+	}
+	waitResp := struct {
+		StatusCode int `json:"StatusCode"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&waitResp)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode container wait response: %w", err)
+	}
+
+	if waitResp.StatusCode != 0 {
+		err := fmt.Errorf("upload container exited with status %d", waitResp.StatusCode)
+		log.WithError(err).WithField("output", client.containerLogs(context.WithoutCancel(ctx), created.ID)).Error("failed to rclone sync")
+		return nil, err
+	}
+
+	return rclone.ParseManifest(client.containerLogs(ctx, created.ID))
+}
@@ -0,0 +1,487 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rclone"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rpc"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// scaleTimeout is how long to wait for a workload to reach the requested number of replicas
+const scaleTimeout = 10 * time.Minute
+
+// replicasAnnotation records the replica count of a workload we scaled down on the workload itself,
+// so it is restored as it was even if the snapshotter restarted in between
+const replicasAnnotation = "snapshotter.ethpandaops.io/replicas"
+
+// KubectlClient controls a node running in Kubernetes by shelling out to kubectl.
+// Stopping a component scales its workload down to zero, starting it scales it back up.
+type KubectlClient struct {
+	TargetConfig *config.KubernetesTargetConfig
+	RCloneConfig *config.RCloneConfig
+	rpc          *rpc.Client
+}
+
+// NewKubectlClient creates a client for the given kubernetes target
+func NewKubectlClient(rcloneCfg *config.RCloneConfig, target *config.KubernetesTargetConfig) *KubectlClient {
+	return &KubectlClient{
+		TargetConfig: target,
+		RCloneConfig: rcloneCfg,
+		rpc:          rpc.New(nil, target.Endpoints.Execution, target.Endpoints.Beacon, target.Endpoints.Timeout()),
+	}
+}
+
+// Alias returns the alias of the target
+func (client *KubectlClient) Alias() string {
+	return client.TargetConfig.Alias
+}
+
+// kubectl runs kubectl with the target's kubeconfig, context and namespace and returns its combined output
+func (client *KubectlClient) kubectl(ctx context.Context, stdin []byte, args ...string) (string, error) {
+	return client.kubectlStreaming(ctx, stdin, nil, args...)
+}
+
+// kubectlStreaming runs kubectl like kubectl, additionally copying its output to w while it is running, if set
+func (client *KubectlClient) kubectlStreaming(ctx context.Context, stdin []byte, w io.Writer, args ...string) (string, error) {
+	base := []string{"--namespace", client.TargetConfig.Namespace}
+	if client.TargetConfig.Kubeconfig != "" {
+		base = append(base, "--kubeconfig", client.TargetConfig.Kubeconfig)
+	}
+	if client.TargetConfig.Context != "" {
+		base = append(base, "--context", client.TargetConfig.Context)
+	}
+
+	cmd := exec.CommandContext(ctx, "kubectl", append(base, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	// Stdout and stderr are the same writer, so exec copies them sequentially
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if w != nil {
+		cmd.Stdout = io.MultiWriter(&out, w)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("kubectl %s: %w", args[0], err)
+	}
+	return out.String(), nil
+}
+
+func (client *KubectlClient) GetSyncStatusCL(ctx context.Context) (*types.BeaconV1NodeSyncing, error) {
+	status, err := client.rpc.BeaconSyncStatus(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting CL sync status")
+		return nil, err
+	}
+	return status, nil
+}
+
+func (client *KubectlClient) GetSyncStatusEL(ctx context.Context) (bool, error) {
+	syncing, progress, err := client.rpc.Syncing(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL sync status")
+		return true, err
+	}
+	if progress != nil {
+		log.WithFields(log.Fields{
+			"startingBlock": progress.StartingBlock,
+			"currentBlock":  progress.CurrentBlock,
+			"highestBlock":  progress.HighestBlock,
+		}).Warn("EL is syncing")
+	}
+	return syncing, nil
+}
+
+func (client *KubectlClient) GetELBlockNumber(ctx context.Context) (string, error) {
+	block, err := client.rpc.BlockNumber(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL block")
+		return "", err
+	}
+	return block, nil
+}
+
+func (client *KubectlClient) GetELChainID(ctx context.Context) (string, error) {
+	chainID, err := client.rpc.ChainID(ctx)
+	if err != nil {
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL chain id")
+		return "", err
+	}
+	return chainID, nil
+}
+
+// execArgs returns the kubectl exec arguments targeting the execution container
+func (client *KubectlClient) execArgs() []string {
+	args := []string{"exec", "-i", client.executionWorkload()}
+	if client.TargetConfig.ExecutionContainer != "" {
+		args = append(args, "-c", client.TargetConfig.ExecutionContainer)
+	}
+	return append(args, "--")
+}
+
+// writeFile writes content to a path inside the execution container
+func (client *KubectlClient) writeFile(ctx context.Context, filePath string, content []byte) error {
+	args := append(client.execArgs(), "sh", "-c", `cat > "$0"`, filePath)
+	out, err := client.kubectl(ctx, content, args...)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"filePath": filePath,
+			"output":   out,
+		}).Error("failed to write file in execution pod")
+		return err
+	}
+	return nil
+}
+
+func (client *KubectlClient) DumpExecutionRPCRequestToFile(ctx context.Context, payload, filePath string) error {
+	out, err := client.rpc.CallRaw(ctx, payload)
+	if err != nil {
+		log.WithError(err).WithField("filePath", filePath).Error("failed to dump execution rpc response to file")
+		return err
+	}
+	return client.writeFile(ctx, filePath, out)
+}
+
+// currentReplicas returns the desired replica count of a workload
+func (client *KubectlClient) currentReplicas(ctx context.Context, workload string) (int, error) {
+	out, err := client.kubectl(ctx, nil, "get", workload, "-o", "jsonpath={.spec.replicas}")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// stoppedReplicas returns the replica count recorded on a workload when it was scaled down, or 0 if
+// there is none
+func (client *KubectlClient) stoppedReplicas(ctx context.Context, workload string) (int, error) {
+	jsonpath := "{.metadata.annotations." + strings.ReplaceAll(replicasAnnotation, ".", `\.`) + "}"
+	out, err := client.kubectl(ctx, nil, "get", workload, "-o", "jsonpath="+jsonpath)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	if strings.TrimSpace(out) == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// annotateReplicas records the replica count of a workload in its annotation, or removes the annotation
+// if replicas is 0
+func (client *KubectlClient) annotateReplicas(ctx context.Context, workload string, replicas int) error {
+	annotation := replicasAnnotation + "-"
+	if replicas > 0 {
+		annotation = replicasAnnotation + "=" + strconv.Itoa(replicas)
+	}
+	out, err := client.kubectl(ctx, nil, "annotate", workload, annotation, "--overwrite")
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// readyReplicas returns the number of ready replicas of a workload
+func (client *KubectlClient) readyReplicas(ctx context.Context, workload string) (int, error) {
+	out, err := client.kubectl(ctx, nil, "get", workload, "-o", "jsonpath={.status.readyReplicas}")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	if strings.TrimSpace(out) == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// scale sets the replica count of a workload and waits until its pods are gone (when scaling to zero)
+// or the rollout has finished (when scaling up)
+func (client *KubectlClient) scale(ctx context.Context, workload string, replicas int) error {
+	log.WithFields(log.Fields{
+		"host":     client.TargetConfig.Alias,
+		"workload": workload,
+		"replicas": replicas,
+	}).Debug("scaling workload")
+
+	out, err := client.kubectl(ctx, nil, "scale", workload, "--replicas", strconv.Itoa(replicas))
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"workload": workload,
+			"output":   out,
+		}).Warn("failed to scale workload")
+		return err
+	}
+
+	if replicas > 0 {
+		out, err := client.kubectl(ctx, nil, "rollout", "status", workload, "--timeout", scaleTimeout.String())
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"workload": workload,
+				"output":   out,
+			}).Warn("workload did not become ready")
+			return err
+		}
+		return nil
+	}
+
+	// Wait for all pods of the workload to be gone, so the volume is released
+	deadline := time.Now().Add(scaleTimeout)
+	for {
+		out, err := client.kubectl(ctx, nil, "get", workload, "-o", "jsonpath={.status.replicas}")
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+		}
+		if current := strings.TrimSpace(out); current == "" || current == "0" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to scale down", workload)
+		}
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stopWorkload scales a workload down to zero, recording its replica count in the replicas annotation
+func (client *KubectlClient) stopWorkload(ctx context.Context, workload string) error {
+	replicas, err := client.currentReplicas(ctx, workload)
+	if err != nil {
+		log.WithError(err).WithField("workload", workload).Warn("failed to get workload replicas")
+		return err
+	}
+	// A workload that is already stopped keeps the count recorded when it was scaled down
+	if replicas > 0 {
+		if err := client.annotateReplicas(ctx, workload, replicas); err != nil {
+			log.WithError(err).WithField("workload", workload).Warn("failed to record workload replicas")
+			return err
+		}
+	}
+	return client.scale(ctx, workload, 0)
+}
+
+// startWorkload scales a workload back up to the replica count recorded when it was stopped, or 1, and
+// removes the replicas annotation once it is up
+func (client *KubectlClient) startWorkload(ctx context.Context, workload string) error {
+	replicas, err := client.stoppedReplicas(ctx, workload)
+	if err != nil {
+		log.WithError(err).WithField("workload", workload).Warn("failed to get recorded workload replicas")
+		return err
+	}
+	if replicas <= 0 {
+		return client.scale(ctx, workload, 1)
+	}
+	if err := client.scale(ctx, workload, replicas); err != nil {
+		return err
+	}
+	return client.annotateReplicas(ctx, workload, 0)
+}
+
+// StopComponent scales the workload of a component down to zero
+func (client *KubectlClient) StopComponent(ctx context.Context, c config.ComponentConfig) error {
+	return client.stopWorkload(ctx, c.Name)
+}
+
+// StartComponent scales the workload of a component back up
+func (client *KubectlClient) StartComponent(ctx context.Context, c config.ComponentConfig) error {
+	return client.startWorkload(ctx, c.Name)
+}
+
+// RestartComponent restarts the pods of the workload of a component
+func (client *KubectlClient) RestartComponent(ctx context.Context, c config.ComponentConfig) error {
+	out, err := client.kubectl(ctx, nil, "rollout", "restart", c.Name)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"workload": c.Name,
+			"output":   out,
+		}).Warn("failed to restart workload")
+		return err
+	}
+	return nil
+}
+
+// EnsureContainersRunning scales up the workloads of the node that have no ready replicas, except those
+// left alone during snapshots
+func (client *KubectlClient) EnsureContainersRunning(ctx context.Context) error {
+	for _, c := range client.TargetConfig.Components {
+		if c.OnSnapshot == config.ComponentNone {
+			continue
+		}
+		ready, err := client.readyReplicas(ctx, c.Name)
+		if err != nil {
+			return err
+		}
+		if ready > 0 {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"host":     client.TargetConfig.Alias,
+			"workload": c.Name,
+		}).Warn("workload has no ready replicas, starting it")
+		if err := client.startWorkload(ctx, c.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executionWorkload returns the workload of the execution component
+func (client *KubectlClient) executionWorkload() string {
+	if execution := client.TargetConfig.ExecutionComponent(); execution != nil {
+		return execution.Name
+	}
+	return client.TargetConfig.Workloads.Execution
+}
+
+// uploadPodName returns the name of the rclone pod used for uploads on this target
+func (client *KubectlClient) uploadPodName() string {
+	return "snapshotter-upload-" + client.TargetConfig.Alias
+}
+
+// uploadSecret returns the manifest of the secret holding the rclone environment of the upload pod
+func uploadSecret(name string, env []string) ([]byte, error) {
+	data := make(map[string]string, len(env))
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		data[k] = v
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata":   map[string]string{"name": name},
+		"stringData": data,
+	})
+}
+
+// applyUploadSecret creates or updates the secret holding the rclone environment of the upload pod. The
+// manifest is passed on stdin, so the credentials don't show up in the kubectl arguments.
+func (client *KubectlClient) applyUploadSecret(ctx context.Context, name string) error {
+	manifest, err := uploadSecret(name, rclone.Env(client.RCloneConfig))
+	if err != nil {
+		return err
+	}
+	if out, err := client.kubectl(ctx, manifest, "apply", "-f", "-"); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// UploadSnapshot uploads the data volume by running an rclone pod that mounts the execution PVC.
+// The execution workload has to be scaled down before, so the volume can be attached.
+func (client *KubectlClient) UploadSnapshot(ctx context.Context, srcDir, uploadPrefix string, blockNumber uint64, progress func(types.UploadProgress)) (*types.SnapshotManifest, error) {
+	rcloneCmd, err := rclone.BuildCommand(client.RCloneConfig, srcDir, uploadPrefix, blockNumber)
+	if err != nil {
+		log.WithError(err).Error("failed to build rclone command")
+		return nil, err
+	}
+
+	metadata := types.SnapshotMetadata{
+		Static: client.TargetConfig.Metadata,
+	}
+	if image, err := client.kubectl(ctx, nil, "get", client.executionWorkload(), "-o", "jsonpath={.spec.template.spec.containers[0].image}"); err == nil {
+		metadata.DockerImage = strings.TrimSpace(image)
+	} else {
+		log.WithError(err).Warn("failed to get execution container image for metadata")
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		log.WithError(err).Error("failed to marshal snapshot metadata")
+		return nil, err
+	}
+
+	// The execution pod is gone at this point, so the metadata file is written by the upload pod itself
+	script := fmt.Sprintf("echo \"$SNAPSHOT_METADATA\" > %q && %s %s",
+		filepath.Join(srcDir, "_snapshot_metadata.json"),
+		rclone.Entrypoint(client.RCloneConfig),
+		rcloneCmd,
+	)
+
+	// The rclone environment holds the S3 credentials, so it is passed through a secret rather than the pod spec
+	secret := client.TargetConfig.RCloneSecret
+	if secret == "" {
+		secret = client.uploadPodName()
+		if err := client.applyUploadSecret(ctx, secret); err != nil {
+			log.WithError(err).Error("failed to create rclone secret")
+			return nil, err
+		}
+		defer func() {
+			if out, err := client.kubectl(context.WithoutCancel(ctx), nil, "delete", "secret", secret, "--ignore-not-found"); err != nil {
+				log.WithError(err).WithField("output", out).Warn("failed to remove rclone secret")
+			}
+		}()
+	}
+
+	volumeMount := map[string]interface{}{
+		"name":      "data",
+		"mountPath": srcDir,
+	}
+	if client.TargetConfig.DataVolumeSubPath != "" {
+		volumeMount["subPath"] = client.TargetConfig.DataVolumeSubPath
+	}
+
+	name := client.uploadPodName()
+	overrides, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"spec": map[string]interface{}{
+			"restartPolicy": "Never",
+			"containers": []map[string]interface{}{{
+				"name":         name,
+				"image":        rclone.Image(client.RCloneConfig),
+				"command":      []string{"/bin/sh", "-c", script},
+				"env":          []map[string]string{{"name": "SNAPSHOT_METADATA", "value": string(metadataJSON)}},
+				"envFrom":      []map[string]interface{}{{"secretRef": map[string]string{"name": secret}}},
+				"volumeMounts": []map[string]interface{}{volumeMount},
+			}},
+			"volumes": []map[string]interface{}{{
+				"name": "data",
+				"persistentVolumeClaim": map[string]string{
+					"claimName": client.TargetConfig.DataVolumeClaim,
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean up any upload pod left behind by a previous run
+	if out, err := client.kubectl(ctx, nil, "delete", "pod", name, "--ignore-not-found", "--wait"); err != nil {
+		log.WithError(err).WithField("output", out).Warn("failed to remove stale upload pod")
+	}
+
+	out, err := client.kubectlStreaming(ctx, nil, rclone.NewProgressWriter(client.TargetConfig.Alias, progress),
+		"run", name,
+		"--image", rclone.Image(client.RCloneConfig),
+		"--restart", "Never",
+		"--attach",
+		"--rm",
+		"--pod-running-timeout", "10m",
+		"--overrides", string(overrides),
+	)
+	if ctx.Err() != nil {
+		log.WithField("host", client.TargetConfig.Alias).Warn("upload cancelled, removing upload pod")
+		if out, err := client.kubectl(context.WithoutCancel(ctx), nil, "delete", "pod", name, "--ignore-not-found", "--wait=false"); err != nil {
+			log.WithError(err).WithField("output", out).Error("failed to abort upload")
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.WithError(err).WithField("output", out).Error("failed to rclone sync")
+		return nil, err
+	}
+
+	return rclone.ParseManifest(out)
+}
@@ -0,0 +1,132 @@
+package kubernetes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+// fakeKubectl puts a kubectl in the PATH that records its arguments, and its stdin when applying a manifest,
+// in the returned directory. cases are added to the case statement on the arguments, with $d set to the
+// directory.
+func fakeKubectl(t *testing.T, cases string) string {
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"d=\"" + dir + "\"\n" +
+		"echo \"$@\" >> \"$d/args\"\n" +
+		"case \"$*\" in *\"apply -f -\"*) cat > \"$d/stdin\" ;;\n" + cases + "esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestUploadSnapshotSecret(t *testing.T) {
+	rcloneCfg := config.GetDefaultRCloneConfig()
+	rcloneCfg.Env["RCLONE_CONFIG_MYS3_ACCESS_KEY_ID"] = "access-key"
+	rcloneCfg.Env["RCLONE_CONFIG_MYS3_SECRET_ACCESS_KEY"] = "secret-key"
+
+	tests := []struct {
+		name         string
+		rcloneSecret string
+		secret       string
+	}{
+		{"secret created for the upload", "", "snapshotter-upload-besu"},
+		{"existing secret", "rclone-credentials", "rclone-credentials"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := fakeKubectl(t, "")
+			target := &config.KubernetesTargetConfig{
+				Namespace:       "hoodi",
+				DataVolumeClaim: "storage-besu-0",
+				RCloneSecret:    tc.rcloneSecret,
+			}
+			target.Alias = "besu"
+			client := NewKubectlClient(&rcloneCfg, target)
+
+			if _, err := client.UploadSnapshot(context.Background(), "/data", "hoodi/besu", 100, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			args, err := os.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(args), "secret-key") || strings.Contains(string(args), "access-key") {
+				t.Errorf("expected the credentials to be kept out of the kubectl arguments, got\n%s", args)
+			}
+			if !strings.Contains(string(args), `"envFrom":[{"secretRef":{"name":"`+tc.secret+`"}}]`) {
+				t.Errorf("expected the upload pod to read its environment from secret %s, got\n%s", tc.secret, args)
+			}
+
+			stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+			created := strings.Contains(string(args), "delete secret "+tc.secret)
+			if tc.rcloneSecret != "" {
+				if err == nil || created {
+					t.Errorf("expected the existing secret to be left alone")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(stdin), `"RCLONE_CONFIG_MYS3_SECRET_ACCESS_KEY":"secret-key"`) ||
+				!strings.Contains(string(stdin), `"name":"`+tc.secret+`"`) {
+				t.Errorf("unexpected secret manifest %s", stdin)
+			}
+			if !created {
+				t.Error("expected the secret to be removed after the upload")
+			}
+		})
+	}
+}
+
+func TestWorkloadReplicas(t *testing.T) {
+	// The workload runs 3 replicas and keeps its annotations in a file
+	dir := fakeKubectl(t, `*"{.spec.replicas}"*) echo 3 ;;
+*"annotate statefulset/geth snapshotter.ethpandaops.io/replicas="*) echo "${5#*=}" > "$d/annotation" ;;
+*"annotate statefulset/geth snapshotter.ethpandaops.io/replicas-"*) rm "$d/annotation" ;;
+*"{.metadata.annotations.snapshotter\.ethpandaops\.io/replicas}"*) cat "$d/annotation" 2>/dev/null || true ;;
+`)
+	target := &config.KubernetesTargetConfig{Namespace: "hoodi"}
+	target.Alias = "geth"
+	rcloneCfg := config.GetDefaultRCloneConfig()
+
+	if err := NewKubectlClient(&rcloneCfg, target).stopWorkload(context.Background(), "statefulset/geth"); err != nil {
+		t.Fatal(err)
+	}
+	annotation, err := os.ReadFile(filepath.Join(dir, "annotation"))
+	if err != nil || strings.TrimSpace(string(annotation)) != "3" {
+		t.Fatalf("expected the replica count to be recorded on the workload, got %q (%v)", annotation, err)
+	}
+
+	// A new client, e.g. after a restart of the snapshotter, scales the workload back to 3 replicas
+	if err := NewKubectlClient(&rcloneCfg, target).startWorkload(context.Background(), "statefulset/geth"); err != nil {
+		t.Fatal(err)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "scale statefulset/geth --replicas 3") {
+		t.Errorf("expected the workload to be scaled back to 3 replicas, got\n%s", args)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "annotation")); !os.IsNotExist(err) {
+		t.Error("expected the replicas annotation to be removed once the workload is up")
+	}
+
+	// Without a recorded count the workload is scaled to 1 replica
+	if err := NewKubectlClient(&rcloneCfg, target).startWorkload(context.Background(), "statefulset/geth"); err != nil {
+		t.Fatal(err)
+	}
+	if args, _ := os.ReadFile(filepath.Join(dir, "args")); !strings.Contains(string(args), "scale statefulset/geth --replicas 1") {
+		t.Errorf("expected the workload to be scaled to 1 replica, got\n%s", args)
+	}
+}
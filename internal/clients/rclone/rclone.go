@@ -0,0 +1,206 @@
+package rclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBucketName is used when no bucket name could be derived from the configuration
+const DefaultBucketName = "ethpandaops-ethereum-node-snapshots"
+
+// ManifestMarker prefixes the line of the upload output that holds the snapshot manifest
+const ManifestMarker = "SNAPSHOT_MANIFEST "
+
+// ProgressMarker prefixes the lines of the upload output that report progress, followed by a
+// types.UploadProgress as JSON
+const ProgressMarker = "SNAPSHOT_PROGRESS "
+
+// CommandVars are the variables available to the rclone command template
+type CommandVars struct {
+	DataDir          string
+	UploadPathPrefix string
+	BucketName       string
+	BlockNumber      uint64
+}
+
+// Image returns the rclone docker image to use for uploads
+func Image(cfg *config.RCloneConfig) string {
+	version := cfg.Version
+	if version == "" {
+		version = config.GetDefaultRCloneConfig().Version
+	}
+	return "rclone/rclone:" + version
+}
+
+// Entrypoint returns the entrypoint of the rclone upload container
+func Entrypoint(cfg *config.RCloneConfig) string {
+	if cfg.Entrypoint == "" {
+		return config.GetDefaultRCloneConfig().Entrypoint
+	}
+	return cfg.Entrypoint
+}
+
+// Env returns the rclone environment variables as KEY=VALUE pairs, sorted by key
+func Env(cfg *config.RCloneConfig) []string {
+	keys := make([]string, 0, len(cfg.Env))
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", k, cfg.Env[k]))
+	}
+	return env
+}
+
+// BucketName returns the bucket name rclone uploads to.
+// This is set in config.ReadFromFile from the s3 configuration.
+func BucketName(cfg *config.RCloneConfig) string {
+	if cfg.Env != nil {
+		if val, exists := cfg.Env["RCLONE_CONFIG_MYS3_BUCKET_NAME"]; exists && val != "" {
+			return val
+		}
+	}
+	log.Warn("Bucket name not found in RClone config environment variables, using default")
+	return DefaultBucketName
+}
+
+// ParseManifest returns the snapshot manifest printed by the upload command, or nil if the command
+// didn't print one (e.g. a custom command template)
+func ParseManifest(output string) (*types.SnapshotManifest, error) {
+	var line string
+	for _, l := range strings.Split(output, "\n") {
+		if strings.HasPrefix(l, ManifestMarker) {
+			line = strings.TrimPrefix(l, ManifestMarker)
+		}
+	}
+	if line == "" {
+		return nil, nil
+	}
+
+	var manifest types.SnapshotManifest
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot manifest: %w", err)
+	}
+	if manifest.SHA256 == "" {
+		return nil, fmt.Errorf("snapshot manifest has no sha256")
+	}
+	return &manifest, nil
+}
+
+// ParseProgress returns the upload progress reported on a line of the upload output, either on a line
+// starting with ProgressMarker or in the stats rclone logs with --use-json-log. rclone only knows the
+// number of bytes it uploaded.
+func ParseProgress(line string) (*types.UploadProgress, bool) {
+	if data, ok := strings.CutPrefix(line, ProgressMarker); ok {
+		var progress types.UploadProgress
+		if err := json.Unmarshal([]byte(data), &progress); err != nil {
+			return nil, false
+		}
+		return &progress, true
+	}
+
+	if !strings.HasPrefix(line, "{") || !strings.Contains(line, `"stats"`) {
+		return nil, false
+	}
+	var entry struct {
+		Stats *struct {
+			Bytes int64 `json:"bytes"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Stats == nil {
+		return nil, false
+	}
+	return &types.UploadProgress{BytesUploaded: entry.Stats.Bytes}, true
+}
+
+// ProgressWriter parses the upload output written to it line by line, passing the progress reported to
+// report and logging it at most every 30 seconds
+type ProgressWriter struct {
+	alias   string
+	report  func(types.UploadProgress)
+	partial []byte
+	logged  time.Time
+}
+
+// NewProgressWriter returns a ProgressWriter for the upload of the given target. report may be nil.
+func NewProgressWriter(alias string, report func(types.UploadProgress)) *ProgressWriter {
+	return &ProgressWriter{alias: alias, report: report}
+}
+
+func (w *ProgressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *ProgressWriter) line(line string) {
+	progress, ok := ParseProgress(line)
+	if !ok {
+		return
+	}
+	if w.report != nil {
+		w.report(*progress)
+	}
+	if time.Since(w.logged) < 30*time.Second {
+		return
+	}
+	w.logged = time.Now()
+
+	fields := log.Fields{
+		"host":     w.alias,
+		"uploaded": progress.BytesUploaded,
+	}
+	if progress.BytesTotal > 0 {
+		fields["read"] = progress.BytesRead
+		fields["percent"] = fmt.Sprintf("%.1f", float64(progress.BytesRead)*100/float64(progress.BytesTotal))
+	}
+	log.WithFields(fields).Info("upload progress")
+}
+
+// BuildCommand renders the rclone command template for a snapshot upload. The result is
+// meant to be passed as arguments to the rclone container entrypoint.
+func BuildCommand(cfg *config.RCloneConfig, dataDir, uploadPrefix string, blockNumber uint64) (string, error) {
+	// Get command template, using default if not specified
+	cmdTemplate := cfg.CommandTemplate
+	if cmdTemplate == "" {
+		// This fallback should rarely happen since we set defaults in config.ReadFromFile
+		log.Debug("RClone command template not specified, using default from config package")
+		cmdTemplate = config.GetDefaultRCloneConfig().CommandTemplate
+	}
+
+	tmpl, err := template.New("cmd").Parse(cmdTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse rclone cmd template: %w", err)
+	}
+
+	var rcloneCmd bytes.Buffer
+	if err := tmpl.Execute(&rcloneCmd, CommandVars{
+		DataDir:          dataDir,
+		UploadPathPrefix: uploadPrefix,
+		BucketName:       BucketName(cfg),
+		BlockNumber:      blockNumber,
+	}); err != nil {
+		return "", fmt.Errorf("failed to execute rclone cmd template: %w", err)
+	}
+
+	return rcloneCmd.String(), nil
+}
@@ -0,0 +1,61 @@
+package rclone
+
+import (
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+func TestParseManifest(t *testing.T) {
+	output := "./\n./chaindata/000001.log\n" +
+		`SNAPSHOT_MANIFEST {"block_number":123,"file":"snapshot.tar.zst","sha256":"a5bbc056d474ea1d5211331ffbbb6d2f1f0a536627eb227ee7ba679d9d87b7a5","size":165}` + "\n"
+
+	manifest, err := ParseManifest(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest == nil || manifest.BlockNumber != 123 || manifest.Size != 165 ||
+		manifest.SHA256 != "a5bbc056d474ea1d5211331ffbbb6d2f1f0a536627eb227ee7ba679d9d87b7a5" {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	// Custom command templates don't have to print a manifest
+	manifest, err = ParseManifest("uploaded\n")
+	if err != nil || manifest != nil {
+		t.Errorf("expected no manifest, got %+v, %v", manifest, err)
+	}
+
+	if _, err := ParseManifest("SNAPSHOT_MANIFEST {\"sha256\":\"\",\"size\":}\n"); err == nil {
+		t.Error("expected an error for a broken manifest")
+	}
+}
+
+func TestParseProgress(t *testing.T) {
+	progress, ok := ParseProgress(`SNAPSHOT_PROGRESS {"bytesRead":100,"bytesTotal":400,"bytesUploaded":40}`)
+	if !ok || progress.BytesRead != 100 || progress.BytesTotal != 400 || progress.BytesUploaded != 40 {
+		t.Errorf("unexpected agent progress %+v", progress)
+	}
+
+	progress, ok = ParseProgress(`{"level":"notice","msg":"Transferred: 1.000 GiB","source":"accounting/stats.go:500","stats":{"bytes":1073741824,"speed":1048576,"totalBytes":0},"time":"2025-05-01T12:00:00Z"}`)
+	if !ok || progress.BytesUploaded != 1<<30 || progress.BytesTotal != 0 {
+		t.Errorf("unexpected rclone progress %+v", progress)
+	}
+
+	for _, line := range []string{"./chaindata/000001.log", `{"level":"info","msg":"copied"}`, "SNAPSHOT_PROGRESS {"} {
+		if _, ok := ParseProgress(line); ok {
+			t.Errorf("expected no progress in %q", line)
+		}
+	}
+
+	var reported []int64
+	w := NewProgressWriter("geth", func(p types.UploadProgress) { reported = append(reported, p.BytesUploaded) })
+	// Lines may be split across writes
+	for _, chunk := range []string{"./key\nSNAPSHOT_PROGRESS {\"bytesUp", "loaded\":1}\nSNAPSHOT_PROGRESS {\"bytesUploaded\":2}\r\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(reported) != 2 || reported[0] != 1 || reported[1] != 2 {
+		t.Errorf("unexpected reported progress %v", reported)
+	}
+}
@@ -0,0 +1,227 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+// DefaultTimeout is the timeout applied to a single call when none is configured
+const DefaultTimeout = 10 * time.Second
+
+// Client talks to the execution JSON-RPC and the beacon HTTP API of a node
+type Client struct {
+	httpClient   *http.Client
+	executionURL string
+	beaconURL    string
+	timeout      time.Duration
+}
+
+// Error is a JSON-RPC error returned by the execution client
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	ID      int           `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+}
+
+// SyncProgress is the object returned by eth_syncing while the execution client is syncing
+type SyncProgress struct {
+	StartingBlock string `json:"startingBlock"`
+	CurrentBlock  string `json:"currentBlock"`
+	HighestBlock  string `json:"highestBlock"`
+}
+
+// New creates a client for the given endpoints. If httpClient is nil, http.DefaultClient is used.
+// A zero timeout falls back to DefaultTimeout.
+func New(httpClient *http.Client, executionURL, beaconURL string, timeout time.Duration) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Client{
+		httpClient:   httpClient,
+		executionURL: executionURL,
+		beaconURL:    strings.TrimSuffix(beaconURL, "/"),
+		timeout:      timeout,
+	}
+}
+
+// post sends a raw JSON-RPC payload to the execution client and returns the raw response body
+func (c *Client) post(ctx context.Context, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.executionURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from execution client: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// Call invokes a JSON-RPC method on the execution client and decodes the result into result
+func (c *Client) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	payload, err := json.Marshal(request{JSONRPC: "2.0", Method: method, Params: params, ID: 1})
+	if err != nil {
+		return err
+	}
+
+	body, err := c.post(ctx, payload)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("%s: failed to decode response: %w", method, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %w", method, resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("%s: failed to decode result: %w", method, err)
+	}
+	return nil
+}
+
+// CallRaw sends a raw JSON-RPC payload and returns the full response, indented for readability.
+// A JSON-RPC error in the response is returned as an error.
+func (c *Client) CallRaw(ctx context.Context, payload string) ([]byte, error) {
+	body, err := c.post(ctx, []byte(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// ChainID returns the chain id reported by the execution client as a hex string
+func (c *Client) ChainID(ctx context.Context) (string, error) {
+	var chainID string
+	err := c.Call(ctx, "eth_chainId", nil, &chainID)
+	return chainID, err
+}
+
+// BlockNumber returns the head block number of the execution client as a hex string
+func (c *Client) BlockNumber(ctx context.Context) (string, error) {
+	var block string
+	err := c.Call(ctx, "eth_blockNumber", nil, &block)
+	return block, err
+}
+
+// Syncing returns whether the execution client is syncing. The progress is only set while syncing.
+func (c *Client) Syncing(ctx context.Context) (bool, *SyncProgress, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, "eth_syncing", nil, &raw); err != nil {
+		return false, nil, err
+	}
+
+	var syncing bool
+	if err := json.Unmarshal(raw, &syncing); err == nil {
+		return syncing, nil, nil
+	}
+
+	var progress SyncProgress
+	if err := json.Unmarshal(raw, &progress); err != nil {
+		return true, nil, fmt.Errorf("eth_syncing: unexpected result %s: %w", string(raw), err)
+	}
+	return true, &progress, nil
+}
+
+// BeaconSyncStatus returns the sync status of the beacon node
+func (c *Client) BeaconSyncStatus(ctx context.Context) (*types.BeaconV1NodeSyncing, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.beaconURL+"/eth/v1/node/syncing", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from beacon node: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var syncing struct {
+		Data types.BeaconV1NodeSyncing `json:"data"`
+	}
+	if err := json.Unmarshal(body, &syncing); err != nil {
+		return nil, fmt.Errorf("failed to decode beacon sync status: %w", err)
+	}
+	return &syncing.Data, nil
+}
@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, results map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/eth/v1/node/syncing" {
+			_, _ = w.Write([]byte(`{"data":{"head_slot":"100","sync_distance":"0","is_syncing":false,"is_optimistic":false,"el_offline":false}}`))
+			return
+		}
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+			return
+		}
+		result, ok := results[req.Method]
+		if !ok {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method does not exist"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	srv := newTestServer(t, map[string]string{
+		"eth_chainId":     `"0x88bb0"`,
+		"eth_blockNumber": `"0x10"`,
+		"eth_syncing":     `{"startingBlock":"0x0","currentBlock":"0x5","highestBlock":"0x10"}`,
+	})
+	client := New(nil, srv.URL, srv.URL, 0)
+	ctx := context.Background()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil || chainID != "0x88bb0" {
+		t.Errorf("Unexpected chain id %q, err %v", chainID, err)
+	}
+
+	block, err := client.BlockNumber(ctx)
+	if err != nil || block != "0x10" {
+		t.Errorf("Unexpected block number %q, err %v", block, err)
+	}
+
+	syncing, progress, err := client.Syncing(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !syncing || progress == nil || progress.CurrentBlock != "0x5" {
+		t.Errorf("Unexpected sync progress %v %+v", syncing, progress)
+	}
+
+	status, err := client.BeaconSyncStatus(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.IsSyncing || status.HeadSlot != "100" {
+		t.Errorf("Unexpected beacon sync status %+v", status)
+	}
+
+	// JSON-RPC errors are decoded
+	_, err = client.CallRaw(ctx, `{"jsonrpc":"2.0","method":"debug_unknown","params":[],"id":1}`)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32601 {
+		t.Errorf("Expected a JSON-RPC error, got %v", err)
+	}
+}
+
+func TestClientSyncingFalse(t *testing.T) {
+	srv := newTestServer(t, map[string]string{"eth_syncing": `false`})
+	syncing, progress, err := New(nil, srv.URL, srv.URL, 0).Syncing(context.Background())
+	if err != nil || syncing || progress != nil {
+		t.Errorf("Expected not syncing, got %v %+v %v", syncing, progress, err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := New(nil, srv.URL, srv.URL, 50*time.Millisecond).ChainID(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
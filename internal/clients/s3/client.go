@@ -3,8 +3,12 @@ package s3
 import (
 	"context"
 	"fmt"
+	"io"
+	"mime"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -162,6 +166,72 @@ func ParseS3URI(uri string) (bucket, key string, err error) {
 	return parts[0], parts[1], nil
 }
 
+// Object is an object listed in a bucket
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects returns all objects under a prefix
+func (c *S3Client) ListObjects(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	if err := c.ensureInitialized(); err != nil {
+		return nil, err
+	}
+
+	// Use default bucket if not specified
+	if bucket == "" {
+		if c.bucketName == "" {
+			return nil, fmt.Errorf("bucket name not specified and no default bucket configured")
+		}
+		bucket = c.bucketName
+	}
+
+	var objects []Object
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// OpenObject returns a reader of the content of an S3 object and its size. The reader has to be closed.
+func (c *S3Client) OpenObject(ctx context.Context, bucket, key string) (io.ReadCloser, int64, error) {
+	if err := c.ensureInitialized(); err != nil {
+		return nil, 0, err
+	}
+
+	// Use default bucket if not specified
+	if bucket == "" {
+		if c.bucketName == "" {
+			return nil, 0, fmt.Errorf("bucket name not specified and no default bucket configured")
+		}
+		bucket = c.bucketName
+	}
+
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get S3 object %s/%s: %w", bucket, key, err)
+	}
+	return out.Body, aws.ToInt64(out.ContentLength), nil
+}
+
 // DeleteObject deletes a single object from S3
 func (c *S3Client) DeleteObject(ctx context.Context, bucket, key string) error {
 	if err := c.ensureInitialized(); err != nil {
@@ -277,6 +347,67 @@ func (c *S3Client) DeleteDirectory(ctx context.Context, bucket, prefix string) e
 	return nil
 }
 
+// AbortMultipartUploads aborts all incomplete multipart uploads under a prefix, so their parts are deleted.
+// It returns the number of aborted uploads.
+func (c *S3Client) AbortMultipartUploads(ctx context.Context, bucket, prefix string) (int, error) {
+	if err := c.ensureInitialized(); err != nil {
+		return 0, err
+	}
+
+	// Use default bucket if not specified
+	if bucket == "" {
+		if c.bucketName == "" {
+			return 0, fmt.Errorf("bucket name not specified and no default bucket configured")
+		}
+		bucket = c.bucketName
+	}
+
+	// Ensure the prefix ends with a slash
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	// There is no paginator for multipart uploads, the markers are passed on by hand
+	var uploads []types.MultipartUpload
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		page, err := c.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list S3 multipart uploads: %w", err)
+		}
+		uploads = append(uploads, page.Uploads...)
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.UploadIdMarker = page.NextUploadIdMarker
+	}
+
+	for i, upload := range uploads {
+		_, err := c.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			return i, fmt.Errorf("failed to abort S3 multipart upload of %s: %w", aws.ToString(upload.Key), err)
+		}
+	}
+
+	if len(uploads) > 0 {
+		log.WithFields(log.Fields{
+			"bucket": bucket,
+			"prefix": prefix,
+			"count":  len(uploads),
+		}).Info("Aborted incomplete multipart uploads")
+	}
+
+	return len(uploads), nil
+}
+
 // PutObject uploads content to S3
 func (c *S3Client) PutObject(ctx context.Context, bucket, key string, content []byte) error {
 	if err := c.ensureInitialized(); err != nil {
@@ -291,11 +422,16 @@ func (c *S3Client) PutObject(ctx context.Context, bucket, key string, content []
 		bucket = c.bucketName
 	}
 
-	_, err := c.client.PutObject(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Body:   strings.NewReader(string(content)),
-	})
+	}
+	// Set the content type for files that are served to browsers, like index.html
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := c.client.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to upload S3 object %s/%s: %w", bucket, key, err)
 	}
@@ -307,3 +443,61 @@ func (c *S3Client) PutObject(ctx context.Context, bucket, key string, content []
 
 	return nil
 }
+
+// GetObject downloads the content of an S3 object
+func (c *S3Client) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	if err := c.ensureInitialized(); err != nil {
+		return nil, err
+	}
+
+	// Use default bucket if not specified
+	if bucket == "" {
+		if c.bucketName == "" {
+			return nil, fmt.Errorf("bucket name not specified and no default bucket configured")
+		}
+		bucket = c.bucketName
+	}
+
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get S3 object %s/%s: %w", bucket, key, err)
+	}
+	defer func() {
+		if err := out.Body.Close(); err != nil {
+			log.WithError(err).Warn("failed to close S3 object body")
+		}
+	}()
+
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 object %s/%s: %w", bucket, key, err)
+	}
+	return content, nil
+}
+
+// PresignGetObject returns a URL that allows downloading an S3 object without credentials until it expires
+func (c *S3Client) PresignGetObject(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	if err := c.ensureInitialized(); err != nil {
+		return "", err
+	}
+
+	// Use default bucket if not specified
+	if bucket == "" {
+		if c.bucketName == "" {
+			return "", fmt.Errorf("bucket name not specified and no default bucket configured")
+		}
+		bucket = c.bucketName
+	}
+
+	req, err := s3.NewPresignClient(c.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 object %s/%s: %w", bucket, key, err)
+	}
+	return req.URL, nil
+}
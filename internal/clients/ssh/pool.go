@@ -0,0 +1,236 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// dialTimeout bounds the TCP connect and SSH handshake of a new connection
+	dialTimeout = 15 * time.Second
+	// minReconnectBackoff is the wait after the first failed connection attempt, doubled on every further failure
+	minReconnectBackoff = time.Second
+
+	DefaultKeepaliveInterval   = 15 * time.Second
+	DefaultMaxReconnectBackoff = time.Minute
+)
+
+// dial opens a new SSH connection to the target
+func (client *SSHClient) dial(ctx context.Context) (*ssh.Client, error) {
+	addr := net.JoinHostPort(client.TargetConfig.Host, strconv.Itoa(client.TargetConfig.Port))
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake doesn't take a context, so bound it with a deadline on the connection
+	deadline := time.Now().Add(dialTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, client.Config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// connection returns the long-lived SSH connection to the target, connecting if there is none.
+// After a failed attempt, new attempts are refused until the reconnect backoff has passed.
+func (client *SSHClient) connection(ctx context.Context) (*ssh.Client, error) {
+	client.connMu.Lock()
+	if c := client.conn; c != nil {
+		client.connMu.Unlock()
+		return c, nil
+	}
+	client.connMu.Unlock()
+
+	// Only one goroutine dials at a time, the others pick up its connection
+	client.dialMu.Lock()
+	defer client.dialMu.Unlock()
+
+	client.connMu.Lock()
+	if c := client.conn; c != nil {
+		client.connMu.Unlock()
+		return c, nil
+	}
+	if wait := time.Until(client.nextDial); wait > 0 {
+		lastErr := client.health.LastError
+		client.connMu.Unlock()
+		return nil, fmt.Errorf("not connected, next reconnect attempt in %s: %s", wait.Round(time.Second), lastErr)
+	}
+	client.connMu.Unlock()
+
+	c, err := client.dial(ctx)
+
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
+
+	if err != nil {
+		// A cancelled caller says nothing about the health of the target
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		client.backoff *= 2
+		if client.backoff < minReconnectBackoff {
+			client.backoff = minReconnectBackoff
+		}
+		if client.backoff > client.maxReconnectBackoff {
+			client.backoff = client.maxReconnectBackoff
+		}
+		client.nextDial = time.Now().Add(client.backoff)
+		client.health.ConsecutiveFailures++
+		client.health.LastError = err.Error()
+		log.WithError(err).WithFields(log.Fields{
+			"host":    client.TargetConfig.Alias,
+			"backoff": client.backoff,
+		}).Warn("failed to connect over SSH")
+		return nil, err
+	}
+
+	now := time.Now()
+	if client.health.ConnectedSince != nil {
+		client.health.Reconnects++
+	}
+	client.conn = c
+	client.backoff = 0
+	client.nextDial = time.Time{}
+	client.health.ConnectedSince = &now
+	client.health.ConsecutiveFailures = 0
+	log.WithField("host", client.TargetConfig.Alias).Debug("connected over SSH")
+
+	go client.keepalive(c)
+
+	return c, nil
+}
+
+// newSession opens a session on the pooled connection. If that fails, the connection is
+// assumed to be dead and a new one is dialed once.
+func (client *SSHClient) newSession(ctx context.Context) (*ssh.Session, error) {
+	c, err := client.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := c.NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	log.WithError(err).WithField("host", client.TargetConfig.Alias).Debug("failed to open SSH session, reconnecting")
+	client.drop(c, err)
+
+	c, err = client.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewSession()
+}
+
+// drop closes the given connection and removes it from the pool, if it is still the current one
+func (client *SSHClient) drop(c *ssh.Client, reason error) {
+	_ = c.Close()
+
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
+	if client.conn != c {
+		return
+	}
+	client.conn = nil
+	if reason != nil {
+		client.health.LastError = reason.Error()
+	}
+}
+
+// keepalive sends keepalive requests on the connection until it fails or is closed
+func (client *SSHClient) keepalive(c *ssh.Client) {
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(client.keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			client.drop(c, fmt.Errorf("connection closed"))
+			return
+		case <-ticker.C:
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+
+			var err error
+			select {
+			case err = <-reply:
+			case <-time.After(client.keepaliveInterval):
+				err = fmt.Errorf("keepalive timed out")
+			case <-closed:
+				client.drop(c, fmt.Errorf("connection closed"))
+				return
+			}
+			if err != nil {
+				log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("SSH keepalive failed, dropping connection")
+				client.drop(c, fmt.Errorf("keepalive failed: %w", err))
+				return
+			}
+
+			now := time.Now()
+			client.connMu.Lock()
+			client.health.LastKeepalive = &now
+			client.connMu.Unlock()
+		}
+	}
+}
+
+// ConnectionHealth returns the state of the pooled SSH connection to the target
+func (client *SSHClient) ConnectionHealth() types.ConnectionHealth {
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
+
+	health := client.health
+	health.Alias = client.TargetConfig.Alias
+	health.Connected = client.conn != nil
+	if !health.Connected {
+		health.ConnectedSince = nil
+		if !client.nextDial.IsZero() {
+			next := client.nextDial
+			health.NextReconnect = &next
+		}
+	}
+	return health
+}
+
+// Close closes the pooled SSH connection
+func (client *SSHClient) Close() error {
+	client.connMu.Lock()
+	c := client.conn
+	client.conn = nil
+	client.connMu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	return c.Close()
+}
@@ -0,0 +1,51 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnectionBackoff(t *testing.T) {
+	// Grab a free port and close it again, so connecting to it fails
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to close listener: %v", err)
+	}
+
+	target := &config.SSHTargetConfig{Host: "127.0.0.1", Port: port}
+	target.Alias = "geth"
+	client := &SSHClient{
+		Config:              &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+		TargetConfig:        target,
+		keepaliveInterval:   DefaultKeepaliveInterval,
+		maxReconnectBackoff: time.Minute,
+	}
+
+	if _, err := client.connection(context.Background()); err == nil {
+		t.Fatal("Expected connection to fail")
+	}
+
+	// Within the backoff no new connection attempt is made
+	_, err = client.connection(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "next reconnect attempt") {
+		t.Errorf("Expected backoff error, got %v", err)
+	}
+
+	health := client.ConnectionHealth()
+	if health.Alias != "geth" || health.Connected {
+		t.Errorf("Unexpected health %+v", health)
+	}
+	if health.ConsecutiveFailures != 1 || health.LastError == "" || health.NextReconnect == nil {
+		t.Errorf("Expected one recorded failure with a reconnect time, got %+v", health)
+	}
+}
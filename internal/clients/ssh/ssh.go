@@ -2,16 +2,22 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
-	"strconv"
 	"strings"
-	"text/template"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/component"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rclone"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rpc"
 	"github.com/ethpandaops/eth-snapshotter/internal/config"
 	"github.com/ethpandaops/eth-snapshotter/internal/types"
 	"golang.org/x/crypto/ssh"
@@ -23,15 +29,25 @@ type SSHClient struct {
 	Config       *ssh.ClientConfig
 	TargetConfig *config.SSHTargetConfig
 	RCloneConfig *config.RCloneConfig
-}
+	rpc          *rpc.Client
+
+	keepaliveInterval   time.Duration
+	maxReconnectBackoff time.Duration
+
+	// The connection to the target is shared by all commands and tunnels
+	dialMu   sync.Mutex
+	connMu   sync.Mutex
+	conn     *ssh.Client
+	backoff  time.Duration
+	nextDial time.Time
+	health   types.ConnectionHealth
 
-// SnapshotMetadata represents metadata about a snapshot
-type SnapshotMetadata struct {
-	DockerImage string            `json:"docker_image,omitempty"`
-	Static      map[string]string `json:"static,omitempty"`
+	// agent is set when uploads go through the upload agent instead of rclone
+	agent *agentUploader
 }
 
-func NewSSHClient(privateKeyPath, privateKeyPassphrasePath, knowHostsPath string, ignoreHostKeyCheck bool, useAgent bool, rclone *config.RCloneConfig, target *config.SSHTargetConfig) *SSHClient {
+// NewSSHClient creates a client for the given target. A zero keepalive interval or reconnect backoff falls back to the defaults.
+func NewSSHClient(privateKeyPath, privateKeyPassphrasePath, knowHostsPath string, ignoreHostKeyCheck bool, useAgent bool, keepaliveInterval, maxReconnectBackoff time.Duration, rclone *config.RCloneConfig, target *config.SSHTargetConfig) *SSHClient {
 
 	var hostkeyCallback ssh.HostKeyCallback
 	hostkeyCallback, err := knownhosts.New(knowHostsPath)
@@ -90,28 +106,118 @@ func NewSSHClient(privateKeyPath, privateKeyPassphrasePath, knowHostsPath string
 		HostKeyCallback: hostkeyCallback,
 	}
 
-	return &SSHClient{
+	client := &SSHClient{
 		Config:       config,
 		TargetConfig: target,
 		RCloneConfig: rclone,
+
+		keepaliveInterval:   keepaliveInterval,
+		maxReconnectBackoff: maxReconnectBackoff,
+	}
+	if client.keepaliveInterval <= 0 {
+		client.keepaliveInterval = DefaultKeepaliveInterval
 	}
+	if client.maxReconnectBackoff <= 0 {
+		client.maxReconnectBackoff = DefaultMaxReconnectBackoff
+	}
+
+	// Unless configured otherwise, the endpoints are reached through the target, as they
+	// are usually only listening on localhost there
+	var httpClient *http.Client
+	if !target.Endpoints.Direct {
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				DialContext:     client.dialTunnel,
+				MaxIdleConns:    2,
+				IdleConnTimeout: 90 * time.Second,
+			},
+		}
+	}
+	client.rpc = rpc.New(httpClient, target.Endpoints.Execution, target.Endpoints.Beacon, target.Endpoints.Timeout())
+
+	return client
+}
+
+// Alias returns the alias of the target
+func (client *SSHClient) Alias() string {
+	return client.TargetConfig.Alias
 }
 
 func (client *SSHClient) RunCommand(cmd string) (string, error) {
-	connection, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", client.TargetConfig.Host, client.TargetConfig.Port), client.Config)
+	return client.RunCommandContext(context.Background(), cmd)
+}
+
+// RunCommandContext runs a command on the target and returns its combined output.
+// If the context is cancelled before the command finishes, the remote process is
+// sent a SIGTERM and the session is torn down.
+func (client *SSHClient) RunCommandContext(ctx context.Context, cmd string) (string, error) {
+	return client.run(ctx, cmd, nil)
+}
+
+// WriteFile writes content to a file on the target. The content is streamed over the session's
+// stdin, so it doesn't need any shell quoting.
+func (client *SSHClient) WriteFile(ctx context.Context, filePath string, content []byte) error {
+	out, err := client.run(ctx, fmt.Sprintf(`sudo tee "%s" > /dev/null`, filePath), bytes.NewReader(content))
 	if err != nil {
-		return "", err
+		log.WithError(err).WithFields(log.Fields{
+			"host":     client.TargetConfig.Alias,
+			"filePath": filePath,
+			"output":   out,
+		}).Error("failed to write file")
+		return err
 	}
-	defer func() {
-		if err := connection.Close(); err != nil {
-			log.WithError(err).Warn("failed to close SSH connection")
+	return nil
+}
+
+// dialTunnel connects to addr as seen from the target, forwarding the connection over SSH
+func (client *SSHClient) dialTunnel(ctx context.Context, network, addr string) (net.Conn, error) {
+	connection, err := client.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connection.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to forward connection to %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// run executes cmd in a new session on the pooled connection, feeding it stdin if set, and returns its combined output
+func (client *SSHClient) run(ctx context.Context, cmd string, stdin io.Reader) (string, error) {
+	output := &outputBuffer{}
+	if err := client.runWithOutput(ctx, cmd, stdin, output, output); err != nil {
+		if ctx.Err() != nil {
+			return "", err
 		}
-	}()
+		return output.String(), err
+	}
+	return output.String(), nil
+}
 
-	session, err := connection.NewSession()
+// runUpload executes an upload command like run, passing the progress it reports to progress while it is running
+func (client *SSHClient) runUpload(ctx context.Context, cmd string, stdin io.Reader, progress func(types.UploadProgress)) (string, error) {
+	output := &outputBuffer{}
+	stdout := io.MultiWriter(output, rclone.NewProgressWriter(client.TargetConfig.Alias, progress))
+	stderr := io.MultiWriter(output, rclone.NewProgressWriter(client.TargetConfig.Alias, progress))
+	if err := client.runWithOutput(ctx, cmd, stdin, stdout, stderr); err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return output.String(), err
+	}
+	return output.String(), nil
+}
+
+// runWithOutput executes cmd in a new session, writing its stdout and stderr to the given writers. If the
+// context is cancelled before the command finishes, the remote process is sent a SIGTERM.
+func (client *SSHClient) runWithOutput(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	session, err := client.newSession(ctx)
 	if err != nil {
-		return "", err
+		return err
 	}
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
 	defer func() {
 		if err := session.Close(); err != nil {
 			// Check if error is EOF, which is expected when the server already closed the connection
@@ -123,18 +229,42 @@ func (client *SSHClient) RunCommand(cmd string) (string, error) {
 		}
 	}()
 
-	output, err := session.CombinedOutput(cmd)
-	if err != nil {
-		return string(output), err
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if err := session.Signal(ssh.SIGTERM); err != nil {
+			log.WithError(err).WithField("host", client.TargetConfig.Alias).Debug("failed to signal remote command")
+		}
+		return ctx.Err()
 	}
+}
+
+// outputBuffer collects the output of a command. Stdout and stderr are copied concurrently, so writes are serialized.
+type outputBuffer struct {
+	mu     sync.Mutex
+	output bytes.Buffer
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.output.Write(p)
+}
 
-	return string(output), nil
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.output.String()
 }
 
-func (client *SSHClient) GetSyncStatusCL() (*types.BeaconV1NodeSyncing, error) {
-	out, err := client.RunCommand(fmt.Sprintf(`bash -ac "
-		curl -s %s/eth/v1/node/syncing | jq -r ".data"
-	"`, client.TargetConfig.Endpoints.Beacon))
+func (client *SSHClient) GetSyncStatusCL(ctx context.Context) (*types.BeaconV1NodeSyncing, error) {
+	status, err := client.rpc.BeaconSyncStatus(ctx)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err":  err,
@@ -142,107 +272,66 @@ func (client *SSHClient) GetSyncStatusCL() (*types.BeaconV1NodeSyncing, error) {
 		}).Warn("failed getting CL sync status")
 		return nil, err
 	}
-
-	var status types.BeaconV1NodeSyncing
-	err = json.Unmarshal([]byte(out), &status)
-	if err != nil {
-		return nil, err
-	}
-	return &status, nil
+	return status, nil
 }
 
-func (client *SSHClient) DumpLatestBlockToFile(filePath string) error {
-	cmd := fmt.Sprintf(`
-	curl -s -X POST -H "Content-Type: application/json" --data '{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["latest",true],"id":1}' %s |
-	jq -r "." | sudo tee %s`, client.TargetConfig.Endpoints.Execution, filePath)
-	out, err := client.RunCommand(cmd)
+func (client *SSHClient) DumpExecutionRPCRequestToFile(ctx context.Context, payload, filePath string) error {
+	out, err := client.rpc.CallRaw(ctx, payload)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
+			"host":     client.TargetConfig.Alias,
 			"filePath": filePath,
-			"out":      out,
-		}).Error("failed to dump latest block info to file")
+		}).Error("failed to dump execution rpc response to file")
 		return err
 	}
-	return nil
+	return client.WriteFile(ctx, filePath, out)
 }
 
-func (client *SSHClient) DumpExecutionRPCRequestToFile(payload, filePath string) error {
-	cmd := fmt.Sprintf(`
-	curl -s -X POST -H "Content-Type: application/json" --data '%s' %s |
-	jq -r "." | sudo tee %s`, payload, client.TargetConfig.Endpoints.Execution, filePath)
-	out, err := client.RunCommand(cmd)
+func (client *SSHClient) GetSyncStatusEL(ctx context.Context) (bool, error) {
+	syncing, progress, err := client.rpc.Syncing(ctx)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"filePath": filePath,
-			"out":      out,
-		}).Error("failed to dump latest block info to file")
-		return err
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL sync status")
+		return true, err
 	}
-	return nil
-}
-
-func (client *SSHClient) GetSyncStatusEL() (bool, error) {
-	out, err := client.RunCommand(fmt.Sprintf(`
-		curl -s -X POST -H "Content-Type: application/json" --data '{"jsonrpc":"2.0","method":"eth_syncing","params":[],"id":1}' %s | jq -r ".result"
-	`, client.TargetConfig.Endpoints.Execution))
-	if err != nil {
-		log.WithError(err).Warn("failed getting EL sync status")
-	}
-	isSyncing, err := strconv.ParseBool(strings.TrimSuffix(out, "\n"))
-	if err != nil {
-		isSyncing = true
-		syncingResp := struct {
-			StartingBlock string `json:"startingBlock"`
-			CurrentBlock  string `json:"currentBlock"`
-			HighestBlock  string `json:"highestBlock"`
-		}{}
-		err = json.Unmarshal([]byte(out), &syncingResp)
-		if err != nil {
-			log.WithError(err).WithField("output", out).Error("failed parsing EL sync status output")
-		} else {
-			log.WithFields(log.Fields{
-				"startingBlock": syncingResp.StartingBlock,
-				"currentBlock":  syncingResp.CurrentBlock,
-				"highestBlock":  syncingResp.HighestBlock,
-			}).Warn("EL is syncing")
-		}
-		return isSyncing, err
+	if progress != nil {
+		log.WithFields(log.Fields{
+			"host":          client.TargetConfig.Alias,
+			"startingBlock": progress.StartingBlock,
+			"currentBlock":  progress.CurrentBlock,
+			"highestBlock":  progress.HighestBlock,
+		}).Warn("EL is syncing")
 	}
-	return isSyncing, nil
+	return syncing, nil
 }
 
-func (client *SSHClient) GetELBlockNumber() (string, error) {
-	out, err := client.RunCommand(fmt.Sprintf(`
-		curl -s -X POST -H "Content-Type: application/json" --data '{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}' %s | jq -r ".result"
-	`, client.TargetConfig.Endpoints.Execution))
+func (client *SSHClient) GetELBlockNumber(ctx context.Context) (string, error) {
+	block, err := client.rpc.BlockNumber(ctx)
 	if err != nil {
-		log.WithError(err).Warn("failed getting EL block")
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL block")
 		return "", err
 	}
-	return strings.TrimSuffix(out, "\n"), nil
+	return block, nil
 }
 
-func (client *SSHClient) GetELChainID() (string, error) {
-	out, err := client.RunCommand(fmt.Sprintf(`
-		curl -s -X POST -H "Content-Type: application/json" --data '{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}' %s | jq -r ".result"
-	`, client.TargetConfig.Endpoints.Execution))
+func (client *SSHClient) GetELChainID(ctx context.Context) (string, error) {
+	chainID, err := client.rpc.ChainID(ctx)
 	if err != nil {
-		log.WithError(err).WithField("output", out).Warn("failed getting EL chain id")
+		log.WithError(err).WithField("host", client.TargetConfig.Alias).Warn("failed getting EL chain id")
 		return "", err
 	}
-	return strings.TrimSuffix(out, "\n"), nil
+	return chainID, nil
 }
 
-func (client *SSHClient) StopDockerContainer(name string) error {
-	return client.StopDockerContainerWithForce(name, false)
+func (client *SSHClient) StopDockerContainer(ctx context.Context, name string) error {
+	return client.StopDockerContainerWithForce(ctx, name, false)
 }
 
-func (client *SSHClient) StopDockerContainerWithForce(name string, force bool) error {
+func (client *SSHClient) StopDockerContainerWithForce(ctx context.Context, name string, force bool) error {
 	args := ""
 	if force {
 		args += "-t 0"
 	}
-	out, err := client.RunCommand(fmt.Sprintf(`docker stop %s "%s"`, args, name))
+	out, err := client.RunCommandContext(ctx, fmt.Sprintf(`docker stop %s "%s"`, args, name))
 	log.WithFields(log.Fields{
 		"host":      client.TargetConfig.Alias,
 		"container": name,
@@ -257,8 +346,8 @@ func (client *SSHClient) StopDockerContainerWithForce(name string, force bool) e
 	return nil
 }
 
-func (client *SSHClient) StartDockerContainer(name string) error {
-	out, err := client.RunCommand(fmt.Sprintf(`docker start "%s"`, name))
+func (client *SSHClient) StartDockerContainer(ctx context.Context, name string) error {
+	out, err := client.RunCommandContext(ctx, fmt.Sprintf(`docker start "%s"`, name))
 	log.WithFields(log.Fields{
 		"host":      client.TargetConfig.Alias,
 		"container": name,
@@ -273,33 +362,46 @@ func (client *SSHClient) StartDockerContainer(name string) error {
 	return nil
 }
 
-func (client *SSHClient) StopSnooper() error {
-	return client.StopDockerContainerWithForce(client.TargetConfig.DockerContainers.EngineSnooper, true)
+// StopComponent stops a component of the node
+func (client *SSHClient) StopComponent(ctx context.Context, c config.ComponentConfig) error {
+	return client.runComponent(ctx, c, component.Stop)
 }
 
-func (client *SSHClient) StartSnooper() error {
-	return client.StartDockerContainer(client.TargetConfig.DockerContainers.EngineSnooper)
+// StartComponent starts a component of the node
+func (client *SSHClient) StartComponent(ctx context.Context, c config.ComponentConfig) error {
+	return client.runComponent(ctx, c, component.Start)
 }
 
-func (client *SSHClient) StopEL() error {
-	return client.StopDockerContainer(client.TargetConfig.DockerContainers.Execution)
+// RestartComponent restarts a component of the node
+func (client *SSHClient) RestartComponent(ctx context.Context, c config.ComponentConfig) error {
+	return client.runComponent(ctx, c, component.Restart)
 }
 
-func (client *SSHClient) StartEL() error {
-	return client.StartDockerContainer(client.TargetConfig.DockerContainers.Execution)
-}
-
-func (client *SSHClient) RestartBeacon() error {
-	err := client.StopDockerContainer(client.TargetConfig.DockerContainers.Beacon)
+func (client *SSHClient) runComponent(ctx context.Context, c config.ComponentConfig, action string) error {
+	cmd, err := component.Command(c, action, true)
 	if err != nil {
 		return err
 	}
-	return client.StartDockerContainer(client.TargetConfig.DockerContainers.Beacon)
+	log.WithFields(log.Fields{
+		"host":      client.TargetConfig.Alias,
+		"component": c.Name,
+		"action":    action,
+	}).Debug("controlling component")
+	out, err := client.RunCommandContext(ctx, cmd)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"component": c.Name,
+			"action":    action,
+			"output":    out,
+		}).Warn("failed to control component")
+		return fmt.Errorf("failed to %s %s: %w", action, c.Name, err)
+	}
+	return nil
 }
 
-func (client *SSHClient) GetDockerContainerImage(containerName string) (string, error) {
+func (client *SSHClient) GetDockerContainerImage(ctx context.Context, containerName string) (string, error) {
 	cmd := fmt.Sprintf(`docker inspect --format='{{.Config.Image}}' "%s"`, containerName)
-	out, err := client.RunCommand(cmd)
+	out, err := client.RunCommandContext(ctx, cmd)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
 			"container": containerName,
@@ -310,15 +412,142 @@ func (client *SSHClient) GetDockerContainerImage(containerName string) (string,
 	return strings.TrimSpace(out), nil
 }
 
-func (client *SSHClient) RCloneSyncLocalToRemote(srcDir, uploadPrefix string, blockNumber uint64) error {
+// uploadContainerName returns the name of the rclone container used for uploads on this target
+func (client *SSHClient) uploadContainerName() string {
+	return "snapshotter-upload-" + client.TargetConfig.Alias
+}
+
+// IsComponentRunning reports whether the given component is currently running
+func (client *SSHClient) IsComponentRunning(ctx context.Context, c config.ComponentConfig) (bool, error) {
+	cmd, err := component.RunningCommand(c)
+	if err != nil {
+		return false, err
+	}
+	out, err := client.RunCommandContext(ctx, cmd)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"component": c.Name,
+			"output":    out,
+		}).Warn("failed to inspect component")
+		return false, err
+	}
+	return component.Running(c, out), nil
+}
+
+// EnsureContainersRunning starts the components of the node that are not running, except those left alone
+// during snapshots
+func (client *SSHClient) EnsureContainersRunning(ctx context.Context) error {
+	for _, c := range client.TargetConfig.Components {
+		if c.OnSnapshot == config.ComponentNone {
+			continue
+		}
+		running, err := client.IsComponentRunning(ctx, c)
+		if err != nil {
+			return err
+		}
+		if running {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"host":      client.TargetConfig.Alias,
+			"component": c.Name,
+		}).Warn("component is not running, starting it")
+		if err := client.StartComponent(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dockerState is the subset of the State of docker inspect we care about
+type dockerState struct {
+	Status   string `json:"Status"`
+	Running  bool   `json:"Running"`
+	ExitCode int    `json:"ExitCode"`
+	Health   *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+// InspectContainers returns the state of the docker container components of the node
+func (client *SSHClient) InspectContainers(ctx context.Context) ([]types.ContainerState, error) {
+	var states []types.ContainerState
+	for _, c := range client.TargetConfig.ComponentsOfType(config.ComponentDocker) {
+		out, err := client.RunCommandContext(ctx, fmt.Sprintf(`docker inspect --format='{{json .State}}' "%s"`, c.Name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect container %s: %w: %s", c.Name, err, strings.TrimSpace(out))
+		}
+		var state dockerState
+		if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &state); err != nil {
+			return nil, fmt.Errorf("failed to decode state of container %s: %w", c.Name, err)
+		}
+		cs := types.ContainerState{
+			Role:     c.Role,
+			Name:     c.Name,
+			Status:   state.Status,
+			Running:  state.Running,
+			ExitCode: state.ExitCode,
+		}
+		if state.Health != nil {
+			cs.Health = state.Health.Status
+		}
+		states = append(states, cs)
+	}
+	return states, nil
+}
+
+// StartContainer starts a stopped container of the node
+func (client *SSHClient) StartContainer(ctx context.Context, name string) error {
+	return client.StartDockerContainer(ctx, name)
+}
+
+// RestartContainer restarts a container of the node
+func (client *SSHClient) RestartContainer(ctx context.Context, name string) error {
+	out, err := client.RunCommandContext(ctx, fmt.Sprintf(`docker restart "%s"`, name))
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"container": name,
+			"output":    out,
+		}).Warn("failed to restart container")
+		return err
+	}
+	return nil
+}
+
+// AbortUpload stops the upload agent, or force removes the rclone upload container, if any
+func (client *SSHClient) AbortUpload() error {
+	if client.agent != nil {
+		return client.abortAgentUpload()
+	}
+	out, err := client.RunCommand(fmt.Sprintf(`docker rm -f "%s"`, client.uploadContainerName()))
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"container": client.uploadContainerName(),
+			"output":    out,
+		}).Warn("failed to remove upload container")
+		return err
+	}
+	return nil
+}
+
+// UploadSnapshot uploads the data dir of the target via the upload agent if enabled, or rclone otherwise
+func (client *SSHClient) UploadSnapshot(ctx context.Context, srcDir, uploadPrefix string, blockNumber uint64, progress func(types.UploadProgress)) (*types.SnapshotManifest, error) {
+	if client.agent != nil {
+		return client.AgentUpload(ctx, srcDir, uploadPrefix, blockNumber, progress)
+	}
+	return client.RCloneSyncLocalToRemote(ctx, srcDir, uploadPrefix, blockNumber, progress)
+}
+
+// writeSnapshotMetadata writes _snapshot_metadata.json to the data dir, which is uploaded next to the archive
+func (client *SSHClient) writeSnapshotMetadata(ctx context.Context, srcDir string) error {
 	// Get Docker image information for metadata
-	metadata := SnapshotMetadata{
+	metadata := types.SnapshotMetadata{
 		Static: client.TargetConfig.Metadata,
 	}
 
 	// Get the execution container image if available
-	if client.TargetConfig.DockerContainers.Execution != "" {
-		dockerImage, err := client.GetDockerContainerImage(client.TargetConfig.DockerContainers.Execution)
+	if execution := client.TargetConfig.ExecutionComponent(); execution != nil && execution.Type == config.ComponentDocker {
+		dockerImage, err := client.GetDockerContainerImage(ctx, execution.Name)
 		if err == nil {
 			metadata.DockerImage = dockerImage
 		} else {
@@ -335,91 +564,48 @@ func (client *SSHClient) RCloneSyncLocalToRemote(srcDir, uploadPrefix string, bl
 
 	// Write metadata to file
 	metadataFile := fmt.Sprintf("%s/_snapshot_metadata.json", srcDir)
-	metadataCmd := fmt.Sprintf(`echo '%s' | sudo tee %s`, string(metadataJSON), metadataFile)
-	_, err = client.RunCommand(metadataCmd)
-	if err != nil {
+	if err := client.WriteFile(ctx, metadataFile, metadataJSON); err != nil {
 		log.WithError(err).Error("failed to write snapshot metadata file")
 		return err
 	}
+	return nil
+}
 
-	cmd := "docker run --rm" +
-		" -v " + srcDir + ":" + srcDir
-
-	// Use default entrypoint if not specified
-	entrypoint := client.RCloneConfig.Entrypoint
-	if entrypoint == "" {
-		entrypoint = config.GetDefaultRCloneConfig().Entrypoint
+func (client *SSHClient) RCloneSyncLocalToRemote(ctx context.Context, srcDir, uploadPrefix string, blockNumber uint64, progress func(types.UploadProgress)) (*types.SnapshotManifest, error) {
+	if err := client.writeSnapshotMetadata(ctx, srcDir); err != nil {
+		return nil, err
 	}
-	cmd += " --entrypoint " + entrypoint
 
-	// Add environment variables
-	if client.RCloneConfig.Env != nil {
-		for k, v := range client.RCloneConfig.Env {
-			cmd += fmt.Sprintf(" -e %s=%s", k, v)
-		}
-	}
+	cmd := "docker run --rm" +
+		" --name " + client.uploadContainerName() +
+		" -v " + srcDir + ":" + srcDir +
+		" --entrypoint " + rclone.Entrypoint(client.RCloneConfig)
 
-	// Get command template, using default if not specified
-	cmdTemplate := client.RCloneConfig.CommandTemplate
-	if cmdTemplate == "" {
-		// If we don't have the template directly, use the default from config package
-		// This fallback should rarely happen since we set defaults in config.ReadFromFile
-		log.Debug("RClone command template not specified, using default from config package")
-		cmdTemplate = config.GetDefaultRCloneConfig().CommandTemplate
+	// Add environment variables
+	for _, e := range rclone.Env(client.RCloneConfig) {
+		cmd += " -e " + e
 	}
 
-	tmpl, err := template.New("cmd").Parse(cmdTemplate)
+	rcloneCmd, err := rclone.BuildCommand(client.RCloneConfig, srcDir, uploadPrefix, blockNumber)
 	if err != nil {
-		log.WithError(err).Error("failed to parse rclone cmd template")
-		return err
+		log.WithError(err).Error("failed to build rclone command")
+		return nil, err
 	}
 
-	// Get bucket name from RClone config environment variables
-	// This is set in config.ReadFromFile from the s3 configuration
-	bucketName := ""
-	if client.RCloneConfig.Env != nil {
-		if val, exists := client.RCloneConfig.Env["RCLONE_CONFIG_MYS3_BUCKET_NAME"]; exists && val != "" {
-			bucketName = val
+	cmd += " " + rclone.Image(client.RCloneConfig) + " " + rcloneCmd
+	out, err := client.runUpload(ctx, cmd, nil, progress)
+	if ctx.Err() != nil {
+		// The upload container keeps running after the SSH session is gone, so remove it explicitly
+		log.WithField("host", client.TargetConfig.Alias).Warn("upload cancelled, removing upload container")
+		if abortErr := client.AbortUpload(); abortErr != nil {
+			log.WithError(abortErr).Error("failed to abort upload")
 		}
+		return nil, ctx.Err()
 	}
-
-	// If not found in rclone env, use the default
-	if bucketName == "" {
-		log.Warn("Bucket name not found in RClone config environment variables, using default")
-		// Use a default if no environment variable is set
-		bucketName = "ethpandaops-ethereum-node-snapshots"
-	}
-
-	cmdVars := struct {
-		DataDir          string
-		UploadPathPrefix string
-		BucketName       string
-		BlockNumber      uint64
-	}{
-		DataDir:          srcDir,
-		UploadPathPrefix: uploadPrefix,
-		BucketName:       bucketName,
-		BlockNumber:      blockNumber,
-	}
-
-	var rcloneCmd bytes.Buffer
-	if err := tmpl.Execute(&rcloneCmd, cmdVars); err != nil {
-		log.WithError(err).Error("failed to execute rclone cmd template")
-		return err
-	}
-
-	// Use default version if not specified
-	version := client.RCloneConfig.Version
-	if version == "" {
-		version = config.GetDefaultRCloneConfig().Version
-	}
-
-	cmd += " rclone/rclone:" + version + " " + rcloneCmd.String()
-	out, err := client.RunCommand(cmd)
 	if err != nil {
 		log.WithError(err).WithField("output", out).Error("failed to rclone sync")
-		return err
+		return nil, err
 	}
 
-	return nil
+	return rclone.ParseManifest(out)
 }
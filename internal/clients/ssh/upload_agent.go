@@ -0,0 +1,192 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	uploadagent "github.com/ethpandaops/eth-snapshotter/internal/agent"
+	"github.com/ethpandaops/eth-snapshotter/internal/clients/rclone"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// agentUploader holds the settings of uploads through the upload agent
+type agentUploader struct {
+	cfg  config.AgentConfig
+	dest uploadagent.Destination
+
+	// The binary is hashed once to check whether the target already has the right one installed
+	hashOnce sync.Once
+	hash     string
+	hashErr  error
+}
+
+// UseAgent makes the client upload snapshots through the upload agent instead of an rclone container.
+// The snapshotter binary is installed on the target on the first upload.
+func (client *SSHClient) UseAgent(cfg config.AgentConfig, dest uploadagent.Destination) {
+	client.agent = &agentUploader{cfg: cfg, dest: dest}
+}
+
+// binary returns the local path of the agent binary
+func (a *agentUploader) binary() (string, error) {
+	if a.cfg.Binary != "" {
+		return a.cfg.Binary, nil
+	}
+	return os.Executable()
+}
+
+// binaryHash returns the SHA-256 of the local agent binary
+func (a *agentUploader) binaryHash() (string, error) {
+	a.hashOnce.Do(func() {
+		binary, err := a.binary()
+		if err != nil {
+			a.hashErr = err
+			return
+		}
+		f, err := os.Open(binary)
+		if err != nil {
+			a.hashErr = err
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			a.hashErr = err
+			return
+		}
+		a.hash = hex.EncodeToString(h.Sum(nil))
+	})
+	return a.hash, a.hashErr
+}
+
+// agentPidDir holds the pid files of running upload agents. It is only writable by root, as the pid is
+// signalled with sudo.
+const agentPidDir = "/run/snapshotter"
+
+// agentPidFile is where the pid of a running upload agent is kept, so it can be stopped
+func (client *SSHClient) agentPidFile() string {
+	return agentPidDir + "/agent-" + client.TargetConfig.Alias + ".pid"
+}
+
+// ensureAgent installs the agent binary on the target, unless the same binary is installed already
+func (client *SSHClient) ensureAgent(ctx context.Context) error {
+	hash, err := client.agent.binaryHash()
+	if err != nil {
+		return fmt.Errorf("failed to read agent binary: %w", err)
+	}
+	remotePath := client.agent.cfg.RemotePath()
+
+	out, err := client.RunCommandContext(ctx, fmt.Sprintf(`sha256sum "%s" 2>/dev/null | cut -d ' ' -f 1`, remotePath))
+	if err == nil && strings.TrimSpace(out) == hash {
+		return nil
+	}
+
+	// Without an explicitly configured binary, the running one is copied, which only works on the same platform
+	if client.agent.cfg.Binary == "" {
+		out, err := client.RunCommandContext(ctx, "uname -sm")
+		if err != nil {
+			return fmt.Errorf("failed to get platform of target: %w", err)
+		}
+		if platform := strings.Fields(strings.ToLower(out)); len(platform) != 2 || platform[0] != runtime.GOOS || unameArch(platform[1]) != runtime.GOARCH {
+			return fmt.Errorf("target runs on %s but the snapshotter is built for %s/%s, configure an agent binary for the target", strings.TrimSpace(out), runtime.GOOS, runtime.GOARCH)
+		}
+	}
+
+	binary, _ := client.agent.binary()
+	content, err := os.ReadFile(binary)
+	if err != nil {
+		return fmt.Errorf("failed to read agent binary: %w", err)
+	}
+	log.WithFields(log.Fields{
+		"host": client.TargetConfig.Alias,
+		"path": remotePath,
+	}).Info("installing upload agent on target")
+	if err := client.WriteFile(ctx, remotePath+".tmp", content); err != nil {
+		return fmt.Errorf("failed to copy agent binary: %w", err)
+	}
+	if out, err := client.RunCommandContext(ctx, fmt.Sprintf(`sudo chmod 0755 "%[1]s.tmp" && sudo mv "%[1]s.tmp" "%[1]s"`, remotePath)); err != nil {
+		return fmt.Errorf("failed to install agent binary: %w: %s", err, out)
+	}
+	return nil
+}
+
+// unameArch maps the machine reported by uname to the GOARCH naming
+func unameArch(machine string) string {
+	switch machine {
+	case "x86_64":
+		return "amd64"
+	case "aarch64", "arm64":
+		return "arm64"
+	}
+	return machine
+}
+
+// AgentUpload uploads the data dir of the target by running the upload agent on it. The job, including the
+// S3 credentials, is passed on stdin. Progress reported by the agent is passed to progress, if set.
+func (client *SSHClient) AgentUpload(ctx context.Context, srcDir, uploadPrefix string, blockNumber uint64, progress func(types.UploadProgress)) (*types.SnapshotManifest, error) {
+	if err := client.ensureAgent(ctx); err != nil {
+		return nil, err
+	}
+	if err := client.writeSnapshotMetadata(ctx, srcDir); err != nil {
+		return nil, err
+	}
+
+	job, err := json.Marshal(uploadagent.Job{
+		Destination:  client.agent.dest,
+		DataDir:      srcDir,
+		UploadPrefix: uploadPrefix,
+		BlockNumber:  blockNumber,
+		PartSize:     int64(client.agent.cfg.PartSizeMB) << 20,
+		Concurrency:  client.agent.cfg.Concurrency,
+		ACL:          client.agent.cfg.ACL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The pid of the shell is the pid of sudo once it is exec'd, which forwards signals to the agent
+	cmd := fmt.Sprintf(`sudo install -d -m 0700 "%s" && echo $$ | sudo tee "%s" > /dev/null && exec sudo "%s" agent upload`,
+		agentPidDir, client.agentPidFile(), client.agent.cfg.RemotePath())
+	out, err := client.runUpload(ctx, cmd, bytes.NewReader(job), progress)
+	if ctx.Err() != nil {
+		// The signal sent on cancellation is not delivered by every SSH server, so stop the agent explicitly
+		log.WithField("host", client.TargetConfig.Alias).Warn("upload cancelled, stopping upload agent")
+		if abortErr := client.AbortUpload(); abortErr != nil {
+			log.WithError(abortErr).Error("failed to abort upload")
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.WithError(err).WithField("output", out).Error("upload agent failed")
+		return nil, err
+	}
+
+	return rclone.ParseManifest(out)
+}
+
+// abortAgentUpload stops a running upload agent, which aborts its multipart upload. The pid is only signalled
+// if it still runs the agent, as the pid file is left behind by agents that finished.
+func (client *SSHClient) abortAgentUpload() error {
+	pidFile := client.agentPidFile()
+	script := fmt.Sprintf(`f="%s"; if [ -f "$f" ]; then pid="$(cat "$f")"; `+
+		`if tr "\0" " " < "/proc/$pid/cmdline" 2>/dev/null | grep -q " agent upload"; then kill -TERM "$pid"; fi; rm -f "$f"; fi`, pidFile)
+	out, err := client.RunCommand(fmt.Sprintf(`sudo sh -c '%s'; true`, script))
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"pid_file": pidFile,
+			"output":   out,
+		}).Warn("failed to stop upload agent")
+		return err
+	}
+	return nil
+}
@@ -3,6 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -18,19 +22,36 @@ type Config struct {
 			KnownHostsPath           string `yaml:"known_hosts_path"`
 			InsecureIgnoreHostKey    bool   `yaml:"ignore_host_key"`
 			UseAgent                 bool   `yaml:"use_agent"`
+			// KeepaliveIntervalSeconds is how often keepalive requests are sent on the connection to each target
+			KeepaliveIntervalSeconds int `yaml:"keepalive_interval_seconds"`
+			// MaxReconnectBackoffSeconds caps the wait between reconnect attempts to an unreachable target
+			MaxReconnectBackoffSeconds int `yaml:"max_reconnect_backoff_seconds"`
 		} `yaml:"ssh"`
 		Snapshots struct {
-			CheckIntervalSeconds int           `yaml:"check_interval_seconds"`
-			BlockInterval        int           `yaml:"block_interval"`
-			DryRun               bool          `yaml:"dry_run"`
-			RunOnce              bool          `yaml:"run_once"`
-			Cleanup              CleanupConfig `yaml:"cleanup"`
-			RClone               RCloneConfig  `yaml:"rclone"`
-			S3                   S3Config      `yaml:"s3"`
+			CheckIntervalSeconds int  `yaml:"check_interval_seconds"`
+			BlockInterval        int  `yaml:"block_interval"`
+			DryRun               bool `yaml:"dry_run"`
+			RunOnce              bool `yaml:"run_once"`
+			// BlockTimeSeconds is the expected time between blocks, used to estimate when the next snapshot is due
+			BlockTimeSeconds int            `yaml:"block_time_seconds"`
+			Schedule         ScheduleConfig `yaml:"schedule"`
+			// HoldFailedUploads keeps targets whose upload failed stopped, so the upload can be retried at the same block
+			HoldFailedUploads bool               `yaml:"hold_failed_uploads"`
+			Verification      VerificationConfig `yaml:"verification"`
+			Index             IndexConfig        `yaml:"index"`
+			Cleanup           CleanupConfig      `yaml:"cleanup"`
+			Reconcile         ReconcileConfig    `yaml:"reconcile"`
+			Uploader          UploaderConfig     `yaml:"uploader"`
+			RClone            RCloneConfig       `yaml:"rclone"`
+			S3                S3Config           `yaml:"s3"`
+			// Replication copies every uploaded snapshot from the S3 bucket to further destinations
+			Replication ReplicationConfig `yaml:"replication"`
 		} `yaml:"snapshots"`
 		Database struct {
 			Path string `yaml:"path"`
 		} `yaml:"database"`
+		Notifications NotificationsConfig `yaml:"notifications"`
+		Watchdog      WatchdogConfig      `yaml:"watchdog"`
 	} `yaml:"global"`
 	Server struct {
 		ListenAddr string `yaml:"listen_addr"`
@@ -39,14 +60,347 @@ type Config struct {
 		} `yaml:"auth"`
 	} `yaml:"server"`
 	Targets struct {
-		SSH []SSHTargetConfig `yaml:"ssh"`
+		SSH        []SSHTargetConfig        `yaml:"ssh"`
+		Local      []LocalTargetConfig      `yaml:"local"`
+		Kubernetes []KubernetesTargetConfig `yaml:"kubernetes"`
 	} `yaml:"targets"`
 }
 
+// ScheduleConfig holds the wall-clock schedule of snapshots, in addition to block_interval.
+// Cron expressions use the standard 5 field format and are evaluated in UTC unless prefixed
+// with CRON_TZ=<zone>.
+type ScheduleConfig struct {
+	// Cron triggers a snapshot at the given times, e.g. "0 2 * * 1,4" for Monday and Thursday at 02:00 UTC
+	Cron string `yaml:"cron"`
+	// MinIntervalMinutes is the minimum wall-clock time between the start of two runs of a group
+	MinIntervalMinutes int `yaml:"min_interval_minutes"`
+	// MaintenanceWindows are periods during which no snapshot is started
+	MaintenanceWindows []MaintenanceWindowConfig `yaml:"maintenance_windows"`
+}
+
+// MaintenanceWindowConfig is a recurring window starting at every time matched by Cron and lasting DurationMinutes
+type MaintenanceWindowConfig struct {
+	Cron            string `yaml:"cron"`
+	DurationMinutes int    `yaml:"duration_minutes"`
+}
+
+// VerificationConfig configures restoring uploaded snapshots on a verifier host and booting a client against
+// them, to check that they are usable. Only targets with a verify image configured are verified.
+type VerificationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Cron verifies the most recent pending snapshot of every target at the given times. Without it,
+	// the snapshots of a run are verified as soon as the run succeeded.
+	Cron string `yaml:"cron"`
+	// GateLatest only moves the latest pointer to a block once all snapshots taken at it are verified
+	GateLatest bool `yaml:"gate_latest"`
+	// TimeoutMinutes bounds the verification of a single snapshot including its download, defaults to 240
+	TimeoutMinutes int `yaml:"timeout_minutes"`
+	// Host is the verifier host, reached over SSH with the global ssh settings. It needs docker, curl, tar and zstd.
+	Host string `yaml:"host"`
+	User string `yaml:"user"`
+	Port int    `yaml:"port"`
+	// WorkDir is the directory snapshots are extracted to on the verifier host, defaults to /tmp/snapshot-verification
+	WorkDir string `yaml:"work_dir"`
+}
+
+// Timeout returns the configured verification timeout, or the default of 4 hours
+func (v VerificationConfig) Timeout() time.Duration {
+	if v.TimeoutMinutes <= 0 {
+		return 4 * time.Hour
+	}
+	return time.Duration(v.TimeoutMinutes) * time.Minute
+}
+
+// VerifyConfig describes how the client of a target is booted against a restored snapshot
+type VerifyConfig struct {
+	// Image is the client image to start, targets without one are not verified
+	Image string `yaml:"image"`
+	// Command is passed to the image and has to enable the JSON-RPC API on all interfaces,
+	// e.g. ["--datadir=/data", "--http", "--http.addr=0.0.0.0"]
+	Command []string `yaml:"command"`
+	// DataDir is where the restored data directory is mounted in the container, defaults to /data
+	DataDir string `yaml:"data_dir"`
+	// RPCPort is the JSON-RPC port within the container, defaults to 8545
+	RPCPort int `yaml:"rpc_port"`
+}
+
+// IndexConfig configures the snapshot catalog published as index.json and index.html at the root prefix of the bucket
+type IndexConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Title is the heading of index.html, defaults to "Snapshots"
+	Title string `yaml:"title"`
+}
+
+// Types of replication destinations
+const (
+	StorageS3    = "s3"
+	StorageGCS   = "gcs"
+	StorageLocal = "local"
+	StorageHTTP  = "http"
+)
+
+// ReplicationConfig configures the destinations snapshots are copied to once the targets uploaded them to
+// the S3 bucket. The copies keep the keys of the bucket.
+type ReplicationConfig struct {
+	Destinations []DestinationConfig `yaml:"destinations"`
+}
+
+// DestinationConfig is a replication destination
+type DestinationConfig struct {
+	// Name identifies the destination in the status of the target snapshots
+	Name string `yaml:"name"`
+	// Type is s3, gcs, local or http
+	Type string `yaml:"type"`
+	// Endpoint, Region, BucketName and the keys configure s3 and gcs destinations. GCS is used through its
+	// S3 compatible API with HMAC keys, the endpoint defaults to https://storage.googleapis.com.
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	BucketName      string `yaml:"bucket_name"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Path is the directory local destinations write to, e.g. an NFS mount
+	Path string `yaml:"path"`
+	// URL is the base URL objects are PUT to by http destinations, as <url>/<key>
+	URL string `yaml:"url"`
+	// Headers are sent with every request to http destinations, e.g. Authorization
+	Headers map[string]string `yaml:"headers"`
+}
+
+// validate checks a destination has the settings its type needs
+func (d DestinationConfig) validate() error {
+	switch d.Type {
+	case StorageS3, StorageGCS:
+		if d.BucketName == "" {
+			return fmt.Errorf("replication destination %q has no bucket_name", d.Name)
+		}
+		if d.Type == StorageS3 && d.Endpoint == "" {
+			return fmt.Errorf("replication destination %q has no endpoint", d.Name)
+		}
+		if d.AccessKeyID == "" || d.SecretAccessKey == "" {
+			return fmt.Errorf("replication destination %q has no access_key_id or secret_access_key", d.Name)
+		}
+	case StorageLocal:
+		if d.Path == "" {
+			return fmt.Errorf("replication destination %q has no path", d.Name)
+		}
+	case StorageHTTP:
+		if d.URL == "" {
+			return fmt.Errorf("replication destination %q has no url", d.Name)
+		}
+	default:
+		return fmt.Errorf("replication destination %q has unknown type %q, expected %s, %s, %s or %s", d.Name, d.Type, StorageS3, StorageGCS, StorageLocal, StorageHTTP)
+	}
+	return nil
+}
+
+// validate checks the destinations have unique names and the settings their types need
+func (r ReplicationConfig) validate() error {
+	names := make(map[string]bool)
+	for _, d := range r.Destinations {
+		if d.Name == "" {
+			return fmt.Errorf("replication destination without name")
+		}
+		if names[d.Name] {
+			return fmt.Errorf("replication destination %q is configured more than once", d.Name)
+		}
+		names[d.Name] = true
+		if err := d.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type CleanupConfig struct {
 	Enabled            bool `yaml:"enabled"`
 	KeepCount          int  `yaml:"keep_count"`
 	CheckIntervalHours int  `yaml:"check_interval_hours"`
+	// Retention replaces keep_count, which keeps the most recent runs of every group, with rules applied
+	// to the snapshots of every target
+	Retention RetentionConfig `yaml:"retention"`
+	// FailedUploadGraceHours is how long the objects left by failed uploads are kept for a retry before they
+	// are deleted, 72 hours by default. A negative value keeps them.
+	FailedUploadGraceHours int `yaml:"failed_upload_grace_hours"`
+}
+
+// FailedUploadGrace returns how long the objects of failed uploads are kept, or false if they are never deleted
+func (c CleanupConfig) FailedUploadGrace() (time.Duration, bool) {
+	switch {
+	case c.FailedUploadGraceHours < 0:
+		return 0, false
+	case c.FailedUploadGraceHours == 0:
+		return 72 * time.Hour, true
+	}
+	return time.Duration(c.FailedUploadGraceHours) * time.Hour, true
+}
+
+// ReconcileConfig configures the periodic comparison of the snapshots in the bucket with the target snapshots
+// in the database
+type ReconcileConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	CheckIntervalHours int  `yaml:"check_interval_hours"`
+	// DeleteOrphans deletes the snapshot prefixes in the bucket that no successful target snapshot points at
+	DeleteOrphans bool `yaml:"delete_orphans"`
+	// MarkMissing marks successful target snapshots whose archive isn't in the bucket as missing
+	MarkMissing bool `yaml:"mark_missing"`
+	// GraceHours leaves prefixes with objects modified more recently alone, as an upload may be writing to them
+	GraceHours int `yaml:"grace_hours"`
+}
+
+// Grace returns the time orphan prefixes are left alone after their last modification, 24 hours by default
+func (r ReconcileConfig) Grace() time.Duration {
+	if r.GraceHours <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(r.GraceHours) * time.Hour
+}
+
+// RetentionRules decide which snapshots of a target are kept. A snapshot is kept if any rule keeps it.
+type RetentionRules struct {
+	// KeepLast keeps the most recent snapshots
+	KeepLast int `yaml:"keep_last"`
+	// KeepDaily, KeepWeekly and KeepMonthly keep the most recent snapshot of as many days, ISO weeks and
+	// months (UTC) with snapshots
+	KeepDaily   int `yaml:"keep_daily"`
+	KeepWeekly  int `yaml:"keep_weekly"`
+	KeepMonthly int `yaml:"keep_monthly"`
+	// MaxAgeDays deletes snapshots older than this many days, even if another rule keeps them.
+	// Without any keep rule, all snapshots younger than this are kept.
+	MaxAgeDays int `yaml:"max_age_days"`
+}
+
+// IsZero reports whether no rule is set
+func (r RetentionRules) IsZero() bool {
+	return r == RetentionRules{}
+}
+
+func (r RetentionRules) validate(scope string) error {
+	for name, v := range map[string]int{
+		"keep_last":    r.KeepLast,
+		"keep_daily":   r.KeepDaily,
+		"keep_weekly":  r.KeepWeekly,
+		"keep_monthly": r.KeepMonthly,
+		"max_age_days": r.MaxAgeDays,
+	} {
+		if v < 0 {
+			return fmt.Errorf("retention %s of %s can't be negative", name, scope)
+		}
+	}
+	return nil
+}
+
+// RetentionConfig holds the default retention rules and their overrides by network and alias. The network of
+// a target is its upload_prefix without the last element, e.g. hoodi for hoodi/geth.
+type RetentionConfig struct {
+	RetentionRules `yaml:",inline"`
+	// Networks replace the default rules for the targets of a network
+	Networks map[string]RetentionRules `yaml:"networks"`
+	// Aliases replace the default and network rules for a target
+	Aliases map[string]RetentionRules `yaml:"aliases"`
+	// ForkBlocks are the hard fork blocks of each network. The last snapshot of every target before each of
+	// them is always kept.
+	ForkBlocks map[string][]uint64 `yaml:"fork_blocks"`
+}
+
+// Enabled reports whether retention rules are configured, otherwise keep_count applies
+func (r RetentionConfig) Enabled() bool {
+	return !r.RetentionRules.IsZero() || len(r.Networks) > 0 || len(r.Aliases) > 0 || len(r.ForkBlocks) > 0
+}
+
+// RulesFor returns the rules that apply to the target with the given alias on the given network
+func (r RetentionConfig) RulesFor(alias, network string) RetentionRules {
+	if rules, ok := r.Aliases[alias]; ok {
+		return rules
+	}
+	if rules, ok := r.Networks[network]; ok {
+		return rules
+	}
+	return r.RetentionRules
+}
+
+func (r RetentionConfig) validate() error {
+	if err := r.RetentionRules.validate("cleanup"); err != nil {
+		return err
+	}
+	for network, rules := range r.Networks {
+		if err := rules.validate("network " + network); err != nil {
+			return err
+		}
+	}
+	for alias, rules := range r.Aliases {
+		if err := rules.validate("alias " + alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchdogConfig configures the checks that the docker containers of SSH and local targets are running
+// after every run and periodically, and bringing them back up if they aren't
+type WatchdogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// IntervalMinutes is the time between periodic checks, defaults to 5
+	IntervalMinutes int `yaml:"interval_minutes"`
+	// RestartUnhealthy restarts running containers whose health check reports them unhealthy.
+	// Without it, only stopped containers are started.
+	RestartUnhealthy bool `yaml:"restart_unhealthy"`
+	// MaxBackoffMinutes caps the growing wait between attempts to bring up the same container, defaults to 60
+	MaxBackoffMinutes int `yaml:"max_backoff_minutes"`
+}
+
+// Interval returns the configured time between periodic checks, or the default of 5 minutes
+func (w WatchdogConfig) Interval() time.Duration {
+	if w.IntervalMinutes <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(w.IntervalMinutes) * time.Minute
+}
+
+// MaxBackoff returns the configured maximum wait between attempts, or the default of an hour
+func (w WatchdogConfig) MaxBackoff() time.Duration {
+	if w.MaxBackoffMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(w.MaxBackoffMinutes) * time.Minute
+}
+
+const (
+	// NotifierWebhook posts the JSON of notifications, signed with HMAC-SHA256 if a secret is set
+	NotifierWebhook = "webhook"
+	// NotifierSlack posts Slack incoming webhook messages
+	NotifierSlack = "slack"
+	// NotifierDiscord posts Discord webhook messages
+	NotifierDiscord = "discord"
+)
+
+// NotificationsConfig configures where the outcomes of runs, uploads and cleanups are sent
+type NotificationsConfig struct {
+	// StaleAfterIntervals notifies once a group didn't produce a successful snapshot for this many of its
+	// block intervals, or cron intervals for groups without one. Zero disables the check.
+	StaleAfterIntervals int              `yaml:"stale_after_intervals"`
+	Notifiers           []NotifierConfig `yaml:"notifiers"`
+}
+
+// NotifierConfig is a single destination of notifications
+type NotifierConfig struct {
+	Name string `yaml:"name"`
+	// Type is webhook, slack or discord
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	// Secret signs the payloads of webhook notifiers
+	Secret string `yaml:"secret"`
+	// Events limits the notifications sent, defaults to run_failed, upload_failed, cleanup_failed and snapshot_stale
+	Events []string `yaml:"events"`
+	// TimeoutSeconds bounds a single delivery attempt, defaults to 10
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+}
+
+// Timeout returns the configured delivery timeout, or the default of 10 seconds
+func (n NotifierConfig) Timeout() time.Duration {
+	if n.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(n.TimeoutSeconds) * time.Second
 }
 
 type S3Config struct {
@@ -56,23 +410,403 @@ type S3Config struct {
 	RootPrefix string `yaml:"root_prefix"`
 }
 
+// TargetConfig holds the settings shared by all target drivers
+type TargetConfig struct {
+	Alias        string            `yaml:"alias"`
+	DataDir      string            `yaml:"data_dir"`
+	UploadPrefix string            `yaml:"upload_prefix"`
+	Metadata     map[string]string `yaml:"metadata"`
+	Endpoints    EndpointsConfig   `yaml:"endpoints"`
+	// Group makes all targets with the same group name snapshot together, at the same block.
+	// Targets without a group are scheduled on their own.
+	Group string `yaml:"group"`
+	// BlockInterval overrides global.snapshots.block_interval for this target
+	BlockInterval int `yaml:"block_interval"`
+	// Verify configures the verification of the target's snapshots, see global.snapshots.verification
+	Verify VerifyConfig `yaml:"verify"`
+	// Hooks run shell commands on the target around the steps of a snapshot
+	Hooks HooksConfig `yaml:"hooks"`
+	// Components are the parts of the node and how they are handled for a snapshot. They default to the
+	// docker_containers of SSH and local targets, and the workloads of Kubernetes targets.
+	Components []ComponentConfig `yaml:"components"`
+}
+
+// Points of a snapshot hooks run at, in the order they are reached
+const (
+	// HookPreStop runs before the snooper is stopped
+	HookPreStop = "pre_stop"
+	// HookPostStop runs once the execution client is stopped
+	HookPostStop = "post_stop"
+	// HookPreUpload runs right before the data dir is uploaded
+	HookPreUpload = "pre_upload"
+	// HookPostUpload runs once the data dir is uploaded
+	HookPostUpload = "post_upload"
+	// HookPostStart runs once the containers are started again
+	HookPostStart = "post_start"
+)
+
+// Failure policies of hooks
+const (
+	// HookAbort fails the target snapshot if the hook fails
+	HookAbort = "abort"
+	// HookContinue records the failure of the hook and carries on
+	HookContinue = "continue"
+)
+
+// HooksConfig holds the hooks of a target by the point of the snapshot they run at. Hooks of the same
+// point run one after the other, in the order they are configured.
+type HooksConfig struct {
+	PreStop    []HookConfig `yaml:"pre_stop"`
+	PostStop   []HookConfig `yaml:"post_stop"`
+	PreUpload  []HookConfig `yaml:"pre_upload"`
+	PostUpload []HookConfig `yaml:"post_upload"`
+	PostStart  []HookConfig `yaml:"post_start"`
+}
+
+// At returns the hooks that run at the given point
+func (h HooksConfig) At(point string) []HookConfig {
+	switch point {
+	case HookPreStop:
+		return h.PreStop
+	case HookPostStop:
+		return h.PostStop
+	case HookPreUpload:
+		return h.PreUpload
+	case HookPostUpload:
+		return h.PostUpload
+	case HookPostStart:
+		return h.PostStart
+	}
+	return nil
+}
+
+// HookConfig is a shell command run on the target. SSH targets run it on the host over SSH, local and
+// Kubernetes targets on the snapshotter host.
+type HookConfig struct {
+	// Name identifies the hook in logs and the run history, defaults to the point and index, e.g. pre_stop-0
+	Name string `yaml:"name"`
+	// Command is a text/template rendered with the Alias, DataDir, UploadPrefix, BlockNumber, RunID,
+	// TargetSnapshotID and Hook of the snapshot, and run with sh -c
+	Command string `yaml:"command"`
+	// TimeoutSeconds bounds the command, defaults to 300
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+	// OnFailure is abort (default) or continue
+	OnFailure string `yaml:"on_failure"`
+}
+
+// Timeout returns the configured timeout of the hook, or the default of 5 minutes
+func (h HookConfig) Timeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// validate checks the hooks of a target can be rendered and have a known failure policy
+func (h HooksConfig) validate(alias string) error {
+	for _, point := range []string{HookPreStop, HookPostStop, HookPreUpload, HookPostUpload, HookPostStart} {
+		for i, hook := range h.At(point) {
+			if hook.Command == "" {
+				return fmt.Errorf("%s hook %d of target %q has no command", point, i, alias)
+			}
+			if _, err := template.New("hook").Parse(hook.Command); err != nil {
+				return fmt.Errorf("%s hook %d of target %q has an invalid command template: %w", point, i, alias, err)
+			}
+			switch hook.OnFailure {
+			case "", HookAbort, HookContinue:
+			default:
+				return fmt.Errorf("%s hook %d of target %q has unknown on_failure %q, expected %s or %s", point, i, alias, hook.OnFailure, HookAbort, HookContinue)
+			}
+		}
+	}
+	return nil
+}
+
+// ScheduleGroup returns the name the target is scheduled under: its group, or its alias if it has none
+func (t *TargetConfig) ScheduleGroup() string {
+	if t.Group != "" {
+		return t.Group
+	}
+	return t.Alias
+}
+
+// EndpointsConfig holds the RPC endpoints of a node
+type EndpointsConfig struct {
+	Beacon    string `yaml:"beacon"`
+	Execution string `yaml:"execution"`
+	// TimeoutSeconds is the timeout of a single RPC call, defaults to 10 seconds
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+	// Direct makes SSH targets call the endpoints from the snapshotter host instead of
+	// through an SSH tunnel to the target
+	Direct bool `yaml:"direct"`
+}
+
+// Timeout returns the configured RPC call timeout, or zero if none is set
+func (e EndpointsConfig) Timeout() time.Duration {
+	return time.Duration(e.TimeoutSeconds) * time.Second
+}
+
+// DockerContainersConfig holds the names of the docker containers that make up a node in the EthPandaOps
+// layout, snooper-engine, execution and beacon if none is set. It is ignored if components are configured.
+type DockerContainersConfig struct {
+	EngineSnooper string `yaml:"engine_snooper"`
+	Execution     string `yaml:"execution"`
+	Beacon        string `yaml:"beacon"`
+}
+
+// Roles of the components that make up a node. Components can have other roles, e.g. validator.
+const (
+	RoleEngineSnooper = "engine_snooper"
+	RoleExecution     = "execution"
+	RoleBeacon        = "beacon"
+)
+
+// Types of components
+const (
+	// ComponentDocker is a docker container, the default on SSH and local targets
+	ComponentDocker = "docker"
+	// ComponentCompose is a docker compose service
+	ComponentCompose = "compose"
+	// ComponentSystemd is a systemd unit
+	ComponentSystemd = "systemd"
+	// ComponentWorkload is a Kubernetes workload as <kind>/<name>, the only type on Kubernetes targets
+	ComponentWorkload = "workload"
+)
+
+// What happens to a component while a snapshot is taken
+const (
+	// ComponentStop stops the component before the upload and starts it again afterwards
+	ComponentStop = "stop"
+	// ComponentRestart restarts the component once the stopped components are started again
+	ComponentRestart = "restart"
+	// ComponentNone leaves the component alone, it is only kept running
+	ComponentNone = "none"
+)
+
+// ComponentConfig is a part of a node the snapshotter controls. Components that are stopped for a snapshot
+// are stopped in the order they are listed and started again in the same order. Those listed before the
+// execution component are stopped first, the block the snapshot is taken at is checked while the execution
+// client still runs, then the execution component and the ones after it are stopped.
+type ComponentConfig struct {
+	// Name is the container, compose service, systemd unit or workload
+	Name string `yaml:"name"`
+	// Role is what the component runs, every target has exactly one execution component
+	Role string `yaml:"role"`
+	// Type is docker, compose or systemd on SSH and local targets, and workload on Kubernetes targets
+	Type string `yaml:"type"`
+	// ComposeFile is passed to docker compose with -f, for compose services outside of the default project
+	ComposeFile string `yaml:"compose_file"`
+	// OnSnapshot is stop, restart or none, defaults to restart for the beacon and stop for other roles
+	OnSnapshot string `yaml:"on_snapshot"`
+	// Kill stops the component without waiting for it to shut down, as done for the engine snooper by default
+	Kill bool `yaml:"kill"`
+}
+
+// ExecutionComponent returns the execution component of the target, nil if it has none
+func (t *TargetConfig) ExecutionComponent() *ComponentConfig {
+	for i := range t.Components {
+		if t.Components[i].Role == RoleExecution {
+			return &t.Components[i]
+		}
+	}
+	return nil
+}
+
+// ComponentsOfType returns the components of the target of the given type, in the order they are listed
+func (t *TargetConfig) ComponentsOfType(kind string) []ComponentConfig {
+	var components []ComponentConfig
+	for _, c := range t.Components {
+		if c.Type == kind {
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// defaultComponents returns the components of a node in the EthPandaOps layout: the engine snooper, killed
+// and started before the execution client, the execution client and the beacon node, restarted at the end.
+// Unset names are skipped.
+func defaultComponents(kind, snooper, execution, beacon string) []ComponentConfig {
+	var components []ComponentConfig
+	for _, c := range []ComponentConfig{
+		{Name: snooper, Role: RoleEngineSnooper, Kill: true},
+		{Name: execution, Role: RoleExecution},
+		{Name: beacon, Role: RoleBeacon},
+	} {
+		if c.Name != "" {
+			c.Type = kind
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// defaultDockerContainers are the containers of a node in the EthPandaOps layout
+var defaultDockerContainers = DockerContainersConfig{
+	EngineSnooper: "snooper-engine",
+	Execution:     "execution",
+	Beacon:        "beacon",
+}
+
+// dockerComponents returns the components of a target that configures docker containers instead, or none
+func dockerComponents(d DockerContainersConfig) []ComponentConfig {
+	if d == (DockerContainersConfig{}) {
+		d = defaultDockerContainers
+	}
+	return defaultComponents(ComponentDocker, d.EngineSnooper, d.Execution, d.Beacon)
+}
+
+// applyComponentDefaults derives the components of targets that don't list them from their docker
+// containers or workloads, and fills in the defaults of the listed ones
+func (c *Config) applyComponentDefaults() {
+	for i := range c.Targets.SSH {
+		t := &c.Targets.SSH[i]
+		if len(t.Components) == 0 {
+			t.Components = dockerComponents(t.DockerContainers)
+		}
+		t.TargetConfig.applyComponentDefaults(ComponentDocker)
+	}
+	for i := range c.Targets.Local {
+		t := &c.Targets.Local[i]
+		if len(t.Components) == 0 {
+			t.Components = dockerComponents(t.DockerContainers)
+		}
+		t.TargetConfig.applyComponentDefaults(ComponentDocker)
+	}
+	for i := range c.Targets.Kubernetes {
+		t := &c.Targets.Kubernetes[i]
+		if len(t.Components) == 0 {
+			w := t.Workloads
+			t.Components = defaultComponents(ComponentWorkload, w.EngineSnooper, w.Execution, w.Beacon)
+		}
+		t.TargetConfig.applyComponentDefaults(ComponentWorkload)
+	}
+}
+
+func (t *TargetConfig) applyComponentDefaults(kind string) {
+	for i := range t.Components {
+		c := &t.Components[i]
+		if c.Type == "" {
+			c.Type = kind
+		}
+		if c.OnSnapshot == "" {
+			c.OnSnapshot = ComponentStop
+			if c.Role == RoleBeacon {
+				c.OnSnapshot = ComponentRestart
+			}
+		}
+	}
+}
+
+// validateComponents checks the components of a target can be controlled by its driver
+func (t *TargetConfig) validateComponents(types ...string) error {
+	executions := 0
+	names := make(map[string]bool)
+	for _, c := range t.Components {
+		if c.Name == "" {
+			return fmt.Errorf("component of target %q has no name", t.Alias)
+		}
+		if names[c.Type+"/"+c.Name] {
+			return fmt.Errorf("target %q has component %q more than once", t.Alias, c.Name)
+		}
+		names[c.Type+"/"+c.Name] = true
+		if !slices.Contains(types, c.Type) {
+			return fmt.Errorf("component %q of target %q has unsupported type %q, expected one of %s", c.Name, t.Alias, c.Type, strings.Join(types, ", "))
+		}
+		switch c.OnSnapshot {
+		case ComponentStop, ComponentRestart, ComponentNone:
+		default:
+			return fmt.Errorf("component %q of target %q has unknown on_snapshot %q, expected %s, %s or %s", c.Name, t.Alias, c.OnSnapshot, ComponentStop, ComponentRestart, ComponentNone)
+		}
+		if c.Role == RoleExecution {
+			executions++
+			if c.OnSnapshot != ComponentStop {
+				return fmt.Errorf("execution component %q of target %q has to be stopped for snapshots", c.Name, t.Alias)
+			}
+		}
+	}
+	if executions != 1 {
+		return fmt.Errorf("target %q needs exactly one component with the %s role, got %d", t.Alias, RoleExecution, executions)
+	}
+	return nil
+}
+
+// SSHTargetConfig is a node controlled over SSH using the docker CLI on the remote host
 type SSHTargetConfig struct {
-	Alias            string            `yaml:"alias"`
-	Host             string            `yaml:"host"`
-	User             string            `yaml:"user"`
-	Port             int               `yaml:"port"`
-	DataDir          string            `yaml:"data_dir"`
-	UploadPrefix     string            `yaml:"upload_prefix"`
-	Metadata         map[string]string `yaml:"metadata"`
-	DockerContainers struct {
+	TargetConfig     `yaml:",inline"`
+	Host             string                 `yaml:"host"`
+	User             string                 `yaml:"user"`
+	Port             int                    `yaml:"port"`
+	DockerContainers DockerContainersConfig `yaml:"docker_containers"`
+}
+
+// LocalTargetConfig is a node running on the same host as the snapshotter, controlled via the Docker Engine API
+type LocalTargetConfig struct {
+	TargetConfig     `yaml:",inline"`
+	DockerSocket     string                 `yaml:"docker_socket"`
+	DockerContainers DockerContainersConfig `yaml:"docker_containers"`
+}
+
+// KubernetesTargetConfig is a node running in a Kubernetes cluster, controlled via kubectl.
+// Workloads are referenced as <kind>/<name>, e.g. statefulset/geth.
+type KubernetesTargetConfig struct {
+	TargetConfig `yaml:",inline"`
+	Kubeconfig   string `yaml:"kubeconfig"`
+	Context      string `yaml:"context"`
+	Namespace    string `yaml:"namespace"`
+	Workloads    struct {
 		EngineSnooper string `yaml:"engine_snooper"`
 		Execution     string `yaml:"execution"`
 		Beacon        string `yaml:"beacon"`
-	} `yaml:"docker_containers"`
-	Endpoints struct {
-		Beacon    string `yaml:"beacon"`
-		Execution string `yaml:"execution"`
-	} `yaml:"endpoints"`
+	} `yaml:"workloads"`
+	// ExecutionContainer is the container within the execution pod that has the data dir mounted
+	ExecutionContainer string `yaml:"execution_container"`
+	// DataVolumeClaim is the PVC holding the execution data, mounted at data_dir by the upload pod
+	DataVolumeClaim string `yaml:"data_volume_claim"`
+	// DataVolumeSubPath is an optional sub path within the PVC to mount
+	DataVolumeSubPath string `yaml:"data_volume_sub_path"`
+	// RCloneSecret is an existing secret the upload pod reads its rclone environment from, including the S3
+	// credentials. If unset, a secret is created from the rclone config for every upload.
+	RCloneSecret string `yaml:"rclone_secret"`
+}
+
+const (
+	// UploaderRClone uploads by running an rclone container on the target
+	UploaderRClone = "rclone"
+	// UploaderAgent uploads by copying the snapshotter binary to the target and running its agent there
+	UploaderAgent = "agent"
+)
+
+// UploaderConfig selects how SSH targets upload their data dir. Local and Kubernetes targets always use rclone.
+type UploaderConfig struct {
+	// Type is either rclone (default) or agent
+	Type  string      `yaml:"type"`
+	Agent AgentConfig `yaml:"agent"`
+}
+
+// AgentConfig configures the upload agent. The agent archives, compresses and uploads the data dir itself,
+// so targets don't need to pull the rclone image, and the S3 credentials are passed on its stdin.
+type AgentConfig struct {
+	// Binary is the local snapshotter binary copied to targets, defaults to the running executable.
+	// It has to be built for the OS and architecture of the targets.
+	Binary string `yaml:"binary"`
+	// Path is where the binary is installed on targets, defaults to /usr/local/bin/snapshotter-agent
+	Path string `yaml:"path"`
+	// PartSizeMB is the size of the multipart upload parts, defaults to 64. It is raised for large data dirs
+	// to stay within the S3 part limit.
+	PartSizeMB int `yaml:"part_size_mb"`
+	// Concurrency is the number of parts uploaded in parallel, defaults to 4. Each part is buffered in memory.
+	Concurrency int `yaml:"concurrency"`
+	// ACL is the canned ACL of the uploaded objects, e.g. public-read, like RCLONE_CONFIG_MYS3_ACL for rclone
+	ACL string `yaml:"acl"`
+}
+
+// RemotePath returns where the agent is installed on targets
+func (a AgentConfig) RemotePath() string {
+	if a.Path == "" {
+		return "/usr/local/bin/snapshotter-agent"
+	}
+	return a.Path
 }
 
 type RCloneConfig struct {
@@ -87,20 +821,38 @@ type RCloneConfig struct {
 // .BucketName is the name of the bucket ( e.g your-bucket-name)
 // .UploadPathPrefix is the prefix of the upload path ( e.g mainnet/geth)
 // .BlockNumber is the block number of the snapshot (e.g 123456)
+//
+// The SHA-256 and size of the archive are computed while it is streamed, written to _snapshot_manifest.json
+// and printed on a line starting with SNAPSHOT_MANIFEST, from which they are recorded.
+// The latest pointer of the upload prefix is written by the snapshotter once the upload succeeded.
+// The size of the data dir is measured first, and tar prints the bytes it read every 10000 records on
+// SNAPSHOT_PROGRESS lines, while rclone logs the bytes it uploaded as JSON every 10 seconds, from which
+// the upload progress is reported.
 const DefaultRCloneCommandTemplate = `-ac "
 apk add --no-cache tar zstd jq &&
 cd {{ .DataDir }} &&
 cat {{ .DataDir }}/_snapshot_metadata.json | jq . &&
+echo \$((\$(du -sk . | cut -f 1) * 1024)) > /tmp/total &&
+mkfifo /tmp/sha256.fifo /tmp/size.fifo &&
+{ sha256sum < /tmp/sha256.fifo | cut -d ' ' -f 1 > /tmp/sha256 & } &&
+{ wc -c < /tmp/size.fifo > /tmp/size & } &&
 tar -I zstd \\
+--checkpoint=10000 \\
+--checkpoint-action=exec='printf \"SNAPSHOT_PROGRESS {\\\"bytesRead\\\":%s,\\\"bytesTotal\\\":%s}\\n\" \$((TAR_CHECKPOINT * TAR_BLOCKING_FACTOR * 512)) \$(cat /tmp/total) >&2' \\
 --exclude=./nodekey \\
 --exclude=./key \\
 --exclude=./discovery-secret \\
+--exclude=./_snapshot_manifest.json \\
 -cvf - . \\
-| rclone rcat --s3-chunk-size 150M mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/{{ .BlockNumber }}/snapshot.tar.zst &&
+| tee /tmp/sha256.fifo /tmp/size.fifo \\
+| rclone rcat --s3-chunk-size 150M --stats 10s --stats-log-level NOTICE --use-json-log mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/{{ .BlockNumber }}/snapshot.tar.zst &&
+wait &&
+printf '{\"block_number\":%s,\"file\":\"snapshot.tar.zst\",\"sha256\":\"%s\",\"size\":%s}\n' {{ .BlockNumber }} \$(cat /tmp/sha256) \$(cat /tmp/size) > {{ .DataDir }}/_snapshot_manifest.json &&
 rclone copy {{ .DataDir }}/_snapshot_eth_getBlockByNumber.json mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/{{ .BlockNumber }} &&
 rclone copy {{ .DataDir }}/_snapshot_web3_clientVersion.json mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/{{ .BlockNumber }} &&
 rclone copy {{ .DataDir }}/_snapshot_metadata.json mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/{{ .BlockNumber }} &&
-echo {{ .BlockNumber }} | rclone rcat mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/latest
+rclone copy {{ .DataDir }}/_snapshot_manifest.json mys3:/{{ .BucketName }}/{{ .UploadPathPrefix }}/{{ .BlockNumber }} &&
+echo SNAPSHOT_MANIFEST \$(cat {{ .DataDir }}/_snapshot_manifest.json)
 "`
 
 // GetDefaultRCloneConfig returns an RCloneConfig with sensible defaults
@@ -177,6 +929,24 @@ func ReadFromFile(path string) (*Config, error) {
 			"target": fmt.Sprintf("%s@%s:%d", t.User, t.Host, t.Port),
 		}).Info("ssh target")
 	}
+	for _, t := range config.Targets.Local {
+		log.WithFields(log.Fields{
+			"alias":  t.Alias,
+			"socket": t.DockerSocket,
+		}).Info("local docker target")
+	}
+	for _, t := range config.Targets.Kubernetes {
+		log.WithFields(log.Fields{
+			"alias":     t.Alias,
+			"namespace": t.Namespace,
+			"context":   t.Context,
+		}).Info("kubernetes target")
+	}
+
+	config.applyComponentDefaults()
+	if err := config.validateTargets(); err != nil {
+		return nil, err
+	}
 
 	// Process any environment variables in the configuration
 	for k, v := range config.Global.Snapshots.RClone.Env {
@@ -189,18 +959,150 @@ func ReadFromFile(path string) (*Config, error) {
 	config.Global.Snapshots.S3.Region = os.ExpandEnv(config.Global.Snapshots.S3.Region)
 	config.Global.Snapshots.S3.RootPrefix = os.ExpandEnv(config.Global.Snapshots.S3.RootPrefix)
 
+	// Expand environment variables in replication destinations, so credentials can be kept out of the config file
+	for i := range config.Global.Snapshots.Replication.Destinations {
+		d := &config.Global.Snapshots.Replication.Destinations[i]
+		d.Endpoint = os.ExpandEnv(d.Endpoint)
+		d.BucketName = os.ExpandEnv(d.BucketName)
+		d.AccessKeyID = os.ExpandEnv(d.AccessKeyID)
+		d.SecretAccessKey = os.ExpandEnv(d.SecretAccessKey)
+		d.Path = os.ExpandEnv(d.Path)
+		d.URL = os.ExpandEnv(d.URL)
+		for k, v := range d.Headers {
+			d.Headers[k] = os.ExpandEnv(v)
+		}
+	}
+	if err := config.Global.Snapshots.Replication.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Global.Snapshots.Cleanup.Retention.validate(); err != nil {
+		return nil, err
+	}
+
 	// Expand environment variables in SSH configuration
 	config.Global.SSH.PrivateKeyPath = os.ExpandEnv(config.Global.SSH.PrivateKeyPath)
 	config.Global.SSH.PrivateKeyPassphrasePath = os.ExpandEnv(config.Global.SSH.PrivateKeyPassphrasePath)
 	config.Global.SSH.KnownHostsPath = os.ExpandEnv(config.Global.SSH.KnownHostsPath)
 
+	// Expand environment variables in notifiers, so webhook URLs and secrets can be kept out of the config file
+	for i := range config.Global.Notifications.Notifiers {
+		n := &config.Global.Notifications.Notifiers[i]
+		n.URL = os.ExpandEnv(n.URL)
+		n.Secret = os.ExpandEnv(n.Secret)
+	}
+
 	// Expand environment variables in database path
 	config.Global.Database.Path = os.ExpandEnv(config.Global.Database.Path)
 
-	// Expand environment variables in SSH target paths
+	// Expand environment variables in target paths
 	for i := range config.Targets.SSH {
 		config.Targets.SSH[i].DataDir = os.ExpandEnv(config.Targets.SSH[i].DataDir)
 	}
+	for i := range config.Targets.Local {
+		config.Targets.Local[i].DataDir = os.ExpandEnv(config.Targets.Local[i].DataDir)
+		config.Targets.Local[i].DockerSocket = os.ExpandEnv(config.Targets.Local[i].DockerSocket)
+		if config.Targets.Local[i].DockerSocket == "" {
+			config.Targets.Local[i].DockerSocket = "/var/run/docker.sock"
+		}
+	}
+	for i := range config.Targets.Kubernetes {
+		config.Targets.Kubernetes[i].DataDir = os.ExpandEnv(config.Targets.Kubernetes[i].DataDir)
+		config.Targets.Kubernetes[i].Kubeconfig = os.ExpandEnv(config.Targets.Kubernetes[i].Kubeconfig)
+		if config.Targets.Kubernetes[i].Namespace == "" {
+			config.Targets.Kubernetes[i].Namespace = "default"
+		}
+	}
 
 	return config, nil
 }
+
+// AllTargets returns the shared configuration of every configured target, regardless of driver
+func (c *Config) AllTargets() []*TargetConfig {
+	targets := make([]*TargetConfig, 0, len(c.Targets.SSH)+len(c.Targets.Local)+len(c.Targets.Kubernetes))
+	for i := range c.Targets.SSH {
+		targets = append(targets, &c.Targets.SSH[i].TargetConfig)
+	}
+	for i := range c.Targets.Local {
+		targets = append(targets, &c.Targets.Local[i].TargetConfig)
+	}
+	for i := range c.Targets.Kubernetes {
+		targets = append(targets, &c.Targets.Kubernetes[i].TargetConfig)
+	}
+	return targets
+}
+
+// validateTargets makes sure every target has an alias and that aliases are unique across drivers
+func (c *Config) validateTargets() error {
+	seen := make(map[string]bool)
+	for _, t := range c.AllTargets() {
+		if t.Alias == "" {
+			return fmt.Errorf("target without alias configured")
+		}
+		if seen[t.Alias] {
+			return fmt.Errorf("duplicate target alias %q", t.Alias)
+		}
+		seen[t.Alias] = true
+		if err := t.Hooks.validate(t.Alias); err != nil {
+			return err
+		}
+	}
+
+	// Runs are recorded per schedule group, so a group can't be named like an independent target
+	// and all of its targets have to agree on the block interval
+	groupIntervals := make(map[string]int)
+	for _, t := range c.AllTargets() {
+		if t.Group == "" {
+			continue
+		}
+		if seen[t.Group] && t.Group != t.Alias {
+			for _, other := range c.AllTargets() {
+				if other.Alias == t.Group && other.Group != t.Group {
+					return fmt.Errorf("group %q has the same name as target %q which is not part of it", t.Group, other.Alias)
+				}
+			}
+		}
+		interval, ok := groupIntervals[t.Group]
+		if ok && interval != t.BlockInterval {
+			return fmt.Errorf("targets of group %q have different block intervals", t.Group)
+		}
+		groupIntervals[t.Group] = t.BlockInterval
+	}
+
+	switch c.Global.Snapshots.Uploader.Type {
+	case "", UploaderRClone, UploaderAgent:
+	default:
+		return fmt.Errorf("unknown uploader type %q, expected %s or %s", c.Global.Snapshots.Uploader.Type, UploaderRClone, UploaderAgent)
+	}
+
+	for i, n := range c.Global.Notifications.Notifiers {
+		switch n.Type {
+		case NotifierWebhook, NotifierSlack, NotifierDiscord:
+		default:
+			return fmt.Errorf("notifier %d has unknown type %q, expected %s, %s or %s", i, n.Type, NotifierWebhook, NotifierSlack, NotifierDiscord)
+		}
+	}
+
+	if v := c.Global.Snapshots.Verification; v.Enabled && v.Host == "" {
+		return fmt.Errorf("snapshot verification is enabled but no verifier host is configured")
+	}
+
+	for _, t := range c.Targets.SSH {
+		if err := t.validateComponents(ComponentDocker, ComponentCompose, ComponentSystemd); err != nil {
+			return err
+		}
+	}
+	for _, t := range c.Targets.Local {
+		if err := t.validateComponents(ComponentDocker, ComponentCompose, ComponentSystemd); err != nil {
+			return err
+		}
+	}
+	for _, t := range c.Targets.Kubernetes {
+		if err := t.validateComponents(ComponentWorkload); err != nil {
+			return fmt.Errorf("%w: set workloads.execution or components", err)
+		}
+		if t.DataVolumeClaim == "" {
+			return fmt.Errorf("kubernetes target %q has no data_volume_claim configured", t.Alias)
+		}
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestEnvironmentVariableExpansion(t *testing.T) {
@@ -123,3 +127,240 @@ targets:
 			cfg.Global.Snapshots.RClone.Env["RCLONE_CONFIG_MYS3_BUCKET_NAME"])
 	}
 }
+
+func TestTargetDrivers(t *testing.T) {
+	tmpConfigContent := `
+targets:
+  ssh:
+    - alias: "geth"
+      host: "127.0.0.1"
+      user: "test"
+      port: 22
+      data_dir: /data/geth
+      upload_prefix: test/geth
+  local:
+    - alias: "reth"
+      data_dir: /data/reth
+      upload_prefix: test/reth
+      docker_containers:
+        execution: reth
+  kubernetes:
+    - alias: "besu"
+      data_dir: /data
+      upload_prefix: test/besu
+      workloads:
+        execution: statefulset/besu
+        beacon: statefulset/lighthouse
+      data_volume_claim: storage-besu-0
+`
+	tmpConfigPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(tmpConfigPath, []byte(tmpConfigContent), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+
+	cfg, err := ReadFromFile(tmpConfigPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	targets := cfg.AllTargets()
+	if len(targets) != 3 {
+		t.Fatalf("Expected 3 targets, got %d", len(targets))
+	}
+	for i, alias := range []string{"geth", "reth", "besu"} {
+		if targets[i].Alias != alias {
+			t.Errorf("Expected target %d to be %s, got %s", i, alias, targets[i].Alias)
+		}
+	}
+
+	if cfg.Targets.Local[0].DockerSocket != "/var/run/docker.sock" {
+		t.Errorf("Local target docker socket not defaulted. Got %s", cfg.Targets.Local[0].DockerSocket)
+	}
+	if cfg.Targets.Kubernetes[0].Namespace != "default" {
+		t.Errorf("Kubernetes target namespace not defaulted. Got %s", cfg.Targets.Kubernetes[0].Namespace)
+	}
+
+	// Aliases have to be unique across drivers
+	duplicate := tmpConfigContent + `
+    - alias: "geth"
+      data_dir: /data
+      workloads:
+        execution: statefulset/geth
+      data_volume_claim: storage-geth-0
+`
+	if err := os.WriteFile(tmpConfigPath, []byte(duplicate), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+	if _, err := ReadFromFile(tmpConfigPath); err == nil {
+		t.Error("Expected an error for duplicate target aliases")
+	}
+
+	// A group can't share its name with a target outside of it
+	grouped := strings.Replace(tmpConfigContent, `upload_prefix: test/reth`, "upload_prefix: test/reth\n      group: geth", 1)
+	if err := os.WriteFile(tmpConfigPath, []byte(grouped), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+	if _, err := ReadFromFile(tmpConfigPath); err == nil {
+		t.Error("Expected an error for a group named like a target outside of it")
+	}
+}
+
+func TestHooksValidation(t *testing.T) {
+	valid := HooksConfig{
+		PreStop:   []HookConfig{{Command: "curl -X POST localhost:8545/flush"}},
+		PostStart: []HookConfig{{Command: "systemctl start validator-{{.Alias}}", OnFailure: HookContinue}},
+	}
+	if err := valid.validate("geth"); err != nil {
+		t.Errorf("Expected hooks to be valid, got %v", err)
+	}
+
+	for name, hooks := range map[string]HooksConfig{
+		"missing command":    {PostStop: []HookConfig{{Name: "compact"}}},
+		"invalid template":   {PreUpload: []HookConfig{{Command: "echo {{.Alias"}}},
+		"unknown on_failure": {PostUpload: []HookConfig{{Command: "true", OnFailure: "retry"}}},
+	} {
+		if err := hooks.validate("geth"); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestReplicationValidation(t *testing.T) {
+	valid := ReplicationConfig{Destinations: []DestinationConfig{
+		{Name: "backup", Type: StorageS3, Endpoint: "https://fra1.digitaloceanspaces.com", BucketName: "snapshots", AccessKeyID: "id", SecretAccessKey: "secret"},
+		{Name: "gcs", Type: StorageGCS, BucketName: "snapshots", AccessKeyID: "id", SecretAccessKey: "secret"},
+		{Name: "nfs", Type: StorageLocal, Path: "/mnt/snapshots"},
+		{Name: "mirror", Type: StorageHTTP, URL: "https://mirror.example.com/snapshots"},
+	}}
+	if err := valid.validate(); err != nil {
+		t.Errorf("Expected destinations to be valid, got %v", err)
+	}
+
+	for name, dest := range map[string][]DestinationConfig{
+		"missing name":        {{Type: StorageLocal, Path: "/mnt/snapshots"}},
+		"duplicate name":      {{Name: "nfs", Type: StorageLocal, Path: "/a"}, {Name: "nfs", Type: StorageLocal, Path: "/b"}},
+		"unknown type":        {{Name: "ftp", Type: "ftp"}},
+		"s3 without endpoint": {{Name: "backup", Type: StorageS3, BucketName: "snapshots", AccessKeyID: "id", SecretAccessKey: "secret"}},
+		"gcs without keys":    {{Name: "gcs", Type: StorageGCS, BucketName: "snapshots"}},
+		"local without path":  {{Name: "nfs", Type: StorageLocal}},
+		"http without url":    {{Name: "mirror", Type: StorageHTTP}},
+	} {
+		if err := (ReplicationConfig{Destinations: dest}).validate(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestRetentionRules(t *testing.T) {
+	var cleanup CleanupConfig
+	err := yaml.Unmarshal([]byte(`
+keep_count: 5
+retention:
+  keep_daily: 7
+  max_age_days: 90
+  networks:
+    hoodi:
+      keep_last: 2
+  aliases:
+    erigon:
+      max_age_days: 14
+  fork_blocks:
+    mainnet: [22431084]
+`), &cleanup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cleanup.Retention
+	if !r.Enabled() {
+		t.Fatal("Expected retention to be enabled")
+	}
+	if got := r.RulesFor("geth", "mainnet"); got != (RetentionRules{KeepDaily: 7, MaxAgeDays: 90}) {
+		t.Errorf("Expected the default rules, got %+v", got)
+	}
+	if got := r.RulesFor("geth", "hoodi"); got != (RetentionRules{KeepLast: 2}) {
+		t.Errorf("Expected the network rules, got %+v", got)
+	}
+	if got := r.RulesFor("erigon", "hoodi"); got != (RetentionRules{MaxAgeDays: 14}) {
+		t.Errorf("Expected the alias rules, got %+v", got)
+	}
+	if (RetentionConfig{}).Enabled() {
+		t.Error("Expected retention to be disabled without rules")
+	}
+	if err := (RetentionConfig{Aliases: map[string]RetentionRules{"geth": {KeepWeekly: -1}}}).validate(); err == nil {
+		t.Error("Expected an error for a negative rule")
+	}
+}
+
+func TestComponents(t *testing.T) {
+	tmpConfigContent := `
+targets:
+  ssh:
+    - alias: "geth"
+      host: "127.0.0.1"
+      user: "test"
+      data_dir: /data/geth
+  local:
+    - alias: "nethermind"
+      data_dir: /data/nethermind
+      components:
+        - name: nethermind.service
+          role: execution
+          type: systemd
+        - name: lighthouse
+          role: beacon
+          type: compose
+          compose_file: /srv/node/compose.yaml
+          on_snapshot: stop
+  kubernetes:
+    - alias: "besu"
+      data_dir: /data
+      workloads:
+        execution: statefulset/besu
+        beacon: statefulset/lighthouse
+      data_volume_claim: storage-besu-0
+`
+	tmpConfigPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(tmpConfigPath, []byte(tmpConfigContent), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+	cfg, err := ReadFromFile(tmpConfigPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	// Targets without components get the EthPandaOps layout
+	geth := cfg.Targets.SSH[0].Components
+	if len(geth) != 3 || geth[0].Name != "snooper-engine" || !geth[0].Kill || geth[0].OnSnapshot != ComponentStop ||
+		geth[2].Role != RoleBeacon || geth[2].OnSnapshot != ComponentRestart || geth[1].Type != ComponentDocker {
+		t.Errorf("Unexpected default components %+v", geth)
+	}
+
+	nethermind := cfg.Targets.Local[0].Components
+	if len(nethermind) != 2 || nethermind[0].OnSnapshot != ComponentStop || nethermind[1].OnSnapshot != ComponentStop {
+		t.Errorf("Unexpected components %+v", nethermind)
+	}
+	if e := cfg.Targets.Local[0].ExecutionComponent(); e == nil || e.Name != "nethermind.service" {
+		t.Errorf("Unexpected execution component %+v", e)
+	}
+
+	besu := cfg.Targets.Kubernetes[0].Components
+	if len(besu) != 2 || besu[0].Type != ComponentWorkload || besu[1].Name != "statefulset/lighthouse" {
+		t.Errorf("Unexpected workload components %+v", besu)
+	}
+
+	for name, replacement := range map[string][2]string{
+		"missing execution":     {"role: execution\n", "role: validator\n"},
+		"restarted execution":   {"type: systemd\n", "type: systemd\n          on_snapshot: restart\n"},
+		"unsupported type":      {"type: compose\n", "type: workload\n"},
+		"unknown on_snapshot":   {"on_snapshot: stop\n", "on_snapshot: pause\n"},
+		"missing execution k8s": {"execution: statefulset/besu\n", ""},
+	} {
+		content := strings.Replace(tmpConfigContent, replacement[0], replacement[1], 1)
+		if err := os.WriteFile(tmpConfigPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create temp config file: %v", err)
+		}
+		if _, err := ReadFromFile(tmpConfigPath); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -14,6 +17,7 @@ type DB struct {
 
 type SnapshotRun struct {
 	ID              int64            `json:"id"`
+	Group           string           `json:"group"`
 	BlockHeight     uint64           `json:"blockHeight"`
 	StartTime       time.Time        `json:"startTime"`
 	EndTime         time.Time        `json:"endTime"`
@@ -37,6 +41,180 @@ type TargetSnapshot struct {
 	DryRun        bool      `json:"isDryRun"`
 	Deleted       bool      `json:"deleted"`
 	Persisted     bool      `json:"persisted"`
+	// Phase is the last phase the target completed, empty if none
+	Phase string `json:"phase"`
+	// SHA256 and Size describe the uploaded snapshot.tar.zst, if the upload command reported them
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	// BlockHash and ClientVersion are read from the metadata uploaded next to the archive
+	BlockHash     string `json:"blockHash,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	// Objects and Bytes count everything stored under the upload prefix, listed after the upload. They are zero
+	// until the snapshot is measured.
+	Objects int   `json:"objects,omitempty"`
+	Bytes   int64 `json:"bytes,omitempty"`
+	// Verification is the state of the restore check of the uploaded snapshot: "pending", "running",
+	// "verified" or "failed", empty if the snapshot isn't verified
+	Verification      string     `json:"verification,omitempty"`
+	VerificationTime  *time.Time `json:"verificationTime,omitempty"`
+	VerificationError string     `json:"verificationError,omitempty"`
+	// Phases are the recorded phase transitions, only loaded for a single target snapshot or run
+	Phases []PhaseTransition `json:"phases,omitempty"`
+	// Hooks are the hooks run for the target snapshot, in the order they ran
+	Hooks []HookExecution `json:"hooks,omitempty"`
+	// Replicas are the copies of the snapshot on the additional storage destinations
+	Replicas []Replica `json:"replicas,omitempty"`
+	// UploadProgress is set by the API while the target snapshot is being uploaded
+	UploadProgress *types.TargetUploadProgress `json:"uploadProgress,omitempty"`
+}
+
+// PhaseTransition records a target snapshot reaching a phase, or failing to reach it with an error
+type PhaseTransition struct {
+	Phase        string    `json:"phase"`
+	Time         time.Time `json:"time"`
+	ErrorMessage string    `json:"errorMessage,omitempty"`
+}
+
+// HookExecution records a hook run for a target snapshot
+type HookExecution struct {
+	// Hook is the point of the snapshot the hook ran at, e.g. pre_stop
+	Hook    string    `json:"hook"`
+	Name    string    `json:"name"`
+	Command string    `json:"command"`
+	Start   time.Time `json:"startTime"`
+	End     time.Time `json:"endTime"`
+	Success bool      `json:"success"`
+	// Output is the combined output of the command, truncated to its last 4 KiB
+	Output       string `json:"output,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// Replica is the copy of a target snapshot on an additional storage destination
+type Replica struct {
+	TargetSnapshotID int64  `json:"-"`
+	Destination      string `json:"destination"`
+	// Status is "pending", "running", "success" or "failed"
+	Status       string     `json:"status"`
+	Objects      int        `json:"objects"`
+	Bytes        int64      `json:"bytes"`
+	StartTime    *time.Time `json:"startTime,omitempty"`
+	EndTime      *time.Time `json:"endTime,omitempty"`
+	ErrorMessage string     `json:"errorMessage,omitempty"`
+	Deleted      bool       `json:"deleted"`
+	// Keys are the objects written to the destination, including those of failed attempts, so they
+	// can be deleted with the snapshot
+	Keys []string `json:"-"`
+}
+
+// ContainerRemediation records an attempt of the watchdog to bring a stopped or unhealthy container of a target back up
+type ContainerRemediation struct {
+	ID        int64  `json:"id"`
+	Alias     string `json:"alias"`
+	Container string `json:"container"`
+	Role      string `json:"role"`
+	// Trigger is "run" for the check after a run, or "periodic"
+	Trigger       string `json:"trigger"`
+	SnapshotRunID int64  `json:"snapshotRunId,omitempty"`
+	// State is the state the container was found in, e.g. "exited (137)" or "unhealthy"
+	State string `json:"state"`
+	// Action is "start" or "restart"
+	Action string `json:"action"`
+	// Attempt counts the attempts since the container was last healthy
+	Attempt      int       `json:"attempt"`
+	Success      bool      `json:"success"`
+	ErrorMessage string    `json:"errorMessage,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// snapshotRunColumns are the columns selected for a SnapshotRun, in the order scanSnapshotRun expects them
+const snapshotRunColumns = "id, group_name, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted"
+
+// targetSnapshotColumns are the columns selected for a TargetSnapshot, in the order scanTargetSnapshot expects them
+const targetSnapshotColumns = "id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted, phase, sha256, size, verification, verification_time, verification_error, block_hash, client_version, object_count, total_bytes"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSnapshotRun scans a row selected with snapshotRunColumns
+func scanSnapshotRun(row rowScanner) (SnapshotRun, error) {
+	var run SnapshotRun
+	var endTime sql.NullTime
+	var errorMessage sql.NullString
+	var persisted sql.NullBool
+	err := row.Scan(
+		&run.ID,
+		&run.Group,
+		&run.BlockHeight,
+		&run.StartTime,
+		&endTime,
+		&run.Status,
+		&errorMessage,
+		&run.DryRun,
+		&run.Deleted,
+		&persisted,
+	)
+	if err != nil {
+		return run, err
+	}
+	if endTime.Valid {
+		run.EndTime = endTime.Time
+	}
+	if errorMessage.Valid {
+		run.ErrorMessage = errorMessage.String
+	}
+	if persisted.Valid {
+		run.Persisted = persisted.Bool
+	}
+	return run, nil
+}
+
+// scanTargetSnapshot scans a row selected with targetSnapshotColumns
+func scanTargetSnapshot(row rowScanner) (TargetSnapshot, error) {
+	var target TargetSnapshot
+	var endTime, verificationTime sql.NullTime
+	var errorMessage sql.NullString
+	var persisted sql.NullBool
+	err := row.Scan(
+		&target.ID,
+		&target.SnapshotRunID,
+		&target.Alias,
+		&target.UploadPrefix,
+		&target.StartTime,
+		&endTime,
+		&target.Status,
+		&errorMessage,
+		&target.DryRun,
+		&target.Deleted,
+		&persisted,
+		&target.Phase,
+		&target.SHA256,
+		&target.Size,
+		&target.Verification,
+		&verificationTime,
+		&target.VerificationError,
+		&target.BlockHash,
+		&target.ClientVersion,
+		&target.Objects,
+		&target.Bytes,
+	)
+	if err != nil {
+		return target, err
+	}
+	if endTime.Valid {
+		target.EndTime = endTime.Time
+	}
+	if verificationTime.Valid {
+		target.VerificationTime = &verificationTime.Time
+	}
+	if errorMessage.Valid {
+		target.ErrorMessage = errorMessage.String
+	}
+	if persisted.Valid {
+		target.Persisted = persisted.Bool
+	}
+	return target, nil
 }
 
 func NewDB(dbPath string) (*DB, error) {
@@ -58,6 +236,11 @@ func NewDB(dbPath string) (*DB, error) {
 	return &DB{db: db}, nil
 }
 
+// Close closes the underlying database connection
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
 func initSchema(db *sql.DB) error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS snapshot_runs (
@@ -87,9 +270,11 @@ func initSchema(db *sql.DB) error {
 	return err
 }
 
-func (d *DB) CreateSnapshotRun(blockHeight uint64, dryRun bool) (*SnapshotRun, error) {
+// CreateSnapshotRun records a new running snapshot run for the given schedule group
+func (d *DB) CreateSnapshotRun(group string, blockHeight uint64, dryRun bool) (*SnapshotRun, error) {
 	result, err := d.db.Exec(
-		"INSERT INTO snapshot_runs (block_height, start_time, status, dry_run) VALUES (?, ?, ?, ?)",
+		"INSERT INTO snapshot_runs (group_name, block_height, start_time, status, dry_run) VALUES (?, ?, ?, ?, ?)",
+		group,
 		blockHeight,
 		time.Now(),
 		"running",
@@ -102,6 +287,7 @@ func (d *DB) CreateSnapshotRun(blockHeight uint64, dryRun bool) (*SnapshotRun, e
 	id, _ := result.LastInsertId()
 	return &SnapshotRun{
 		ID:          id,
+		Group:       group,
 		BlockHeight: blockHeight,
 		StartTime:   time.Now(),
 		Status:      "running",
@@ -159,7 +345,7 @@ func (d *DB) UpdateTargetSnapshotStatus(id int64, status string, errorMsg string
 
 func (d *DB) GetTargetSnapshotsForRun(runID int64) (targets []TargetSnapshot, err error) {
 	rows, err := d.db.Query(`
-		SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT `+targetSnapshotColumns+`
 		FROM target_snapshots
 		WHERE snapshot_run_id = ?
 		ORDER BY start_time ASC
@@ -177,35 +363,10 @@ func (d *DB) GetTargetSnapshotsForRun(runID int64) (targets []TargetSnapshot, er
 
 	targets = []TargetSnapshot{}
 	for rows.Next() {
-		var target TargetSnapshot
-		var endTime sql.NullTime
-		var errorMessage sql.NullString
-		var persisted sql.NullBool
-		err := rows.Scan(
-			&target.ID,
-			&target.SnapshotRunID,
-			&target.Alias,
-			&target.UploadPrefix,
-			&target.StartTime,
-			&endTime,
-			&target.Status,
-			&errorMessage,
-			&target.DryRun,
-			&target.Deleted,
-			&persisted,
-		)
+		target, err := scanTargetSnapshot(rows)
 		if err != nil {
 			return nil, err
 		}
-		if endTime.Valid {
-			target.EndTime = endTime.Time
-		}
-		if errorMessage.Valid {
-			target.ErrorMessage = errorMessage.String
-		}
-		if persisted.Valid {
-			target.Persisted = persisted.Bool
-		}
 		targets = append(targets, target)
 	}
 	return targets, nil
@@ -213,7 +374,7 @@ func (d *DB) GetTargetSnapshotsForRun(runID int64) (targets []TargetSnapshot, er
 
 func (d *DB) GetAllRuns() (runs []SnapshotRun, err error) {
 	rows, err := d.db.Query(`
-		SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT ` + snapshotRunColumns + `
 		FROM snapshot_runs
 		ORDER BY start_time DESC
 	`)
@@ -230,33 +391,10 @@ func (d *DB) GetAllRuns() (runs []SnapshotRun, err error) {
 
 	runs = []SnapshotRun{}
 	for rows.Next() {
-		var run SnapshotRun
-		var endTime sql.NullTime
-		var errorMessage sql.NullString
-		var persisted sql.NullBool
-		err := rows.Scan(
-			&run.ID,
-			&run.BlockHeight,
-			&run.StartTime,
-			&endTime,
-			&run.Status,
-			&errorMessage,
-			&run.DryRun,
-			&run.Deleted,
-			&persisted,
-		)
+		run, err := scanSnapshotRun(rows)
 		if err != nil {
 			return nil, err
 		}
-		if endTime.Valid {
-			run.EndTime = endTime.Time
-		}
-		if errorMessage.Valid {
-			run.ErrorMessage = errorMessage.String
-		}
-		if persisted.Valid {
-			run.Persisted = persisted.Bool
-		}
 
 		// Get associated target snapshots
 		targets, err := d.GetTargetSnapshotsForRun(run.ID)
@@ -271,27 +409,13 @@ func (d *DB) GetAllRuns() (runs []SnapshotRun, err error) {
 
 func (d *DB) GetMostRecentRun() (*SnapshotRun, error) {
 	row := d.db.QueryRow(`
-		SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT ` + snapshotRunColumns + `
 		FROM snapshot_runs
 		ORDER BY start_time DESC
 		LIMIT 1
 	`)
 
-	var run SnapshotRun
-	var endTime sql.NullTime
-	var errorMessage sql.NullString
-	var persisted sql.NullBool
-	err := row.Scan(
-		&run.ID,
-		&run.BlockHeight,
-		&run.StartTime,
-		&endTime,
-		&run.Status,
-		&errorMessage,
-		&run.DryRun,
-		&run.Deleted,
-		&persisted,
-	)
+	run, err := scanSnapshotRun(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -299,22 +423,83 @@ func (d *DB) GetMostRecentRun() (*SnapshotRun, error) {
 		return nil, err
 	}
 
-	if endTime.Valid {
-		run.EndTime = endTime.Time
+	targets, err := d.GetTargetSnapshotsForRun(run.ID)
+	if err != nil {
+		return nil, err
 	}
-	if errorMessage.Valid {
-		run.ErrorMessage = errorMessage.String
+	for i := range targets {
+		if targets[i].Phases, err = d.GetTargetSnapshotPhases(targets[i].ID); err != nil {
+			return nil, err
+		}
+		if targets[i].Hooks, err = d.GetTargetSnapshotHooks(targets[i].ID); err != nil {
+			return nil, err
+		}
+		if targets[i].Replicas, err = d.GetTargetSnapshotReplicas(targets[i].ID); err != nil {
+			return nil, err
+		}
 	}
-	if persisted.Valid {
-		run.Persisted = persisted.Bool
+	run.TargetsSnapshot = targets
+
+	return &run, nil
+}
+
+// groupRunsFilter returns the condition matching the runs of a schedule group, and its arguments. Runs recorded
+// before groups existed have no group and belong to every group holding one of their targets.
+func groupRunsFilter(group string, aliases []string) (string, []interface{}) {
+	args := []interface{}{group}
+	if len(aliases) == 0 {
+		return "group_name = ?", args
+	}
+	for _, alias := range aliases {
+		args = append(args, alias)
 	}
+	return `(group_name = ? OR (group_name = '' AND EXISTS (
+			SELECT 1 FROM target_snapshots t
+			WHERE t.snapshot_run_id = snapshot_runs.id AND t.alias IN (?` + strings.Repeat(", ?", len(aliases)-1) + `)
+		)))`, args
+}
 
-	targets, err := d.GetTargetSnapshotsForRun(run.ID)
+// GetMostRecentRunForGroup gets the most recent snapshot run of a schedule group with the given targets,
+// without its target snapshots. It is looked up on every check of the schedule.
+func (d *DB) GetMostRecentRunForGroup(group string, aliases []string) (*SnapshotRun, error) {
+	filter, args := groupRunsFilter(group, aliases)
+	row := d.db.QueryRow(`
+		SELECT `+snapshotRunColumns+`
+		FROM snapshot_runs
+		WHERE `+filter+`
+		ORDER BY start_time DESC
+		LIMIT 1
+	`, args...)
+
+	run, err := scanSnapshotRun(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	run.TargetsSnapshot = targets
+	return &run, nil
+}
+
+// GetMostRecentSuccessfulRunForGroup gets the most recent successful snapshot run of a schedule group with the
+// given targets, without its target snapshots
+func (d *DB) GetMostRecentSuccessfulRunForGroup(group string, aliases []string) (*SnapshotRun, error) {
+	filter, args := groupRunsFilter(group, aliases)
+	row := d.db.QueryRow(`
+		SELECT `+snapshotRunColumns+`
+		FROM snapshot_runs
+		WHERE `+filter+` AND status = 'success'
+		ORDER BY start_time DESC
+		LIMIT 1
+	`, args...)
 
+	run, err := scanSnapshotRun(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &run, nil
 }
 
@@ -330,7 +515,7 @@ func (d *DB) GetPaginatedRuns(offset, limit int, includeDeleted bool, onlyPersis
 	switch {
 	case !includeDeleted && onlyPersisted:
 		query = `
-			SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+			SELECT ` + snapshotRunColumns + `
 			FROM snapshot_runs
 			WHERE deleted = 0 AND persisted = 1
 			ORDER BY start_time DESC
@@ -338,7 +523,7 @@ func (d *DB) GetPaginatedRuns(offset, limit int, includeDeleted bool, onlyPersis
 		`
 	case includeDeleted && onlyPersisted:
 		query = `
-			SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+			SELECT ` + snapshotRunColumns + `
 			FROM snapshot_runs
 			WHERE persisted = 1
 			ORDER BY start_time DESC
@@ -346,14 +531,14 @@ func (d *DB) GetPaginatedRuns(offset, limit int, includeDeleted bool, onlyPersis
 		`
 	case includeDeleted && !onlyPersisted:
 		query = `
-			SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+			SELECT ` + snapshotRunColumns + `
 			FROM snapshot_runs
 			ORDER BY start_time DESC
 			LIMIT ? OFFSET ?
 		`
 	default: // !includeDeleted && !onlyPersisted
 		query = `
-			SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+			SELECT ` + snapshotRunColumns + `
 			FROM snapshot_runs
 			WHERE deleted = 0
 			ORDER BY start_time DESC
@@ -376,33 +561,10 @@ func (d *DB) GetPaginatedRuns(offset, limit int, includeDeleted bool, onlyPersis
 
 	runs = []SnapshotRun{}
 	for rows.Next() {
-		var run SnapshotRun
-		var endTime sql.NullTime
-		var errorMessage sql.NullString
-		var persisted sql.NullBool
-		err := rows.Scan(
-			&run.ID,
-			&run.BlockHeight,
-			&run.StartTime,
-			&endTime,
-			&run.Status,
-			&errorMessage,
-			&run.DryRun,
-			&run.Deleted,
-			&persisted,
-		)
+		run, err := scanSnapshotRun(rows)
 		if err != nil {
 			return nil, err
 		}
-		if endTime.Valid {
-			run.EndTime = endTime.Time
-		}
-		if errorMessage.Valid {
-			run.ErrorMessage = errorMessage.String
-		}
-		if persisted.Valid {
-			run.Persisted = persisted.Bool
-		}
 
 		targets, err := d.GetTargetSnapshotsForRun(run.ID)
 		if err != nil {
@@ -416,7 +578,7 @@ func (d *DB) GetPaginatedRuns(offset, limit int, includeDeleted bool, onlyPersis
 
 func (d *DB) GetSuccessfulRunsForCleanup() (runs []SnapshotRun, err error) {
 	rows, err := d.db.Query(`
-		SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT ` + snapshotRunColumns + `
 		FROM snapshot_runs
 		WHERE status = 'success' AND deleted = 0
 		ORDER BY block_height DESC
@@ -434,32 +596,10 @@ func (d *DB) GetSuccessfulRunsForCleanup() (runs []SnapshotRun, err error) {
 
 	runs = []SnapshotRun{}
 	for rows.Next() {
-		var run SnapshotRun
-		var endTime sql.NullTime
-		var errorMessage sql.NullString
-		var deleted bool
-		var persisted bool
-		err := rows.Scan(
-			&run.ID,
-			&run.BlockHeight,
-			&run.StartTime,
-			&endTime,
-			&run.Status,
-			&errorMessage,
-			&run.DryRun,
-			&deleted,
-			&persisted,
-		)
+		run, err := scanSnapshotRun(rows)
 		if err != nil {
 			return nil, err
 		}
-		if endTime.Valid {
-			run.EndTime = endTime.Time
-		}
-		if errorMessage.Valid {
-			run.ErrorMessage = errorMessage.String
-		}
-		run.Persisted = persisted
 
 		// Get associated target snapshots
 		targets, err := d.GetTargetSnapshotsForRun(run.ID)
@@ -512,26 +652,12 @@ func (d *DB) SetSnapshotRunPersisted(id int64, persisted bool) error {
 // Get a single snapshot run by ID
 func (d *DB) GetSnapshotRunByID(id int64) (*SnapshotRun, error) {
 	row := d.db.QueryRow(`
-		SELECT id, block_height, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT `+snapshotRunColumns+`
 		FROM snapshot_runs
 		WHERE id = ?
 	`, id)
 
-	var run SnapshotRun
-	var endTime sql.NullTime
-	var errorMessage sql.NullString
-	var persisted sql.NullBool
-	err := row.Scan(
-		&run.ID,
-		&run.BlockHeight,
-		&run.StartTime,
-		&endTime,
-		&run.Status,
-		&errorMessage,
-		&run.DryRun,
-		&run.Deleted,
-		&persisted,
-	)
+	run, err := scanSnapshotRun(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -539,20 +665,21 @@ func (d *DB) GetSnapshotRunByID(id int64) (*SnapshotRun, error) {
 		return nil, err
 	}
 
-	if endTime.Valid {
-		run.EndTime = endTime.Time
-	}
-	if errorMessage.Valid {
-		run.ErrorMessage = errorMessage.String
-	}
-	if persisted.Valid {
-		run.Persisted = persisted.Bool
-	}
-
 	targets, err := d.GetTargetSnapshotsForRun(run.ID)
 	if err != nil {
 		return nil, err
 	}
+	for i := range targets {
+		if targets[i].Phases, err = d.GetTargetSnapshotPhases(targets[i].ID); err != nil {
+			return nil, err
+		}
+		if targets[i].Hooks, err = d.GetTargetSnapshotHooks(targets[i].ID); err != nil {
+			return nil, err
+		}
+		if targets[i].Replicas, err = d.GetTargetSnapshotReplicas(targets[i].ID); err != nil {
+			return nil, err
+		}
+	}
 	run.TargetsSnapshot = targets
 
 	return &run, nil
@@ -571,28 +698,12 @@ func (d *DB) SetTargetSnapshotPersisted(id int64, persisted bool) error {
 // Get a single target snapshot by ID
 func (d *DB) GetTargetSnapshotByID(id int64) (*TargetSnapshot, error) {
 	row := d.db.QueryRow(`
-		SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT `+targetSnapshotColumns+`
 		FROM target_snapshots
 		WHERE id = ?
 	`, id)
 
-	var target TargetSnapshot
-	var endTime sql.NullTime
-	var errorMessage sql.NullString
-	var persisted sql.NullBool
-	err := row.Scan(
-		&target.ID,
-		&target.SnapshotRunID,
-		&target.Alias,
-		&target.UploadPrefix,
-		&target.StartTime,
-		&endTime,
-		&target.Status,
-		&errorMessage,
-		&target.DryRun,
-		&target.Deleted,
-		&persisted,
-	)
+	target, err := scanTargetSnapshot(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -600,26 +711,26 @@ func (d *DB) GetTargetSnapshotByID(id int64) (*TargetSnapshot, error) {
 		return nil, err
 	}
 
-	if endTime.Valid {
-		target.EndTime = endTime.Time
+	if target.Phases, err = d.GetTargetSnapshotPhases(target.ID); err != nil {
+		return nil, err
 	}
-	if errorMessage.Valid {
-		target.ErrorMessage = errorMessage.String
+	if target.Hooks, err = d.GetTargetSnapshotHooks(target.ID); err != nil {
+		return nil, err
 	}
-	if persisted.Valid {
-		target.Persisted = persisted.Bool
+	if target.Replicas, err = d.GetTargetSnapshotReplicas(target.ID); err != nil {
+		return nil, err
 	}
 
 	return &target, nil
 }
 
-// Get all target snapshots for cleanup that are successful and not deleted
-func (d *DB) GetSuccessfulTargetSnapshotsForCleanup() (targets []TargetSnapshot, err error) {
+// GetTargetSnapshotsForReconcile returns all target snapshots that are not dry runs, oldest first
+func (d *DB) GetTargetSnapshotsForReconcile() (targets []TargetSnapshot, err error) {
 	rows, err := d.db.Query(`
-		SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
+		SELECT ` + targetSnapshotColumns + `
 		FROM target_snapshots
-		WHERE status = 'success' AND deleted = 0
-		ORDER BY start_time DESC
+		WHERE dry_run = 0
+		ORDER BY id ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -634,81 +745,173 @@ func (d *DB) GetSuccessfulTargetSnapshotsForCleanup() (targets []TargetSnapshot,
 
 	targets = []TargetSnapshot{}
 	for rows.Next() {
-		var target TargetSnapshot
-		var endTime sql.NullTime
-		var errorMessage sql.NullString
-		var persisted sql.NullBool
-		err := rows.Scan(
-			&target.ID,
-			&target.SnapshotRunID,
-			&target.Alias,
-			&target.UploadPrefix,
-			&target.StartTime,
-			&endTime,
-			&target.Status,
-			&errorMessage,
-			&target.DryRun,
-			&target.Deleted,
-			&persisted,
-		)
+		target, err := scanTargetSnapshot(rows)
 		if err != nil {
 			return nil, err
 		}
-		if endTime.Valid {
-			target.EndTime = endTime.Time
-		}
-		if errorMessage.Valid {
-			target.ErrorMessage = errorMessage.String
-		}
-		if persisted.Valid {
-			target.Persisted = persisted.Bool
-		}
 		targets = append(targets, target)
 	}
-	return targets, nil
+	return targets, rows.Err()
 }
 
-// Mark a single target snapshot as deleted
-func (d *DB) MarkTargetSnapshotAsDeleted(id int64) error {
-	_, err := d.db.Exec(
-		"UPDATE target_snapshots SET deleted = 1 WHERE id = ?",
-		id,
-	)
-	return err
+// StoredTargetSnapshot is a successful target snapshot with the run it belongs to, for storage accounting
+type StoredTargetSnapshot struct {
+	TargetSnapshot
+	Group       string
+	BlockHeight uint64
+	RunTime     time.Time
 }
 
-// GetTargetSnapshotsByAlias gets all target snapshots matching the given alias
-func (d *DB) GetTargetSnapshotsByAlias(alias string, limit, offset int, includeDeleted bool, onlyPersisted bool) (targets []TargetSnapshot, err error) {
-	if limit > 20 {
-		limit = 20
-	}
+// runScanner scans the run columns selected after the target snapshot columns of a row
+type runScanner struct {
+	row    rowScanner
+	target *StoredTargetSnapshot
+}
 
-	var query string
-	var args []interface{}
+func (r runScanner) Scan(dest ...interface{}) error {
+	return r.row.Scan(append(dest, &r.target.Group, &r.target.BlockHeight, &r.target.RunTime)...)
+}
 
-	// Build query based on filter options
-	switch {
-	case !includeDeleted && onlyPersisted:
-		query = `
-			SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
-			FROM target_snapshots
-			WHERE alias = ? AND deleted = 0 AND persisted = 1
-			ORDER BY start_time DESC
-			LIMIT ? OFFSET ?
-		`
-		args = []interface{}{alias, limit, offset}
-	case includeDeleted && onlyPersisted:
-		query = `
-			SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
-			FROM target_snapshots
-			WHERE alias = ? AND persisted = 1
-			ORDER BY start_time DESC
-			LIMIT ? OFFSET ?
+// GetStoredTargetSnapshots returns the successful target snapshots that weren't dry runs, including deleted ones,
+// with their run, most recent first
+func (d *DB) GetStoredTargetSnapshots() (targets []StoredTargetSnapshot, err error) {
+	rows, err := d.db.Query(`
+		SELECT t.` + strings.ReplaceAll(targetSnapshotColumns, ", ", ", t.") + `, r.group_name, r.block_height, r.start_time
+		FROM target_snapshots t
+		JOIN snapshot_runs r ON r.id = t.snapshot_run_id
+		WHERE t.status = 'success' AND t.dry_run = 0
+		ORDER BY r.block_height DESC, t.id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	targets = []StoredTargetSnapshot{}
+	for rows.Next() {
+		var target StoredTargetSnapshot
+		if target.TargetSnapshot, err = scanTargetSnapshot(runScanner{row: rows, target: &target}); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// GetFailedTargetSnapshotsForCleanup returns the target snapshots that failed, were interrupted or cancelled and
+// weren't deleted yet, oldest first. Target snapshots sharing their upload prefix with a successful or running
+// one are left out, as deleting their objects would delete that one's.
+func (d *DB) GetFailedTargetSnapshotsForCleanup() (targets []TargetSnapshot, err error) {
+	rows, err := d.db.Query(`
+		SELECT ` + targetSnapshotColumns + `
+		FROM target_snapshots t
+		WHERE t.status IN ('failed', 'interrupted', 'cancelled') AND t.deleted = 0 AND t.dry_run = 0
+			AND NOT EXISTS (
+				SELECT 1 FROM target_snapshots o
+				WHERE o.upload_prefix = t.upload_prefix AND o.id != t.id AND o.deleted = 0
+					AND o.status IN ('success', 'running')
+			)
+		ORDER BY t.id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	targets = []TargetSnapshot{}
+	for rows.Next() {
+		target, err := scanTargetSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// Get all target snapshots for cleanup that are successful and not deleted
+func (d *DB) GetSuccessfulTargetSnapshotsForCleanup() (targets []TargetSnapshot, err error) {
+	rows, err := d.db.Query(`
+		SELECT ` + targetSnapshotColumns + `
+		FROM target_snapshots
+		WHERE status = 'success' AND deleted = 0
+		ORDER BY start_time DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	targets = []TargetSnapshot{}
+	for rows.Next() {
+		target, err := scanTargetSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// Mark a single target snapshot as deleted
+func (d *DB) MarkTargetSnapshotAsDeleted(id int64) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshots SET deleted = 1 WHERE id = ?",
+		id,
+	)
+	return err
+}
+
+// GetTargetSnapshotsByAlias gets all target snapshots matching the given alias
+func (d *DB) GetTargetSnapshotsByAlias(alias string, limit, offset int, includeDeleted bool, onlyPersisted bool) (targets []TargetSnapshot, err error) {
+	if limit > 20 {
+		limit = 20
+	}
+
+	var query string
+	var args []interface{}
+
+	// Build query based on filter options
+	switch {
+	case !includeDeleted && onlyPersisted:
+		query = `
+			SELECT ` + targetSnapshotColumns + `
+			FROM target_snapshots
+			WHERE alias = ? AND deleted = 0 AND persisted = 1
+			ORDER BY start_time DESC
+			LIMIT ? OFFSET ?
+		`
+		args = []interface{}{alias, limit, offset}
+	case includeDeleted && onlyPersisted:
+		query = `
+			SELECT ` + targetSnapshotColumns + `
+			FROM target_snapshots
+			WHERE alias = ? AND persisted = 1
+			ORDER BY start_time DESC
+			LIMIT ? OFFSET ?
 		`
 		args = []interface{}{alias, limit, offset}
 	case includeDeleted && !onlyPersisted:
 		query = `
-			SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
+			SELECT ` + targetSnapshotColumns + `
 			FROM target_snapshots
 			WHERE alias = ?
 			ORDER BY start_time DESC
@@ -717,7 +920,7 @@ func (d *DB) GetTargetSnapshotsByAlias(alias string, limit, offset int, includeD
 		args = []interface{}{alias, limit, offset}
 	default: // !includeDeleted && !onlyPersisted
 		query = `
-			SELECT id, snapshot_run_id, alias, upload_prefix, start_time, end_time, status, error_message, dry_run, deleted, persisted
+			SELECT ` + targetSnapshotColumns + `
 			FROM target_snapshots
 			WHERE alias = ? AND deleted = 0
 			ORDER BY start_time DESC
@@ -740,36 +943,602 @@ func (d *DB) GetTargetSnapshotsByAlias(alias string, limit, offset int, includeD
 
 	targets = []TargetSnapshot{}
 	for rows.Next() {
-		var target TargetSnapshot
-		var endTime sql.NullTime
-		var errorMessage sql.NullString
-		var persisted sql.NullBool
-		err := rows.Scan(
-			&target.ID,
-			&target.SnapshotRunID,
-			&target.Alias,
-			&target.UploadPrefix,
-			&target.StartTime,
-			&endTime,
-			&target.Status,
-			&errorMessage,
-			&target.DryRun,
-			&target.Deleted,
-			&persisted,
-		)
+		target, err := scanTargetSnapshot(rows)
 		if err != nil {
 			return nil, err
 		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// LastSuccessfulSnapshot holds the most recent successful snapshot for an alias
+type LastSuccessfulSnapshot struct {
+	Alias       string
+	BlockHeight uint64
+	EndTime     time.Time
+}
+
+// GetLastSuccessfulSnapshotsByAlias returns the most recent successful, non dry-run snapshot for every alias
+func (d *DB) GetLastSuccessfulSnapshotsByAlias() (snapshots []LastSuccessfulSnapshot, err error) {
+	rows, err := d.db.Query(`
+		SELECT t.alias, r.block_height, t.end_time
+		FROM target_snapshots t
+		JOIN snapshot_runs r ON r.id = t.snapshot_run_id
+		WHERE t.status = 'success' AND t.dry_run = 0
+		AND r.block_height = (
+			SELECT MAX(r2.block_height)
+			FROM target_snapshots t2
+			JOIN snapshot_runs r2 ON r2.id = t2.snapshot_run_id
+			WHERE t2.alias = t.alias AND t2.status = 'success' AND t2.dry_run = 0
+		)
+		GROUP BY t.alias
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	snapshots = []LastSuccessfulSnapshot{}
+	for rows.Next() {
+		var snapshot LastSuccessfulSnapshot
+		var endTime sql.NullTime
+		if err := rows.Scan(&snapshot.Alias, &snapshot.BlockHeight, &endTime); err != nil {
+			return nil, err
+		}
 		if endTime.Valid {
-			target.EndTime = endTime.Time
+			snapshot.EndTime = endTime.Time
 		}
-		if errorMessage.Valid {
-			target.ErrorMessage = errorMessage.String
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// GetRunsByStatus gets all snapshot runs with the given status
+func (d *DB) GetRunsByStatus(status string) (runs []SnapshotRun, err error) {
+	rows, err := d.db.Query(`
+		SELECT `+snapshotRunColumns+`
+		FROM snapshot_runs
+		WHERE status = ?
+		ORDER BY start_time DESC
+	`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
 		}
-		if persisted.Valid {
-			target.Persisted = persisted.Bool
+	}()
+
+	runs = []SnapshotRun{}
+	for rows.Next() {
+		run, err := scanSnapshotRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range runs {
+		targets, err := d.GetTargetSnapshotsForRun(runs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		runs[i].TargetsSnapshot = targets
+	}
+	return runs, nil
+}
+
+// UpdateRunningTargetSnapshotsStatus updates the status of all target snapshots of a run that are still running
+func (d *DB) UpdateRunningTargetSnapshotsStatus(runID int64, status string, errorMsg string) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshots SET status = ?, error_message = ?, end_time = ? WHERE snapshot_run_id = ? AND status = 'running'",
+		status,
+		errorMsg,
+		time.Now(),
+		runID,
+	)
+	return err
+}
+
+// SetTargetSnapshotArchive records the SHA-256 and size of the uploaded archive of a target snapshot
+func (d *DB) SetTargetSnapshotArchive(id int64, sha256 string, size int64) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshots SET sha256 = ?, size = ? WHERE id = ?",
+		sha256,
+		size,
+		id,
+	)
+	return err
+}
+
+// SetTargetSnapshotUsage records the number and total size of the objects stored for a target snapshot
+func (d *DB) SetTargetSnapshotUsage(id int64, objects int, bytes int64) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshots SET object_count = ?, total_bytes = ? WHERE id = ?",
+		objects,
+		bytes,
+		id,
+	)
+	return err
+}
+
+// SetTargetSnapshotDetails records the hash of the block a target snapshot was taken at and the client version
+func (d *DB) SetTargetSnapshotDetails(id int64, blockHash, clientVersion string) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshots SET block_hash = ?, client_version = ? WHERE id = ?",
+		blockHash,
+		clientVersion,
+		id,
+	)
+	return err
+}
+
+// GetAvailableTargetSnapshots returns the target snapshots that can be downloaded: successful, not deleted
+// and not waiting for or failed verification, ordered by alias and most recent first
+func (d *DB) GetAvailableTargetSnapshots() (targets []TargetSnapshot, err error) {
+	rows, err := d.db.Query(`
+		SELECT ` + targetSnapshotColumns + `
+		FROM target_snapshots
+		WHERE status = 'success' AND deleted = 0 AND dry_run = 0 AND verification IN ('', 'verified')
+		ORDER BY alias, start_time DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	targets = []TargetSnapshot{}
+	for rows.Next() {
+		target, err := scanTargetSnapshot(rows)
+		if err != nil {
+			return nil, err
 		}
 		targets = append(targets, target)
 	}
 	return targets, nil
 }
+
+// SetTargetSnapshotVerification records the verification state of a target snapshot
+func (d *DB) SetTargetSnapshotVerification(id int64, state string, errorMsg string) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshots SET verification = ?, verification_error = ?, verification_time = ? WHERE id = ?",
+		state,
+		errorMsg,
+		time.Now(),
+		id,
+	)
+	return err
+}
+
+// ResetRunningVerifications marks verifications that were cut short by a shutdown as pending again
+func (d *DB) ResetRunningVerifications() (int64, error) {
+	result, err := d.db.Exec("UPDATE target_snapshots SET verification = 'pending' WHERE verification = 'running'")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPendingVerifications returns the most recent successful target snapshot of every alias that
+// is still waiting to be verified
+func (d *DB) GetPendingVerifications() (targets []TargetSnapshot, err error) {
+	rows, err := d.db.Query(`
+		SELECT ` + targetSnapshotColumns + `
+		FROM target_snapshots
+		WHERE id IN (
+			SELECT MAX(id) FROM target_snapshots
+			WHERE status = 'success' AND deleted = 0 AND dry_run = 0 AND verification = 'pending'
+			GROUP BY alias
+		)
+		ORDER BY alias
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	targets = []TargetSnapshot{}
+	for rows.Next() {
+		target, err := scanTargetSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// GetLatestUsableTargetSnapshot returns the successful, non-deleted target snapshot of an alias at the highest
+// block, together with that block. Snapshots that are waiting for or failed verification are skipped.
+// It returns nil if there is none.
+func (d *DB) GetLatestUsableTargetSnapshot(alias string) (*TargetSnapshot, uint64, error) {
+	var id int64
+	var block uint64
+	err := d.db.QueryRow(`
+		SELECT t.id, r.block_height
+		FROM target_snapshots t
+		JOIN snapshot_runs r ON r.id = t.snapshot_run_id
+		WHERE t.alias = ? AND t.status = 'success' AND t.deleted = 0 AND t.dry_run = 0
+			AND t.verification IN ('', 'verified')
+		ORDER BY r.block_height DESC, t.id DESC
+		LIMIT 1
+	`, alias).Scan(&id, &block)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	target, err := d.GetTargetSnapshotByID(id)
+	if err != nil || target == nil {
+		return nil, 0, err
+	}
+	return target, block, nil
+}
+
+// GetLatestUsableRunBlock returns the highest block of a successful, non-deleted run whose snapshots are
+// all usable, i.e. not waiting for or failed verification, and that has a snapshot of every given alias. It
+// returns 0 if there is none.
+func (d *DB) GetLatestUsableRunBlock(aliases []string) (uint64, error) {
+	query := `
+		SELECT MAX(r.block_height)
+		FROM snapshot_runs r
+		WHERE r.status = 'success' AND r.deleted = 0 AND r.dry_run = 0
+			AND NOT EXISTS (
+				SELECT 1 FROM target_snapshots t
+				WHERE t.snapshot_run_id = r.id AND t.verification NOT IN ('', 'verified')
+			)`
+	args := make([]interface{}, 0, len(aliases))
+	for _, alias := range aliases {
+		query += `
+			AND EXISTS (
+				SELECT 1 FROM target_snapshots t
+				WHERE t.snapshot_run_id = r.id AND t.alias = ? AND t.status = 'success' AND t.deleted = 0
+			)`
+		args = append(args, alias)
+	}
+
+	var block sql.NullInt64
+	if err := d.db.QueryRow(query, args...).Scan(&block); err != nil {
+		return 0, err
+	}
+	return uint64(block.Int64), nil
+}
+
+// RecordTargetSnapshotPhase records that a target snapshot completed the given phase
+func (d *DB) RecordTargetSnapshotPhase(id int64, phase string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec("UPDATE target_snapshots SET phase = ? WHERE id = ?", phase, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO target_snapshot_phases (target_snapshot_id, phase, time) VALUES (?, ?, ?)",
+		id,
+		phase,
+		time.Now(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordTargetSnapshotPhaseFailure records that a target snapshot failed to reach the given phase.
+// The last completed phase is kept so the target can be resumed from it.
+func (d *DB) RecordTargetSnapshotPhaseFailure(id int64, phase, errorMsg string) error {
+	_, err := d.db.Exec(
+		"INSERT INTO target_snapshot_phases (target_snapshot_id, phase, time, error_message) VALUES (?, ?, ?, ?)",
+		id,
+		phase,
+		time.Now(),
+		errorMsg,
+	)
+	return err
+}
+
+// GetTargetSnapshotPhases returns the phase transitions of a target snapshot in the order they happened
+func (d *DB) GetTargetSnapshotPhases(id int64) (phases []PhaseTransition, err error) {
+	rows, err := d.db.Query(`
+		SELECT phase, time, error_message
+		FROM target_snapshot_phases
+		WHERE target_snapshot_id = ?
+		ORDER BY id ASC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	phases = []PhaseTransition{}
+	for rows.Next() {
+		var p PhaseTransition
+		if err := rows.Scan(&p.Phase, &p.Time, &p.ErrorMessage); err != nil {
+			return nil, err
+		}
+		phases = append(phases, p)
+	}
+	return phases, rows.Err()
+}
+
+// RecordContainerRemediation stores an attempt of the watchdog to bring a container back up
+func (d *DB) RecordContainerRemediation(r *ContainerRemediation) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	result, err := d.db.Exec(`
+		INSERT INTO container_remediations
+			(alias, container, role, trigger, snapshot_run_id, state, action, attempt, success, error_message, time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Alias, r.Container, r.Role, r.Trigger, r.SnapshotRunID, r.State, r.Action, r.Attempt, r.Success, r.ErrorMessage, r.Time)
+	if err != nil {
+		return err
+	}
+	r.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetContainerRemediations returns the most recent remediations first, of all targets if alias is empty
+func (d *DB) GetContainerRemediations(alias string, limit, offset int) (remediations []ContainerRemediation, err error) {
+	query := `
+		SELECT id, alias, container, role, trigger, snapshot_run_id, state, action, attempt, success, error_message, time
+		FROM container_remediations
+	`
+	var args []interface{}
+	if alias != "" {
+		query += " WHERE alias = ?"
+		args = append(args, alias)
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	remediations = []ContainerRemediation{}
+	for rows.Next() {
+		var r ContainerRemediation
+		if err := rows.Scan(&r.ID, &r.Alias, &r.Container, &r.Role, &r.Trigger, &r.SnapshotRunID, &r.State, &r.Action,
+			&r.Attempt, &r.Success, &r.ErrorMessage, &r.Time); err != nil {
+			return nil, err
+		}
+		remediations = append(remediations, r)
+	}
+	return remediations, rows.Err()
+}
+
+// RecordHookExecution records a hook run for a target snapshot
+func (d *DB) RecordHookExecution(id int64, h HookExecution) error {
+	_, err := d.db.Exec(`
+		INSERT INTO target_snapshot_hooks
+			(target_snapshot_id, hook, name, command, start_time, end_time, success, output, error_message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, h.Hook, h.Name, h.Command, h.Start, h.End, h.Success, h.Output, h.ErrorMessage)
+	return err
+}
+
+// GetTargetSnapshotHooks returns the hooks run for a target snapshot in the order they ran
+func (d *DB) GetTargetSnapshotHooks(id int64) (hooks []HookExecution, err error) {
+	rows, err := d.db.Query(`
+		SELECT hook, name, command, start_time, end_time, success, output, error_message
+		FROM target_snapshot_hooks
+		WHERE target_snapshot_id = ?
+		ORDER BY id ASC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	hooks = []HookExecution{}
+	for rows.Next() {
+		var h HookExecution
+		if err := rows.Scan(&h.Hook, &h.Name, &h.Command, &h.Start, &h.End, &h.Success, &h.Output, &h.ErrorMessage); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// replicaColumns are the columns selected for a Replica, in the order scanReplica expects them
+const replicaColumns = "target_snapshot_id, destination, status, objects, bytes, start_time, end_time, error_message, keys, deleted"
+
+// scanReplica scans a row selected with replicaColumns
+func scanReplica(row rowScanner) (Replica, error) {
+	var r Replica
+	var startTime, endTime sql.NullTime
+	var keys string
+	err := row.Scan(&r.TargetSnapshotID, &r.Destination, &r.Status, &r.Objects, &r.Bytes, &startTime, &endTime,
+		&r.ErrorMessage, &keys, &r.Deleted)
+	if err != nil {
+		return r, err
+	}
+	if startTime.Valid {
+		r.StartTime = &startTime.Time
+	}
+	if endTime.Valid {
+		r.EndTime = &endTime.Time
+	}
+	if err := json.Unmarshal([]byte(keys), &r.Keys); err != nil {
+		return r, fmt.Errorf("invalid keys of replica %s of target snapshot %d: %w", r.Destination, r.TargetSnapshotID, err)
+	}
+	return r, nil
+}
+
+// queryReplicas returns the replicas selected by a query on target_snapshot_replicas
+func (d *DB) queryReplicas(query string, args ...interface{}) (replicas []Replica, err error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	replicas = []Replica{}
+	for rows.Next() {
+		r, err := scanReplica(rows)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+// CreateReplicas records a target snapshot as pending on the given destinations. Replicas that
+// already exist are left as they are.
+func (d *DB) CreateReplicas(id int64, destinations []string) error {
+	for _, dest := range destinations {
+		_, err := d.db.Exec(`
+			INSERT OR IGNORE INTO target_snapshot_replicas (target_snapshot_id, destination, status)
+			VALUES (?, ?, 'pending')
+		`, id, dest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetReplicaStatus sets the status of the replica of a target snapshot on a destination, "running"
+// also records the start of the attempt
+func (d *DB) SetReplicaStatus(id int64, destination, status string) error {
+	query := "UPDATE target_snapshot_replicas SET status = ? WHERE target_snapshot_id = ? AND destination = ?"
+	args := []interface{}{status, id, destination}
+	if status == "running" {
+		query = `
+			UPDATE target_snapshot_replicas SET status = ?, start_time = ?, end_time = NULL, error_message = ''
+			WHERE target_snapshot_id = ? AND destination = ?
+		`
+		args = []interface{}{status, time.Now(), id, destination}
+	}
+	_, err := d.db.Exec(query, args...)
+	return err
+}
+
+// FinishReplica records the outcome of copying a target snapshot to a destination
+func (d *DB) FinishReplica(id int64, destination string, objects int, bytes int64, keys []string, errorMsg string) error {
+	status := "success"
+	if errorMsg != "" {
+		status = "failed"
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	encoded, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(`
+		UPDATE target_snapshot_replicas
+		SET status = ?, objects = ?, bytes = ?, keys = ?, error_message = ?, end_time = ?
+		WHERE target_snapshot_id = ? AND destination = ?
+	`, status, objects, bytes, string(encoded), errorMsg, time.Now(), id, destination)
+	return err
+}
+
+// GetTargetSnapshotReplicas returns the replicas of a target snapshot by destination name
+func (d *DB) GetTargetSnapshotReplicas(id int64) ([]Replica, error) {
+	return d.queryReplicas(`
+		SELECT `+replicaColumns+`
+		FROM target_snapshot_replicas
+		WHERE target_snapshot_id = ?
+		ORDER BY destination
+	`, id)
+}
+
+// GetPendingReplicas returns the pending replicas of successful target snapshots that weren't deleted,
+// oldest first
+func (d *DB) GetPendingReplicas() ([]Replica, error) {
+	return d.queryReplicas(`
+		SELECT ` + replicaColumns + `
+		FROM target_snapshot_replicas
+		WHERE status = 'pending' AND deleted = 0 AND target_snapshot_id IN (
+			SELECT id FROM target_snapshots WHERE status = 'success' AND deleted = 0
+		)
+		ORDER BY target_snapshot_id, destination
+	`)
+}
+
+// ResetRunningReplicas marks replicas that were cut short by a shutdown as pending again
+func (d *DB) ResetRunningReplicas() (int64, error) {
+	result, err := d.db.Exec("UPDATE target_snapshot_replicas SET status = 'pending' WHERE status = 'running'")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MarkReplicaAsDeleted marks the replica of a target snapshot on a destination as deleted
+func (d *DB) MarkReplicaAsDeleted(id int64, destination string) error {
+	_, err := d.db.Exec(
+		"UPDATE target_snapshot_replicas SET deleted = 1 WHERE target_snapshot_id = ? AND destination = ?",
+		id,
+		destination,
+	)
+	return err
+}
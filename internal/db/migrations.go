@@ -31,6 +31,51 @@ var migrations = []Migration{
 		Name:    "Add persisted column to target_snapshots table",
 		Migrate: migrateAddPersistedColumnToTargetSnapshots,
 	},
+	{
+		ID:      4,
+		Name:    "Add group_name column to snapshot_runs table",
+		Migrate: migrateAddGroupNameColumn,
+	},
+	{
+		ID:      5,
+		Name:    "Add phase column to target_snapshots and target_snapshot_phases table",
+		Migrate: migrateAddTargetSnapshotPhases,
+	},
+	{
+		ID:      6,
+		Name:    "Add sha256 and size columns to target_snapshots table",
+		Migrate: migrateAddArchiveColumns,
+	},
+	{
+		ID:      7,
+		Name:    "Add verification columns to target_snapshots table",
+		Migrate: migrateAddVerificationColumns,
+	},
+	{
+		ID:      8,
+		Name:    "Add block_hash and client_version columns to target_snapshots table",
+		Migrate: migrateAddSnapshotDetailColumns,
+	},
+	{
+		ID:      9,
+		Name:    "Add container_remediations table",
+		Migrate: migrateAddContainerRemediations,
+	},
+	{
+		ID:      10,
+		Name:    "Add target_snapshot_hooks table",
+		Migrate: migrateAddTargetSnapshotHooks,
+	},
+	{
+		ID:      11,
+		Name:    "Add target_snapshot_replicas table",
+		Migrate: migrateAddTargetSnapshotReplicas,
+	},
+	{
+		ID:      12,
+		Name:    "Add object_count and total_bytes columns to target_snapshots table",
+		Migrate: migrateAddStorageColumns,
+	},
 }
 
 // migrateAddDeletedColumn adds the deleted column to the snapshot_runs and target_snapshots tables
@@ -132,6 +177,244 @@ func migrateAddPersistedColumnToTargetSnapshots(db *sql.DB) error {
 	return nil
 }
 
+// migrateAddGroupNameColumn adds the group_name column to the snapshot_runs table.
+// Runs recorded before scheduling groups existed are left with an empty group name.
+func migrateAddGroupNameColumn(db *sql.DB) error {
+	var columnExists int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('snapshot_runs')
+		WHERE name='group_name'
+	`).Scan(&columnExists)
+	if err != nil {
+		return fmt.Errorf("failed to check if group_name column exists in snapshot_runs: %w", err)
+	}
+
+	if columnExists == 0 {
+		_, err := db.Exec(`
+			ALTER TABLE snapshot_runs
+			ADD COLUMN group_name TEXT NOT NULL DEFAULT ''
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to add group_name column to snapshot_runs: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// migrateAddTargetSnapshotPhases adds the last completed phase to target_snapshots and a table
+// recording every phase transition of a target snapshot
+func migrateAddTargetSnapshotPhases(db *sql.DB) error {
+	var columnExists int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('target_snapshots')
+		WHERE name='phase'
+	`).Scan(&columnExists)
+	if err != nil {
+		return fmt.Errorf("failed to check if phase column exists in target_snapshots: %w", err)
+	}
+
+	if columnExists == 0 {
+		_, err := db.Exec(`
+			ALTER TABLE target_snapshots
+			ADD COLUMN phase TEXT NOT NULL DEFAULT ''
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to add phase column to target_snapshots: %w", err)
+		}
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS target_snapshot_phases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_snapshot_id INTEGER NOT NULL,
+			phase TEXT NOT NULL,
+			time DATETIME NOT NULL,
+			error_message TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY(target_snapshot_id) REFERENCES target_snapshots(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create target_snapshot_phases table: %w", err)
+	}
+
+	return nil
+}
+
+// migrateAddArchiveColumns adds the SHA-256 and size of the uploaded archive to target_snapshots
+func migrateAddArchiveColumns(db *sql.DB) error {
+	columns := map[string]string{
+		"sha256": "TEXT NOT NULL DEFAULT ''",
+		"size":   "INTEGER NOT NULL DEFAULT 0",
+	}
+	for _, name := range []string{"sha256", "size"} {
+		var columnExists int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('target_snapshots')
+			WHERE name=?
+		`, name).Scan(&columnExists)
+		if err != nil {
+			return fmt.Errorf("failed to check if %s column exists in target_snapshots: %w", name, err)
+		}
+
+		if columnExists == 0 {
+			_, err := db.Exec(`ALTER TABLE target_snapshots ADD COLUMN ` + name + ` ` + columns[name])
+			if err != nil {
+				return fmt.Errorf("failed to add %s column to target_snapshots: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateAddVerificationColumns adds the verification state, time and error to target_snapshots
+func migrateAddVerificationColumns(db *sql.DB) error {
+	columns := map[string]string{
+		"verification":       "TEXT NOT NULL DEFAULT ''",
+		"verification_time":  "DATETIME",
+		"verification_error": "TEXT NOT NULL DEFAULT ''",
+	}
+	for _, name := range []string{"verification", "verification_time", "verification_error"} {
+		var columnExists int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('target_snapshots')
+			WHERE name=?
+		`, name).Scan(&columnExists)
+		if err != nil {
+			return fmt.Errorf("failed to check if %s column exists in target_snapshots: %w", name, err)
+		}
+
+		if columnExists == 0 {
+			_, err := db.Exec(`ALTER TABLE target_snapshots ADD COLUMN ` + name + ` ` + columns[name])
+			if err != nil {
+				return fmt.Errorf("failed to add %s column to target_snapshots: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateAddSnapshotDetailColumns adds the hash of the snapshot block and the client version to target_snapshots
+func migrateAddSnapshotDetailColumns(db *sql.DB) error {
+	for _, name := range []string{"block_hash", "client_version"} {
+		var columnExists int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('target_snapshots')
+			WHERE name=?
+		`, name).Scan(&columnExists)
+		if err != nil {
+			return fmt.Errorf("failed to check if %s column exists in target_snapshots: %w", name, err)
+		}
+
+		if columnExists == 0 {
+			_, err := db.Exec(`ALTER TABLE target_snapshots ADD COLUMN ` + name + ` TEXT NOT NULL DEFAULT ''`)
+			if err != nil {
+				return fmt.Errorf("failed to add %s column to target_snapshots: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateAddContainerRemediations adds the table the watchdog records its attempts to bring containers back up in
+func migrateAddContainerRemediations(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS container_remediations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			alias TEXT NOT NULL,
+			container TEXT NOT NULL,
+			role TEXT NOT NULL,
+			trigger TEXT NOT NULL,
+			snapshot_run_id INTEGER NOT NULL DEFAULT 0,
+			state TEXT NOT NULL,
+			action TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			success BOOLEAN NOT NULL,
+			error_message TEXT NOT NULL DEFAULT '',
+			time DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create container_remediations table: %w", err)
+	}
+	return nil
+}
+
+// migrateAddTargetSnapshotHooks adds the table the hooks run for target snapshots are recorded in
+func migrateAddTargetSnapshotHooks(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS target_snapshot_hooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_snapshot_id INTEGER NOT NULL,
+			hook TEXT NOT NULL,
+			name TEXT NOT NULL,
+			command TEXT NOT NULL,
+			start_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			success BOOLEAN NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			error_message TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY(target_snapshot_id) REFERENCES target_snapshots(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create target_snapshot_hooks table: %w", err)
+	}
+	return nil
+}
+
+// migrateAddTargetSnapshotReplicas adds the table the copies of target snapshots on additional storage
+// destinations are recorded in
+func migrateAddTargetSnapshotReplicas(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS target_snapshot_replicas (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_snapshot_id INTEGER NOT NULL,
+			destination TEXT NOT NULL,
+			status TEXT NOT NULL,
+			objects INTEGER NOT NULL DEFAULT 0,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			start_time DATETIME,
+			end_time DATETIME,
+			error_message TEXT NOT NULL DEFAULT '',
+			keys TEXT NOT NULL DEFAULT '[]',
+			deleted BOOLEAN NOT NULL DEFAULT 0,
+			UNIQUE(target_snapshot_id, destination),
+			FOREIGN KEY(target_snapshot_id) REFERENCES target_snapshots(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create target_snapshot_replicas table: %w", err)
+	}
+	return nil
+}
+
+// migrateAddStorageColumns adds the number and total size of the objects stored for a target snapshot
+func migrateAddStorageColumns(db *sql.DB) error {
+	for _, name := range []string{"object_count", "total_bytes"} {
+		var columnExists int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('target_snapshots')
+			WHERE name=?
+		`, name).Scan(&columnExists)
+		if err != nil {
+			return fmt.Errorf("failed to check if %s column exists in target_snapshots: %w", name, err)
+		}
+
+		if columnExists == 0 {
+			_, err := db.Exec(`ALTER TABLE target_snapshots ADD COLUMN ` + name + ` INTEGER NOT NULL DEFAULT 0`)
+			if err != nil {
+				return fmt.Errorf("failed to add %s column to target_snapshots: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // RunMigrations runs all database migrations
 func RunMigrations(db *sql.DB) error {
 	// Create migrations table if it doesn't exist
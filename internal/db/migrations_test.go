@@ -70,8 +70,8 @@ func TestMigrations(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to query migrations table: %v", err)
 	}
-	if count != 3 {
-		t.Errorf("Expected 3 migration records, got %d", count)
+	if count != 12 {
+		t.Errorf("Expected 12 migration records, got %d", count)
 	}
 
 	// Check if the deleted column was added to snapshot_runs
@@ -122,4 +122,16 @@ func TestMigrations(t *testing.T) {
 	if columnCount != 1 {
 		t.Errorf("Expected persisted column in target_snapshots, but it wasn't found")
 	}
+
+	// Check if the group_name column was added to snapshot_runs
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('snapshot_runs')
+		WHERE name='group_name'
+	`).Scan(&columnCount)
+	if err != nil {
+		t.Fatalf("Failed to check snapshot_runs table for group_name column: %v", err)
+	}
+	if columnCount != 1 {
+		t.Errorf("Expected group_name column in snapshot_runs, but it wasn't found")
+	}
 }
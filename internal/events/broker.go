@@ -0,0 +1,86 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// historySize is the number of recent events kept to be replayed to reconnecting subscribers
+const historySize = 256
+
+// Broker fans out published events to its subscribers. Subscribers that fall behind by more than
+// historySize events are dropped, they can resubscribe from the last event they received.
+// All methods are safe to call on a nil receiver, publishing is a no-op then.
+type Broker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	subscribers map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		nextID:      1,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish sends an event of the given type to every subscriber
+func (b *Broker) Publish(eventType string, data any) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := Event{ID: b.nextID, Type: eventType, Time: time.Now().UTC(), Data: data}
+	b.nextID++
+	if len(b.history) == historySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, ev)
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			log.WithField("event_id", ev.ID).Warn("dropping events subscriber that fell behind")
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on. If lastID is set, the kept
+// events after it are replayed first. The channel is closed once cancel is called, or when the subscriber
+// is dropped for falling behind.
+func (b *Broker) Subscribe(lastID uint64) (<-chan Event, func()) {
+	ch := make(chan Event, historySize)
+	if b == nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastID > 0 {
+		for _, ev := range b.history {
+			if ev.ID > lastID {
+				ch <- ev
+			}
+		}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
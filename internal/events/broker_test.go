@@ -0,0 +1,61 @@
+package events
+
+import "testing"
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	b.Publish(TypeSyncCheck, SyncCheck{Group: "geth", Synced: true, BlockNumber: 100})
+
+	stream, cancel := b.Subscribe(0)
+	b.Publish(TypeRunFinished, RunFinished{RunID: 1, Status: "success"})
+	ev := <-stream
+	if ev.ID != 2 || ev.Type != TypeRunFinished {
+		t.Fatalf("expected only events published after subscribing, got %+v", ev)
+	}
+	cancel()
+	if _, ok := <-stream; ok {
+		t.Error("expected stream to be closed once cancelled")
+	}
+	cancel()
+
+	// Reconnecting subscribers get the events they missed first
+	resumed, cancel := b.Subscribe(1)
+	defer cancel()
+	if ev := <-resumed; ev.ID != 2 {
+		t.Errorf("expected event 2 to be replayed, got %+v", ev)
+	}
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	stream, cancel := b.Subscribe(0)
+	defer cancel()
+
+	for i := 0; i <= historySize; i++ {
+		b.Publish(TypePhaseChanged, PhaseChanged{Alias: "geth"})
+	}
+	received := 0
+	for range stream {
+		received++
+	}
+	if received != historySize {
+		t.Errorf("expected %d buffered events before the stream was closed, got %d", historySize, received)
+	}
+
+	// Only the most recent events are kept
+	replay, cancelReplay := b.Subscribe(1)
+	defer cancelReplay()
+	if ev := <-replay; ev.ID != 2 {
+		t.Errorf("expected replay to start at the oldest kept event, got %d", ev.ID)
+	}
+}
+
+func TestNilBroker(t *testing.T) {
+	var b *Broker
+	b.Publish(TypeSyncCheck, SyncCheck{})
+	stream, cancel := b.Subscribe(0)
+	defer cancel()
+	if _, ok := <-stream; ok {
+		t.Error("expected closed stream from nil broker")
+	}
+}
@@ -0,0 +1,121 @@
+package events
+
+import "time"
+
+// Types of the events published by the snapshotter
+const (
+	TypeSyncCheck           = "sync_check"
+	TypeSnapshotStarted     = "snapshot_started"
+	TypePhaseChanged        = "phase_changed"
+	TypeTargetUploaded      = "target_uploaded"
+	TypeRunFinished         = "run_finished"
+	TypeCleanupDeleted      = "cleanup_deleted"
+	TypeLatestUpdated       = "latest_updated"
+	TypeContainerRemediated = "container_remediated"
+	TypeReplicaFinished     = "replica_finished"
+)
+
+// Event is a single event published by the snapshotter. IDs increase by one with every event.
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// SyncCheck is published when the outcome of the sync check of a group changes, i.e. when the targets
+// become synced or unsynced, or when they reach a new block
+type SyncCheck struct {
+	Group       string `json:"group"`
+	Synced      bool   `json:"synced"`
+	BlockNumber uint64 `json:"blockNumber"`
+}
+
+// SnapshotStarted is published when a run starts, or when a failed target snapshot of a run is resumed
+type SnapshotStarted struct {
+	RunID       int64    `json:"runId"`
+	Group       string   `json:"group"`
+	BlockNumber uint64   `json:"blockNumber"`
+	Targets     []string `json:"targets"`
+	DryRun      bool     `json:"dryRun"`
+	Resumed     bool     `json:"resumed,omitempty"`
+}
+
+// PhaseChanged is published when a target snapshot completes a phase, or fails to reach one
+type PhaseChanged struct {
+	TargetSnapshotID int64  `json:"targetSnapshotId"`
+	Alias            string `json:"alias"`
+	Phase            string `json:"phase"`
+	Error            string `json:"error,omitempty"`
+}
+
+// TargetUploaded is published when the snapshot of a target is uploaded
+type TargetUploaded struct {
+	TargetSnapshotID int64   `json:"targetSnapshotId"`
+	Alias            string  `json:"alias"`
+	BlockNumber      uint64  `json:"blockNumber"`
+	UploadPrefix     string  `json:"uploadPrefix"`
+	SHA256           string  `json:"sha256,omitempty"`
+	Size             int64   `json:"size,omitempty"`
+	DurationSeconds  float64 `json:"durationSeconds"`
+}
+
+// RunFinished is published with the final status of a run
+type RunFinished struct {
+	RunID       int64  `json:"runId"`
+	Group       string `json:"group"`
+	BlockNumber uint64 `json:"blockNumber"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Kinds of deletions reported by CleanupDeleted
+const (
+	DeletedTarget       = "target"
+	DeletedRun          = "run"
+	DeletedFailedUpload = "failed_upload"
+)
+
+// CleanupDeleted is published when the cleanup routine deleted the snapshot of a target or the objects left
+// by a failed upload, or marked a run as deleted once none of its targets are left
+type CleanupDeleted struct {
+	Kind             string `json:"kind"`
+	RunID            int64  `json:"runId"`
+	TargetSnapshotID int64  `json:"targetSnapshotId,omitempty"`
+	Alias            string `json:"alias,omitempty"`
+	Group            string `json:"group"`
+	BlockNumber      uint64 `json:"blockNumber"`
+	UploadPrefix     string `json:"uploadPrefix,omitempty"`
+}
+
+// LatestUpdated is published when a latest file in the bucket is written or removed. Alias is empty
+// for the latest file at the root prefix.
+type LatestUpdated struct {
+	Alias       string `json:"alias,omitempty"`
+	Key         string `json:"key"`
+	BlockNumber uint64 `json:"blockNumber,omitempty"`
+	Removed     bool   `json:"removed,omitempty"`
+}
+
+// ContainerRemediated is published when the watchdog tried to start or restart a stopped or unhealthy container
+type ContainerRemediated struct {
+	Alias     string `json:"alias"`
+	Container string `json:"container"`
+	Role      string `json:"role"`
+	State     string `json:"state"`
+	Action    string `json:"action"`
+	Attempt   int    `json:"attempt"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReplicaFinished is published when copying a target snapshot to an additional storage destination ends
+type ReplicaFinished struct {
+	TargetSnapshotID int64  `json:"targetSnapshotId"`
+	Alias            string `json:"alias"`
+	Destination      string `json:"destination"`
+	Status           string `json:"status"`
+	Objects          int    `json:"objects"`
+	Bytes            int64  `json:"bytes"`
+	Error            string `json:"error,omitempty"`
+}
@@ -0,0 +1,327 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "snapshotter"
+
+// Metrics holds the prometheus collectors exported by the snapshotter.
+// All methods are safe to call on a nil receiver so that components can be
+// constructed without metrics (e.g. in tests).
+type Metrics struct {
+	runsTotal               *prometheus.CounterVec
+	uploadsTotal            *prometheus.CounterVec
+	uploadDuration          *prometheus.HistogramVec
+	lastSuccessfulBlock     *prometheus.GaugeVec
+	lastSuccessfulTimestamp *prometheus.GaugeVec
+	processedBlockHeight    *prometheus.GaugeVec
+	nextSnapshotBlockHeight *prometheus.GaugeVec
+	blockInterval           *prometheus.GaugeVec
+	snapshotInProgress      *prometheus.GaugeVec
+	verificationsTotal      *prometheus.CounterVec
+	cleanupDeletedTotal     *prometheus.CounterVec
+	cleanupRunsDeletedTotal prometheus.Counter
+	cleanupFailedTotal      *prometheus.CounterVec
+	cleanupAbortedUploads   prometheus.Counter
+	targetSynced            *prometheus.GaugeVec
+	targetBlockHeight       *prometheus.GaugeVec
+	lastSyncCheckTimestamp  *prometheus.GaugeVec
+	lastSyncCheckAllInSync  *prometheus.GaugeVec
+	containerRemediations   *prometheus.CounterVec
+	replicationsTotal       *prometheus.CounterVec
+	reconcileOrphans        prometheus.Gauge
+	reconcileMissing        prometheus.Gauge
+}
+
+// New creates the snapshotter metrics and registers them with the given registerer
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		runsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "runs_total",
+			Help:      "Total number of snapshot runs by final status",
+		}, []string{"status"}),
+		uploadsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploads_total",
+			Help:      "Total number of target snapshot uploads by alias and status",
+		}, []string{"alias", "status"}),
+		uploadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upload_duration_seconds",
+			Help:      "Duration of target snapshot uploads by alias and status",
+			Buckets:   []float64{60, 300, 600, 1800, 3600, 7200, 14400, 28800, 57600},
+		}, []string{"alias", "status"}),
+		lastSuccessfulBlock: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_successful_snapshot_block",
+			Help:      "Block height of the last successful snapshot per alias",
+		}, []string{"alias"}),
+		lastSuccessfulTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_successful_snapshot_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful snapshot per alias",
+		}, []string{"alias"}),
+		processedBlockHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "processed_block_height",
+			Help:      "Most recent block height that all targets of a group agreed on",
+		}, []string{"group"}),
+		nextSnapshotBlockHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "next_snapshot_block_height",
+			Help:      "Block height at which the next periodic snapshot of a group will be taken",
+		}, []string{"group"}),
+		blockInterval: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "block_interval",
+			Help:      "Configured number of blocks between snapshots per group",
+		}, []string{"group"}),
+		snapshotInProgress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "snapshot_in_progress",
+			Help:      "Whether a snapshot run of a group is currently in progress (1) or not (0)",
+		}, []string{"group"}),
+		verificationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "verifications_total",
+			Help:      "Total number of snapshot verifications by alias and outcome",
+		}, []string{"alias", "status"}),
+		cleanupDeletedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_deleted_target_snapshots_total",
+			Help:      "Total number of target snapshots deleted by the cleanup routine per alias",
+		}, []string{"alias"}),
+		cleanupRunsDeletedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_deleted_runs_total",
+			Help:      "Total number of snapshot runs marked as deleted by the cleanup routine",
+		}),
+		cleanupFailedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_deleted_failed_uploads_total",
+			Help:      "Total number of failed target snapshot uploads deleted by the cleanup routine per alias",
+		}, []string{"alias"}),
+		cleanupAbortedUploads: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_aborted_multipart_uploads_total",
+			Help:      "Total number of incomplete multipart uploads of failed target snapshots aborted by the cleanup routine",
+		}),
+		targetSynced: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_synced",
+			Help:      "Sync verdict of the last check per alias and layer (1 synced, 0 not synced)",
+		}, []string{"alias", "layer"}),
+		targetBlockHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_el_block_height",
+			Help:      "Execution layer block height reported by each target on the last check",
+		}, []string{"alias"}),
+		lastSyncCheckTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_sync_check_timestamp_seconds",
+			Help:      "Unix timestamp of the last target sync check per group",
+		}, []string{"group"}),
+		lastSyncCheckAllInSync: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "targets_in_sync",
+			Help:      "Whether all targets of a group were synced and on the same block on the last check (1) or not (0)",
+		}, []string{"group"}),
+		containerRemediations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "container_remediations_total",
+			Help:      "Total number of attempts of the watchdog to start or restart a container by alias, container and result",
+		}, []string{"alias", "container", "result"}),
+		replicationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "replications_total",
+			Help:      "Total number of copies of target snapshots to additional storage destinations by destination and status",
+		}, []string{"destination", "status"}),
+		reconcileOrphans: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reconcile_orphan_prefixes",
+			Help:      "Snapshot prefixes in the bucket no successful target snapshot points at, as of the last reconciliation",
+		}),
+		reconcileMissing: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reconcile_missing_snapshots",
+			Help:      "Successful target snapshots whose objects weren't found in the bucket by the last reconciliation",
+		}),
+	}
+
+	reg.MustRegister(
+		m.runsTotal,
+		m.uploadsTotal,
+		m.uploadDuration,
+		m.lastSuccessfulBlock,
+		m.lastSuccessfulTimestamp,
+		m.processedBlockHeight,
+		m.nextSnapshotBlockHeight,
+		m.blockInterval,
+		m.snapshotInProgress,
+		m.verificationsTotal,
+		m.cleanupDeletedTotal,
+		m.cleanupRunsDeletedTotal,
+		m.cleanupFailedTotal,
+		m.cleanupAbortedUploads,
+		m.targetSynced,
+		m.targetBlockHeight,
+		m.lastSyncCheckTimestamp,
+		m.lastSyncCheckAllInSync,
+		m.containerRemediations,
+		m.replicationsTotal,
+		m.reconcileOrphans,
+		m.reconcileMissing,
+	)
+
+	return m
+}
+
+// ObserveRun records the final status of a snapshot run
+func (m *Metrics) ObserveRun(status string) {
+	if m == nil {
+		return
+	}
+	m.runsTotal.WithLabelValues(status).Inc()
+}
+
+// ObserveUpload records the outcome and duration of a target snapshot upload
+func (m *Metrics) ObserveUpload(alias, status string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.uploadsTotal.WithLabelValues(alias, status).Inc()
+	m.uploadDuration.WithLabelValues(alias, status).Observe(took.Seconds())
+}
+
+// ObserveVerification records the outcome of a snapshot verification
+func (m *Metrics) ObserveVerification(alias, status string) {
+	if m == nil {
+		return
+	}
+	m.verificationsTotal.WithLabelValues(alias, status).Inc()
+}
+
+// ObserveReplication records the outcome of copying a target snapshot to a storage destination
+func (m *Metrics) ObserveReplication(destination, status string) {
+	if m == nil {
+		return
+	}
+	m.replicationsTotal.WithLabelValues(destination, status).Inc()
+}
+
+// SetReconcileResult records the orphan prefixes and missing snapshots found by a reconciliation
+func (m *Metrics) SetReconcileResult(orphans, missing int) {
+	if m == nil {
+		return
+	}
+	m.reconcileOrphans.Set(float64(orphans))
+	m.reconcileMissing.Set(float64(missing))
+}
+
+// SetLastSuccessfulSnapshot records the block and time of the last successful snapshot for an alias
+func (m *Metrics) SetLastSuccessfulSnapshot(alias string, block uint64, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.lastSuccessfulBlock.WithLabelValues(alias).Set(float64(block))
+	m.lastSuccessfulTimestamp.WithLabelValues(alias).Set(float64(at.Unix()))
+}
+
+// SetBlockHeights records the processed block height and the next snapshot block height of a group
+func (m *Metrics) SetBlockHeights(group string, processed, next uint64) {
+	if m == nil {
+		return
+	}
+	m.processedBlockHeight.WithLabelValues(group).Set(float64(processed))
+	m.nextSnapshotBlockHeight.WithLabelValues(group).Set(float64(next))
+}
+
+// SetBlockInterval records the configured block interval of a group
+func (m *Metrics) SetBlockInterval(group string, interval uint64) {
+	if m == nil {
+		return
+	}
+	m.blockInterval.WithLabelValues(group).Set(float64(interval))
+}
+
+// SetSnapshotInProgress records whether a snapshot run of a group is currently in progress
+func (m *Metrics) SetSnapshotInProgress(group string, inProgress bool) {
+	if m == nil {
+		return
+	}
+	m.snapshotInProgress.WithLabelValues(group).Set(boolToFloat(inProgress))
+}
+
+// ObserveCleanupDeletedTarget records a target snapshot deleted by the cleanup routine
+func (m *Metrics) ObserveCleanupDeletedTarget(alias string) {
+	if m == nil {
+		return
+	}
+	m.cleanupDeletedTotal.WithLabelValues(alias).Inc()
+}
+
+// ObserveContainerRemediation records an attempt of the watchdog to bring a container back up
+func (m *Metrics) ObserveContainerRemediation(alias, container string, success bool) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	m.containerRemediations.WithLabelValues(alias, container, result).Inc()
+}
+
+// ObserveCleanupDeletedRun records a snapshot run marked as deleted by the cleanup routine
+func (m *Metrics) ObserveCleanupDeletedRun() {
+	if m == nil {
+		return
+	}
+	m.cleanupRunsDeletedTotal.Inc()
+}
+
+// ObserveCleanupDeletedFailedUpload records a failed target snapshot upload deleted by the cleanup routine,
+// with the number of incomplete multipart uploads aborted under its prefix
+func (m *Metrics) ObserveCleanupDeletedFailedUpload(alias string, abortedUploads int) {
+	if m == nil {
+		return
+	}
+	m.cleanupFailedTotal.WithLabelValues(alias).Inc()
+	m.cleanupAbortedUploads.Add(float64(abortedUploads))
+}
+
+// SetTargetSynced records the sync verdict of a target for the given layer ("cl" or "el")
+func (m *Metrics) SetTargetSynced(alias, layer string, synced bool) {
+	if m == nil {
+		return
+	}
+	m.targetSynced.WithLabelValues(alias, layer).Set(boolToFloat(synced))
+}
+
+// SetTargetBlockHeight records the execution layer block height reported by a target
+func (m *Metrics) SetTargetBlockHeight(alias string, block uint64) {
+	if m == nil {
+		return
+	}
+	m.targetBlockHeight.WithLabelValues(alias).Set(float64(block))
+}
+
+// ObserveSyncCheck records the overall verdict of a sync check across the targets of a group
+func (m *Metrics) ObserveSyncCheck(group string, allSynced bool) {
+	if m == nil {
+		return
+	}
+	m.lastSyncCheckTimestamp.WithLabelValues(group).SetToCurrentTime()
+	m.lastSyncCheckAllInSync.WithLabelValues(group).Set(boolToFloat(allSynced))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+
+	// None of these should panic
+	m.ObserveRun("success")
+	m.ObserveUpload("geth", "success", time.Second)
+	m.SetLastSuccessfulSnapshot("geth", 100, time.Now())
+	m.SetBlockHeights("geth", 100, 110)
+	m.SetTargetSynced("geth", "cl", true)
+	m.ObserveSyncCheck("geth", true)
+}
+
+func TestMetricsRecorded(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+
+	m.ObserveRun("success")
+	m.ObserveRun("failed")
+	m.ObserveRun("failed")
+	if got := testutil.ToFloat64(m.runsTotal.WithLabelValues("failed")); got != 2 {
+		t.Errorf("expected 2 failed runs, got %v", got)
+	}
+
+	at := time.Unix(1700000000, 0)
+	m.SetLastSuccessfulSnapshot("geth", 12345, at)
+	if got := testutil.ToFloat64(m.lastSuccessfulBlock.WithLabelValues("geth")); got != 12345 {
+		t.Errorf("expected last successful block 12345, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.lastSuccessfulTimestamp.WithLabelValues("geth")); got != 1700000000 {
+		t.Errorf("expected last successful timestamp 1700000000, got %v", got)
+	}
+
+	m.SetTargetSynced("besu", "el", false)
+	if got := testutil.ToFloat64(m.targetSynced.WithLabelValues("besu", "el")); got != 0 {
+		t.Errorf("expected besu el to be reported as not synced, got %v", got)
+	}
+}
@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Events notifications are sent for
+const (
+	RunSucceeded     = "run_succeeded"
+	RunFailed        = "run_failed"
+	UploadSucceeded  = "upload_succeeded"
+	UploadFailed     = "upload_failed"
+	CleanupSucceeded = "cleanup_succeeded"
+	CleanupFailed    = "cleanup_failed"
+	SnapshotStale    = "snapshot_stale"
+	// ContainerRemediated is sent when the watchdog brought a stopped or unhealthy container back up
+	ContainerRemediated = "container_remediated"
+	// ContainerRemediationFailed is sent when the watchdog failed to bring a container back up
+	ContainerRemediationFailed = "container_remediation_failed"
+	// ReplicationFailed is sent when a snapshot could not be copied to an additional storage destination
+	ReplicationFailed = "replication_failed"
+)
+
+var knownEvents = map[string]bool{
+	RunSucceeded:     true,
+	RunFailed:        true,
+	UploadSucceeded:  true,
+	UploadFailed:     true,
+	CleanupSucceeded: true,
+	CleanupFailed:    true,
+	SnapshotStale:    true,
+
+	ContainerRemediated:        true,
+	ContainerRemediationFailed: true,
+
+	ReplicationFailed: true,
+}
+
+// defaultEvents are sent to notifiers without configured events
+var defaultEvents = []string{RunFailed, UploadFailed, CleanupFailed, SnapshotStale, ContainerRemediationFailed, ReplicationFailed}
+
+const (
+	// deliveryAttempts is how often a notification is sent before it is given up on
+	deliveryAttempts = 3
+	// retryBackoff is the wait before the first retry, it doubles with every attempt
+	retryBackoff = 2 * time.Second
+)
+
+// Notification describes the outcome of a run, upload, cleanup, container remediation or replication
+type Notification struct {
+	Event            string    `json:"event"`
+	Time             time.Time `json:"time"`
+	Title            string    `json:"title"`
+	Message          string    `json:"message"`
+	Group            string    `json:"group,omitempty"`
+	Alias            string    `json:"alias,omitempty"`
+	Container        string    `json:"container,omitempty"`
+	RunID            int64     `json:"runId,omitempty"`
+	TargetSnapshotID int64     `json:"targetSnapshotId,omitempty"`
+	BlockNumber      uint64    `json:"blockNumber,omitempty"`
+	DryRun           bool      `json:"dryRun,omitempty"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// Failure reports whether the notification is about something that went wrong
+func (n Notification) Failure() bool {
+	switch n.Event {
+	case RunFailed, UploadFailed, CleanupFailed, SnapshotStale, ContainerRemediationFailed, ReplicationFailed:
+		return true
+	}
+	return false
+}
+
+type notifier struct {
+	name    string
+	kind    string
+	url     string
+	secret  string
+	events  map[string]bool
+	timeout time.Duration
+}
+
+// Dispatcher sends notifications to the configured notifiers in the background.
+// All methods are safe to call on a nil receiver, notifications are dropped then.
+type Dispatcher struct {
+	notifiers []*notifier
+	client    *http.Client
+	inFlight  sync.WaitGroup
+}
+
+// New validates the notifier configuration. It returns nil if no notifiers are configured.
+func New(cfg config.NotificationsConfig) (*Dispatcher, error) {
+	if len(cfg.Notifiers) == 0 {
+		return nil, nil
+	}
+	d := &Dispatcher{client: &http.Client{}}
+	for i, nc := range cfg.Notifiers {
+		name := nc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", nc.Type, i)
+		}
+		if nc.URL == "" {
+			return nil, fmt.Errorf("notifier %s has no url configured", name)
+		}
+		events := nc.Events
+		if len(events) == 0 {
+			events = defaultEvents
+		}
+		n := &notifier{
+			name:    name,
+			kind:    nc.Type,
+			url:     nc.URL,
+			secret:  nc.Secret,
+			events:  make(map[string]bool),
+			timeout: nc.Timeout(),
+		}
+		for _, ev := range events {
+			if !knownEvents[ev] {
+				return nil, fmt.Errorf("notifier %s has unknown event %q", name, ev)
+			}
+			n.events[ev] = true
+		}
+		d.notifiers = append(d.notifiers, n)
+	}
+	return d, nil
+}
+
+// Notify sends the notification to every notifier subscribed to its event, without waiting for the delivery
+func (d *Dispatcher) Notify(n Notification) {
+	if d == nil {
+		return
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	for _, nt := range d.notifiers {
+		if !nt.events[n.Event] {
+			continue
+		}
+		d.inFlight.Add(1)
+		go func() {
+			defer d.inFlight.Done()
+			d.deliver(nt, n)
+		}()
+	}
+}
+
+// Wait blocks until all notifications sent so far are delivered or given up on
+func (d *Dispatcher) Wait() {
+	if d == nil {
+		return
+	}
+	d.inFlight.Wait()
+}
+
+// deliver sends a notification to a notifier, retrying failed attempts with a growing backoff
+func (d *Dispatcher) deliver(nt *notifier, n Notification) {
+	body, headers, err := nt.payload(n)
+	if err != nil {
+		log.WithError(err).WithField("notifier", nt.name).Error("failed to build notification")
+		return
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.post(nt, body, headers)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"notifier": nt.name,
+				"event":    n.Event,
+			}).Debug("sent notification")
+			return
+		}
+		if attempt == deliveryAttempts {
+			log.WithError(err).WithFields(log.Fields{
+				"notifier": nt.name,
+				"event":    n.Event,
+				"attempts": attempt,
+			}).Error("failed to send notification")
+			return
+		}
+		log.WithError(err).WithFields(log.Fields{
+			"notifier": nt.name,
+			"event":    n.Event,
+			"retry_in": backoff,
+		}).Warn("failed to send notification, retrying")
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) post(nt *notifier, body []byte, headers http.Header) error {
+	ctx, cancel := context.WithTimeout(context.Background(), nt.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, nt.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = headers
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records the requests it gets, failing the first failures of them
+func receiver(t *testing.T, failures int) (*httptest.Server, func() []receivedRequest) {
+	var mu sync.Mutex
+	var received []receivedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received = append(received, receivedRequest{header: r.Header, body: body})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	webhook, webhookReceived := receiver(t, 0)
+	slack, slackReceived := receiver(t, 0)
+	discord, discordReceived := receiver(t, 0)
+
+	d, err := New(config.NotificationsConfig{Notifiers: []config.NotifierConfig{
+		{Type: config.NotifierWebhook, URL: webhook.URL, Secret: "s3cret", Events: []string{RunSucceeded, RunFailed}},
+		{Type: config.NotifierSlack, URL: slack.URL},
+		{Type: config.NotifierDiscord, URL: discord.URL},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Notify(Notification{Event: RunSucceeded, Title: "Snapshot of geth at block 100 succeeded", RunID: 1})
+	d.Notify(Notification{Event: RunFailed, Title: "Snapshot of geth at block 200 failed", RunID: 2, Error: "upload failed"})
+	d.Wait()
+
+	// The webhook gets both, signed
+	requests := webhookReceived()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 webhook requests, got %d", len(requests))
+	}
+	for _, req := range requests {
+		timestamp := req.header.Get(TimestampHeader)
+		if sig := req.header.Get(SignatureHeader); timestamp == "" || sig != "sha256="+Sign("s3cret", timestamp, req.body) {
+			t.Errorf("invalid signature %q for timestamp %q", sig, timestamp)
+		}
+		var n Notification
+		if err := json.Unmarshal(req.body, &n); err != nil || n.RunID == 0 || n.Time.IsZero() {
+			t.Errorf("unexpected webhook payload %s (%v)", req.body, err)
+		}
+	}
+
+	// Chat notifiers only get failures by default
+	requests = slackReceived()
+	if len(requests) != 1 || !strings.Contains(string(requests[0].body), `"text":`) || !strings.Contains(string(requests[0].body), "upload failed") {
+		t.Errorf("unexpected slack requests %+v", requests)
+	}
+	requests = discordReceived()
+	if len(requests) != 1 || !strings.Contains(string(requests[0].body), `"content":`) {
+		t.Errorf("unexpected discord requests %+v", requests)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	srv, received := receiver(t, 1)
+	d, err := New(config.NotificationsConfig{Notifiers: []config.NotifierConfig{{Type: config.NotifierWebhook, URL: srv.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Notify(Notification{Event: SnapshotStale, Group: "geth"})
+	d.Wait()
+	if requests := received(); len(requests) != 1 || requests[0].header.Get(SignatureHeader) != "" {
+		t.Errorf("expected a single unsigned delivery after the retry, got %+v", requests)
+	}
+}
+
+func TestNewValidatesEvents(t *testing.T) {
+	if _, err := New(config.NotificationsConfig{Notifiers: []config.NotifierConfig{{Type: config.NotifierSlack, URL: "http://localhost", Events: []string{"run_exploded"}}}}); err == nil {
+		t.Error("expected unknown event to be rejected")
+	}
+	if _, err := New(config.NotificationsConfig{Notifiers: []config.NotifierConfig{{Type: config.NotifierSlack}}}); err == nil {
+		t.Error("expected notifier without url to be rejected")
+	}
+	if d, err := New(config.NotificationsConfig{}); d != nil || err != nil {
+		t.Errorf("expected no dispatcher without notifiers, got %v (%v)", d, err)
+	}
+}
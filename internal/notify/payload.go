@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 of "<timestamp>.<body>" as sha256=<hex> on signed webhooks
+	SignatureHeader = "X-Snapshotter-Signature"
+	// TimestampHeader holds the unix time the webhook was signed at, so receivers can reject replays
+	TimestampHeader = "X-Snapshotter-Timestamp"
+)
+
+// payload returns the body and headers of the request sending the notification to the notifier
+func (nt *notifier) payload(n Notification) ([]byte, http.Header, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+
+	var (
+		body []byte
+		err  error
+	)
+	switch nt.kind {
+	case config.NotifierSlack:
+		body, err = json.Marshal(map[string]string{"text": text(n, "*")})
+	case config.NotifierDiscord:
+		body, err = json.Marshal(map[string]string{"content": text(n, "**")})
+	default:
+		body, err = json.Marshal(n)
+		if err == nil && nt.secret != "" {
+			timestamp := strconv.FormatInt(n.Time.Unix(), 10)
+			headers.Set(TimestampHeader, timestamp)
+			headers.Set(SignatureHeader, "sha256="+Sign(nt.secret, timestamp, body))
+		}
+	}
+	return body, headers, err
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of a webhook body sent at the given timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// text renders a notification as a chat message, with the title in bold using the given markup
+func text(n Notification, bold string) string {
+	icon := ":white_check_mark:"
+	if n.Failure() {
+		icon = ":rotating_light:"
+	}
+	msg := icon + " " + bold + n.Title + bold
+	if n.Message != "" {
+		msg += "\n" + n.Message
+	}
+	if n.Error != "" {
+		msg += "\n```" + n.Error + "```"
+	}
+	return msg
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// keepaliveInterval is how often a comment is sent on idle event streams, so proxies don't close them
+const keepaliveInterval = 15 * time.Second
+
+// handleEvents streams the events of the snapshotter as server-sent events. The types query parameter
+// limits the stream to a comma separated list of event types. Clients reconnecting with a Last-Event-ID
+// header get the recent events they missed first.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		http.Error(w, "events are not available", http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	var eventTypes map[string]bool
+	if filter := r.URL.Query().Get("types"); filter != "" {
+		eventTypes = make(map[string]bool)
+		for _, t := range strings.Split(filter, ",") {
+			eventTypes[strings.TrimSpace(t)] = true
+		}
+	}
+
+	stream, cancel := s.events.Subscribe(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-stream:
+			if !ok {
+				// Dropped for falling behind, the client reconnects and resumes from the last event it got
+				return
+			}
+			if eventTypes != nil && !eventTypes[ev.Type] {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.WithError(err).WithField("type", ev.Type).Error("failed to encode event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		}
+	}
+}
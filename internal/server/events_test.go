@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+func TestEventStream(t *testing.T) {
+	broker := events.NewBroker()
+	broker.Publish(events.TypeSyncCheck, events.SyncCheck{Group: "geth", Synced: true, BlockNumber: 100})
+	broker.Publish(events.TypePhaseChanged, events.PhaseChanged{TargetSnapshotID: 1, Alias: "geth", Phase: "el_stopped"})
+
+	srv := New(&config.Config{}, nil, func() *types.SnapshotterStatus { return &types.SnapshotterStatus{} }, &fakeRunController{}, broker)
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/v1/events?types=phase_changed,run_finished", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Resume after the first event, so the phase change is replayed and the sync check is filtered out
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	broker.Publish(events.TypeSyncCheck, events.SyncCheck{Group: "geth", BlockNumber: 101})
+	broker.Publish(events.TypeRunFinished, events.RunFinished{RunID: 1, Group: "geth", BlockNumber: 100, Status: "success"})
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() && len(lines) < 6 {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	expected := []string{
+		"id: 2",
+		"event: phase_changed",
+		`"data":{"targetSnapshotId":1,"alias":"geth","phase":"el_stopped"}`,
+		"id: 4",
+		"event: run_finished",
+		`"data":{"runId":1,"group":"geth","blockNumber":100,"status":"success"}`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %v", len(expected), lines)
+	}
+	for i, want := range expected {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d: expected %q in %q", i, want, lines[i])
+		}
+	}
+}
+
+func TestEventStreamEndsOnShutdown(t *testing.T) {
+	srv := New(&config.Config{}, nil, func() *types.SnapshotterStatus { return &types.SnapshotterStatus{} }, &fakeRunController{}, events.NewBroker())
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = bufio.NewReader(resp.Body).ReadString(0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected event stream to end on shutdown")
+	}
+}
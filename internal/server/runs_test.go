@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+type fakeRunController struct {
+	triggered []types.TriggerRunRequest
+	active    map[int64]bool
+	reconcile *types.ReconcileReport
+}
+
+func (f *fakeRunController) TriggerRun(ctx context.Context, req types.TriggerRunRequest) ([]*db.SnapshotRun, error) {
+	for _, alias := range req.Aliases {
+		if alias != "geth" {
+			return nil, fmt.Errorf("%w: %s", types.ErrUnknownAlias, alias)
+		}
+	}
+	f.triggered = append(f.triggered, req)
+	return []*db.SnapshotRun{{ID: 1, Group: "geth", Status: "running"}}, nil
+}
+
+func (f *fakeRunController) CancelRun(id int64) error {
+	if !f.active[id] {
+		return types.ErrRunNotActive
+	}
+	return nil
+}
+
+func (f *fakeRunController) RetryTarget(id int64) (*db.TargetSnapshot, error) {
+	return nil, types.ErrTargetNotResumable
+}
+
+func (f *fakeRunController) RestoreTarget(id int64) (*db.TargetSnapshot, error) {
+	return &db.TargetSnapshot{ID: id, Status: "failed"}, nil
+}
+
+func (f *fakeRunController) VerifyTarget(id int64) (*db.TargetSnapshot, error) {
+	return nil, types.ErrVerificationDisabled
+}
+
+func (f *fakeRunController) ReplicateTarget(id int64) (*db.TargetSnapshot, error) {
+	return nil, fmt.Errorf("%w: snapshot was deleted", types.ErrTargetNotReplicable)
+}
+
+func (f *fakeRunController) CleanupPlan() (*types.CleanupPlan, error) {
+	return &types.CleanupPlan{Policy: types.CleanupKeepCount}, nil
+}
+
+func (f *fakeRunController) Reconcile(ctx context.Context, req types.ReconcileRequest) (*types.ReconcileReport, error) {
+	f.reconcile = &types.ReconcileReport{Orphans: []types.OrphanPrefix{{Prefix: "geth/90", Deleted: req.DeleteOrphans != nil && *req.DeleteOrphans}}}
+	return f.reconcile, nil
+}
+
+func (f *fakeRunController) LastReconcile() *types.ReconcileReport {
+	return f.reconcile
+}
+
+func (f *fakeRunController) StorageUsage(days int) (*types.StorageUsage, error) {
+	return &types.StorageUsage{Growth: make([]types.StorageGrowth, days)}, nil
+}
+
+func TestRunEndpoints(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	active, err := database.CreateSnapshotRun("geth", 100, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, err := database.CreateSnapshotRun("geth", 50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := database.CreateTargetSnapshot(active.ID, "geth", "geth/100", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Server.Auth.APIToken = "test-token"
+	runs := &fakeRunController{active: map[int64]bool{active.ID: true}}
+	srv := New(cfg, database, func() *types.SnapshotterStatus { return &types.SnapshotterStatus{} }, runs, nil)
+	router := srv.router()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		token          string
+		expectedStatus int
+	}{
+		{"trigger without token", "POST", "/api/v1/runs", "", "", http.StatusUnauthorized},
+		{"trigger all targets", "POST", "/api/v1/runs", "", "test-token", http.StatusAccepted},
+		{"trigger subset with dry run override", "POST", "/api/v1/runs", `{"aliases":["geth"],"dryRun":false}`, "test-token", http.StatusAccepted},
+		{"trigger unknown alias", "POST", "/api/v1/runs", `{"aliases":["reth"]}`, "test-token", http.StatusBadRequest},
+		{"trigger invalid body", "POST", "/api/v1/runs", `{`, "test-token", http.StatusBadRequest},
+		{"list runs stays public", "GET", "/api/v1/runs", "", "", http.StatusOK},
+		{"cancel without token", "POST", fmt.Sprintf("/api/v1/runs/%d/cancel", active.ID), "", "", http.StatusUnauthorized},
+		{"cancel active run", "POST", fmt.Sprintf("/api/v1/runs/%d/cancel", active.ID), "", "test-token", http.StatusAccepted},
+		{"cancel finished run", "POST", fmt.Sprintf("/api/v1/runs/%d/cancel", finished.ID), "", "test-token", http.StatusConflict},
+		{"cancel unknown run", "POST", "/api/v1/runs/9999/cancel", "", "test-token", http.StatusNotFound},
+		{"retry without token", "POST", fmt.Sprintf("/api/v1/targets/%d/retry", target.ID), "", "", http.StatusUnauthorized},
+		{"retry not resumable target", "POST", fmt.Sprintf("/api/v1/targets/%d/retry", target.ID), "", "test-token", http.StatusConflict},
+		{"retry unknown target", "POST", "/api/v1/targets/9999/retry", "", "test-token", http.StatusNotFound},
+		{"restore target", "POST", fmt.Sprintf("/api/v1/targets/%d/restore", target.ID), "", "test-token", http.StatusAccepted},
+		{"verify without token", "POST", fmt.Sprintf("/api/v1/targets/%d/verify", target.ID), "", "", http.StatusUnauthorized},
+		{"verify with verification disabled", "POST", fmt.Sprintf("/api/v1/targets/%d/verify", target.ID), "", "test-token", http.StatusServiceUnavailable},
+		{"cleanup plan stays public", "GET", "/api/v1/cleanup/plan", "", "", http.StatusOK},
+		{"replicate deleted snapshot", "POST", fmt.Sprintf("/api/v1/targets/%d/replicate", target.ID), "", "test-token", http.StatusConflict},
+		{"no reconciliation yet", "GET", "/api/v1/reconcile", "", "", http.StatusNotFound},
+		{"reconcile without token", "POST", "/api/v1/reconcile", "", "", http.StatusUnauthorized},
+		{"reconcile invalid body", "POST", "/api/v1/reconcile", `{"deleteOrphans":"yes"}`, "test-token", http.StatusBadRequest},
+		{"reconcile and delete orphans", "POST", "/api/v1/reconcile", `{"deleteOrphans":true}`, "test-token", http.StatusOK},
+		{"last reconciliation stays public", "GET", "/api/v1/reconcile", "", "", http.StatusOK},
+		{"storage usage stays public", "GET", "/api/v1/storage?days=7", "", "", http.StatusOK},
+		{"storage usage with invalid days", "GET", "/api/v1/storage?days=0", "", "", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if len(runs.triggered) != 2 {
+		t.Fatalf("expected 2 triggered runs, got %d", len(runs.triggered))
+	}
+	if dryRun := runs.triggered[1].DryRun; dryRun == nil || *dryRun {
+		t.Error("expected the dry run override to be passed on")
+	}
+	if runs.reconcile == nil || !runs.reconcile.Orphans[0].Deleted {
+		t.Error("expected the delete orphans override to be passed on")
+	}
+}
@@ -1,60 +1,123 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/ethpandaops/eth-snapshotter/internal/config"
 	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
 	"github.com/ethpandaops/eth-snapshotter/internal/types"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
+// RunController starts, cancels, resumes, verifies and replicates snapshot runs on demand, previews the cleanup,
+// reconciles the bucket and reports its usage
+type RunController interface {
+	TriggerRun(ctx context.Context, req types.TriggerRunRequest) ([]*db.SnapshotRun, error)
+	CancelRun(id int64) error
+	RetryTarget(id int64) (*db.TargetSnapshot, error)
+	RestoreTarget(id int64) (*db.TargetSnapshot, error)
+	VerifyTarget(id int64) (*db.TargetSnapshot, error)
+	ReplicateTarget(id int64) (*db.TargetSnapshot, error)
+	CleanupPlan() (*types.CleanupPlan, error)
+	Reconcile(ctx context.Context, req types.ReconcileRequest) (*types.ReconcileReport, error)
+	LastReconcile() *types.ReconcileReport
+	StorageUsage(days int) (*types.StorageUsage, error)
+}
+
 type Server struct {
-	cfg       *config.Config
-	db        *db.DB
-	getStatus func() *types.SnapshotterStatus
+	cfg        *config.Config
+	db         *db.DB
+	getStatus  func() *types.SnapshotterStatus
+	runs       RunController
+	events     *events.Broker
+	httpServer *http.Server
+
+	// streamsDone is closed on shutdown to end the event streams, which would otherwise hold the shutdown up
+	streamsDone chan struct{}
+	closeOnce   sync.Once
 }
 
-func New(cfg *config.Config, database *db.DB, getStatusFn func() *types.SnapshotterStatus) *Server {
+func New(cfg *config.Config, database *db.DB, getStatusFn func() *types.SnapshotterStatus, runs RunController, broker *events.Broker) *Server {
 	return &Server{
-		cfg:       cfg,
-		db:        database,
-		getStatus: getStatusFn,
+		cfg:         cfg,
+		db:          database,
+		getStatus:   getStatusFn,
+		runs:        runs,
+		events:      broker,
+		httpServer:  &http.Server{},
+		streamsDone: make(chan struct{}),
 	}
 }
 
 func (s *Server) Start() error {
+	listenAddr := s.cfg.Server.ListenAddr
+	if listenAddr == "" {
+		listenAddr = "0.0.0.0:5001"
+	}
+
+	// Log API authentication status
+	if s.cfg.Server.Auth.APIToken == "" {
+		log.Fatal("API authentication needs to be set - no API token configured")
+	}
+
+	s.httpServer.Addr = listenAddr
+	s.httpServer.Handler = s.router()
+
+	log.WithField("addr", listenAddr).Info("starting HTTP server")
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) router() *mux.Router {
 	r := mux.NewRouter()
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
 	publicRouter := r.PathPrefix("/api/v1").Subrouter()
 	publicRouter.HandleFunc("/runs", s.handleGetRuns).Methods("GET")
 	publicRouter.HandleFunc("/status", s.handleGetStatus).Methods("GET")
 	publicRouter.HandleFunc("/runs/{id}", s.handleGetRun).Methods("GET")
 	publicRouter.HandleFunc("/targets/{id}", s.handleGetTargetSnapshot).Methods("GET")
 	publicRouter.HandleFunc("/targets", s.handleGetTargets).Methods("GET")
+	publicRouter.HandleFunc("/events", s.handleEvents).Methods("GET")
+	publicRouter.HandleFunc("/remediations", s.handleGetRemediations).Methods("GET")
+	publicRouter.HandleFunc("/cleanup/plan", s.handleGetCleanupPlan).Methods("GET")
+	publicRouter.HandleFunc("/reconcile", s.handleGetReconcile).Methods("GET")
+	publicRouter.HandleFunc("/storage", s.handleGetStorage).Methods("GET")
 
 	// Create a subrouter for authenticated endpoints
 	authRouter := r.PathPrefix("/api/v1").Subrouter()
 	authRouter.Use(s.authMiddleware)
+	authRouter.HandleFunc("/runs", s.handleTriggerRun).Methods("POST")
+	authRouter.HandleFunc("/runs/{id}/cancel", s.handleCancelRun).Methods("POST")
+	authRouter.HandleFunc("/reconcile", s.handleReconcile).Methods("POST")
 	authRouter.HandleFunc("/runs/{id}/persist", s.handleSetPersisted).Methods("POST")
 	authRouter.HandleFunc("/runs/{id}/unpersist", s.handleSetUnpersisted).Methods("POST")
 	authRouter.HandleFunc("/targets/{id}/persist", s.handleSetTargetPersisted).Methods("POST")
 	authRouter.HandleFunc("/targets/{id}/unpersist", s.handleSetTargetUnpersisted).Methods("POST")
+	authRouter.HandleFunc("/targets/{id}/retry", s.handleTargetAction(s.runs.RetryTarget)).Methods("POST")
+	authRouter.HandleFunc("/targets/{id}/restore", s.handleTargetAction(s.runs.RestoreTarget)).Methods("POST")
+	authRouter.HandleFunc("/targets/{id}/verify", s.handleTargetAction(s.runs.VerifyTarget)).Methods("POST")
+	authRouter.HandleFunc("/targets/{id}/replicate", s.handleTargetAction(s.runs.ReplicateTarget)).Methods("POST")
 
-	listenAddr := s.cfg.Server.ListenAddr
-	if listenAddr == "" {
-		listenAddr = "0.0.0.0:5001"
-	}
-
-	// Log API authentication status
-	if s.cfg.Server.Auth.APIToken == "" {
-		log.Fatal("API authentication needs to be set - no API token configured")
-	}
+	return r
+}
 
-	log.WithField("addr", listenAddr).Info("starting HTTP server")
-	return http.ListenAndServe(listenAddr, r)
+// Shutdown gracefully stops the HTTP server, waiting for in-flight requests to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Info("shutting down HTTP server")
+	s.closeOnce.Do(func() { close(s.streamsDone) })
+	return s.httpServer.Shutdown(ctx)
 }
 
 // authMiddleware is a middleware function that checks for a valid API token
@@ -150,6 +213,7 @@ func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "snapshot run not found", http.StatusNotFound)
 		return
 	}
+	s.addUploadProgress(run.TargetsSnapshot)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(run); err != nil {
@@ -159,6 +223,135 @@ func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// addUploadProgress sets the progress of the target snapshots that are being uploaded
+func (s *Server) addUploadProgress(targets []db.TargetSnapshot) {
+	status := s.getStatus()
+	status.Lock()
+	defer status.Unlock()
+	for _, upload := range status.Uploads {
+		for i := range targets {
+			if targets[i].ID == upload.TargetSnapshotID {
+				targets[i].UploadProgress = &upload
+			}
+		}
+	}
+}
+
+func (s *Server) handleTriggerRun(w http.ResponseWriter, r *http.Request) {
+	var req types.TriggerRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	runs, err := s.runs.TriggerRun(r.Context(), req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, types.ErrUnknownAlias):
+			status = http.StatusBadRequest
+		case errors.Is(err, types.ErrSnapshotInProgress), errors.Is(err, types.ErrTargetsNotSynced):
+			status = http.StatusConflict
+		case errors.Is(err, types.ErrSnapshotterNotRunning):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"runs": runs,
+	}); err != nil {
+		log.WithError(err).Error("failed to encode runs")
+	}
+}
+
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	// Check if the run exists
+	run, err := s.db.GetSnapshotRunByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if run == nil {
+		http.Error(w, "snapshot run not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.runs.CancelRun(id); err != nil {
+		if errors.Is(err, types.ErrRunNotActive) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The run finishes in the background once the containers are restored
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		log.WithError(err).Error("failed to encode run")
+	}
+}
+
+// handleTargetAction resumes or verifies a target snapshot with the given function, which runs in the background
+func (s *Server) handleTargetAction(action func(id int64) (*db.TargetSnapshot, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		idStr := vars["id"]
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid target ID", http.StatusBadRequest)
+			return
+		}
+
+		// Check if the target exists
+		target, err := s.db.GetTargetSnapshotByID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if target == nil {
+			http.Error(w, "target snapshot not found", http.StatusNotFound)
+			return
+		}
+
+		target, err = action(id)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, types.ErrTargetNotResumable), errors.Is(err, types.ErrTargetNotVerifiable),
+				errors.Is(err, types.ErrTargetNotReplicable), errors.Is(err, types.ErrSnapshotInProgress):
+				status = http.StatusConflict
+			case errors.Is(err, types.ErrUnknownAlias):
+				status = http.StatusUnprocessableEntity
+			case errors.Is(err, types.ErrSnapshotterNotRunning), errors.Is(err, types.ErrVerificationDisabled),
+				errors.Is(err, types.ErrReplicationDisabled):
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(target); err != nil {
+			log.WithError(err).Error("failed to encode target")
+		}
+	}
+}
+
 func (s *Server) handleSetPersisted(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
@@ -258,6 +451,9 @@ func (s *Server) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if run != nil {
+		s.addUploadProgress(run.TargetsSnapshot)
+	}
 	// create anonymous struct to hide the run object
 	resp := struct {
 		LatestRun *db.SnapshotRun          `json:"latestRun"`
@@ -440,3 +636,139 @@ func (s *Server) handleGetTargets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// handleGetRemediations lists the attempts of the container watchdog to bring containers back up, most recent first
+func (s *Server) handleGetRemediations(w http.ResponseWriter, r *http.Request) {
+	alias := r.URL.Query().Get("alias")
+
+	page := 1
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	remediations, err := s.db.GetContainerRemediations(alias, limit, (page-1)*limit)
+	if err != nil {
+		log.WithError(err).Error("failed to get container remediations")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"page":         page,
+		"limit":        limit,
+		"remediations": remediations,
+	}); err != nil {
+		log.WithError(err).Error("failed to encode container remediations")
+		http.Error(w, "failed to encode container remediations", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetCleanupPlan returns the snapshots the cleanup would keep and delete if it ran now, and why
+func (s *Server) handleGetCleanupPlan(w http.ResponseWriter, r *http.Request) {
+	plan, err := s.runs.CleanupPlan()
+	if err != nil {
+		log.WithError(err).Error("failed to plan cleanup")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if alias := r.URL.Query().Get("alias"); alias != "" {
+		snapshots := []types.CleanupDecision{}
+		for _, d := range plan.Snapshots {
+			if d.Alias == alias {
+				snapshots = append(snapshots, d)
+			}
+		}
+		plan.Snapshots = snapshots
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		log.WithError(err).Error("failed to encode cleanup plan")
+		http.Error(w, "failed to encode cleanup plan", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetStorage returns the space the snapshots take, its growth over the last days and its projection
+func (s *Server) handleGetStorage(w http.ResponseWriter, r *http.Request) {
+	days := 0
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		d, err := strconv.Atoi(daysStr)
+		if err != nil || d <= 0 || d > 366 {
+			http.Error(w, "days must be between 1 and 366", http.StatusBadRequest)
+			return
+		}
+		days = d
+	}
+
+	usage, err := s.runs.StorageUsage(days)
+	if err != nil {
+		log.WithError(err).Error("failed to get storage usage")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		log.WithError(err).Error("failed to encode storage usage")
+		http.Error(w, "failed to encode storage usage", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetReconcile returns the report of the last reconciliation of the bucket
+func (s *Server) handleGetReconcile(w http.ResponseWriter, r *http.Request) {
+	report := s.runs.LastReconcile()
+	if report == nil {
+		http.Error(w, "no reconciliation has run yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithError(err).Error("failed to encode reconciliation report")
+		http.Error(w, "failed to encode reconciliation report", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleReconcile reconciles the bucket now and returns the report
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	var req types.ReconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.runs.Reconcile(r.Context(), req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, types.ErrReconcileInProgress) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithError(err).Error("failed to encode reconciliation report")
+		http.Error(w, "failed to encode reconciliation report", http.StatusInternalServerError)
+		return
+	}
+}
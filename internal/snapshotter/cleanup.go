@@ -6,171 +6,288 @@ import (
 	"time"
 
 	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
 	log "github.com/sirupsen/logrus"
 )
 
-// StartCleanupRoutine starts a goroutine for cleaning up old snapshots
-func (s *SnapShotter) StartCleanupRoutine() {
-	if !s.cfg.Global.Snapshots.Cleanup.Enabled {
+// StartCleanupRoutine starts a goroutine for cleaning up old snapshots until the context is cancelled
+func (s *SnapShotter) StartCleanupRoutine(ctx context.Context) {
+	cfg := s.cfg.Global.Snapshots.Cleanup
+	if !cfg.Enabled {
 		log.Info("Snapshot cleanup is disabled")
 		return
 	}
 
-	keepCount := s.cfg.Global.Snapshots.Cleanup.KeepCount
-	if keepCount <= 0 {
-		keepCount = 3 // Default to keeping the 3 most recent snapshots
-	}
-
-	checkIntervalHours := s.cfg.Global.Snapshots.Cleanup.CheckIntervalHours
+	checkIntervalHours := cfg.CheckIntervalHours
 	if checkIntervalHours <= 0 {
 		checkIntervalHours = 24 // Default to checking once per day
 	}
 
-	log.WithFields(log.Fields{
-		"keep_count":           keepCount,
-		"check_interval_hours": checkIntervalHours,
-	}).Info("starting snapshot cleanup routine")
+	fields := log.Fields{"check_interval_hours": checkIntervalHours}
+	if cfg.Retention.Enabled() {
+		fields["policy"] = types.CleanupRetention
+	} else {
+		fields["policy"] = types.CleanupKeepCount
+		fields["keep_count"] = cfg.KeepCount
+		if cfg.KeepCount <= 0 {
+			fields["keep_count"] = defaultKeepCount
+		}
+	}
+	log.WithFields(fields).Info("starting snapshot cleanup routine")
 
 	go func() {
 		for {
-			err := s.cleanupSnapshots(keepCount)
+			err := s.cleanupSnapshots()
 			if err != nil {
 				log.WithError(err).Error("failed to cleanup snapshots")
 			}
 
 			// Sleep until next check
-			time.Sleep(time.Duration(checkIntervalHours) * time.Hour)
+			select {
+			case <-time.After(time.Duration(checkIntervalHours) * time.Hour):
+			case <-ctx.Done():
+				log.Info("stopping snapshot cleanup routine")
+				return
+			}
 		}
 	}()
 }
 
-// cleanupSnapshots deletes old snapshots, keeping the most recent 'keepCount' snapshots
-// and any snapshots/targets that are marked as persisted
-func (s *SnapShotter) cleanupSnapshots(keepCount int) error {
+// cleanupSnapshots deletes the target snapshots the cleanup plan doesn't keep, and marks runs as deleted once
+// none of their targets are left apart from persisted ones
+func (s *SnapShotter) cleanupSnapshots() error {
 	log.Info("running snapshot cleanup")
 
 	// Get all successful snapshots that have not been deleted
 	runs, err := s.db.GetSuccessfulRunsForCleanup()
 	if err != nil {
-		return fmt.Errorf("failed to get snapshots for cleanup: %w", err)
+		err = fmt.Errorf("failed to get snapshots for cleanup: %w", err)
+		s.notifyCleanup(0, 0, 0, err)
+		return err
 	}
+	plan := planCleanup(s.cfg.Global.Snapshots.Cleanup, runs, time.Now().UTC())
 
-	// First, exclude snapshots that are persisted at the run level
-	var nonPersistedRuns []db.SnapshotRun
-	var persistedRuns []db.SnapshotRun
-	for _, run := range runs {
-		if run.Persisted {
-			persistedRuns = append(persistedRuns, run)
-		} else {
-			nonPersistedRuns = append(nonPersistedRuns, run)
-		}
+	// A failure to get the failed uploads doesn't hold up the cleanup of successful snapshots
+	failedUploads, err := s.expiredFailedUploads(time.Now())
+	if err != nil {
+		log.WithError(err).Error("failed to get failed uploads for cleanup")
 	}
 
 	log.WithFields(log.Fields{
-		"total_snapshots":    len(runs),
-		"persisted_runs":     len(persistedRuns),
-		"non_persisted_runs": len(nonPersistedRuns),
-		"keep_count":         keepCount,
+		"policy":         plan.Policy,
+		"snapshots":      len(plan.Snapshots),
+		"keep":           plan.Keep,
+		"delete":         plan.Delete,
+		"failed_uploads": len(failedUploads),
 	}).Info("snapshot cleanup stats")
 
-	if len(nonPersistedRuns) <= keepCount {
-		log.WithFields(log.Fields{
-			"non_persisted_count": len(nonPersistedRuns),
-			"keep_count":          keepCount,
-		}).Info("not enough non-persisted snapshots to cleanup")
-		return nil
+	runsByID := make(map[int64]*db.SnapshotRun)
+	for i := range runs {
+		runsByID[runs[i].ID] = &runs[i]
+	}
+	deletedTargets := make(map[int64]bool)
+	var deleted, failed int
+	for _, d := range plan.Snapshots {
+		if !d.Delete {
+			continue
+		}
+		run := runsByID[d.RunID]
+		if s.deleteTargetSnapshot(run, d) {
+			deletedTargets[d.TargetSnapshotID] = true
+			deleted++
+		} else {
+			failed++
+		}
 	}
 
-	// The snapshots are ordered by block height DESC, so we keep the first 'keepCount' snapshots
-	runsToProcess := nonPersistedRuns[keepCount:]
-
-	log.WithFields(log.Fields{
-		"total_snapshots": len(runs),
-		"persisted_runs":  len(persistedRuns),
-		"keep_count":      keepCount,
-		"runs_to_process": len(runsToProcess),
-	}).Info("found snapshots to process")
-
-	// Now check target snapshots for each run to see if any individual targets are persisted
-	for _, run := range runsToProcess {
-		log.WithFields(log.Fields{
-			"id":           run.ID,
-			"block_height": run.BlockHeight,
-			"start_time":   run.StartTime,
-		}).Info("processing snapshot run for deletion")
-
-		// Find which targets are persisted
-		var persistedTargets []db.TargetSnapshot
-		var nonPersistedTargets []db.TargetSnapshot
-
+	// Runs are marked as deleted once some of their targets are deleted and all others are persisted
+	for i := range runs {
+		run := &runs[i]
+		if run.Persisted {
+			continue
+		}
+		allTargetsDeleted, anyDeleted := true, false
 		for _, target := range run.TargetsSnapshot {
-			// Skip targets that failed during snapshot creation
 			if target.Status != "success" {
 				continue
 			}
-
-			if target.Persisted {
-				persistedTargets = append(persistedTargets, target)
-			} else {
-				nonPersistedTargets = append(nonPersistedTargets, target)
+			if target.Deleted || deletedTargets[target.ID] {
+				anyDeleted = true
+			} else if !target.Persisted {
+				allTargetsDeleted = false
+				break
 			}
 		}
+		if !allTargetsDeleted || !anyDeleted {
+			continue
+		}
 
-		log.WithFields(log.Fields{
-			"run_id":                run.ID,
-			"persisted_targets":     len(persistedTargets),
-			"non_persisted_targets": len(nonPersistedTargets),
-		}).Info("target snapshot stats")
+		log.WithField("id", run.ID).Info("all targets are deleted or persisted, marking run as deleted")
+		if err := s.db.MarkSnapshotRunAsDeleted(run.ID); err != nil {
+			log.WithError(err).WithField("id", run.ID).Error("failed to mark snapshot run as deleted in database")
+			continue
+		}
+		s.metrics.ObserveCleanupDeletedRun()
+		s.events.Publish(events.TypeCleanupDeleted, events.CleanupDeleted{
+			Kind:        events.DeletedRun,
+			RunID:       run.ID,
+			Group:       run.Group,
+			BlockNumber: run.BlockHeight,
+		})
+
+		if err := s.revertRootLatest(context.Background(), run.BlockHeight); err != nil {
+			log.WithError(err).WithField("id", run.ID).Error("failed to revert latest file")
+		}
+	}
 
-		// Delete non-persisted targets
-		for _, target := range nonPersistedTargets {
-			log.WithFields(log.Fields{
-				"id":            target.ID,
-				"run_id":        run.ID,
-				"target_alias":  target.Alias,
-				"upload_prefix": target.UploadPrefix,
-			}).Info("deleting target snapshot")
-
-			// Delete the target snapshot files using S3 API
-			if err := s.deleteTargetSnapshotFiles(target); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"id":           target.ID,
-					"target_alias": target.Alias,
-				}).Error("failed to delete target snapshot files")
-				continue
-			}
+	var deletedFailedUploads int
+	for _, ts := range failedUploads {
+		if s.deleteFailedUpload(ts) {
+			deletedFailedUploads++
+		} else {
+			failed++
+		}
+	}
+	s.notifyCleanup(deleted, deletedFailedUploads, failed, nil)
 
-			// Mark the target snapshot as deleted in the database
-			if err := s.db.MarkTargetSnapshotAsDeleted(target.ID); err != nil {
-				log.WithError(err).WithField("id", target.ID).Error("failed to mark target snapshot as deleted in database")
-				continue
-			}
+	s.publishIndex()
 
-			log.WithFields(log.Fields{
-				"id":           target.ID,
-				"target_alias": target.Alias,
-			}).Info("successfully deleted target snapshot")
+	return nil
+}
+
+// deleteTargetSnapshot deletes the files of a target snapshot the cleanup plan doesn't keep and marks it as
+// deleted. It returns whether the target snapshot was deleted.
+func (s *SnapShotter) deleteTargetSnapshot(run *db.SnapshotRun, d types.CleanupDecision) bool {
+	var target db.TargetSnapshot
+	for _, t := range run.TargetsSnapshot {
+		if t.ID == d.TargetSnapshotID {
+			target = t
 		}
+	}
+	log.WithFields(log.Fields{
+		"id":            target.ID,
+		"run_id":        run.ID,
+		"target_alias":  target.Alias,
+		"upload_prefix": target.UploadPrefix,
+		"reasons":       d.Reasons,
+	}).Info("deleting target snapshot")
+
+	// Delete the target snapshot files using S3 API
+	if err := s.deleteTargetSnapshotFiles(target); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"id":           target.ID,
+			"target_alias": target.Alias,
+		}).Error("failed to delete target snapshot files")
+		return false
+	}
 
-		// If all targets are now deleted or were already deleted, mark the run as deleted
-		allTargetsDeleted := true
-		for _, target := range run.TargetsSnapshot {
-			if target.Status == "success" && !target.Deleted && !target.Persisted {
-				allTargetsDeleted = false
-				break
-			}
+	// Mark the target snapshot as deleted in the database
+	if err := s.db.MarkTargetSnapshotAsDeleted(target.ID); err != nil {
+		log.WithError(err).WithField("id", target.ID).Error("failed to mark target snapshot as deleted in database")
+		return false
+	}
+
+	s.metrics.ObserveCleanupDeletedTarget(target.Alias)
+	s.events.Publish(events.TypeCleanupDeleted, events.CleanupDeleted{
+		Kind:             events.DeletedTarget,
+		RunID:            run.ID,
+		TargetSnapshotID: target.ID,
+		Alias:            target.Alias,
+		Group:            run.Group,
+		BlockNumber:      run.BlockHeight,
+		UploadPrefix:     target.UploadPrefix,
+	})
+
+	if err := s.revertTargetLatest(context.Background(), target, run.BlockHeight); err != nil {
+		log.WithError(err).WithField("target_alias", target.Alias).Error("failed to revert latest file of target")
+	}
+
+	log.WithFields(log.Fields{
+		"id":           target.ID,
+		"target_alias": target.Alias,
+	}).Info("successfully deleted target snapshot")
+	return true
+}
+
+// expiredFailedUploads returns the target snapshots that failed, were interrupted or cancelled longer than the
+// failed upload grace period ago. Target snapshots whose target is held for a retry are left out.
+func (s *SnapShotter) expiredFailedUploads(now time.Time) ([]db.TargetSnapshot, error) {
+	grace, ok := s.cfg.Global.Snapshots.Cleanup.FailedUploadGrace()
+	if !ok {
+		return nil, nil
+	}
+	targets, err := s.db.GetFailedTargetSnapshotsForCleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []db.TargetSnapshot
+	for _, ts := range targets {
+		failedAt := ts.EndTime
+		if failedAt.IsZero() {
+			failedAt = ts.StartTime
+		}
+		if now.Sub(failedAt) < grace {
+			continue
+		}
+		if held, ok := s.heldTargetSnapshot(ts.Alias); ok && (held == nil || held.ID == ts.ID) {
+			log.WithFields(log.Fields{
+				"id":           ts.ID,
+				"target_alias": ts.Alias,
+			}).Debug("keeping failed upload of target held for a retry")
+			continue
 		}
+		expired = append(expired, ts)
+	}
+	return expired, nil
+}
 
-		if allTargetsDeleted {
-			log.WithField("id", run.ID).Info("all targets are deleted or persisted, marking run as deleted")
-			if err := s.db.MarkSnapshotRunAsDeleted(run.ID); err != nil {
-				log.WithError(err).WithField("id", run.ID).Error("failed to mark snapshot run as deleted in database")
-				continue
-			}
+// deleteFailedUpload aborts the incomplete multipart uploads of a failed target snapshot, deletes the objects
+// its upload left behind and marks it as deleted. It returns whether the target snapshot was deleted.
+func (s *SnapShotter) deleteFailedUpload(target db.TargetSnapshot) bool {
+	ctx := context.Background()
+	logger := log.WithFields(log.Fields{
+		"id":            target.ID,
+		"target_alias":  target.Alias,
+		"status":        target.Status,
+		"upload_prefix": target.UploadPrefix,
+	})
+	logger.Info("deleting failed upload")
+
+	aborted := 0
+	if !s.cfg.Global.Snapshots.DryRun {
+		var err error
+		aborted, err = s.s3Client.AbortMultipartUploads(ctx, s.s3Client.GetBucketName(), target.UploadPrefix)
+		if err != nil {
+			logger.WithError(err).Error("failed to abort multipart uploads of failed upload")
+			return false
 		}
 	}
+	if err := s.deleteTargetSnapshotFiles(target); err != nil {
+		logger.WithError(err).Error("failed to delete files of failed upload")
+		return false
+	}
+	if err := s.db.MarkTargetSnapshotAsDeleted(target.ID); err != nil {
+		logger.WithError(err).Error("failed to mark failed upload as deleted in database")
+		return false
+	}
 
-	return nil
+	s.metrics.ObserveCleanupDeletedFailedUpload(target.Alias, aborted)
+	deleted := events.CleanupDeleted{
+		Kind:             events.DeletedFailedUpload,
+		RunID:            target.SnapshotRunID,
+		TargetSnapshotID: target.ID,
+		Alias:            target.Alias,
+		UploadPrefix:     target.UploadPrefix,
+	}
+	if run, err := s.db.GetSnapshotRunByID(target.SnapshotRunID); err == nil && run != nil {
+		deleted.Group, deleted.BlockNumber = run.Group, run.BlockHeight
+	}
+	s.events.Publish(events.TypeCleanupDeleted, deleted)
+
+	logger.WithField("aborted_multipart_uploads", aborted).Info("successfully deleted failed upload")
+	return true
 }
 
 // deleteTargetSnapshotFiles deletes the snapshot files for a specific target snapshot
@@ -216,6 +333,11 @@ func (s *SnapShotter) deleteTargetSnapshotFiles(target db.TargetSnapshot) error
 		return fmt.Errorf("failed to delete snapshot for %s: %w", target.Alias, err)
 	}
 
+	// Delete the copies on the replication destinations
+	if err := s.deleteReplicas(ctx, target); err != nil {
+		return fmt.Errorf("failed to delete replicas of snapshot for %s: %w", target.Alias, err)
+	}
+
 	log.WithFields(log.Fields{
 		"target_alias": target.Alias,
 		"bucket":       bucketName,
@@ -0,0 +1,98 @@
+package snapshotter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+)
+
+func TestCleanupFailedUploads(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	target := func(alias, prefix, status string) *db.TargetSnapshot {
+		run, err := database.CreateSnapshotRun(alias, 100, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := database.CreateTargetSnapshot(run.ID, alias, prefix, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.UpdateTargetSnapshotStatus(ts.ID, status, "upload failed"); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	failed := target("geth", "hoodi/geth/100", "failed")
+	// Retried in a later run at the same block, the objects belong to the successful one now
+	target("besu", "hoodi/besu/100", "failed")
+	target("besu", "hoodi/besu/100", "success")
+	// Held after the failed upload, so it can still be retried
+	held := target("reth", "hoodi/reth/100", "failed")
+	if err := database.RecordTargetSnapshotPhase(held.ID, phaseELStopped); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &MockS3Client{
+		bucketName: "test-bucket",
+		uploadedFiles: map[string]string{
+			"hoodi/geth/100/_snapshot_eth_getBlockByNumber.json": "{}",
+			"hoodi/geth/latest":               "90",
+			"hoodi/besu/100/snapshot.tar.zst": "archive",
+			"hoodi/reth/100/snapshot.tar.zst": "partial",
+		},
+		multipartUploads: []string{"hoodi/geth/100/snapshot.tar.zst", "hoodi/geth/1000/snapshot.tar.zst"},
+	}
+	ss := &SnapShotter{cfg: &config.Config{}, db: database, s3Client: mock}
+	ss.cfg.Global.Snapshots.HoldFailedUploads = true
+
+	// Failed uploads are kept for the grace period
+	expired, err := ss.expiredFailedUploads(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expected no failed uploads within the grace period, got %d", len(expired))
+	}
+
+	expired, err = ss.expiredFailedUploads(time.Now().Add(73 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != failed.ID {
+		t.Fatalf("expected only the failed geth upload to be deleted, got %+v", expired)
+	}
+	if !ss.deleteFailedUpload(expired[0]) {
+		t.Fatal("expected the failed upload to be deleted")
+	}
+
+	if _, ok := mock.uploadedFiles["hoodi/geth/100/_snapshot_eth_getBlockByNumber.json"]; ok {
+		t.Error("expected the objects of the failed upload to be deleted")
+	}
+	if _, ok := mock.uploadedFiles["hoodi/geth/latest"]; !ok {
+		t.Error("expected the latest file of the target to be left alone")
+	}
+	if len(mock.multipartUploads) != 1 || mock.multipartUploads[0] != "hoodi/geth/1000/snapshot.tar.zst" {
+		t.Errorf("expected only the multipart upload under the prefix to be aborted, got %q", mock.multipartUploads)
+	}
+	ts, err := database.GetTargetSnapshotByID(failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Deleted || ts.Status != "failed" {
+		t.Errorf("expected the failed upload to be marked as deleted, got %+v", ts)
+	}
+
+	// Negative grace keeps failed uploads
+	ss.cfg.Global.Snapshots.Cleanup.FailedUploadGraceHours = -1
+	if expired, err = ss.expiredFailedUploads(time.Now().Add(1000 * time.Hour)); err != nil || len(expired) != 0 {
+		t.Errorf("expected failed uploads to be kept, got %d (%v)", len(expired), err)
+	}
+}
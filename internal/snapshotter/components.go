@@ -0,0 +1,85 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// componentsBeforeExecution returns the components of a target that are stopped before the block is checked,
+// the ones listed before the execution component
+func componentsBeforeExecution(cfg *config.TargetConfig) []config.ComponentConfig {
+	var components []config.ComponentConfig
+	for _, c := range cfg.Components {
+		if c.Role == config.RoleExecution {
+			break
+		}
+		if c.OnSnapshot == config.ComponentStop {
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// componentsFromExecution returns the execution component of a target and the components listed after it
+// that are stopped for a snapshot
+func componentsFromExecution(cfg *config.TargetConfig) []config.ComponentConfig {
+	var components []config.ComponentConfig
+	found := false
+	for _, c := range cfg.Components {
+		found = found || c.Role == config.RoleExecution
+		if found && c.OnSnapshot == config.ComponentStop {
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// stopComponents stops the given components of a target one after the other
+func stopComponents(ctx context.Context, t *target, components []config.ComponentConfig) error {
+	for _, c := range components {
+		log.WithFields(log.Fields{
+			"alias":     t.cfg.Alias,
+			"component": c.Name,
+			"role":      c.Role,
+		}).Info("stopping component")
+		if err := t.driver.StopComponent(ctx, c); err != nil {
+			return fmt.Errorf("could not stop %s component %s: %w", c.Role, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// startComponents starts the components of a target that were stopped for a snapshot in the order they are
+// listed, then restarts the ones that are restarted after a snapshot
+func startComponents(ctx context.Context, t *target) error {
+	for _, c := range t.cfg.Components {
+		if c.OnSnapshot != config.ComponentStop {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"alias":     t.cfg.Alias,
+			"component": c.Name,
+			"role":      c.Role,
+		}).Info("starting component")
+		if err := t.driver.StartComponent(ctx, c); err != nil {
+			return fmt.Errorf("could not start %s component %s: %w", c.Role, c.Name, err)
+		}
+	}
+	for _, c := range t.cfg.Components {
+		if c.OnSnapshot != config.ComponentRestart {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"alias":     t.cfg.Alias,
+			"component": c.Name,
+			"role":      c.Role,
+		}).Info("restarting component")
+		if err := t.driver.RestartComponent(ctx, c); err != nil {
+			return fmt.Errorf("could not restart %s component %s: %w", c.Role, c.Name, err)
+		}
+	}
+	return nil
+}
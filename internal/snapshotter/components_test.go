@@ -0,0 +1,85 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+// fakeComponents is a target driver that records the actions on its components
+type fakeComponents struct {
+	TargetDriver
+	actions []string
+	fail    string
+}
+
+func (f *fakeComponents) record(action string, c config.ComponentConfig) error {
+	f.actions = append(f.actions, action+" "+c.Name)
+	if c.Name == f.fail {
+		return errors.New("unit not found")
+	}
+	return nil
+}
+
+func (f *fakeComponents) StopComponent(ctx context.Context, c config.ComponentConfig) error {
+	return f.record("stop", c)
+}
+
+func (f *fakeComponents) StartComponent(ctx context.Context, c config.ComponentConfig) error {
+	return f.record("start", c)
+}
+
+func (f *fakeComponents) RestartComponent(ctx context.Context, c config.ComponentConfig) error {
+	return f.record("restart", c)
+}
+
+func TestComponentOrder(t *testing.T) {
+	driver := &fakeComponents{}
+	tgt := &target{driver: driver, cfg: &config.TargetConfig{
+		Alias: "nethermind",
+		Components: []config.ComponentConfig{
+			{Name: "validator", Role: "validator", OnSnapshot: config.ComponentStop},
+			{Name: "mev-boost", Role: "mev_boost", OnSnapshot: config.ComponentNone},
+			{Name: "nethermind", Role: config.RoleExecution, OnSnapshot: config.ComponentStop},
+			{Name: "teku", Role: config.RoleBeacon, OnSnapshot: config.ComponentStop},
+			{Name: "exporter", Role: "metrics", OnSnapshot: config.ComponentRestart},
+		},
+	}}
+	ctx := context.Background()
+
+	if err := stopComponents(ctx, tgt, componentsBeforeExecution(tgt.cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopComponents(ctx, tgt, componentsFromExecution(tgt.cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if err := startComponents(ctx, tgt); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"stop validator", "stop nethermind", "stop teku",
+		"start validator", "start nethermind", "start teku", "restart exporter",
+	}
+	if !reflect.DeepEqual(driver.actions, want) {
+		t.Errorf("expected actions %q, got %q", want, driver.actions)
+	}
+
+	// Without a snooper nothing is stopped before the execution client
+	noSnooper := &config.TargetConfig{Components: tgt.cfg.Components[2:]}
+	if got := componentsBeforeExecution(noSnooper); len(got) != 0 {
+		t.Errorf("expected no components before the execution client, got %+v", got)
+	}
+
+	// A failing component stops the sequence
+	driver.actions, driver.fail = nil, "nethermind"
+	err := startComponents(ctx, tgt)
+	if err == nil || err.Error() != "could not start execution component nethermind: unit not found" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(driver.actions) != 2 {
+		t.Errorf("expected components after the failed one to be skipped, got %q", driver.actions)
+	}
+}
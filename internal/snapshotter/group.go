@@ -0,0 +1,284 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotGroup is a set of targets that are scheduled and snapshotted together.
+// Targets without a configured group get a group of their own.
+type snapshotGroup struct {
+	name          string
+	lockstep      bool
+	blockInterval uint64
+	dryRun        bool
+	targets       []*target
+	status        *types.GroupStatus
+}
+
+// busy returns ErrSnapshotInProgress if a run or a watchdog check holds the group. The caller holds g.status.
+func (g *snapshotGroup) busy() error {
+	switch {
+	case g.status.SnapshotInProgress:
+		return fmt.Errorf("%w for group %s", types.ErrSnapshotInProgress, g.name)
+	case g.status.HealthCheckInProgress:
+		return fmt.Errorf("%w for group %s: its containers are being checked", types.ErrSnapshotInProgress, g.name)
+	}
+	return nil
+}
+
+// buildGroups assigns the targets to their schedule groups, keeping the order of the targets
+func buildGroups(cfg *config.Config, targets []*target) []*snapshotGroup {
+	var groups []*snapshotGroup
+	byName := make(map[string]*snapshotGroup)
+
+	for _, t := range targets {
+		name := t.cfg.ScheduleGroup()
+		g, ok := byName[name]
+		if !ok {
+			interval := uint64(cfg.Global.Snapshots.BlockInterval)
+			if t.cfg.BlockInterval > 0 {
+				interval = uint64(t.cfg.BlockInterval)
+			}
+			g = &snapshotGroup{
+				name:          name,
+				lockstep:      t.cfg.Group != "",
+				blockInterval: interval,
+				dryRun:        cfg.Global.Snapshots.DryRun,
+				status: &types.GroupStatus{
+					Name:          name,
+					Lockstep:      t.cfg.Group != "",
+					BlockInterval: interval,
+				},
+			}
+			byName[name] = g
+			groups = append(groups, g)
+		}
+		g.targets = append(g.targets, t)
+		g.status.Targets = append(g.status.Targets, t.cfg.Alias)
+	}
+
+	return groups
+}
+
+// subset returns a view of the group limited to the given targets. It shares the status, and with it
+// the in-progress lock, with the group.
+func (g *snapshotGroup) subset(targets []*target, dryRun bool) *snapshotGroup {
+	return &snapshotGroup{
+		name:          g.name,
+		lockstep:      g.lockstep,
+		blockInterval: g.blockInterval,
+		dryRun:        dryRun,
+		targets:       targets,
+		status:        g.status,
+	}
+}
+
+// aliases returns the aliases of the targets of the group
+func (g *snapshotGroup) aliases() []string {
+	aliases := make([]string, 0, len(g.targets))
+	for _, t := range g.targets {
+		aliases = append(aliases, t.cfg.Alias)
+	}
+	return aliases
+}
+
+// BlocksLeftToNextSnapshot returns how many blocks are left until the group's next snapshot.
+// The group must have a block interval.
+func (g *snapshotGroup) BlocksLeftToNextSnapshot(blockNumber uint64) uint64 {
+	b := g.blockInterval - (blockNumber % g.blockInterval)
+	if b == g.blockInterval {
+		return 0
+	}
+	return b
+}
+
+// StartPeriodicPolling checks the targets of every group every check interval and creates a
+// snapshot of a group when its next snapshot block is reached. Groups are polled independently.
+// It returns once the context is cancelled, or with run_once once every group took a snapshot.
+// Runs triggered through the API are started with the same context and waited for before returning.
+func (s *SnapShotter) StartPeriodicPolling(ctx context.Context) {
+	s.runsMu.Lock()
+	s.runCtx = ctx
+	s.runsMu.Unlock()
+	defer s.verifications.Wait()
+	defer s.replications.Wait()
+	defer s.manualRuns.Wait()
+
+	var wg sync.WaitGroup
+	for _, g := range s.groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.pollGroup(ctx, g)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *SnapShotter) pollGroup(ctx context.Context, g *snapshotGroup) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Global.Snapshots.CheckIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	// Without any run of the group yet, cron triggers are counted from the start of polling
+	pollingSince := time.Now()
+
+	// Sync check events are only published when the outcome changes, not on every check
+	var lastCheck *events.SyncCheck
+
+	// Missing snapshots are checked regardless of the sync state, since unsynced targets are a common cause
+	var lastStaleCheck time.Time
+	var staleNotified int64
+
+	for {
+		select {
+		case <-ticker.C:
+			t1 := time.Now()
+			if t1.Sub(lastStaleCheck) >= staleCheckInterval {
+				staleNotified = s.checkStale(g, pollingSince, staleNotified)
+				lastStaleCheck = t1
+			}
+			allSynced, blockNumber := s.VerifyTargetsAreSynced(ctx, g)
+			if check := (events.SyncCheck{Group: g.name, Synced: allSynced, BlockNumber: blockNumber}); lastCheck == nil || *lastCheck != check {
+				s.events.Publish(events.TypeSyncCheck, check)
+				lastCheck = &check
+			}
+			if !allSynced {
+				continue
+			}
+
+			run, err := s.db.GetMostRecentRunForGroup(g.name, g.aliases())
+			if err != nil {
+				log.WithError(err).Error("failed to get most recent run")
+			}
+			lastRunStart := pollingSince
+			if run != nil {
+				lastRunStart = run.StartTime
+			}
+
+			now := time.Now()
+			nextCron := s.schedule.nextCron(lastRunStart)
+			maintenanceUntil := s.schedule.maintenanceUntil(now)
+
+			var blocksLeft uint64
+			blockDue := false
+			if g.blockInterval > 0 {
+				blocksLeft = g.BlocksLeftToNextSnapshot(blockNumber)
+
+				// If the most recent run is far away from the current block number, we need to create a new snapshot
+				lastRunIsTooOld := false
+				if run != nil && blockNumber > run.BlockHeight+g.blockInterval {
+					lastRunIsTooOld = true
+					log.WithFields(log.Fields{
+						"group":             g.name,
+						"run_id":            run.ID,
+						"block_current":     blockNumber,
+						"block_last_run":    run.BlockHeight,
+						"should_have_block": run.BlockHeight + g.blockInterval,
+					}).Warn("most recent run is too old")
+				}
+				blockDue = blocksLeft == 0 || lastRunIsTooOld
+			}
+			cronDue := s.schedule.cronDue(lastRunStart, now)
+
+			log.WithFields(log.Fields{
+				"group":          g.name,
+				"block_current":  blockNumber,
+				"block_next":     blockNumber + blocksLeft,
+				"blocks_left":    blocksLeft,
+				"block_interval": g.blockInterval,
+				"eta":            time.Duration(blocksLeft) * s.blockTime(),
+				"next_cron":      nextCron,
+				"took":           time.Since(t1),
+			}).Info("all targets are synced")
+			var nextBlock uint64
+			if g.blockInterval > 0 {
+				nextBlock = blockNumber + blocksLeft
+			}
+			g.status.Lock()
+			g.status.ProcessedBlockHeight = blockNumber
+			g.status.NextSnapshotBlockHeight = nextBlock
+			g.status.NextScheduledSnapshot = timePtr(nextCron)
+			g.status.MaintenanceUntil = timePtr(maintenanceUntil)
+			g.status.Unlock()
+			s.metrics.SetBlockHeights(g.name, blockNumber, nextBlock)
+
+			if !blockDue && !cronDue {
+				continue
+			}
+
+			if !maintenanceUntil.IsZero() {
+				log.WithFields(log.Fields{
+					"group": g.name,
+					"until": maintenanceUntil,
+				}).Info("snapshot due but in maintenance window, postponing")
+				continue
+			}
+			if notBefore := s.schedule.notBefore(lastRunStart); run != nil && now.Before(notBefore) {
+				log.WithFields(log.Fields{
+					"group":      g.name,
+					"last_run":   lastRunStart,
+					"not_before": notBefore,
+				}).Info("snapshot due but last run was too recent, postponing")
+				continue
+			}
+
+			g.status.Lock()
+			busy := g.busy()
+			g.status.Unlock()
+			if busy != nil {
+				log.WithError(busy).WithField("group", g.name).Info("snapshot due but the group is busy, postponing")
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"group":          g.name,
+				"block":          blockNumber,
+				"block_interval": g.blockInterval,
+				"block_due":      blockDue,
+				"cron_due":       cronDue,
+			}).Info("reached block to be snapshotted")
+			if err := s.CreateSnapshot(ctx, g); err != nil {
+				log.WithError(err).WithField("group", g.name).Error("failed to create snapshot")
+			}
+
+			if s.cfg.Global.Snapshots.RunOnce {
+				log.WithField("group", g.name).Info("snapshot.run_once is true. stopping polling")
+				return
+			}
+
+			waitSecs := 60
+			log.Infof("waiting %d seconds for next run", waitSecs)
+			select {
+			case <-time.After(time.Duration(waitSecs) * time.Second):
+			case <-ctx.Done():
+			}
+
+		case <-ctx.Done():
+			log.WithField("group", g.name).Info("stopping periodic polling")
+			return
+		}
+	}
+}
+
+// blockTime returns the expected time between blocks
+func (s *SnapShotter) blockTime() time.Duration {
+	if s.cfg.Global.Snapshots.BlockTimeSeconds > 0 {
+		return time.Duration(s.cfg.Global.Snapshots.BlockTimeSeconds) * time.Second
+	}
+	return 12 * time.Second
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
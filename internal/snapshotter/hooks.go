@@ -0,0 +1,155 @@
+package snapshotter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"text/template"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxHookOutput is how much of the output of a hook is kept in the run history, from its end
+const maxHookOutput = 4096
+
+// HookVars are the values hook command templates are rendered with
+type HookVars struct {
+	Alias            string
+	DataDir          string
+	UploadPrefix     string
+	BlockNumber      uint64
+	RunID            int64
+	TargetSnapshotID int64
+	// Hook is the point of the snapshot the hook runs at, e.g. pre_stop
+	Hook string
+}
+
+// runHooksAcross runs the hooks of a point on all targets of a group in parallel. Targets whose hooks
+// fail record a failure to reach phase.
+func (s *SnapShotter) runHooksAcross(ctx context.Context, g *snapshotGroup, phases *targetPhases, point, phase string) error {
+	group := errgroup.Group{}
+	for _, t := range g.targets {
+		if len(t.cfg.Hooks.At(point)) == 0 {
+			continue
+		}
+		group.Go(func() error {
+			if err := s.runHooks(ctx, t, phases, point); err != nil {
+				phases.fail(ctx, t, phase, err)
+				return err
+			}
+			return nil
+		})
+	}
+	return group.Wait()
+}
+
+// runHooks runs the hooks of a target at a point one after the other. It returns the error of the first
+// hook that fails with the abort policy, the following hooks are skipped then.
+func (s *SnapShotter) runHooks(ctx context.Context, t *target, phases *targetPhases, point string) error {
+	for i, hook := range t.cfg.Hooks.At(point) {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", point, i)
+		}
+		err := s.runHook(ctx, t, phases, point, name, hook)
+		if err == nil {
+			continue
+		}
+		if hook.OnFailure == config.HookContinue {
+			log.WithError(err).WithFields(log.Fields{
+				"alias": t.cfg.Alias,
+				"hook":  name,
+			}).Warn("hook failed, continuing")
+			continue
+		}
+		return fmt.Errorf("%s hook %s failed: %w", point, name, err)
+	}
+	return nil
+}
+
+// runHook renders and runs a single hook and records it with the target snapshot
+func (s *SnapShotter) runHook(ctx context.Context, t *target, phases *targetPhases, point, name string, hook config.HookConfig) error {
+	id, _ := phases.id(t)
+	var runID int64
+	var block uint64
+	if phases != nil {
+		runID, block = phases.runID, phases.block
+	}
+	logger := log.WithFields(log.Fields{
+		"alias": t.cfg.Alias,
+		"hook":  name,
+	})
+	record := db.HookExecution{Hook: point, Name: name, Command: hook.Command, Start: time.Now()}
+	command, err := renderHook(hook.Command, HookVars{
+		Alias:            t.cfg.Alias,
+		DataDir:          t.cfg.DataDir,
+		UploadPrefix:     t.cfg.UploadPrefix,
+		BlockNumber:      block,
+		RunID:            runID,
+		TargetSnapshotID: id,
+		Hook:             point,
+	})
+	if err == nil {
+		record.Command = command
+		logger.WithField("command", command).Info("running hook")
+
+		hookCtx, cancel := context.WithTimeout(ctx, hook.Timeout())
+		var out string
+		out, err = runShell(hookCtx, t.driver, command)
+		cancel()
+		if err != nil && hookCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s: %w", hook.Timeout(), err)
+		}
+		record.Output = truncateOutput(out)
+	}
+	record.End = time.Now()
+	record.Success = err == nil
+	if err != nil {
+		record.ErrorMessage = err.Error()
+		logger.WithError(err).WithField("output", record.Output).Error("hook failed")
+	} else {
+		logger.WithField("took", record.End.Sub(record.Start)).Info("hook succeeded")
+	}
+
+	if id != 0 {
+		if errDB := s.db.RecordHookExecution(id, record); errDB != nil {
+			logger.WithError(errDB).Error("failed to record hook")
+		}
+	}
+	return err
+}
+
+// renderHook renders the command template of a hook
+func renderHook(command string, vars HookVars) (string, error) {
+	tmpl, err := template.New("hook").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse hook command template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to execute hook command template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// runShell runs a command on the target if its driver can, or on the snapshotter host otherwise
+func runShell(ctx context.Context, driver TargetDriver, command string) (string, error) {
+	if runner, ok := driver.(commandRunner); ok {
+		return runner.RunCommandContext(ctx, command)
+	}
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	return string(out), err
+}
+
+// truncateOutput keeps the end of the output of a hook, where errors usually are
+func truncateOutput(out string) string {
+	if len(out) <= maxHookOutput {
+		return out
+	}
+	return "..." + out[len(out)-maxHookOutput:]
+}
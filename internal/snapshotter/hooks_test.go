@@ -0,0 +1,107 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+)
+
+// fakeShell is a target driver that records the commands it runs, failing those containing "fail"
+type fakeShell struct {
+	TargetDriver
+	commands []string
+}
+
+func (f *fakeShell) RunCommandContext(ctx context.Context, cmd string) (string, error) {
+	f.commands = append(f.commands, cmd)
+	if strings.Contains(cmd, "fail") {
+		return "something went wrong", errors.New("exit status 1")
+	}
+	return "ok", nil
+}
+
+func TestRunHooks(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	run, err := database.CreateSnapshotRun("erigon", 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := database.CreateTargetSnapshot(run.ID, "erigon", "erigon/100", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shell := &fakeShell{}
+	tgt := &target{driver: shell, cfg: &config.TargetConfig{
+		Alias:   "erigon",
+		DataDir: "/data/erigon",
+		Hooks: config.HooksConfig{
+			PreStop: []config.HookConfig{
+				{Name: "flush", Command: "flush {{.DataDir}} at {{.BlockNumber}}"},
+				{Command: "fail-validator-stop", OnFailure: config.HookContinue},
+			},
+			PostStart: []config.HookConfig{
+				{Command: "fail-compaction"},
+				{Command: "never run"},
+			},
+		},
+	}}
+	ss := &SnapShotter{cfg: &config.Config{}, db: database}
+	phases := &targetPhases{db: database, ids: map[string]int64{"erigon": ts.ID}, runID: run.ID, block: run.BlockHeight}
+
+	// Failures of hooks that continue are recorded but not returned
+	if err := ss.runHooks(context.Background(), tgt, phases, config.HookPreStop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(shell.commands) != 2 || shell.commands[0] != "flush /data/erigon at 100" {
+		t.Fatalf("unexpected commands %q", shell.commands)
+	}
+
+	// The first hook that aborts stops the others
+	err = ss.runHooks(context.Background(), tgt, phases, config.HookPostStart)
+	if err == nil || !strings.Contains(err.Error(), "post_start hook post_start-0 failed") {
+		t.Fatalf("expected post_start hook to fail, got %v", err)
+	}
+	if len(shell.commands) != 3 {
+		t.Errorf("expected hooks after the failed one to be skipped, got %q", shell.commands)
+	}
+
+	hooks, err := database.GetTargetSnapshotHooks(ts.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 3 {
+		t.Fatalf("expected 3 hooks recorded, got %+v", hooks)
+	}
+	if h := hooks[0]; h.Hook != config.HookPreStop || h.Name != "flush" || !h.Success || h.Output != "ok" || h.Command != "flush /data/erigon at 100" {
+		t.Errorf("unexpected first hook %+v", h)
+	}
+	if h := hooks[1]; h.Name != "pre_stop-1" || h.Success || h.Output != "something went wrong" || h.ErrorMessage != "exit status 1" {
+		t.Errorf("unexpected second hook %+v", h)
+	}
+	if h := hooks[2]; h.Hook != config.HookPostStart || h.Success {
+		t.Errorf("unexpected third hook %+v", h)
+	}
+}
+
+func TestRenderHook(t *testing.T) {
+	cmd, err := renderHook("echo {{.Alias}} {{.RunID}} {{.TargetSnapshotID}} {{.Hook}} {{.UploadPrefix}}", HookVars{
+		Alias: "geth", RunID: 3, TargetSnapshotID: 7, Hook: config.HookPostUpload, UploadPrefix: "hoodi/geth",
+	})
+	if err != nil || cmd != "echo geth 3 7 post_upload hoodi/geth" {
+		t.Errorf("unexpected command %q (%v)", cmd, err)
+	}
+	if _, err := renderHook("echo {{.Unknown}}", HookVars{}); err == nil {
+		t.Error("expected unknown template field to fail")
+	}
+}
@@ -0,0 +1,199 @@
+package snapshotter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"humanSize": humanSize,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 4px 12px; text-align: left; border-bottom: 1px solid #ddd; }
+code { font-size: 85%; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>Generated at {{ .Index.GeneratedAt.Format "2006-01-02 15:04:05 UTC" }}. Also available as <a href="index.json">index.json</a>.</p>
+{{- range $network, $clients := .Index.Networks }}
+<h2>{{ if $network }}{{ $network }}{{ else }}-{{ end }}</h2>
+{{- range $client, $c := $clients }}
+<h3>{{ $client }}</h3>
+<table>
+<tr><th>Block</th><th>Taken at</th><th>Size</th><th>SHA-256</th><th>Client version</th><th>Verified</th><th></th></tr>
+{{- range $c.Snapshots }}
+<tr><td>{{ .BlockNumber }}</td><td>{{ .Timestamp.Format "2006-01-02 15:04 UTC" }}</td><td>{{ humanSize .Size }}</td><td><code>{{ .SHA256 }}</code></td><td>{{ .ClientVersion }}</td><td>{{ if .Verified }}yes{{ end }}</td><td><a href="{{ $.Base }}{{ .File }}">download</a></td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
+
+// humanSize formats a size in bytes with a binary unit, or returns an empty string for an unknown size
+func humanSize(size int64) string {
+	if size <= 0 {
+		return ""
+	}
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// buildIndex groups the available target snapshots by network and client. Both are derived from the upload
+// prefix of the target, e.g. hoodi/geth is the geth client on hoodi.
+func buildIndex(targets []db.TargetSnapshot, now time.Time) *types.SnapshotIndex {
+	index := &types.SnapshotIndex{
+		GeneratedAt: now.UTC(),
+		Networks:    make(map[string]map[string]*types.SnapshotIndexClient),
+	}
+
+	for _, ts := range targets {
+		// The upload prefix of a target snapshot is <upload_prefix>/<block>
+		block, err := strconv.ParseUint(path.Base(ts.UploadPrefix), 10, 64)
+		if err != nil {
+			log.WithField("upload_prefix", ts.UploadPrefix).Warn("target snapshot without block in upload prefix, leaving it out of the index")
+			continue
+		}
+		prefix := targetLatestPrefix(&ts)
+		network, client := path.Dir(prefix), path.Base(prefix)
+		if network == "." {
+			network = ""
+		}
+
+		if index.Networks[network] == nil {
+			index.Networks[network] = make(map[string]*types.SnapshotIndexClient)
+		}
+		c := index.Networks[network][client]
+		if c == nil {
+			c = &types.SnapshotIndexClient{}
+			index.Networks[network][client] = c
+		}
+		c.Snapshots = append(c.Snapshots, types.SnapshotIndexEntry{
+			BlockNumber:   block,
+			BlockHash:     ts.BlockHash,
+			Timestamp:     ts.EndTime.UTC(),
+			File:          ts.UploadPrefix + "/snapshot.tar.zst",
+			SHA256:        ts.SHA256,
+			Size:          ts.Size,
+			ClientVersion: ts.ClientVersion,
+			Verified:      ts.Verification == verificationVerified,
+		})
+	}
+
+	for _, clients := range index.Networks {
+		for _, c := range clients {
+			sort.SliceStable(c.Snapshots, func(i, j int) bool {
+				return c.Snapshots[i].BlockNumber > c.Snapshots[j].BlockNumber
+			})
+			c.Latest = c.Snapshots[0].BlockNumber
+		}
+	}
+	return index
+}
+
+// renderIndexHTML renders the index as a static HTML listing. Links are relative to the root prefix
+// index.html is uploaded to, as the snapshots themselves are stored at the bucket root.
+func renderIndexHTML(index *types.SnapshotIndex, title, rootPrefix string) ([]byte, error) {
+	if title == "" {
+		title = "Snapshots"
+	}
+	base := strings.Repeat("../", strings.Count(strings.Trim(rootPrefix, "/"), "/")+1)
+	if strings.Trim(rootPrefix, "/") == "" {
+		base = ""
+	}
+
+	var buf bytes.Buffer
+	err := indexTemplate.Execute(&buf, struct {
+		Title string
+		Base  string
+		Index *types.SnapshotIndex
+	}{title, base, index})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// publishIndex regenerates index.json and index.html from the database and uploads them, if enabled.
+// Failures are logged, the index is regenerated after the next run or cleanup anyway.
+func (s *SnapShotter) publishIndex() {
+	if !s.cfg.Global.Snapshots.Index.Enabled {
+		return
+	}
+	if s.cfg.Global.Snapshots.DryRun {
+		log.Warn("dry run mode enabled - skipping index update")
+		return
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if err := s.writeIndex(context.Background()); err != nil {
+		log.WithError(err).Error("failed to update snapshot index in S3")
+	}
+}
+
+func (s *SnapShotter) writeIndex(ctx context.Context) error {
+	bucket := s.s3Client.GetBucketName()
+	if bucket == "" {
+		return fmt.Errorf("bucket name not configured in S3 settings")
+	}
+
+	targets, err := s.db.GetAvailableTargetSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to get available snapshots: %w", err)
+	}
+	index := buildIndex(targets, time.Now())
+
+	content, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	rootPrefix := s.s3Client.GetRootPrefix()
+	if err := s.s3Client.PutObject(ctx, bucket, rootPrefix+"index.json", content); err != nil {
+		return fmt.Errorf("failed to upload index.json: %w", err)
+	}
+
+	html, err := renderIndexHTML(index, s.cfg.Global.Snapshots.Index.Title, rootPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to render index.html: %w", err)
+	}
+	if err := s.s3Client.PutObject(ctx, bucket, rootPrefix+"index.html", html); err != nil {
+		return fmt.Errorf("failed to upload index.html: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"bucket":    bucket,
+		"key":       rootPrefix + "index.json",
+		"snapshots": len(targets),
+	}).Info("updated snapshot index in S3")
+	return nil
+}
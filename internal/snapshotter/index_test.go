@@ -0,0 +1,62 @@
+package snapshotter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+)
+
+func TestBuildIndex(t *testing.T) {
+	taken := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	targets := []db.TargetSnapshot{
+		{Alias: "geth", UploadPrefix: "hoodi/geth/100", EndTime: taken, SHA256: "aaa", Size: 1 << 30, ClientVersion: "Geth/v1.15.0"},
+		{Alias: "geth", UploadPrefix: "hoodi/geth/200", EndTime: taken, SHA256: "bbb", Verification: verificationVerified},
+		{Alias: "reth", UploadPrefix: "mainnet/reth/300", EndTime: taken},
+		{Alias: "broken", UploadPrefix: "hoodi/broken/latest"},
+	}
+
+	index := buildIndex(targets, taken)
+
+	geth := index.Networks["hoodi"]["geth"]
+	if geth == nil || len(geth.Snapshots) != 2 {
+		t.Fatalf("expected 2 geth snapshots on hoodi, got %+v", geth)
+	}
+	if geth.Latest != 200 || geth.Snapshots[0].BlockNumber != 200 || geth.Snapshots[1].BlockNumber != 100 {
+		t.Errorf("expected geth snapshots most recent first with latest 200, got %+v", geth)
+	}
+	if !geth.Snapshots[0].Verified || geth.Snapshots[1].Verified {
+		t.Error("expected only the verified snapshot to be marked as verified")
+	}
+	if entry := geth.Snapshots[1]; entry.File != "hoodi/geth/100/snapshot.tar.zst" || entry.SHA256 != "aaa" || entry.ClientVersion != "Geth/v1.15.0" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if reth := index.Networks["mainnet"]["reth"]; reth == nil || reth.Latest != 300 {
+		t.Errorf("expected reth snapshot on mainnet, got %+v", reth)
+	}
+	if _, ok := index.Networks["hoodi"]["broken"]; ok {
+		t.Error("expected snapshot without block to be left out")
+	}
+
+	tests := []struct {
+		rootPrefix string
+		link       string
+	}{
+		{"", `href="hoodi/geth/200/snapshot.tar.zst"`},
+		{"snapshots/", `href="../hoodi/geth/200/snapshot.tar.zst"`},
+		{"a/b/", `href="../../hoodi/geth/200/snapshot.tar.zst"`},
+	}
+	for _, tc := range tests {
+		html, err := renderIndexHTML(index, "", tc.rootPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(html), tc.link) {
+			t.Errorf("expected link %s with root prefix %q in:\n%s", tc.link, tc.rootPrefix, html)
+		}
+		if !strings.Contains(string(html), "1.0 GiB") {
+			t.Error("expected human readable size")
+		}
+	}
+}
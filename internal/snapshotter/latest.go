@@ -0,0 +1,223 @@
+package snapshotter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// targetLatestPrefix returns the upload prefix of the target a snapshot belongs to, i.e. its upload prefix without the block
+func targetLatestPrefix(ts *db.TargetSnapshot) string {
+	return path.Dir(ts.UploadPrefix)
+}
+
+// readLatest returns the block the latest file at key points to, or false if there is none
+func (s *SnapShotter) readLatest(ctx context.Context, key string) (uint64, bool) {
+	body, err := s.s3Client.GetObject(ctx, s.s3Client.GetBucketName(), key)
+	if err != nil {
+		return 0, false
+	}
+	block, err := strconv.ParseUint(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return block, true
+}
+
+// updateTargetLatest points the latest files of the target a snapshot belongs to at it, unless they already
+// point at a newer block. latest.json is written before latest, so latest never points at a block whose
+// details aren't published yet.
+func (s *SnapShotter) updateTargetLatest(ctx context.Context, ts *db.TargetSnapshot, block uint64) error {
+	bucket := s.s3Client.GetBucketName()
+	if bucket == "" {
+		return fmt.Errorf("bucket name not configured in S3 settings")
+	}
+	prefix := targetLatestPrefix(ts)
+	if current, ok := s.readLatest(ctx, prefix+"/latest"); ok && current > block {
+		log.WithFields(log.Fields{
+			"alias":  ts.Alias,
+			"block":  block,
+			"latest": current,
+		}).Info("latest file of target already points at a newer block")
+		return nil
+	}
+	return s.writeTargetLatest(ctx, ts, block)
+}
+
+// writeTargetLatest writes latest.json and latest of the target a snapshot belongs to
+func (s *SnapShotter) writeTargetLatest(ctx context.Context, ts *db.TargetSnapshot, block uint64) error {
+	bucket := s.s3Client.GetBucketName()
+	prefix := targetLatestPrefix(ts)
+
+	info := types.LatestSnapshot{
+		BlockNumber: block,
+		File:        ts.UploadPrefix + "/snapshot.tar.zst",
+		SHA256:      ts.SHA256,
+		Size:        ts.Size,
+		Timestamp:   ts.EndTime.UTC(),
+	}
+	if ts.EndTime.IsZero() {
+		info.Timestamp = time.Now().UTC()
+	}
+
+	info.BlockHash, info.ClientVersion = ts.BlockHash, ts.ClientVersion
+	if info.BlockHash == "" && info.ClientVersion == "" {
+		info.BlockHash, info.ClientVersion = s.readSnapshotDetails(ctx, ts)
+	}
+
+	content, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := s.s3Client.PutObject(ctx, bucket, prefix+"/latest.json", content); err != nil {
+		return fmt.Errorf("failed to upload latest.json: %w", err)
+	}
+	if err := s.s3Client.PutObject(ctx, bucket, prefix+"/latest", []byte(strconv.FormatUint(block, 10))); err != nil {
+		return fmt.Errorf("failed to upload latest file: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"alias":  ts.Alias,
+		"prefix": prefix,
+		"block":  block,
+	}).Info("updated latest file of target in S3")
+	s.events.Publish(events.TypeLatestUpdated, events.LatestUpdated{Alias: ts.Alias, Key: prefix + "/latest", BlockNumber: block})
+	return nil
+}
+
+// readSnapshotDetails reads the hash of the snapshot block and the client version from the metadata that was
+// dumped on the target and uploaded next to the archive. Missing details are logged and returned empty.
+func (s *SnapShotter) readSnapshotDetails(ctx context.Context, ts *db.TargetSnapshot) (blockHash, clientVersion string) {
+	bucket := s.s3Client.GetBucketName()
+	if body, err := s.s3Client.GetObject(ctx, bucket, ts.UploadPrefix+"/_snapshot_eth_getBlockByNumber.json"); err != nil {
+		log.WithError(err).WithField("alias", ts.Alias).Warn("could not read snapshot block, no block hash recorded")
+	} else if b, err := parseBlockResponse(body); err != nil || b == nil {
+		log.WithError(err).WithField("alias", ts.Alias).Warn("invalid snapshot block, no block hash recorded")
+	} else {
+		blockHash = b.Hash
+	}
+
+	if body, err := s.s3Client.GetObject(ctx, bucket, ts.UploadPrefix+"/_snapshot_web3_clientVersion.json"); err != nil {
+		log.WithError(err).WithField("alias", ts.Alias).Warn("could not read snapshot client version, no client version recorded")
+	} else {
+		var resp struct {
+			Result string `json:"result"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			log.WithError(err).WithField("alias", ts.Alias).Warn("invalid snapshot client version, no client version recorded")
+		}
+		clientVersion = resp.Result
+	}
+	return blockHash, clientVersion
+}
+
+// recordSnapshotDetails stores the hash of the snapshot block and the client version of an uploaded target snapshot
+func (s *SnapShotter) recordSnapshotDetails(ctx context.Context, id int64) {
+	ts, err := s.db.GetTargetSnapshotByID(id)
+	if err != nil || ts == nil {
+		log.WithError(err).WithField("target_snapshot_id", id).Error("failed to get target snapshot to record its details")
+		return
+	}
+	blockHash, clientVersion := s.readSnapshotDetails(ctx, ts)
+	if err := s.db.SetTargetSnapshotDetails(id, blockHash, clientVersion); err != nil {
+		log.WithError(err).Error("failed to record snapshot details")
+	}
+}
+
+// publishTargetLatest updates the latest files of a target snapshot that just became usable, logging any failure
+func (s *SnapShotter) publishTargetLatest(ctx context.Context, id int64, block uint64) {
+	ts, err := s.db.GetTargetSnapshotByID(id)
+	if err != nil || ts == nil {
+		log.WithError(err).WithField("target_snapshot_id", id).Error("failed to get target snapshot to update latest file")
+		return
+	}
+	if err := s.updateTargetLatest(ctx, ts, block); err != nil {
+		log.WithError(err).WithField("alias", ts.Alias).Error("failed to update latest file of target in S3")
+	}
+}
+
+// revertTargetLatest moves the latest files of a target away from a snapshot that was deleted, to the most
+// recent remaining usable snapshot of the alias. If there is none, the latest files are removed.
+func (s *SnapShotter) revertTargetLatest(ctx context.Context, deleted db.TargetSnapshot, deletedBlock uint64) error {
+	bucket := s.s3Client.GetBucketName()
+	prefix := targetLatestPrefix(&deleted)
+	current, ok := s.readLatest(ctx, prefix+"/latest")
+	if !ok || current != deletedBlock {
+		return nil
+	}
+
+	ts, block, err := s.db.GetLatestUsableTargetSnapshot(deleted.Alias)
+	if err != nil {
+		return err
+	}
+	if ts == nil || targetLatestPrefix(ts) != prefix {
+		log.WithFields(log.Fields{
+			"alias":  deleted.Alias,
+			"prefix": prefix,
+			"block":  deletedBlock,
+		}).Warn("latest snapshot of target was deleted and there is no other one, removing latest files")
+		if err := s.s3Client.DeleteObject(ctx, bucket, prefix+"/latest"); err != nil {
+			return err
+		}
+		s.events.Publish(events.TypeLatestUpdated, events.LatestUpdated{Alias: deleted.Alias, Key: prefix + "/latest", Removed: true})
+		return s.s3Client.DeleteObject(ctx, bucket, prefix+"/latest.json")
+	}
+
+	log.WithFields(log.Fields{
+		"alias":    deleted.Alias,
+		"deleted":  deletedBlock,
+		"reverted": block,
+	}).Warn("latest snapshot of target was deleted, reverting latest files")
+	return s.writeTargetLatest(ctx, ts, block)
+}
+
+// coversAllTargets reports whether the given aliases include every configured target. Only runs that do
+// move the root latest file.
+func (s *SnapShotter) coversAllTargets(aliases []string) bool {
+	for _, t := range s.targets {
+		if !slices.Contains(aliases, t.cfg.Alias) {
+			return false
+		}
+	}
+	return true
+}
+
+// revertRootLatest moves the root latest file away from the block of a run that was deleted, to the most
+// recent remaining usable run of every target. If there is none, the file is left as is.
+func (s *SnapShotter) revertRootLatest(ctx context.Context, deletedBlock uint64) error {
+	current, ok := s.readLatest(ctx, s.s3Client.GetRootPrefix()+"latest")
+	if !ok || current != deletedBlock {
+		return nil
+	}
+	aliases := make([]string, 0, len(s.targets))
+	for _, t := range s.targets {
+		aliases = append(aliases, t.cfg.Alias)
+	}
+	block, err := s.db.GetLatestUsableRunBlock(aliases)
+	if err != nil {
+		return err
+	}
+	if block == deletedBlock {
+		// Another group took a snapshot at the same block
+		return nil
+	}
+	if block == 0 {
+		log.WithField("block", deletedBlock).Warn("run the latest file points at was deleted and there is no other one")
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"deleted":  deletedBlock,
+		"reverted": block,
+	}).Warn("run the latest file points at was deleted, reverting latest file")
+	return s.writeLatestFile(ctx, block)
+}
@@ -0,0 +1,173 @@
+package snapshotter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+func TestTargetLatest(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	mockS3 := &MockS3Client{bucketName: "test-bucket", uploadedFiles: make(map[string]string)}
+	ss := &SnapShotter{
+		cfg:      &config.Config{},
+		db:       database,
+		s3Client: mockS3,
+	}
+	ctx := context.Background()
+
+	// createSnapshot records a successful snapshot of geth at the given block and uploads its metadata
+	createSnapshot := func(block uint64) *db.TargetSnapshot {
+		run, err := database.CreateSnapshotRun("geth", block, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := database.CreateTargetSnapshot(run.ID, "geth", fmt.Sprintf("hoodi/geth/%d", block), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.SetTargetSnapshotArchive(ts.ID, "abc123", 42); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.UpdateTargetSnapshotStatus(ts.ID, "success", ""); err != nil {
+			t.Fatal(err)
+		}
+		mockS3.uploadedFiles[ts.UploadPrefix+"/_snapshot_eth_getBlockByNumber.json"] = fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"result":{"number":"0x%x","hash":"0xhash%d"}}`, block, block)
+		mockS3.uploadedFiles[ts.UploadPrefix+"/_snapshot_web3_clientVersion.json"] = `{"jsonrpc":"2.0","id":1,"result":"Geth/v1.15.0"}`
+		ts, err = database.GetTargetSnapshotByID(ts.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	older := createSnapshot(100)
+	newer := createSnapshot(200)
+
+	if err := ss.updateTargetLatest(ctx, newer, 200); err != nil {
+		t.Fatal(err)
+	}
+	// An older snapshot finishing late doesn't move latest back
+	if err := ss.updateTargetLatest(ctx, older, 100); err != nil {
+		t.Fatal(err)
+	}
+	if latest := mockS3.uploadedFiles["hoodi/geth/latest"]; latest != "200" {
+		t.Fatalf("expected latest to point at 200, got %q", latest)
+	}
+
+	var info types.LatestSnapshot
+	if err := json.Unmarshal([]byte(mockS3.uploadedFiles["hoodi/geth/latest.json"]), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.BlockNumber != 200 || info.BlockHash != "0xhash200" || info.ClientVersion != "Geth/v1.15.0" ||
+		info.SHA256 != "abc123" || info.Size != 42 || info.File != "hoodi/geth/200/snapshot.tar.zst" || info.Timestamp.IsZero() {
+		t.Errorf("unexpected latest.json %+v", info)
+	}
+
+	// Deleting the snapshot latest points at reverts it to the previous one
+	if err := database.MarkTargetSnapshotAsDeleted(newer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.revertTargetLatest(ctx, *newer, 200); err != nil {
+		t.Fatal(err)
+	}
+	if latest := mockS3.uploadedFiles["hoodi/geth/latest"]; latest != "100" {
+		t.Fatalf("expected latest to be reverted to 100, got %q", latest)
+	}
+
+	// Once no snapshot is left, the latest files are removed
+	if err := database.MarkTargetSnapshotAsDeleted(older.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.revertTargetLatest(ctx, *older, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mockS3.uploadedFiles["hoodi/geth/latest"]; ok {
+		t.Error("expected latest to be removed")
+	}
+	if _, ok := mockS3.uploadedFiles["hoodi/geth/latest.json"]; ok {
+		t.Error("expected latest.json to be removed")
+	}
+}
+
+func TestRootLatest(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	mockS3 := &MockS3Client{bucketName: "test-bucket", uploadedFiles: make(map[string]string)}
+	ss := &SnapShotter{
+		cfg:      &config.Config{},
+		db:       database,
+		s3Client: mockS3,
+		targets:  []*target{{cfg: &config.TargetConfig{Alias: "geth"}}, {cfg: &config.TargetConfig{Alias: "nethermind"}}},
+	}
+	ctx := context.Background()
+
+	if ss.coversAllTargets([]string{"geth"}) {
+		t.Error("expected a group of geth only not to cover every target")
+	}
+	if !ss.coversAllTargets([]string{"nethermind", "geth"}) {
+		t.Error("expected a group of geth and nethermind to cover every target")
+	}
+
+	// createRun records a successful run at the given block with a snapshot of each alias
+	createRun := func(block uint64, aliases ...string) *db.SnapshotRun {
+		run, err := database.CreateSnapshotRun("group", block, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, alias := range aliases {
+			ts, err := database.CreateTargetSnapshot(run.ID, alias, fmt.Sprintf("hoodi/%s/%d", alias, block), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := database.UpdateTargetSnapshotStatus(ts.ID, "success", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := database.UpdateSnapshotRunStatus(run.ID, "success", ""); err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+
+	createRun(100, "geth", "nethermind")
+	createRun(200, "geth")
+	newest := createRun(300, "geth", "nethermind")
+
+	if err := ss.updateLatestFile(300, false); err != nil {
+		t.Fatal(err)
+	}
+	// A run finishing late doesn't move latest back
+	if err := ss.updateLatestFile(100, false); err != nil {
+		t.Fatal(err)
+	}
+	if latest := mockS3.uploadedFiles["latest"]; latest != "300" {
+		t.Fatalf("expected latest to point at 300, got %q", latest)
+	}
+
+	// Deleting the run latest points at reverts it to the previous run of every target
+	if err := database.MarkSnapshotRunAsDeleted(newest.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.revertRootLatest(ctx, 300); err != nil {
+		t.Fatal(err)
+	}
+	if latest := mockS3.uploadedFiles["latest"]; latest != "100" {
+		t.Errorf("expected latest to be reverted to 100, got %q", latest)
+	}
+}
@@ -0,0 +1,135 @@
+package snapshotter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// staleCheckInterval is how often each group is checked for missing snapshots
+const staleCheckInterval = time.Minute
+
+// notifyRun sends the outcome of a run. Cancelled runs were stopped on purpose and are not notified.
+func (s *SnapShotter) notifyRun(runID int64, group string, block uint64, dryRun bool, status, errMsg string) {
+	n := notify.Notification{
+		Group:       group,
+		RunID:       runID,
+		BlockNumber: block,
+		DryRun:      dryRun,
+	}
+	switch status {
+	case "success":
+		n.Event = notify.RunSucceeded
+		n.Title = fmt.Sprintf("Snapshot of %s at block %d succeeded", group, block)
+	case "cancelled":
+		return
+	default:
+		n.Event = notify.RunFailed
+		n.Title = fmt.Sprintf("Snapshot of %s at block %d %s", group, block, status)
+		n.Error = errMsg
+	}
+	n.Message = fmt.Sprintf("Run %d", runID)
+	if dryRun {
+		n.Message += " (dry run)"
+	}
+	s.notifications.Notify(n)
+}
+
+// notifyUpload sends the outcome of the upload of a target snapshot
+func (s *SnapShotter) notifyUpload(alias string, id int64, block uint64, took time.Duration, err error) {
+	n := notify.Notification{
+		Alias:            alias,
+		TargetSnapshotID: id,
+		BlockNumber:      block,
+		Message:          fmt.Sprintf("Target snapshot %d, took %s", id, took.Round(time.Second)),
+	}
+	if err != nil {
+		n.Event = notify.UploadFailed
+		n.Title = fmt.Sprintf("Upload of %s at block %d failed", alias, block)
+		n.Error = err.Error()
+	} else {
+		n.Event = notify.UploadSucceeded
+		n.Title = fmt.Sprintf("Uploaded %s at block %d", alias, block)
+	}
+	s.notifications.Notify(n)
+}
+
+// notifyCleanup sends the outcome of a cleanup that deleted or failed to delete snapshots. failedUploads
+// counts the failed uploads deleted, apart from the successful target snapshots.
+func (s *SnapShotter) notifyCleanup(deleted, failedUploads, failed int, err error) {
+	switch {
+	case err != nil:
+		s.notifications.Notify(notify.Notification{
+			Event: notify.CleanupFailed,
+			Title: "Snapshot cleanup failed",
+			Error: err.Error(),
+		})
+	case failed > 0:
+		s.notifications.Notify(notify.Notification{
+			Event:   notify.CleanupFailed,
+			Title:   fmt.Sprintf("Snapshot cleanup failed to delete %d target snapshots", failed),
+			Message: fmt.Sprintf("Deleted %d target snapshots and %d failed uploads, the others are retried on the next cleanup", deleted, failedUploads),
+		})
+	case deleted > 0 || failedUploads > 0:
+		s.notifications.Notify(notify.Notification{
+			Event: notify.CleanupSucceeded,
+			Title: fmt.Sprintf("Snapshot cleanup deleted %d target snapshots and %d failed uploads", deleted, failedUploads),
+		})
+	}
+}
+
+// staleDeadline returns the time a group whose last successful snapshot started at last has gone
+// stale_after_intervals intervals without a snapshot, or the zero time if it is not checked
+func (s *SnapShotter) staleDeadline(g *snapshotGroup, last time.Time) time.Time {
+	intervals := s.cfg.Global.Notifications.StaleAfterIntervals
+	if intervals <= 0 {
+		return time.Time{}
+	}
+	if g.blockInterval > 0 {
+		return last.Add(time.Duration(uint64(intervals)*g.blockInterval) * s.blockTime())
+	}
+	deadline := last
+	for i := 0; i < intervals; i++ {
+		if deadline = s.schedule.nextCron(deadline); deadline.IsZero() {
+			return time.Time{}
+		}
+	}
+	return deadline
+}
+
+// checkStale notifies once a group didn't produce a successful snapshot for stale_after_intervals intervals.
+// Without any successful snapshot, the intervals are counted from since. notified is the run the group was
+// last notified about, 0 for none, so every stale period is only notified once. It returns the updated value.
+func (s *SnapShotter) checkStale(g *snapshotGroup, since time.Time, notified int64) int64 {
+	run, err := s.db.GetMostRecentSuccessfulRunForGroup(g.name, g.aliases())
+	if err != nil {
+		log.WithError(err).WithField("group", g.name).Error("failed to get most recent successful run")
+		return notified
+	}
+	last, lastID, message := since, int64(-1), "The group hasn't produced a snapshot yet"
+	if run != nil {
+		last, lastID = run.StartTime, run.ID
+		message = fmt.Sprintf("The last successful snapshot was taken at block %d, at %s", run.BlockHeight, run.StartTime.UTC().Format(time.RFC3339))
+	}
+	if notified == lastID {
+		return notified
+	}
+
+	deadline := s.staleDeadline(g, last)
+	if deadline.IsZero() || time.Now().Before(deadline) {
+		return notified
+	}
+	log.WithFields(log.Fields{
+		"group":    g.name,
+		"last_run": last,
+	}).Warn("group didn't produce a snapshot for too long")
+	s.notifications.Notify(notify.Notification{
+		Event:   notify.SnapshotStale,
+		Title:   fmt.Sprintf("No snapshot of %s for %d intervals", g.name, s.cfg.Global.Notifications.StaleAfterIntervals),
+		Message: message,
+		Group:   g.name,
+	})
+	return lastID
+}
@@ -0,0 +1,76 @@
+package snapshotter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+)
+
+func TestStaleDeadline(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Global.Notifications.StaleAfterIntervals = 3
+	cronSchedule, err := newSchedule(config.ScheduleConfig{Cron: "0 2 * * *"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := &SnapShotter{cfg: cfg, schedule: cronSchedule}
+	last := time.Date(2025, 5, 1, 2, 0, 0, 0, time.UTC)
+
+	// 3 intervals of 100 blocks of 12 seconds
+	blocks := &snapshotGroup{name: "geth", blockInterval: 100}
+	if deadline := ss.staleDeadline(blocks, last); !deadline.Equal(last.Add(3 * 1200 * time.Second)) {
+		t.Errorf("unexpected deadline for block interval %s", deadline)
+	}
+
+	// 3 daily cron triggers
+	cronOnly := &snapshotGroup{name: "reth"}
+	if deadline := ss.staleDeadline(cronOnly, last); !deadline.Equal(last.Add(72 * time.Hour)) {
+		t.Errorf("unexpected deadline for cron schedule %s", deadline)
+	}
+
+	cfg.Global.Notifications.StaleAfterIntervals = 0
+	if deadline := ss.staleDeadline(blocks, last); !deadline.IsZero() {
+		t.Errorf("expected no deadline when disabled, got %s", deadline)
+	}
+}
+
+func TestCheckStale(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	cfg := &config.Config{}
+	cfg.Global.Notifications.StaleAfterIntervals = 2
+	sc, err := newSchedule(config.ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := &SnapShotter{cfg: cfg, db: database, schedule: sc}
+	g := &snapshotGroup{name: "geth", blockInterval: 10}
+
+	// Polling started long ago and there is no snapshot yet
+	notified := ss.checkStale(g, time.Now().Add(-time.Hour), 0)
+	if notified != -1 {
+		t.Fatalf("expected group without snapshots to be stale, got %d", notified)
+	}
+	if again := ss.checkStale(g, time.Now().Add(-time.Hour), notified); again != notified {
+		t.Errorf("expected stale period to be notified once, got %d", again)
+	}
+
+	// A fresh successful run ends the stale period
+	run, err := database.CreateSnapshotRun("geth", 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateSnapshotRunStatus(run.ID, "success", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := ss.checkStale(g, time.Now().Add(-time.Hour), notified); got != notified {
+		t.Errorf("expected group with a fresh snapshot not to be stale, got %d", got)
+	}
+}
@@ -0,0 +1,398 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Phases of a target snapshot, in the order they are completed
+const (
+	phaseSnooperStopped = "snooper_stopped"
+	phaseMetadataDumped = "metadata_dumped"
+	phaseELStopped      = "el_stopped"
+	phaseUploading      = "uploading"
+	phaseUploaded       = "uploaded"
+	phaseRestarted      = "restarted"
+)
+
+var phaseOrder = []string{phaseSnooperStopped, phaseMetadataDumped, phaseELStopped, phaseUploading, phaseUploaded, phaseRestarted}
+
+// phaseDone reports whether a target whose last completed phase is last has completed phase
+func phaseDone(last, phase string) bool {
+	return slices.Index(phaseOrder, last) >= slices.Index(phaseOrder, phase)
+}
+
+// targetPhases records the phase transitions of the target snapshots of a run.
+// All methods are safe to call on a nil receiver.
+type targetPhases struct {
+	db     *db.DB
+	events *events.Broker
+	ids    map[string]int64
+	// runID and block are the run the target snapshots belong to and its block, passed to hooks
+	runID int64
+	block uint64
+
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+// createTargetSnapshots records a target snapshot for every target of the run before any of them is touched
+func (s *SnapShotter) createTargetSnapshots(g *snapshotGroup, run *db.SnapshotRun) (*targetPhases, error) {
+	p := &targetPhases{db: s.db, events: s.events, ids: make(map[string]int64), runID: run.ID, block: run.BlockHeight}
+	for _, t := range g.targets {
+		uploadPrefix := fmt.Sprintf("%s/%d", t.cfg.UploadPrefix, run.BlockHeight)
+		ts, err := s.db.CreateTargetSnapshot(run.ID, t.cfg.Alias, uploadPrefix, g.dryRun)
+		if err != nil {
+			log.WithError(err).Error("failed to create target snapshot record")
+			return nil, err
+		}
+		p.ids[t.cfg.Alias] = ts.ID
+	}
+	return p, nil
+}
+
+func (p *targetPhases) id(t *target) (int64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	id, ok := p.ids[t.cfg.Alias]
+	return id, ok
+}
+
+// complete records that the target completed the phase
+func (p *targetPhases) complete(t *target, phase string) {
+	id, ok := p.id(t)
+	if !ok {
+		return
+	}
+	if err := p.db.RecordTargetSnapshotPhase(id, phase); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"alias": t.cfg.Alias,
+			"phase": phase,
+		}).Error("failed to record target snapshot phase")
+	}
+	p.events.Publish(events.TypePhaseChanged, events.PhaseChanged{TargetSnapshotID: id, Alias: t.cfg.Alias, Phase: phase})
+}
+
+// fail records that the target failed to reach the phase. Failures caused by the run being
+// interrupted or cancelled are not recorded, the run records those.
+func (p *targetPhases) fail(ctx context.Context, t *target, phase string, err error) {
+	id, ok := p.id(t)
+	if !ok || ctx.Err() != nil {
+		return
+	}
+	if errDB := p.db.RecordTargetSnapshotPhaseFailure(id, phase, err.Error()); errDB != nil {
+		log.WithError(errDB).WithFields(log.Fields{
+			"alias": t.cfg.Alias,
+			"phase": phase,
+		}).Error("failed to record target snapshot phase failure")
+	}
+	p.events.Publish(events.TypePhaseChanged, events.PhaseChanged{TargetSnapshotID: id, Alias: t.cfg.Alias, Phase: phase, Error: err.Error()})
+}
+
+// hold keeps the target from being restarted at the end of the run
+func (p *targetPhases) hold(t *target) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.held == nil {
+		p.held = make(map[string]bool)
+	}
+	p.held[t.cfg.Alias] = true
+}
+
+func (p *targetPhases) isHeld(t *target) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.held[t.cfg.Alias]
+}
+
+func (p *targetPhases) heldTargets() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var aliases []string
+	for alias := range p.held {
+		aliases = append(aliases, alias)
+	}
+	slices.Sort(aliases)
+	return aliases
+}
+
+// dumpMetadata writes the block and client version the snapshot is taken at into the data directory
+func dumpMetadata(ctx context.Context, t *target) error {
+	cl := t.driver
+	err := cl.DumpExecutionRPCRequestToFile(ctx, `{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["latest",true],"id":1}`, t.cfg.DataDir+"/_snapshot_eth_getBlockByNumber.json")
+	if err != nil {
+		log.WithError(err).Errorf("could not dump eth_getBlockByNumber to file %s", cl.Alias())
+		return err
+	}
+	err = cl.DumpExecutionRPCRequestToFile(ctx, `{"jsonrpc":"2.0","method":"web3_clientVersion","params":[],"id":1}`, t.cfg.DataDir+"/_snapshot_web3_clientVersion.json")
+	if err != nil {
+		log.WithError(err).Errorf("could not dump web3_clientVersion to file %s", cl.Alias())
+		return err
+	}
+	return nil
+}
+
+// RetryTarget resumes a failed target snapshot from its last completed phase. This is only possible
+// while the target hasn't been restarted since, e.g. when it was held after a failed upload, or
+// when only its restart failed.
+func (s *SnapShotter) RetryTarget(id int64) (*db.TargetSnapshot, error) {
+	return s.resumeTarget(id, false)
+}
+
+// RestoreTarget brings the containers of a target held after a failed upload back up without retrying it
+func (s *SnapShotter) RestoreTarget(id int64) (*db.TargetSnapshot, error) {
+	return s.resumeTarget(id, true)
+}
+
+func (s *SnapShotter) resumeTarget(id int64, restoreOnly bool) (*db.TargetSnapshot, error) {
+	s.runsMu.Lock()
+	runCtx := s.runCtx
+	s.runsMu.Unlock()
+	if runCtx == nil || runCtx.Err() != nil {
+		return nil, types.ErrSnapshotterNotRunning
+	}
+
+	ts, err := s.db.GetTargetSnapshotByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if ts == nil {
+		return nil, fmt.Errorf("target snapshot %d not found", id)
+	}
+	if err := resumable(ts); err != nil {
+		return nil, err
+	}
+
+	var g *snapshotGroup
+	var t *target
+	for _, group := range s.groups {
+		for _, gt := range group.targets {
+			if gt.cfg.Alias == ts.Alias {
+				g, t = group, gt
+			}
+		}
+	}
+	if t == nil {
+		return nil, fmt.Errorf("%w: %s", types.ErrUnknownAlias, ts.Alias)
+	}
+
+	run, err := s.db.GetSnapshotRunByID(ts.SnapshotRunID)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("snapshot run %d not found", ts.SnapshotRunID)
+	}
+
+	g.status.Lock()
+	if err := g.busy(); err != nil {
+		g.status.Unlock()
+		return nil, err
+	}
+	g.status.SnapshotInProgress = true
+	g.status.Unlock()
+	s.metrics.SetSnapshotInProgress(g.name, true)
+
+	if err := s.db.UpdateTargetSnapshotStatus(ts.ID, "running", ""); err != nil {
+		s.releaseGroup(g)
+		return nil, err
+	}
+	if err := s.db.UpdateSnapshotRunStatus(run.ID, "running", ""); err != nil {
+		s.releaseGroup(g)
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"alias":        ts.Alias,
+		"run_id":       run.ID,
+		"block":        run.BlockHeight,
+		"last_phase":   ts.Phase,
+		"restore_only": restoreOnly,
+	}).Info("resuming target snapshot")
+
+	s.events.Publish(events.TypeSnapshotStarted, events.SnapshotStarted{
+		RunID:       run.ID,
+		Group:       run.Group,
+		BlockNumber: run.BlockHeight,
+		Targets:     []string{ts.Alias},
+		Resumed:     true,
+	})
+
+	view := g.subset([]*target{t}, false)
+	phases := &targetPhases{db: s.db, events: s.events, ids: map[string]int64{t.cfg.Alias: ts.ID}, runID: run.ID, block: run.BlockHeight}
+	s.manualRuns.Add(1)
+	go func() {
+		defer s.manualRuns.Done()
+		defer s.checkContainers(runCtx, view, triggerRun, run.ID)
+		defer s.releaseGroup(g)
+
+		ctx, cancel := context.WithCancelCause(runCtx)
+		defer cancel(nil)
+		s.trackRun(run.ID, cancel)
+		defer s.untrackRun(run.ID)
+
+		err := s.resumePhases(ctx, view, t, ts, phases, run.BlockHeight, restoreOnly)
+		s.finishResumedTarget(ctx, ts, run, err, restoreOnly)
+	}()
+
+	resp := *ts
+	resp.Status, resp.ErrorMessage = "running", ""
+	return &resp, nil
+}
+
+// resumable checks that the target snapshot failed and its targets were not restarted since
+func resumable(ts *db.TargetSnapshot) error {
+	if ts.DryRun {
+		return fmt.Errorf("%w: dry run target snapshots can't be resumed", types.ErrTargetNotResumable)
+	}
+	switch ts.Status {
+	case "failed", "interrupted", "cancelled":
+	default:
+		return fmt.Errorf("%w: target snapshot is %s", types.ErrTargetNotResumable, ts.Status)
+	}
+	if ts.Phase == phaseRestarted {
+		return fmt.Errorf("%w: target was already restarted", types.ErrTargetNotResumable)
+	}
+	// Once a restart was attempted the data directory can't be assumed to be at the snapshot block anymore,
+	// only the restart itself can be retried then
+	if !phaseDone(ts.Phase, phaseUploaded) {
+		for _, p := range ts.Phases {
+			if p.Phase == phaseRestarted {
+				return fmt.Errorf("%w: target was restarted before its upload completed", types.ErrTargetNotResumable)
+			}
+		}
+	}
+	return nil
+}
+
+// resumePhases runs the phases of a target after its last completed phase and brings it back up,
+// unless the upload fails again and the target is held
+func (s *SnapShotter) resumePhases(ctx context.Context, g *snapshotGroup, t *target, ts *db.TargetSnapshot, phases *targetPhases, block uint64, restoreOnly bool) (err error) {
+	defer func() {
+		if phases.isHeld(t) {
+			log.WithField("alias", t.cfg.Alias).Warn("keeping target stopped after failed upload, retry or restore it through the API")
+			return
+		}
+		if errPost := s.PostSnapshotStart(context.WithoutCancel(ctx), g, phases); errPost != nil {
+			log.WithError(errPost).Error("failed to restore service after resumed snapshot")
+			if err == nil {
+				err = errPost
+			}
+		}
+	}()
+
+	if restoreOnly {
+		return nil
+	}
+
+	if !phaseDone(ts.Phase, phaseSnooperStopped) {
+		if err := s.runHooks(ctx, t, phases, config.HookPreStop); err != nil {
+			phases.fail(ctx, t, phaseSnooperStopped, err)
+			return err
+		}
+		if err := stopComponents(ctx, t, componentsBeforeExecution(t.cfg)); err != nil {
+			phases.fail(ctx, t, phaseSnooperStopped, err)
+			return err
+		}
+		phases.complete(t, phaseSnooperStopped)
+	}
+	if !phaseDone(ts.Phase, phaseMetadataDumped) {
+		if err := dumpMetadata(ctx, t); err != nil {
+			phases.fail(ctx, t, phaseMetadataDumped, err)
+			return err
+		}
+		phases.complete(t, phaseMetadataDumped)
+	}
+	if !phaseDone(ts.Phase, phaseELStopped) {
+		if err := stopComponents(ctx, t, componentsFromExecution(t.cfg)); err != nil {
+			phases.fail(ctx, t, phaseELStopped, err)
+			return err
+		}
+		phases.complete(t, phaseELStopped)
+		if err := s.runHooks(ctx, t, phases, config.HookPostStop); err != nil {
+			phases.fail(ctx, t, phaseUploading, err)
+			return err
+		}
+	}
+	if !phaseDone(ts.Phase, phaseUploaded) {
+		return s.uploadTarget(ctx, t, phases, block)
+	}
+	return nil
+}
+
+// finishResumedTarget records the outcome of a resumed target snapshot and updates its run accordingly
+func (s *SnapShotter) finishResumedTarget(ctx context.Context, ts *db.TargetSnapshot, run *db.SnapshotRun, err error, restoreOnly bool) {
+	status, errMsg := "success", ""
+	switch {
+	case err != nil:
+		status, errMsg = "failed", err.Error()
+		if ctx.Err() != nil {
+			status = "interrupted"
+			if errors.Is(context.Cause(ctx), types.ErrRunCancelled) {
+				status, errMsg = "cancelled", types.ErrRunCancelled.Error()
+			}
+		}
+	case restoreOnly:
+		// The target is up again, but its snapshot is still missing
+		status, errMsg = ts.Status, ts.ErrorMessage
+	}
+	if errDB := s.db.UpdateTargetSnapshotStatus(ts.ID, status, errMsg); errDB != nil {
+		log.WithError(errDB).Error("failed to update target snapshot status")
+	}
+
+	targets, errDB := s.db.GetTargetSnapshotsForRun(run.ID)
+	if errDB != nil {
+		log.WithError(errDB).Error("failed to get target snapshots of run")
+		return
+	}
+	runStatus, runErrMsg := "success", ""
+	for _, other := range targets {
+		if other.Status != "success" {
+			runStatus, runErrMsg = "failed", fmt.Sprintf("target %s %s: %s", other.Alias, other.Status, other.ErrorMessage)
+			break
+		}
+	}
+	if errDB := s.db.UpdateSnapshotRunStatus(run.ID, runStatus, runErrMsg); errDB != nil {
+		log.WithError(errDB).Error("failed to update snapshot run status")
+	}
+	s.events.Publish(events.TypeRunFinished, events.RunFinished{
+		RunID:       run.ID,
+		Group:       run.Group,
+		BlockNumber: run.BlockHeight,
+		Status:      runStatus,
+		Error:       runErrMsg,
+	})
+	if !restoreOnly {
+		s.notifyRun(run.ID, run.Group, run.BlockHeight, run.DryRun, runStatus, runErrMsg)
+	}
+	if status == "success" && !restoreOnly {
+		s.publishIndex()
+		s.verifyRunInBackground(run.ID)
+	}
+
+	log.WithFields(log.Fields{
+		"alias":      ts.Alias,
+		"run_id":     run.ID,
+		"status":     status,
+		"run_status": runStatus,
+	}).Info("finished resuming target snapshot")
+}
@@ -0,0 +1,74 @@
+package snapshotter
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+func TestResumable(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	run, err := database.CreateSnapshotRun("geth", 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newTarget records a target snapshot that went through the given phases and ended with status
+	newTarget := func(status string, completed []string, failedPhase string) *db.TargetSnapshot {
+		ts, err := database.CreateTargetSnapshot(run.ID, "geth", "geth/100", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, phase := range completed {
+			if err := database.RecordTargetSnapshotPhase(ts.ID, phase); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if failedPhase != "" {
+			if err := database.RecordTargetSnapshotPhaseFailure(ts.ID, failedPhase, "boom"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := database.UpdateTargetSnapshotStatus(ts.ID, status, ""); err != nil {
+			t.Fatal(err)
+		}
+		ts, err = database.GetTargetSnapshotByID(ts.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	stopped := []string{phaseSnooperStopped, phaseMetadataDumped, phaseELStopped, phaseUploading}
+
+	held := newTarget("failed", stopped, phaseUploaded)
+	if held.Phase != phaseUploading || len(held.Phases) != 5 || held.Phases[4].ErrorMessage != "boom" {
+		t.Fatalf("unexpected phases recorded: %s %+v", held.Phase, held.Phases)
+	}
+	if err := resumable(held); err != nil {
+		t.Errorf("held target should be resumable: %v", err)
+	}
+
+	restartFailed := newTarget("failed", append(stopped, phaseUploaded), phaseRestarted)
+	if err := resumable(restartFailed); err != nil {
+		t.Errorf("target with failed restart should be resumable: %v", err)
+	}
+
+	for name, ts := range map[string]*db.TargetSnapshot{
+		"successful":                          newTarget("success", append(stopped, phaseUploaded, phaseRestarted), ""),
+		"restored after failure":              newTarget("failed", append(stopped, phaseRestarted), ""),
+		"restore failed after upload failure": newTarget("failed", stopped, phaseRestarted),
+	} {
+		if err := resumable(ts); !errors.Is(err, types.ErrTargetNotResumable) {
+			t.Errorf("%s target should not be resumable, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package snapshotter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+// progressWindow is the minimum period the throughput and ETA of an upload are computed over
+const progressWindow = 30 * time.Second
+
+// progressSample is the progress of an upload at a point in time
+type progressSample struct {
+	at       time.Time
+	progress types.UploadProgress
+}
+
+// uploadTracker keeps the progress of a running upload. Rates are computed against the older of two
+// samples, which is replaced by the newer one every progressWindow, so they cover the last 30 to 60 seconds.
+type uploadTracker struct {
+	mu           sync.Mutex
+	progress     types.TargetUploadProgress
+	older, newer progressSample
+}
+
+func newUploadTracker(alias string, id int64, now time.Time) *uploadTracker {
+	return &uploadTracker{
+		progress: types.TargetUploadProgress{
+			Alias:            alias,
+			TargetSnapshotID: id,
+			StartedAt:        now,
+			UpdatedAt:        now,
+		},
+		older: progressSample{at: now},
+		newer: progressSample{at: now},
+	}
+}
+
+// update records progress reported at the given time. The counters only grow, so each keeps its largest
+// value: the rclone command template reports the bytes read by tar and uploaded by rclone on separate lines.
+func (u *uploadTracker) update(progress types.UploadProgress, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	progress.BytesRead = max(progress.BytesRead, u.progress.BytesRead)
+	progress.BytesTotal = max(progress.BytesTotal, u.progress.BytesTotal)
+	progress.BytesUploaded = max(progress.BytesUploaded, u.progress.BytesUploaded)
+	u.progress.UploadProgress = progress
+	u.progress.UpdatedAt = now
+
+	if now.Sub(u.newer.at) >= progressWindow {
+		u.older = u.newer
+		u.newer = progressSample{at: now, progress: progress}
+	}
+
+	if elapsed := now.Sub(u.older.at).Seconds(); elapsed > 0 {
+		u.progress.BytesPerSecond = int64(float64(progress.BytesUploaded-u.older.progress.BytesUploaded) / elapsed)
+		readRate := float64(progress.BytesRead-u.older.progress.BytesRead) / elapsed
+		u.progress.ETASeconds = nil
+		if progress.BytesTotal > 0 && readRate > 0 {
+			eta := int64(float64(max(progress.BytesTotal-progress.BytesRead, 0)) / readRate)
+			u.progress.ETASeconds = &eta
+		}
+	}
+}
+
+func (u *uploadTracker) snapshot() types.TargetUploadProgress {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.progress
+}
+
+// trackUpload starts tracking the progress of the upload of a target snapshot. The returned function
+// records reported progress, done stops tracking once the upload finished.
+func (s *SnapShotter) trackUpload(alias string, id int64) (report func(types.UploadProgress), done func()) {
+	tracker := newUploadTracker(alias, id, time.Now())
+	s.uploadsMu.Lock()
+	if s.uploads == nil {
+		s.uploads = make(map[int64]*uploadTracker)
+	}
+	s.uploads[id] = tracker
+	s.uploadsMu.Unlock()
+
+	report = func(progress types.UploadProgress) {
+		tracker.update(progress, time.Now())
+	}
+	done = func() {
+		s.uploadsMu.Lock()
+		defer s.uploadsMu.Unlock()
+		if s.uploads[id] == tracker {
+			delete(s.uploads, id)
+		}
+	}
+	return report, done
+}
+
+// uploadProgress returns the progress of all running uploads, ordered by alias
+func (s *SnapShotter) uploadProgress() []types.TargetUploadProgress {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	uploads := make([]types.TargetUploadProgress, 0, len(s.uploads))
+	for _, u := range s.uploads {
+		uploads = append(uploads, u.snapshot())
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Alias < uploads[j].Alias
+	})
+	return uploads
+}
@@ -0,0 +1,69 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+func TestUploadTracker(t *testing.T) {
+	start := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newUploadTracker("geth", 1, start)
+
+	// 10 MB/s read and 5 MB/s uploaded over the first 20 seconds
+	tracker.update(types.UploadProgress{BytesRead: 200e6, BytesTotal: 1000e6, BytesUploaded: 100e6}, start.Add(20*time.Second))
+	p := tracker.snapshot()
+	if p.BytesPerSecond != 5e6 {
+		t.Errorf("expected 5 MB/s, got %d", p.BytesPerSecond)
+	}
+	if p.ETASeconds == nil || *p.ETASeconds != 80 {
+		t.Errorf("expected an ETA of 80s, got %v", p.ETASeconds)
+	}
+	if !p.UpdatedAt.Equal(start.Add(20 * time.Second)) {
+		t.Errorf("unexpected update time %s", p.UpdatedAt)
+	}
+
+	// The upload slows down to 1 MB/s, which shows once the first window rolled over
+	tracker.update(types.UploadProgress{BytesRead: 400e6, BytesTotal: 1000e6, BytesUploaded: 200e6}, start.Add(40*time.Second))
+	tracker.update(types.UploadProgress{BytesRead: 420e6, BytesTotal: 1000e6, BytesUploaded: 210e6}, start.Add(50*time.Second))
+	tracker.update(types.UploadProgress{BytesRead: 440e6, BytesTotal: 1000e6, BytesUploaded: 220e6}, start.Add(60*time.Second))
+	tracker.update(types.UploadProgress{BytesRead: 460e6, BytesTotal: 1000e6, BytesUploaded: 230e6}, start.Add(70*time.Second))
+	if p := tracker.snapshot(); p.BytesPerSecond != 1e6 {
+		t.Errorf("expected 1 MB/s over the last window, got %d", p.BytesPerSecond)
+	}
+
+	// Without the size of the data dir there is no ETA
+	tracker = newUploadTracker("geth", 2, start)
+	tracker.update(types.UploadProgress{BytesUploaded: 100e6}, start.Add(10*time.Second))
+	if p := tracker.snapshot(); p.ETASeconds != nil || p.BytesPerSecond != 10e6 {
+		t.Errorf("unexpected progress without total %+v", p)
+	}
+
+	// tar and rclone report the bytes read and uploaded on separate lines
+	tracker = newUploadTracker("geth", 3, start)
+	tracker.update(types.UploadProgress{BytesRead: 200e6, BytesTotal: 1000e6}, start.Add(10*time.Second))
+	tracker.update(types.UploadProgress{BytesUploaded: 100e6}, start.Add(20*time.Second))
+	p = tracker.snapshot()
+	if p.BytesRead != 200e6 || p.BytesTotal != 1000e6 || p.BytesUploaded != 100e6 || p.BytesPerSecond != 5e6 {
+		t.Errorf("unexpected merged progress %+v", p)
+	}
+	if p.ETASeconds == nil || *p.ETASeconds != 80 {
+		t.Errorf("expected an ETA of 80s, got %v", p.ETASeconds)
+	}
+}
+
+func TestTrackUpload(t *testing.T) {
+	ss := &SnapShotter{}
+	report, done := ss.trackUpload("geth", 7)
+	report(types.UploadProgress{BytesUploaded: 42})
+
+	uploads := ss.uploadProgress()
+	if len(uploads) != 1 || uploads[0].TargetSnapshotID != 7 || uploads[0].BytesUploaded != 42 {
+		t.Fatalf("unexpected uploads %+v", uploads)
+	}
+	done()
+	if uploads := ss.uploadProgress(); len(uploads) != 0 {
+		t.Errorf("expected no uploads once done, got %+v", uploads)
+	}
+}
@@ -0,0 +1,292 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// archiveName is the object every successful target snapshot has under its upload prefix
+const archiveName = "snapshot.tar.zst"
+
+// StartReconcileRoutine compares the bucket with the database every check interval until the context is cancelled
+func (s *SnapShotter) StartReconcileRoutine(ctx context.Context) {
+	cfg := s.cfg.Global.Snapshots.Reconcile
+	if !cfg.Enabled {
+		log.Info("Bucket reconciliation is disabled")
+		return
+	}
+
+	checkIntervalHours := cfg.CheckIntervalHours
+	if checkIntervalHours <= 0 {
+		checkIntervalHours = 24
+	}
+
+	log.WithFields(log.Fields{
+		"check_interval_hours": checkIntervalHours,
+		"delete_orphans":       cfg.DeleteOrphans,
+		"mark_missing":         cfg.MarkMissing,
+		"grace":                cfg.Grace(),
+	}).Info("starting bucket reconciliation routine")
+
+	go func() {
+		for {
+			if _, err := s.Reconcile(ctx, types.ReconcileRequest{}); err != nil {
+				log.WithError(err).Error("failed to reconcile bucket")
+			}
+
+			select {
+			case <-time.After(time.Duration(checkIntervalHours) * time.Hour):
+			case <-ctx.Done():
+				log.Info("stopping bucket reconciliation routine")
+				return
+			}
+		}
+	}()
+}
+
+// LastReconcile returns the report of the last reconciliation, or nil if none ran yet
+func (s *SnapShotter) LastReconcile() *types.ReconcileReport {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+	return s.lastReconcile
+}
+
+// Reconcile compares the snapshot prefixes in the bucket with the target snapshots in the database. It reports
+// prefixes no successful target snapshot points at, e.g. left by failed uploads, and successful target snapshots
+// whose archive is gone. Depending on the configuration or request, orphans are deleted and missing target
+// snapshots marked as missing. Only one reconciliation runs at a time.
+func (s *SnapShotter) Reconcile(ctx context.Context, req types.ReconcileRequest) (*types.ReconcileReport, error) {
+	if !s.reconcileRunning.TryLock() {
+		return nil, types.ErrReconcileInProgress
+	}
+	defer s.reconcileRunning.Unlock()
+
+	cfg := s.cfg.Global.Snapshots.Reconcile
+	deleteOrphans, markMissing := cfg.DeleteOrphans, cfg.MarkMissing
+	if req.DeleteOrphans != nil {
+		deleteOrphans = *req.DeleteOrphans
+	}
+	if req.MarkMissing != nil {
+		markMissing = *req.MarkMissing
+	}
+
+	log.Info("reconciling bucket")
+	report, err := s.reconcile(ctx, deleteOrphans, markMissing, cfg.Grace())
+	report.EndTime = time.Now().UTC()
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		s.metrics.SetReconcileResult(len(report.Orphans), len(report.Missing))
+	}
+
+	s.reconcileMu.Lock()
+	s.lastReconcile = report
+	s.reconcileMu.Unlock()
+
+	log.WithFields(log.Fields{
+		"prefixes": report.Prefixes,
+		"orphans":  len(report.Orphans),
+		"missing":  len(report.Missing),
+		"took":     report.EndTime.Sub(report.StartTime),
+	}).Info("reconciled bucket")
+	return report, err
+}
+
+// bucketPrefix accumulates the objects found under a snapshot prefix
+type bucketPrefix struct {
+	objects      int
+	bytes        int64
+	lastModified time.Time
+	keys         []string
+}
+
+// reconcile lists the snapshot prefixes under the upload prefixes of all targets and compares them with the
+// target snapshots. The database is read before the bucket, so snapshots uploaded in between are either
+// running in the database or recent in the bucket, and left alone.
+func (s *SnapShotter) reconcile(ctx context.Context, deleteOrphans, markMissing bool, grace time.Duration) (*types.ReconcileReport, error) {
+	report := &types.ReconcileReport{
+		StartTime: time.Now().UTC(),
+		Orphans:   []types.OrphanPrefix{},
+		Missing:   []types.MissingSnapshot{},
+	}
+
+	targets, err := s.db.GetTargetSnapshotsForReconcile()
+	if err != nil {
+		return report, fmt.Errorf("failed to get target snapshots: %w", err)
+	}
+	byPrefix := make(map[string][]db.TargetSnapshot)
+	var bases []string
+	for _, ts := range targets {
+		byPrefix[ts.UploadPrefix] = append(byPrefix[ts.UploadPrefix], ts)
+		bases = append(bases, path.Dir(ts.UploadPrefix))
+	}
+	for _, t := range s.targets {
+		bases = append(bases, t.cfg.UploadPrefix)
+	}
+	slices.Sort(bases)
+	bases = slices.Compact(bases)
+
+	bucket := s.s3Client.GetBucketName()
+	found := make(map[string]*bucketPrefix)
+	var prefixes []string
+	for _, base := range bases {
+		if base == "" || base == "." {
+			continue
+		}
+		objects, err := s.s3Client.ListObjects(ctx, bucket, base+"/")
+		if err != nil {
+			return report, err
+		}
+		for _, obj := range objects {
+			// Snapshots are stored as <upload_prefix>/<block>/..., the latest files next to them are skipped
+			block, _, ok := strings.Cut(strings.TrimPrefix(obj.Key, base+"/"), "/")
+			if !ok {
+				continue
+			}
+			if _, err := strconv.ParseUint(block, 10, 64); err != nil {
+				continue
+			}
+			prefix := base + "/" + block
+			p := found[prefix]
+			if p == nil {
+				p = &bucketPrefix{}
+				found[prefix] = p
+				prefixes = append(prefixes, prefix)
+			}
+			p.objects++
+			p.bytes += obj.Size
+			p.keys = append(p.keys, obj.Key)
+			if obj.LastModified.After(p.lastModified) {
+				p.lastModified = obj.LastModified
+			}
+			report.Objects++
+			report.Bytes += obj.Size
+		}
+	}
+	report.Prefixes = len(prefixes)
+
+	for _, prefix := range prefixes {
+		orphan, ok := orphanPrefix(prefix, found[prefix], byPrefix[prefix])
+		if !ok {
+			continue
+		}
+		orphan.Recent = report.StartTime.Sub(orphan.LastModified) < grace
+		if deleteOrphans && !orphan.Recent {
+			orphan.Deleted = s.deleteOrphan(ctx, bucket, orphan)
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+
+	marked := false
+	for _, ts := range targets {
+		if ts.Status != "success" || ts.Deleted {
+			continue
+		}
+		archive := ts.UploadPrefix + "/" + archiveName
+		if p := found[ts.UploadPrefix]; p != nil && slices.Contains(p.keys, archive) {
+			// Snapshots uploaded before their storage usage was recorded are measured here
+			if ts.Objects != p.objects || ts.Bytes != p.bytes {
+				if err := s.db.SetTargetSnapshotUsage(ts.ID, p.objects, p.bytes); err != nil {
+					log.WithError(err).WithField("target_snapshot_id", ts.ID).Error("failed to record storage usage")
+				} else {
+					report.Measured++
+				}
+			}
+			continue
+		}
+		missing := types.MissingSnapshot{
+			TargetSnapshotID: ts.ID,
+			Alias:            ts.Alias,
+			UploadPrefix:     ts.UploadPrefix,
+			MissingKeys:      []string{archive},
+		}
+		log.WithFields(log.Fields{
+			"target_snapshot_id": ts.ID,
+			"alias":              ts.Alias,
+			"upload_prefix":      ts.UploadPrefix,
+		}).Warn("snapshot archive not found in the bucket")
+		if markMissing {
+			missing.Marked = s.markMissing(ctx, ts)
+			marked = marked || missing.Marked
+		}
+		report.Missing = append(report.Missing, missing)
+	}
+	if marked {
+		s.publishIndex()
+	}
+	return report, nil
+}
+
+// orphanPrefix reports whether a snapshot prefix found in the bucket is an orphan, given the target snapshots
+// with that upload prefix. Prefixes of successful or running target snapshots aren't.
+func orphanPrefix(prefix string, p *bucketPrefix, targets []db.TargetSnapshot) (types.OrphanPrefix, bool) {
+	orphan := types.OrphanPrefix{
+		Prefix:       prefix,
+		Objects:      p.objects,
+		Bytes:        p.bytes,
+		LastModified: p.lastModified,
+		Reason:       "no target snapshot",
+	}
+	for _, ts := range targets {
+		if !ts.Deleted && (ts.Status == "success" || ts.Status == "running") {
+			return orphan, false
+		}
+		orphan.TargetSnapshotID = ts.ID
+		if ts.Deleted {
+			orphan.Reason = fmt.Sprintf("target snapshot %d was deleted", ts.ID)
+		} else {
+			orphan.Reason = fmt.Sprintf("target snapshot %d is %s", ts.ID, ts.Status)
+		}
+	}
+	return orphan, true
+}
+
+// deleteOrphan deletes the objects of an orphan prefix and reports whether they were deleted
+func (s *SnapShotter) deleteOrphan(ctx context.Context, bucket string, orphan types.OrphanPrefix) bool {
+	logger := log.WithFields(log.Fields{
+		"prefix":  orphan.Prefix,
+		"objects": orphan.Objects,
+		"bytes":   orphan.Bytes,
+		"reason":  orphan.Reason,
+	})
+	if s.cfg.Global.Snapshots.DryRun {
+		logger.Warn("DRY RUN: Would delete orphan prefix")
+		return false
+	}
+	if err := s.s3Client.DeleteDirectory(ctx, bucket, orphan.Prefix); err != nil {
+		logger.WithError(err).Error("failed to delete orphan prefix")
+		return false
+	}
+	logger.Info("deleted orphan prefix")
+	return true
+}
+
+// markMissing marks a target snapshot whose archive is gone as missing, and moves the latest files of its target
+// away from it. It reports whether the target snapshot was marked.
+func (s *SnapShotter) markMissing(ctx context.Context, ts db.TargetSnapshot) bool {
+	logger := log.WithFields(log.Fields{
+		"target_snapshot_id": ts.ID,
+		"alias":              ts.Alias,
+	})
+	if err := s.db.UpdateTargetSnapshotStatus(ts.ID, "missing", archiveName+" not found in the bucket"); err != nil {
+		logger.WithError(err).Error("failed to mark target snapshot as missing")
+		return false
+	}
+	run, err := s.db.GetSnapshotRunByID(ts.SnapshotRunID)
+	if err != nil || run == nil {
+		logger.WithError(err).Error("failed to get run of target snapshot")
+	} else if err := s.revertTargetLatest(ctx, ts, run.BlockHeight); err != nil {
+		logger.WithError(err).Error("failed to revert latest file of target")
+	}
+	logger.Warn("marked target snapshot as missing")
+	return true
+}
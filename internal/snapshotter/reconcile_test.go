@@ -0,0 +1,113 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+func TestReconcile(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	target := func(block uint64, status string) *db.TargetSnapshot {
+		run, err := database.CreateSnapshotRun("geth", block, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := database.CreateTargetSnapshot(run.ID, "geth", fmt.Sprintf("hoodi/geth/%d", block), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.UpdateTargetSnapshotStatus(ts.ID, status, ""); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	target(100, "success")
+	failed := target(200, "failed")
+	gone := target(300, "success")
+	target(400, "running")
+
+	mock := &MockS3Client{
+		bucketName: "test-bucket",
+		uploadedFiles: map[string]string{
+			"hoodi/geth/100/snapshot.tar.zst":        "archive",
+			"hoodi/geth/100/_snapshot_manifest.json": "{}",
+			"hoodi/geth/200/snapshot.tar.zst":        "partial",
+			"hoodi/geth/250/snapshot.tar.zst":        "left behind",
+			"hoodi/geth/400/snapshot.tar.zst":        "uploading",
+			"hoodi/geth/latest":                      "300",
+			"hoodi/geth/latest.json":                 "{}",
+			"hoodi/geth/manual/snapshot.tar.zst":     "not a snapshot",
+			"hoodi/reth/500/snapshot.tar.zst":        "other target",
+		},
+	}
+	ss := &SnapShotter{cfg: &config.Config{}, db: database, s3Client: mock}
+
+	// Reporting only leaves the bucket and the database as they are
+	report, err := ss.Reconcile(context.Background(), types.ReconcileRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Prefixes != 4 || report.Objects != 5 {
+		t.Errorf("expected 4 prefixes with 5 objects, got %d with %d", report.Prefixes, report.Objects)
+	}
+	// The successful snapshot is measured on the way
+	if report.Measured != 1 {
+		t.Errorf("expected 1 snapshot to be measured, got %d", report.Measured)
+	}
+	if len(report.Orphans) != 2 || report.Orphans[0].Prefix != "hoodi/geth/200" || report.Orphans[1].Prefix != "hoodi/geth/250" {
+		t.Fatalf("unexpected orphans %+v", report.Orphans)
+	}
+	if o := report.Orphans[0]; o.TargetSnapshotID != failed.ID || o.Reason != "target snapshot 2 is failed" || o.Deleted || o.Recent {
+		t.Errorf("unexpected orphan %+v", o)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].TargetSnapshotID != gone.ID || report.Missing[0].Marked {
+		t.Fatalf("unexpected missing snapshots %+v", report.Missing)
+	}
+	if ss.LastReconcile() != report {
+		t.Error("expected the report to be kept as the last one")
+	}
+
+	// Orphans are deleted and the missing snapshot marked, moving the latest file back to the one left
+	deleteOrphans, markMissing := true, true
+	report, err = ss.Reconcile(context.Background(), types.ReconcileRequest{DeleteOrphans: &deleteOrphans, MarkMissing: &markMissing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range report.Orphans {
+		if !o.Deleted {
+			t.Errorf("expected orphan %s to be deleted", o.Prefix)
+		}
+	}
+	for _, key := range []string{"hoodi/geth/200/snapshot.tar.zst", "hoodi/geth/250/snapshot.tar.zst"} {
+		if _, ok := mock.uploadedFiles[key]; ok {
+			t.Errorf("expected %s to be deleted", key)
+		}
+	}
+	if _, ok := mock.uploadedFiles["hoodi/geth/400/snapshot.tar.zst"]; !ok {
+		t.Error("expected the running upload to be left alone")
+	}
+	if !report.Missing[0].Marked {
+		t.Error("expected the missing snapshot to be marked")
+	}
+	ts, err := database.GetTargetSnapshotByID(gone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Status != "missing" {
+		t.Errorf("expected status missing, got %s", ts.Status)
+	}
+	if latest := mock.uploadedFiles["hoodi/geth/latest"]; latest != "100" {
+		t.Errorf("expected the latest file to be reverted to 100, got %q", latest)
+	}
+}
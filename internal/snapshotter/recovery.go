@@ -0,0 +1,99 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// RecoverInterruptedRuns marks snapshot runs that were left in the running state by a previous
+// process as interrupted. For the runs that touched the targets (i.e. were not dry runs),
+// it makes sure the containers on the targets of the run's group are running again.
+func (s *SnapShotter) RecoverInterruptedRuns(ctx context.Context) error {
+	runs, err := s.db.GetRunsByStatus("running")
+	if err != nil {
+		return fmt.Errorf("failed to get running snapshot runs: %w", err)
+	}
+
+	if len(runs) == 0 {
+		log.Debug("no interrupted snapshot runs found")
+		return nil
+	}
+
+	// Runs recorded before schedule groups existed, or of groups no longer configured, check all targets
+	groups := make(map[string]*snapshotGroup)
+	for _, g := range s.groups {
+		groups[g.name] = g
+	}
+	toCheck := make(map[string]*target)
+	// The target snapshots of each alias whose containers get restarted, to record the restart on them
+	restarted := make(map[string][]int64)
+	for _, run := range runs {
+		log.WithFields(log.Fields{
+			"group":   run.Group,
+			"run_id":  run.ID,
+			"block":   run.BlockHeight,
+			"dry_run": run.DryRun,
+		}).Warn("found snapshot run left in running state, marking as interrupted")
+
+		errMsg := "snapshotter stopped while the run was in progress"
+		if err := s.db.UpdateRunningTargetSnapshotsStatus(run.ID, "interrupted", errMsg); err != nil {
+			return fmt.Errorf("failed to mark target snapshots of run %d as interrupted: %w", run.ID, err)
+		}
+		if err := s.db.UpdateSnapshotRunStatus(run.ID, "interrupted", errMsg); err != nil {
+			return fmt.Errorf("failed to mark run %d as interrupted: %w", run.ID, err)
+		}
+		s.metrics.ObserveRun("interrupted")
+
+		if run.DryRun {
+			continue
+		}
+		targetSnapshots, err := s.db.GetTargetSnapshotsForRun(run.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get target snapshots of run %d: %w", run.ID, err)
+		}
+		for _, ts := range targetSnapshots {
+			restarted[ts.Alias] = append(restarted[ts.Alias], ts.ID)
+		}
+		targets := s.targets
+		if g, ok := groups[run.Group]; ok {
+			targets = g.targets
+		}
+		for _, t := range targets {
+			toCheck[t.cfg.Alias] = t
+		}
+	}
+
+	if len(toCheck) == 0 {
+		return nil
+	}
+
+	log.Info("verifying containers are running on targets after interrupted run")
+	group, ctx := errgroup.WithContext(ctx)
+	for alias, t := range toCheck {
+		cl := t.driver
+		group.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := cl.EnsureContainersRunning(ctx); err != nil {
+				log.WithError(err).Errorf("could not ensure containers are running on %s", cl.Alias())
+				return err
+			}
+			for _, id := range restarted[alias] {
+				if err := s.db.RecordTargetSnapshotPhase(id, phaseRestarted); err != nil {
+					log.WithError(err).WithField("alias", alias).Error("failed to record target snapshot phase")
+				}
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return fmt.Errorf("failed to restore containers after interrupted run: %w", err)
+	}
+	log.Info("verified containers are running on targets")
+
+	return nil
+}
@@ -0,0 +1,352 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	s3Client "github.com/ethpandaops/eth-snapshotter/internal/clients/s3"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/notify"
+	"github.com/ethpandaops/eth-snapshotter/internal/storage"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replication states of a target snapshot on a destination
+const (
+	replicaPending = "pending"
+	replicaRunning = "running"
+	replicaSuccess = "success"
+	replicaFailed  = "failed"
+)
+
+// destinationNames returns the names of the configured replication destinations
+func (s *SnapShotter) destinationNames() []string {
+	names := make([]string, 0, len(s.destinations))
+	for _, d := range s.destinations {
+		names = append(names, d.Name())
+	}
+	return names
+}
+
+// destination returns the replication destination with the given name, or nil if it is no longer configured
+func (s *SnapShotter) destination(name string) storage.Destination {
+	for _, d := range s.destinations {
+		if d.Name() == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// StartReplicationRoutine copies uploaded snapshots to the replication destinations until the context is
+// cancelled. Copies left pending or cut short by a previous process are picked up on start.
+func (s *SnapShotter) StartReplicationRoutine(ctx context.Context) {
+	if len(s.destinations) == 0 {
+		log.Info("Snapshot replication is disabled")
+		return
+	}
+
+	s.runsMu.Lock()
+	s.replicaCtx = ctx
+	s.runsMu.Unlock()
+
+	if n, err := s.db.ResetRunningReplicas(); err != nil {
+		log.WithError(err).Error("failed to reset interrupted replications")
+	} else if n > 0 {
+		log.WithField("count", n).Warn("replications were interrupted by a previous shutdown, marked as pending again")
+	}
+
+	log.WithField("destinations", s.destinationNames()).Info("starting snapshot replication")
+	pending, err := s.db.GetPendingReplicas()
+	if err != nil {
+		log.WithError(err).Error("failed to get pending replications")
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	s.replications.Add(1)
+	go func() {
+		defer s.replications.Done()
+		for _, r := range pending {
+			if ctx.Err() != nil {
+				return
+			}
+			ts, err := s.db.GetTargetSnapshotByID(r.TargetSnapshotID)
+			if err != nil || ts == nil {
+				log.WithError(err).WithField("target_snapshot_id", r.TargetSnapshotID).Error("failed to get target snapshot to replicate")
+				continue
+			}
+			s.replicateTargetSnapshot(ctx, *ts, r.Destination)
+		}
+	}()
+}
+
+// replicateInBackground records a target snapshot that was just uploaded as pending on every destination
+// and copies it to them in the background, once the replication routine is started
+func (s *SnapShotter) replicateInBackground(id int64) {
+	if len(s.destinations) == 0 {
+		return
+	}
+	if err := s.db.CreateReplicas(id, s.destinationNames()); err != nil {
+		log.WithError(err).WithField("target_snapshot_id", id).Error("failed to record pending replications")
+		return
+	}
+
+	s.runsMu.Lock()
+	ctx := s.replicaCtx
+	s.runsMu.Unlock()
+	if ctx == nil {
+		return
+	}
+
+	s.replications.Add(1)
+	go func() {
+		defer s.replications.Done()
+		ts, err := s.db.GetTargetSnapshotByID(id)
+		if err != nil || ts == nil {
+			log.WithError(err).WithField("target_snapshot_id", id).Error("failed to get target snapshot to replicate")
+			return
+		}
+		for _, name := range s.destinationNames() {
+			if ctx.Err() != nil {
+				return
+			}
+			s.replicateTargetSnapshot(ctx, *ts, name)
+		}
+	}()
+}
+
+// ReplicateTarget copies a successful target snapshot again to the destinations it is pending on or
+// failed to be copied to, including destinations added since it was uploaded. The copies run in the
+// background.
+func (s *SnapShotter) ReplicateTarget(id int64) (*db.TargetSnapshot, error) {
+	if len(s.destinations) == 0 {
+		return nil, types.ErrReplicationDisabled
+	}
+	s.runsMu.Lock()
+	ctx := s.replicaCtx
+	s.runsMu.Unlock()
+	if ctx == nil || ctx.Err() != nil {
+		return nil, types.ErrSnapshotterNotRunning
+	}
+
+	ts, err := s.db.GetTargetSnapshotByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if ts == nil {
+		return nil, fmt.Errorf("target snapshot %d not found", id)
+	}
+	switch {
+	case ts.Status != "success" || ts.DryRun:
+		return nil, fmt.Errorf("%w: only successful uploads can be replicated", types.ErrTargetNotReplicable)
+	case ts.Deleted:
+		return nil, fmt.Errorf("%w: snapshot was deleted", types.ErrTargetNotReplicable)
+	}
+
+	if err := s.db.CreateReplicas(ts.ID, s.destinationNames()); err != nil {
+		return nil, err
+	}
+	replicas, err := s.db.GetTargetSnapshotReplicas(ts.ID)
+	if err != nil {
+		return nil, err
+	}
+	var retry []string
+	for _, r := range replicas {
+		if (r.Status == replicaPending || r.Status == replicaFailed) && s.destination(r.Destination) != nil {
+			retry = append(retry, r.Destination)
+		}
+	}
+	if len(retry) == 0 {
+		return nil, fmt.Errorf("%w: snapshot is replicated to or being copied to every destination", types.ErrTargetNotReplicable)
+	}
+	for _, name := range retry {
+		if err := s.db.SetReplicaStatus(ts.ID, name, replicaPending); err != nil {
+			return nil, err
+		}
+	}
+	if ts.Replicas, err = s.db.GetTargetSnapshotReplicas(ts.ID); err != nil {
+		return nil, err
+	}
+
+	s.replications.Add(1)
+	go func() {
+		defer s.replications.Done()
+		for _, name := range retry {
+			if ctx.Err() != nil {
+				return
+			}
+			s.replicateTargetSnapshot(ctx, *ts, name)
+		}
+	}()
+	return ts, nil
+}
+
+// replicateTargetSnapshot copies the objects of a target snapshot from the bucket to a destination under the
+// same keys and records the outcome. Only one copy runs at a time. If the context is cancelled, the copy
+// stays pending.
+func (s *SnapShotter) replicateTargetSnapshot(ctx context.Context, ts db.TargetSnapshot, name string) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	logger := log.WithFields(log.Fields{
+		"alias":              ts.Alias,
+		"target_snapshot_id": ts.ID,
+		"destination":        name,
+	})
+
+	dest := s.destination(name)
+	if dest == nil {
+		logger.Warn("replication destination is no longer configured, skipping")
+		return
+	}
+	// The copy may have been done or started while this one was waiting for the previous one
+	replicas, err := s.db.GetTargetSnapshotReplicas(ts.ID)
+	if err != nil {
+		logger.WithError(err).Error("failed to get replication state")
+		return
+	}
+	idx := slices.IndexFunc(replicas, func(r db.Replica) bool { return r.Destination == name })
+	if idx < 0 || replicas[idx].Status != replicaPending || replicas[idx].Deleted {
+		return
+	}
+	replica := replicas[idx]
+
+	if err := s.db.SetReplicaStatus(ts.ID, name, replicaRunning); err != nil {
+		logger.WithError(err).Error("failed to record replication state")
+	}
+	logger.Info("replicating snapshot")
+	t1 := time.Now()
+
+	objects, size, keys, err := s.copyObjects(ctx, ts, dest, replica.Keys)
+
+	status, errMsg := replicaSuccess, ""
+	switch {
+	case err != nil && ctx.Err() != nil:
+		status = replicaPending
+		logger.WithError(err).Warn("replication interrupted, snapshot stays pending")
+	case err != nil:
+		status, errMsg = replicaFailed, err.Error()
+		logger.WithError(err).Error("snapshot replication failed")
+	default:
+		logger.WithFields(log.Fields{
+			"objects": objects,
+			"bytes":   size,
+			"took":    time.Since(t1),
+		}).Info("snapshot replicated")
+	}
+	// The keys are recorded whatever the outcome, so the objects written are deleted with the snapshot
+	if errDB := s.db.FinishReplica(ts.ID, name, objects, size, keys, errMsg); errDB != nil {
+		logger.WithError(errDB).Error("failed to record replication state")
+	}
+	if status == replicaPending {
+		if errDB := s.db.SetReplicaStatus(ts.ID, name, replicaPending); errDB != nil {
+			logger.WithError(errDB).Error("failed to record replication state")
+		}
+		return
+	}
+
+	s.metrics.ObserveReplication(name, status)
+	s.events.Publish(events.TypeReplicaFinished, events.ReplicaFinished{
+		TargetSnapshotID: ts.ID,
+		Alias:            ts.Alias,
+		Destination:      name,
+		Status:           status,
+		Objects:          objects,
+		Bytes:            size,
+		Error:            errMsg,
+	})
+	if status == replicaFailed {
+		s.notifications.Notify(notify.Notification{
+			Event:            notify.ReplicationFailed,
+			Title:            fmt.Sprintf("Replication of %s to %s failed", ts.Alias, name),
+			Message:          fmt.Sprintf("Target snapshot %d (%s)", ts.ID, ts.UploadPrefix),
+			Alias:            ts.Alias,
+			TargetSnapshotID: ts.ID,
+			Error:            errMsg,
+		})
+	}
+}
+
+// copyObjects streams every object under the upload prefix of a target snapshot to a destination. It returns the
+// number and size of the copied objects and the keys written to the destination, added to the given ones.
+func (s *SnapShotter) copyObjects(ctx context.Context, ts db.TargetSnapshot, dest storage.Destination, keys []string) (int, int64, []string, error) {
+	bucket := s.s3Client.GetBucketName()
+	listed, err := s.s3Client.ListObjects(ctx, bucket, ts.UploadPrefix+"/")
+	if err != nil {
+		return 0, 0, keys, err
+	}
+	if len(listed) == 0 {
+		return 0, 0, keys, fmt.Errorf("no objects found under %s", ts.UploadPrefix)
+	}
+
+	var size int64
+	for i, obj := range listed {
+		if !slices.Contains(keys, obj.Key) {
+			keys = append(keys, obj.Key)
+		}
+		if err := s.copyObject(ctx, bucket, obj, dest); err != nil {
+			return i, size, keys, err
+		}
+		size += obj.Size
+	}
+	return len(listed), size, keys, nil
+}
+
+// copyObject streams a single object from the bucket to a destination
+func (s *SnapShotter) copyObject(ctx context.Context, bucket string, obj s3Client.Object, dest storage.Destination) error {
+	body, size, err := s.s3Client.OpenObject(ctx, bucket, obj.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := dest.Put(ctx, obj.Key, body, size); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", obj.Key, dest.Name(), err)
+	}
+	return nil
+}
+
+// deleteReplicas deletes the objects written to the replication destinations for a target snapshot. Replicas on
+// destinations that are no longer configured are left as they are.
+func (s *SnapShotter) deleteReplicas(ctx context.Context, target db.TargetSnapshot) error {
+	replicas, err := s.db.GetTargetSnapshotReplicas(target.ID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, r := range replicas {
+		if r.Deleted {
+			continue
+		}
+		dest := s.destination(r.Destination)
+		if dest == nil {
+			log.WithFields(log.Fields{
+				"target_alias": target.Alias,
+				"destination":  r.Destination,
+			}).Warn("replication destination is no longer configured, not deleting its copy")
+			continue
+		}
+		if err := dest.Delete(ctx, r.Keys); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete replica on %s: %w", r.Destination, err))
+			continue
+		}
+		if err := s.db.MarkReplicaAsDeleted(target.ID, r.Destination); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"target_alias": target.Alias,
+			"destination":  r.Destination,
+			"objects":      len(r.Keys),
+		}).Info("deleted replica")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/storage"
+)
+
+// failingDestination accepts the first put and fails every one after it
+type failingDestination struct {
+	puts    []string
+	deleted []string
+}
+
+func (f *failingDestination) Name() string {
+	return "mirror"
+}
+
+func (f *failingDestination) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	f.puts = append(f.puts, key)
+	if len(f.puts) > 1 {
+		return errors.New("503 Service Unavailable")
+	}
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func (f *failingDestination) Delete(ctx context.Context, keys []string) error {
+	f.deleted = append(f.deleted, keys...)
+	return nil
+}
+
+func TestReplicateTargetSnapshot(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	run, err := database.CreateSnapshotRun("geth", 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := database.CreateTargetSnapshot(run.ID, "geth", "geth/100", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateTargetSnapshotStatus(ts.ID, "success", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	local, err := storage.New(config.DestinationConfig{Name: "nfs", Type: config.StorageLocal, Path: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := &failingDestination{}
+	ss := &SnapShotter{
+		cfg: &config.Config{},
+		db:  database,
+		s3Client: &MockS3Client{
+			bucketName: "test-bucket",
+			uploadedFiles: map[string]string{
+				"geth/100/_snapshot_manifest.json": `{"sha256":"abc"}`,
+				"geth/100/snapshot.tar.zst":        "archive",
+				"geth/1000/snapshot.tar.zst":       "other snapshot",
+				"geth/latest":                      "100",
+			},
+		},
+		destinations: []storage.Destination{local, mirror},
+	}
+
+	ctx := context.Background()
+	ss.replicaCtx = ctx
+	ss.replicateInBackground(ts.ID)
+	ss.replications.Wait()
+
+	content, err := os.ReadFile(filepath.Join(root, "geth/100/snapshot.tar.zst"))
+	if err != nil || string(content) != "archive" {
+		t.Fatalf("expected the archive to be copied, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "geth/1000")); !os.IsNotExist(err) {
+		t.Error("expected objects of other snapshots not to be copied")
+	}
+
+	replicas, err := database.GetTargetSnapshotReplicas(ts.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replicas) != 2 {
+		t.Fatalf("expected 2 replicas, got %+v", replicas)
+	}
+	byName := map[string]db.Replica{replicas[0].Destination: replicas[0], replicas[1].Destination: replicas[1]}
+	if r := byName["nfs"]; r.Status != replicaSuccess || r.Objects != 2 || r.Bytes != 23 || len(r.Keys) != 2 {
+		t.Errorf("unexpected local replica %+v", r)
+	}
+	if r := byName["mirror"]; r.Status != replicaFailed || r.ErrorMessage == "" || len(r.Keys) != 2 {
+		t.Errorf("unexpected failed replica %+v", r)
+	}
+
+	// Only the failed destination is retried
+	mirror.puts = nil
+	if _, err := ss.ReplicateTarget(ts.ID); err != nil {
+		t.Fatal(err)
+	}
+	ss.replications.Wait()
+	if len(mirror.puts) != 2 {
+		t.Errorf("expected the failed destination to be retried, got puts %q", mirror.puts)
+	}
+
+	// Deleting the snapshot deletes the objects written to every destination, including failed attempts
+	if err := ss.deleteReplicas(ctx, *ts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "geth/100")); !os.IsNotExist(err) {
+		t.Errorf("expected the local replica to be deleted, got %v", err)
+	}
+	if len(mirror.deleted) != 2 {
+		t.Errorf("expected the keys of the failed replica to be deleted, got %q", mirror.deleted)
+	}
+	replicas, err = database.GetTargetSnapshotReplicas(ts.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range replicas {
+		if !r.Deleted {
+			t.Errorf("expected replica on %s to be marked as deleted", r.Destination)
+		}
+	}
+}
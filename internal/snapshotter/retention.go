@@ -0,0 +1,267 @@
+package snapshotter
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+// defaultKeepCount is the number of snapshots kept without keep_count, or without rules for a target
+const defaultKeepCount = 3
+
+// cleanupCandidate is a successful target snapshot the cleanup may delete, with its run
+type cleanupCandidate struct {
+	run    *db.SnapshotRun
+	target db.TargetSnapshot
+}
+
+// decision returns the cleanup decision for the candidate, deleting it if no reason to keep it is given
+func (c cleanupCandidate) decision(keep []string, deleteReason string) types.CleanupDecision {
+	d := types.CleanupDecision{
+		TargetSnapshotID: c.target.ID,
+		RunID:            c.run.ID,
+		Alias:            c.target.Alias,
+		Group:            c.run.Group,
+		BlockNumber:      c.run.BlockHeight,
+		Time:             c.run.StartTime,
+		UploadPrefix:     c.target.UploadPrefix,
+		Reasons:          keep,
+	}
+	if len(keep) == 0 {
+		d.Delete, d.Reasons = true, []string{deleteReason}
+	}
+	return d
+}
+
+// CleanupPlan returns what the cleanup would keep and delete if it ran now
+func (s *SnapShotter) CleanupPlan() (*types.CleanupPlan, error) {
+	runs, err := s.db.GetSuccessfulRunsForCleanup()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots for cleanup: %w", err)
+	}
+	return planCleanup(s.cfg.Global.Snapshots.Cleanup, runs, time.Now().UTC()), nil
+}
+
+// planCleanup decides which of the successful target snapshots of the given runs are kept. The runs have to be
+// ordered by block height, most recent first.
+func planCleanup(cfg config.CleanupConfig, runs []db.SnapshotRun, now time.Time) *types.CleanupPlan {
+	keepCount := cfg.KeepCount
+	if keepCount <= 0 {
+		keepCount = defaultKeepCount
+	}
+
+	plan := &types.CleanupPlan{
+		Enabled:   cfg.Enabled,
+		Policy:    types.CleanupKeepCount,
+		Time:      now,
+		Snapshots: []types.CleanupDecision{},
+	}
+	if cfg.Retention.Enabled() {
+		plan.Policy = types.CleanupRetention
+		plan.Snapshots = planRetention(cfg.Retention, keepCount, runs, now)
+	} else {
+		plan.Snapshots = planKeepCount(keepCount, runs)
+	}
+	for _, d := range plan.Snapshots {
+		if d.Delete {
+			plan.Delete++
+		} else {
+			plan.Keep++
+		}
+	}
+	return plan
+}
+
+// candidates returns the successful target snapshots of a run that weren't deleted yet
+func candidates(run *db.SnapshotRun) []cleanupCandidate {
+	var c []cleanupCandidate
+	for _, target := range run.TargetsSnapshot {
+		// Skip targets that failed during snapshot creation
+		if target.Status != "success" || target.Deleted {
+			continue
+		}
+		c = append(c, cleanupCandidate{run: run, target: target})
+	}
+	return c
+}
+
+// planKeepCount keeps the most recent 'keepCount' runs of every schedule group and any runs and targets
+// that are marked as persisted. Groups are scheduled independently, so each one keeps its own. Runs recorded
+// before groups existed have no group: each of their snapshots counts against the group its target is in now,
+// taken from its newer runs, or the group named after the target if it has none yet.
+func planKeepCount(keepCount int, runs []db.SnapshotRun) []types.CleanupDecision {
+	groupOf := make(map[string]string)
+	for i := range runs {
+		if runs[i].Group == "" {
+			continue
+		}
+		for _, ts := range runs[i].TargetsSnapshot {
+			if _, ok := groupOf[ts.Alias]; !ok {
+				groupOf[ts.Alias] = runs[i].Group
+			}
+		}
+	}
+
+	var decisions []types.CleanupDecision
+	kept := make(map[string]int)
+	for i := range runs {
+		run := &runs[i]
+		// keep holds the reasons to keep the snapshots of the run, by group, so a run counts once per group
+		keep := make(map[string][]string)
+		reasonsFor := func(group string) []string {
+			if reasons, ok := keep[group]; ok {
+				return reasons
+			}
+			var reasons []string
+			switch {
+			case run.Persisted:
+				reasons = []string{"run is persisted"}
+			case kept[group] < keepCount:
+				kept[group]++
+				reasons = []string{fmt.Sprintf("one of the last %d runs of group %s", keepCount, group)}
+			}
+			keep[group] = reasons
+			return reasons
+		}
+		if run.Group != "" {
+			reasonsFor(run.Group)
+		}
+
+		for _, c := range candidates(run) {
+			group := run.Group
+			if group == "" {
+				group = c.target.Alias
+				if g, ok := groupOf[c.target.Alias]; ok {
+					group = g
+				}
+			}
+			reasons := reasonsFor(group)
+			pinned := run.Persisted
+			if len(reasons) == 0 && c.target.Persisted {
+				reasons, pinned = []string{"persisted"}, true
+			}
+			d := c.decision(reasons, fmt.Sprintf("older than the last %d runs of group %s", keepCount, group))
+			d.Pinned = pinned
+			decisions = append(decisions, d)
+		}
+	}
+	return decisions
+}
+
+// planRetention applies the retention rules to the snapshots of every target, most recent first
+func planRetention(cfg config.RetentionConfig, keepCount int, runs []db.SnapshotRun, now time.Time) []types.CleanupDecision {
+	var aliases []string
+	byAlias := make(map[string][]cleanupCandidate)
+	for i := range runs {
+		for _, c := range candidates(&runs[i]) {
+			if _, ok := byAlias[c.target.Alias]; !ok {
+				aliases = append(aliases, c.target.Alias)
+			}
+			byAlias[c.target.Alias] = append(byAlias[c.target.Alias], c)
+		}
+	}
+
+	var decisions []types.CleanupDecision
+	for _, alias := range aliases {
+		snapshots := byAlias[alias]
+		network := path.Dir(targetLatestPrefix(&snapshots[0].target))
+		if network == "." {
+			network = ""
+		}
+		rules := cfg.RulesFor(alias, network)
+		if rules.IsZero() {
+			rules.KeepLast = keepCount
+		}
+		decisions = append(decisions, applyRetention(rules, cfg.ForkBlocks[network], snapshots, now)...)
+	}
+	return decisions
+}
+
+// applyRetention decides which snapshots of a single target are kept, most recent first. Persisted snapshots,
+// the most recent one and the last ones before a fork block are always kept. Persisted snapshots don't count
+// towards the keep rules.
+func applyRetention(rules config.RetentionRules, forks []uint64, snapshots []cleanupCandidate, now time.Time) []types.CleanupDecision {
+	reasons := make([][]string, len(snapshots))
+	deleteReasons := make([]string, len(snapshots))
+	// ruled is set for the snapshots kept by a keep rule, the others are pinned if they are kept
+	ruled := make([]bool, len(snapshots))
+
+	hasKeepRules := rules.KeepLast > 0 || rules.KeepDaily > 0 || rules.KeepWeekly > 0 || rules.KeepMonthly > 0
+	periods := []struct {
+		keep   int
+		name   string
+		bucket func(time.Time) string
+		last   string
+		kept   int
+	}{
+		{keep: rules.KeepDaily, name: "daily", bucket: func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{keep: rules.KeepWeekly, name: "weekly", bucket: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{keep: rules.KeepMonthly, name: "monthly", bucket: func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	n := 0
+	for i, c := range snapshots {
+		deleteReasons[i] = "not kept by any rule"
+		if c.run.Persisted || c.target.Persisted {
+			reasons[i] = append(reasons[i], "persisted")
+			continue
+		}
+
+		t := c.run.StartTime.UTC()
+		if n < rules.KeepLast {
+			reasons[i] = append(reasons[i], fmt.Sprintf("one of the last %d snapshots", rules.KeepLast))
+			ruled[i] = true
+		}
+		n++
+		for p := range periods {
+			period := &periods[p]
+			bucket := period.bucket(t)
+			if period.kept < period.keep && bucket != period.last {
+				period.kept++
+				period.last = bucket
+				reasons[i] = append(reasons[i], fmt.Sprintf("%s %s", period.name, bucket))
+				ruled[i] = true
+			}
+		}
+
+		if rules.MaxAgeDays > 0 {
+			maxAge := time.Duration(rules.MaxAgeDays) * 24 * time.Hour
+			switch {
+			case now.Sub(t) > maxAge:
+				reasons[i], ruled[i] = nil, false
+				deleteReasons[i] = fmt.Sprintf("older than %d days", rules.MaxAgeDays)
+			case !hasKeepRules:
+				reasons[i] = append(reasons[i], fmt.Sprintf("younger than %d days", rules.MaxAgeDays))
+				ruled[i] = true
+			}
+		}
+	}
+
+	// The snapshots are ordered by block DESC, so the first one below a fork block is the last before it
+	for _, fork := range forks {
+		for i, c := range snapshots {
+			if c.run.BlockHeight < fork {
+				reasons[i] = append(reasons[i], fmt.Sprintf("last snapshot before fork block %d", fork))
+				break
+			}
+		}
+	}
+	if len(snapshots) > 0 && len(reasons[0]) == 0 {
+		reasons[0], ruled[0] = []string{"most recent snapshot"}, true
+	}
+
+	decisions := make([]types.CleanupDecision, 0, len(snapshots))
+	for i, c := range snapshots {
+		d := c.decision(reasons[i], deleteReasons[i])
+		d.Pinned = !d.Delete && !ruled[i]
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
@@ -0,0 +1,224 @@
+package snapshotter
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+// dailyRuns returns one successful run per day for the given aliases, most recent first, the first one a day before now
+func dailyRuns(now time.Time, days int, aliases ...string) []db.SnapshotRun {
+	var runs []db.SnapshotRun
+	id := int64(1)
+	for day := 1; day <= days; day++ {
+		block := uint64(10000 - day*100)
+		run := db.SnapshotRun{
+			ID:          int64(day),
+			Group:       "hoodi",
+			BlockHeight: block,
+			StartTime:   now.Add(-time.Duration(day) * 24 * time.Hour),
+			Status:      "success",
+		}
+		for _, alias := range aliases {
+			run.TargetsSnapshot = append(run.TargetsSnapshot, db.TargetSnapshot{
+				ID:            id,
+				SnapshotRunID: run.ID,
+				Alias:         alias,
+				UploadPrefix:  fmt.Sprintf("hoodi/%s/%d", alias, block),
+				Status:        "success",
+			})
+			id++
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// kept returns the blocks of the snapshots of an alias the plan keeps
+func kept(plan *types.CleanupPlan, alias string) []uint64 {
+	var blocks []uint64
+	for _, d := range plan.Snapshots {
+		if d.Alias == alias && !d.Delete {
+			blocks = append(blocks, d.BlockNumber)
+		}
+	}
+	return blocks
+}
+
+func TestPlanKeepCount(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	runs := dailyRuns(now, 5, "geth", "besu")
+	runs[1].Persisted = true
+	runs[3].TargetsSnapshot[1].Persisted = true
+
+	plan := planCleanup(config.CleanupConfig{KeepCount: 2}, runs, now)
+	if plan.Policy != types.CleanupKeepCount || plan.Keep != 7 || plan.Delete != 3 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if got, want := kept(plan, "geth"), []uint64{9900, 9800, 9700}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected geth to keep %v, got %v", want, got)
+	}
+	if got, want := kept(plan, "besu"), []uint64{9900, 9800, 9700, 9600}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected besu to keep %v, got %v", want, got)
+	}
+	if last := plan.Snapshots[len(plan.Snapshots)-1]; !last.Delete || last.Reasons[0] != "older than the last 2 runs of group hoodi" {
+		t.Errorf("unexpected decision %+v", last)
+	}
+	for _, d := range plan.Snapshots {
+		if pinned := d.BlockNumber == 9800 || (d.BlockNumber == 9600 && d.Alias == "besu"); d.Pinned != pinned {
+			t.Errorf("expected pinned to be %v, got %+v", pinned, d)
+		}
+	}
+}
+
+func TestPlanKeepCountLegacyRuns(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// Three runs of the geth group, then two runs recorded before groups existed holding geth and nethermind
+	runs := dailyRuns(now, 5, "geth", "nethermind")
+	for i := range runs {
+		runs[i].Group = ""
+		if i < 3 {
+			runs[i].Group = "geth"
+			runs[i].TargetsSnapshot = runs[i].TargetsSnapshot[:1]
+		}
+	}
+
+	plan := planCleanup(config.CleanupConfig{KeepCount: 2}, runs, now)
+	if got, want := kept(plan, "geth"), []uint64{9900, 9800}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected geth to keep %v, got %v", want, got)
+	}
+	// nethermind has no newer runs, so its snapshots of the legacy runs are kept
+	if got, want := kept(plan, "nethermind"), []uint64{9600, 9500}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected nethermind to keep %v, got %v", want, got)
+	}
+	for _, d := range plan.Snapshots {
+		if d.Alias == "geth" && d.BlockNumber == 9600 && (!d.Delete || d.Reasons[0] != "older than the last 2 runs of group geth") {
+			t.Errorf("unexpected decision for the legacy snapshot of geth %+v", d)
+		}
+	}
+}
+
+func TestGroupRunsIncludeLegacyRuns(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	legacy, err := database.CreateSnapshotRun("", 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alias := range []string{"geth", "nethermind"} {
+		if _, err := database.CreateTargetSnapshot(legacy.ID, alias, fmt.Sprintf("hoodi/%s/100", alias), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.UpdateSnapshotRunStatus(legacy.ID, "success", ""); err != nil {
+		t.Fatal(err)
+	}
+	grouped, err := database.CreateSnapshotRun("geth", 200, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		group   string
+		aliases []string
+		want    int64
+	}{
+		{"geth", []string{"geth"}, grouped.ID},
+		{"nethermind", []string{"nethermind"}, legacy.ID},
+		{"besu", []string{"besu"}, 0},
+	}
+	for _, tc := range tests {
+		run, err := database.GetMostRecentRunForGroup(tc.group, tc.aliases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got int64
+		if run != nil {
+			got = run.ID
+		}
+		if got != tc.want {
+			t.Errorf("expected the last run of group %s to be %d, got %d", tc.group, tc.want, got)
+		}
+	}
+
+	run, err := database.GetMostRecentSuccessfulRunForGroup("nethermind", []string{"nethermind"})
+	if err != nil || run == nil || run.ID != legacy.ID {
+		t.Errorf("expected the last successful run of group nethermind to be %d, got %+v, %v", legacy.ID, run, err)
+	}
+}
+
+func TestPlanRetention(t *testing.T) {
+	// A friday, so the weeks start on the 12th and the 5th
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	runs := dailyRuns(now, 60, "geth", "besu", "reth")
+	runs[40].TargetsSnapshot[0].Persisted = true
+
+	cfg := config.CleanupConfig{Retention: config.RetentionConfig{
+		RetentionRules: config.RetentionRules{KeepLast: 2, KeepDaily: 3, KeepWeekly: 2, KeepMonthly: 2, MaxAgeDays: 45},
+		Aliases: map[string]config.RetentionRules{
+			"besu": {MaxAgeDays: 5},
+			"reth": {},
+		},
+		ForkBlocks: map[string][]uint64{"hoodi": {5050}},
+	}}
+	plan := planCleanup(cfg, runs, now)
+	if plan.Policy != types.CleanupRetention {
+		t.Fatalf("unexpected policy %s", plan.Policy)
+	}
+
+	// geth: the last 2, 3 daily (days 1-3), 2 weekly (day 1 of this week, day 5 of the last), 2 monthly
+	// (day 1, and day 16, the last of september), the persisted one and the last before the fork
+	if got, want := kept(plan, "geth"), []uint64{9900, 9800, 9700, 9500, 8400, 5900, 5000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected geth to keep %v, got %v", want, got)
+	}
+	// besu: everything up to 5 days old and the last before the fork
+	if got, want := kept(plan, "besu"), []uint64{9900, 9800, 9700, 9600, 9500, 5000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected besu to keep %v, got %v", want, got)
+	}
+	// reth: without rules the default keep count applies
+	if got, want := kept(plan, "reth"), []uint64{9900, 9800, 9700, 5000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected reth to keep %v, got %v", want, got)
+	}
+
+	for _, d := range plan.Snapshots {
+		switch {
+		case d.Alias == "geth" && d.BlockNumber == 5000:
+			if !d.Pinned || !reflect.DeepEqual(d.Reasons, []string{"last snapshot before fork block 5050"}) {
+				t.Errorf("unexpected decision %+v", d)
+			}
+		case d.Alias == "geth" && d.BlockNumber == 5900:
+			if !d.Pinned {
+				t.Errorf("expected the persisted snapshot to be pinned, got %+v", d)
+			}
+		case d.Alias == "geth" && d.BlockNumber == 9900:
+			if d.Pinned {
+				t.Errorf("expected a snapshot kept by the rules not to be pinned, got %+v", d)
+			}
+		case d.Alias == "geth" && d.BlockNumber == 4800:
+			if !d.Delete || !reflect.DeepEqual(d.Reasons, []string{"older than 45 days"}) {
+				t.Errorf("unexpected decision %+v", d)
+			}
+		case d.Alias == "besu" && d.BlockNumber == 9000:
+			if !d.Delete || !reflect.DeepEqual(d.Reasons, []string{"older than 5 days"}) {
+				t.Errorf("unexpected decision %+v", d)
+			}
+		}
+	}
+
+	// The most recent snapshot is kept even if it is older than the max age
+	old := dailyRuns(now, 2, "geth")
+	plan = planCleanup(config.CleanupConfig{Retention: config.RetentionConfig{RetentionRules: config.RetentionRules{MaxAgeDays: 1}}}, old, now.Add(48*time.Hour))
+	if got, want := kept(plan, "geth"), []uint64{9900}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the most recent snapshot to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,135 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// TriggerRun starts a snapshot now, outside of the schedule. Without aliases every group is
+// snapshotted, otherwise only the given targets, which may be a part of a lockstep group.
+// The targets have to be synced. The runs are started in the background and returned once recorded.
+func (s *SnapShotter) TriggerRun(ctx context.Context, req types.TriggerRunRequest) ([]*db.SnapshotRun, error) {
+	s.runsMu.Lock()
+	runCtx := s.runCtx
+	s.runsMu.Unlock()
+	if runCtx == nil || runCtx.Err() != nil {
+		return nil, types.ErrSnapshotterNotRunning
+	}
+
+	selected := make(map[string]bool)
+	for _, alias := range req.Aliases {
+		selected[alias] = true
+	}
+	for alias := range selected {
+		found := false
+		for _, t := range s.targets {
+			if t.cfg.Alias == alias {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", types.ErrUnknownAlias, alias)
+		}
+	}
+
+	dryRun := s.cfg.Global.Snapshots.DryRun
+	if req.DryRun != nil {
+		dryRun = *req.DryRun
+	}
+
+	var views []*snapshotGroup
+	for _, g := range s.groups {
+		var targets []*target
+		for _, t := range g.targets {
+			if len(selected) == 0 || selected[t.cfg.Alias] {
+				targets = append(targets, t)
+			}
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		g.status.Lock()
+		busy := g.busy()
+		g.status.Unlock()
+		if busy != nil {
+			return nil, busy
+		}
+		views = append(views, g.subset(targets, dryRun))
+	}
+
+	// Check all groups before starting any run, so that a trigger either starts everything or nothing
+	blocks := make([]uint64, len(views))
+	for i, v := range views {
+		synced, block := s.VerifyTargetsAreSynced(ctx, v)
+		if !synced {
+			return nil, fmt.Errorf("%w in group %s", types.ErrTargetsNotSynced, v.name)
+		}
+		blocks[i] = block
+	}
+
+	var runs []*db.SnapshotRun
+	for i, v := range views {
+		run, err := s.beginRun(v, blocks[i])
+		if err != nil {
+			// Another run of the group started in the meantime. The runs already begun still go ahead.
+			log.WithError(err).WithField("group", v.name).Warn("could not start triggered snapshot")
+			if len(runs) == 0 {
+				return nil, err
+			}
+			continue
+		}
+		log.WithFields(log.Fields{
+			"group":   v.name,
+			"run_id":  run.ID,
+			"block":   run.BlockHeight,
+			"dry_run": run.DryRun,
+		}).Info("snapshot triggered through the API")
+
+		s.manualRuns.Add(1)
+		go func() {
+			defer s.manualRuns.Done()
+			if err := s.executeRun(runCtx, v, run); err != nil {
+				log.WithError(err).WithField("group", v.name).Error("failed to create triggered snapshot")
+			}
+		}()
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// CancelRun aborts a run that is in progress. The containers on its targets are restored
+// and the run is marked as cancelled.
+func (s *SnapShotter) CancelRun(id int64) error {
+	s.runsMu.Lock()
+	cancel, ok := s.activeRuns[id]
+	s.runsMu.Unlock()
+	if !ok {
+		return types.ErrRunNotActive
+	}
+
+	log.WithField("run_id", id).Warn("cancelling snapshot run")
+	cancel(types.ErrRunCancelled)
+	return nil
+}
+
+func (s *SnapShotter) trackRun(id int64, cancel context.CancelCauseFunc) {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	if s.activeRuns == nil {
+		s.activeRuns = make(map[int64]context.CancelCauseFunc)
+	}
+	s.activeRuns[id] = cancel
+}
+
+func (s *SnapShotter) untrackRun(id int64) {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	delete(s.activeRuns, id)
+}
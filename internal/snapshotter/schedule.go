@@ -0,0 +1,93 @@
+package snapshotter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/robfig/cron/v3"
+)
+
+// schedule decides when snapshots may be taken based on wall-clock time: cron triggers,
+// a minimum spacing between runs and maintenance windows during which no run is started
+type schedule struct {
+	cron        cron.Schedule
+	minInterval time.Duration
+	windows     []maintenanceWindow
+}
+
+type maintenanceWindow struct {
+	spec     string
+	start    cron.Schedule
+	duration time.Duration
+}
+
+// newSchedule parses the schedule configuration
+func newSchedule(cfg config.ScheduleConfig) (*schedule, error) {
+	sc := &schedule{
+		minInterval: time.Duration(cfg.MinIntervalMinutes) * time.Minute,
+	}
+
+	if cfg.Cron != "" {
+		c, err := cron.ParseStandard(cfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule cron %q: %w", cfg.Cron, err)
+		}
+		sc.cron = c
+	}
+
+	for _, w := range cfg.MaintenanceWindows {
+		c, err := cron.ParseStandard(w.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window cron %q: %w", w.Cron, err)
+		}
+		if w.DurationMinutes <= 0 {
+			return nil, fmt.Errorf("maintenance window %q needs a positive duration_minutes", w.Cron)
+		}
+		sc.windows = append(sc.windows, maintenanceWindow{
+			spec:     w.Cron,
+			start:    c,
+			duration: time.Duration(w.DurationMinutes) * time.Minute,
+		})
+	}
+
+	return sc, nil
+}
+
+// nextCron returns the first cron trigger after the given time, or the zero time if no cron is configured
+func (sc *schedule) nextCron(after time.Time) time.Time {
+	if sc.cron == nil {
+		return time.Time{}
+	}
+	return sc.cron.Next(after.UTC())
+}
+
+// cronDue reports whether a cron trigger has passed since the last run (or since since, if there was no run)
+func (sc *schedule) cronDue(since, now time.Time) bool {
+	next := sc.nextCron(since)
+	return !next.IsZero() && !next.After(now)
+}
+
+// maintenanceUntil returns the end of the maintenance window now falls into, or the zero time if there is none
+func (sc *schedule) maintenanceUntil(now time.Time) time.Time {
+	var until time.Time
+	for _, w := range sc.windows {
+		// A window is active if it started within the last duration
+		start := w.start.Next(now.Add(-w.duration).UTC())
+		if start.After(now) {
+			continue
+		}
+		if end := start.Add(w.duration); end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// notBefore returns the earliest time a new run may start given the start of the last run
+func (sc *schedule) notBefore(lastRun time.Time) time.Time {
+	if sc.minInterval <= 0 || lastRun.IsZero() {
+		return time.Time{}
+	}
+	return lastRun.Add(sc.minInterval)
+}
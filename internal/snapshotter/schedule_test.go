@@ -0,0 +1,56 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+func TestSchedule(t *testing.T) {
+	sc, err := newSchedule(config.ScheduleConfig{
+		Cron:               "0 2 * * 1,4",
+		MinIntervalMinutes: 360,
+		MaintenanceWindows: []config.MaintenanceWindowConfig{
+			{Cron: "0 1 * * 1", DurationMinutes: 120},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday 2025-06-01
+	sunday := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	monday0230 := time.Date(2025, 6, 2, 2, 30, 0, 0, time.UTC)
+	monday0330 := time.Date(2025, 6, 2, 3, 30, 0, 0, time.UTC)
+
+	if next := sc.nextCron(sunday); !next.Equal(time.Date(2025, 6, 2, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected next cron %s", next)
+	}
+	if sc.cronDue(sunday, sunday.Add(time.Hour)) {
+		t.Error("cron should not be due on sunday")
+	}
+	if !sc.cronDue(sunday, monday0230) {
+		t.Error("cron should be due on monday 02:30")
+	}
+
+	if until := sc.maintenanceUntil(monday0230); !until.Equal(monday0330.Add(-30 * time.Minute)) {
+		t.Errorf("expected maintenance until 03:00, got %s", until)
+	}
+	if until := sc.maintenanceUntil(monday0330); !until.IsZero() {
+		t.Errorf("expected no maintenance at 03:30, got %s", until)
+	}
+
+	if nb := sc.notBefore(sunday); !nb.Equal(sunday.Add(6 * time.Hour)) {
+		t.Errorf("unexpected not before %s", nb)
+	}
+
+	if _, err := newSchedule(config.ScheduleConfig{Cron: "not a cron"}); err == nil {
+		t.Error("expected error for invalid cron")
+	}
+	if _, err := newSchedule(config.ScheduleConfig{
+		MaintenanceWindows: []config.MaintenanceWindowConfig{{Cron: "0 1 * * *"}},
+	}); err == nil {
+		t.Error("expected error for maintenance window without duration")
+	}
+}
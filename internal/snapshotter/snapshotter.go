@@ -2,7 +2,9 @@ package snapshotter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -11,10 +13,15 @@ import (
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	s3Client "github.com/ethpandaops/eth-snapshotter/internal/clients/s3"
-	sshClient "github.com/ethpandaops/eth-snapshotter/internal/clients/ssh"
 	"github.com/ethpandaops/eth-snapshotter/internal/config"
 	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/metrics"
+	"github.com/ethpandaops/eth-snapshotter/internal/notify"
+	"github.com/ethpandaops/eth-snapshotter/internal/storage"
 	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
@@ -27,20 +34,62 @@ type S3ClientInterface interface {
 	GetRegion() string
 	GetRootPrefix() string
 	PutObject(ctx context.Context, bucket, key string, content []byte) error
+	GetObject(ctx context.Context, bucket, key string) ([]byte, error)
+	PresignGetObject(ctx context.Context, bucket, key string, expires time.Duration) (string, error)
+	DeleteObject(ctx context.Context, bucket, key string) error
 	DeleteDirectory(ctx context.Context, bucket, prefix string) error
+	AbortMultipartUploads(ctx context.Context, bucket, prefix string) (int, error)
+	ListObjects(ctx context.Context, bucket, prefix string) ([]s3Client.Object, error)
+	OpenObject(ctx context.Context, bucket, key string) (io.ReadCloser, int64, error)
 }
 
 type SnapShotter struct {
-	cfg        *config.Config
-	status     *types.SnapshotterStatus
-	sshTargets []*sshTarget
-	db         *db.DB
-	s3Client   S3ClientInterface
-}
-
-type sshTarget struct {
-	client *sshClient.SSHClient
-	cfg    *config.SSHTargetConfig
+	cfg      *config.Config
+	status   *types.SnapshotterStatus
+	targets  []*target
+	groups   []*snapshotGroup
+	schedule *schedule
+	db       *db.DB
+
+	s3Client S3ClientInterface
+	metrics  *metrics.Metrics
+	events   *events.Broker
+
+	notifications *notify.Dispatcher
+
+	// runCtx is the context runs triggered through the API are started with, set once polling starts
+	runCtx     context.Context
+	activeRuns map[int64]context.CancelCauseFunc
+	runsMu     sync.Mutex
+	manualRuns sync.WaitGroup
+
+	// verifier is the host snapshots are restored on to verify them, nil if verification is disabled
+	verifier      verifierHost
+	verifyCron    cron.Schedule
+	verifyCtx     context.Context
+	verifyMu      sync.Mutex
+	verifications sync.WaitGroup
+
+	// destinations are the additional storage destinations uploaded snapshots are copied to
+	destinations []storage.Destination
+	replicaCtx   context.Context
+	replicaMu    sync.Mutex
+	replications sync.WaitGroup
+
+	indexMu sync.Mutex
+
+	// reconcileRunning is held while the bucket is reconciled, reconcileMu guards the last report
+	reconcileRunning sync.Mutex
+	reconcileMu      sync.Mutex
+	lastReconcile    *types.ReconcileReport
+
+	// uploads holds the progress of the running uploads by target snapshot ID
+	uploadsMu sync.Mutex
+	uploads   map[int64]*uploadTracker
+
+	// containers holds the state of the containers found by the watchdog by alias and container name
+	containersMu sync.Mutex
+	containers   map[string]*types.ContainerHealth
 }
 
 func Init(cfg *config.Config) (*SnapShotter, error) {
@@ -73,8 +122,12 @@ func Init(cfg *config.Config) (*SnapShotter, error) {
 		},
 		db:       db,
 		s3Client: s3Client.NewS3Client(&cfg.Global.Snapshots.S3),
+		metrics:  metrics.New(prometheus.DefaultRegisterer),
+		events:   events.NewBroker(),
 	}
 
+	ss.initMetricsFromDB()
+
 	// Initialize S3 client
 	if err := ss.s3Client.Initialize(); err != nil {
 		log.WithError(err).Fatal("Failed to initialize S3 client, snapshot cleanup will use fallback method")
@@ -84,53 +137,134 @@ func Init(cfg *config.Config) (*SnapShotter, error) {
 	cfg.Global.Snapshots.RClone.Env["RCLONE_CONFIG_MYS3_ACCESS_KEY_ID"] = os.Getenv("AWS_ACCESS_KEY_ID")
 	cfg.Global.Snapshots.RClone.Env["RCLONE_CONFIG_MYS3_SECRET_ACCESS_KEY"] = os.Getenv("AWS_SECRET_ACCESS_KEY")
 
-	sshTargets := make([]*sshTarget, len(cfg.Targets.SSH))
+	ss.schedule, err = newSchedule(cfg.Global.Snapshots.Schedule)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, t := range cfg.Targets.SSH {
-		tt := t
-		sshTargets[i] = &sshTarget{
-			client: sshClient.NewSSHClient(
-				cfg.Global.SSH.PrivateKeyPath,
-				cfg.Global.SSH.PrivateKeyPassphrasePath,
-				cfg.Global.SSH.KnownHostsPath,
-				cfg.Global.SSH.InsecureIgnoreHostKey,
-				cfg.Global.SSH.UseAgent,
-				&cfg.Global.Snapshots.RClone,
-				&cfg.Targets.SSH[i],
-			),
-			cfg: &tt,
+	ss.notifications, err = notify.New(cfg.Global.Notifications)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range cfg.Global.Snapshots.Replication.Destinations {
+		dest, err := storage.New(d)
+		if err != nil {
+			return nil, err
 		}
+		ss.destinations = append(ss.destinations, dest)
 	}
 
-	log.Info("starting snapshotter")
+	if cfg.Global.Snapshots.Verification.Enabled {
+		ss.verifyCron, err = parseVerificationCron(cfg.Global.Snapshots.Verification.Cron)
+		if err != nil {
+			return nil, err
+		}
+		ss.verifier = newVerifierHost(cfg)
+	}
 
-	ss.initValidations()
+	ss.targets = buildTargets(cfg)
+	if cfg.Global.Snapshots.Uploader.Type == config.UploaderAgent {
+		ss.useUploadAgent()
+	}
+	ss.groups = buildGroups(cfg, ss.targets)
+	for _, g := range ss.groups {
+		if g.blockInterval == 0 && ss.schedule.cron == nil {
+			return nil, fmt.Errorf("group %s has neither a block_interval nor a schedule cron", g.name)
+		}
+		ss.status.Groups = append(ss.status.Groups, g.status)
+		ss.metrics.SetBlockInterval(g.name, g.blockInterval)
+		log.WithFields(log.Fields{
+			"group":          g.name,
+			"lockstep":       g.lockstep,
+			"targets":        g.status.Targets,
+			"block_interval": g.blockInterval,
+		}).Info("snapshot group")
+	}
 
-	ss.sshTargets = sshTargets
+	log.Info("starting snapshotter")
+
+	ss.initValidations(context.Background())
 
 	return &ss, nil
 }
 
 func (s *SnapShotter) GetStatus() *types.SnapshotterStatus {
+	var connections []types.ConnectionHealth
+	for _, t := range s.targets {
+		if r, ok := t.driver.(connectionHealthReporter); ok {
+			connections = append(connections, r.ConnectionHealth())
+		}
+	}
+
+	// The top level fields summarize the groups: the highest processed block, the closest next
+	// snapshot and whether any group is taking a snapshot
+	var processed, next uint64
+	inProgress := false
+	for _, g := range s.groups {
+		g.status.Lock()
+		processed = max(processed, g.status.ProcessedBlockHeight)
+		if next == 0 || (g.status.NextSnapshotBlockHeight != 0 && g.status.NextSnapshotBlockHeight < next) {
+			next = g.status.NextSnapshotBlockHeight
+		}
+		inProgress = inProgress || g.status.SnapshotInProgress
+		g.status.Unlock()
+	}
+
+	s.status.Lock()
+	s.status.ProcessedBlockHeight = processed
+	s.status.NextSnapshotBlockHeight = next
+	s.status.SnapshotInProgress = inProgress
+	s.status.Connections = connections
+	s.status.Uploads = s.uploadProgress()
+	s.status.Containers = s.containerHealth()
+	s.status.Unlock()
 	return s.status
 }
 
-func (s *SnapShotter) initValidations() {
+// Close closes the connections to the targets and the database
+func (s *SnapShotter) Close() error {
+	// Notifications of the last runs are delivered before shutting down
+	s.notifications.Wait()
+	for _, t := range s.targets {
+		if c, ok := t.driver.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.WithError(err).WithField("alias", t.cfg.Alias).Warn("failed to close target connection")
+			}
+		}
+	}
+	return s.db.Close()
+}
+
+// initMetricsFromDB seeds the last successful snapshot metrics from the database,
+// so that alerting keeps working across restarts
+func (s *SnapShotter) initMetricsFromDB() {
+	snapshots, err := s.db.GetLastSuccessfulSnapshotsByAlias()
+	if err != nil {
+		log.WithError(err).Warn("failed to load last successful snapshots for metrics")
+		return
+	}
+	for _, snapshot := range snapshots {
+		s.metrics.SetLastSuccessfulSnapshot(snapshot.Alias, snapshot.BlockHeight, snapshot.EndTime)
+	}
+}
+
+func (s *SnapShotter) initValidations(ctx context.Context) {
 	var wg sync.WaitGroup
-	for _, t := range s.sshTargets {
+	for _, t := range s.targets {
 		wg.Add(1)
-		cl := t.client
+		cl := t.driver
 		go func() {
 			defer wg.Done()
-			chain, err := cl.GetELChainID()
+			chain, err := cl.GetELChainID(ctx)
 			if err != nil {
-				log.WithError(err).Fatalf("could not get chain Id from %s", cl.TargetConfig.Alias)
+				log.WithError(err).Fatalf("could not get chain Id from %s", cl.Alias())
 			}
 			if chain != s.cfg.Global.ChainID {
-				log.Fatalf("chain id mismatch for host %s . got %s expected %s", cl.TargetConfig.Alias, chain, s.cfg.Global.ChainID)
+				log.Fatalf("chain id mismatch for host %s . got %s expected %s", cl.Alias(), chain, s.cfg.Global.ChainID)
 			}
 			log.WithFields(log.Fields{
-				"node":    cl.TargetConfig.Alias,
+				"node":    cl.Alias(),
 				"chainID": chain,
 			}).Info("got correct chain ID from target")
 		}()
@@ -138,31 +272,33 @@ func (s *SnapShotter) initValidations() {
 	wg.Wait()
 }
 
-func (s *SnapShotter) VerifyTargetsAreSynced() (bool, uint64) {
+// VerifyTargetsAreSynced checks that all targets of a group are synced and on the same EL block
+func (s *SnapShotter) VerifyTargetsAreSynced(ctx context.Context, g *snapshotGroup) (bool, uint64) {
 	var wg sync.WaitGroup
 
-	syncResults := make(chan bool, 3*len(s.sshTargets))
-	blockResults := make(chan uint64, len(s.sshTargets))
-	for _, t := range s.sshTargets {
+	syncResults := make(chan bool, 3*len(g.targets))
+	blockResults := make(chan uint64, len(g.targets))
+	for _, t := range g.targets {
 		wg.Add(3)
-		cl := t.client
+		cl := t.driver
 		tt := t
 		go func() {
 
 			// CL sync status
 			go func() {
 				defer wg.Done()
-				status, err := cl.GetSyncStatusCL()
+				status, err := cl.GetSyncStatusCL(ctx)
 				if err != nil {
 					log.WithFields(log.Fields{
-						"host": cl.TargetConfig.Alias,
+						"host": cl.Alias(),
 						"err":  err,
 					}).Warn("failed getting sync status")
+					s.metrics.SetTargetSynced(tt.cfg.Alias, "cl", false)
 					syncResults <- false
 					return
 				}
 				log.WithFields(log.Fields{
-					"host":          cl.TargetConfig.Alias,
+					"host":          cl.Alias(),
 					"is_syncing":    status.IsSyncing,
 					"is_optimistic": status.IsOptimistic,
 					"sync_distance": status.SyncDistance,
@@ -172,24 +308,27 @@ func (s *SnapShotter) VerifyTargetsAreSynced() (bool, uint64) {
 				if status.IsSyncing {
 					log.WithFields(log.Fields{
 						"alias": tt.cfg.Alias,
-						"host":  cl.TargetConfig.Alias,
+						"host":  cl.Alias(),
 					}).Warn("CL is syncing")
+					s.metrics.SetTargetSynced(tt.cfg.Alias, "cl", false)
 					syncResults <- false
 					return
 				}
 				if status.IsOptimistic {
 					log.WithFields(log.Fields{
 						"alias": tt.cfg.Alias,
-						"host":  cl.TargetConfig.Alias,
+						"host":  cl.Alias(),
 					}).Warn("CL is running in optimistic mode")
+					s.metrics.SetTargetSynced(tt.cfg.Alias, "cl", false)
 					syncResults <- false
 					return
 				}
 				if status.ElOffline {
 					log.WithFields(log.Fields{
 						"alias": tt.cfg.Alias,
-						"host":  cl.TargetConfig.Alias,
+						"host":  cl.Alias(),
 					}).Warn("CL can't connect to the EL")
+					s.metrics.SetTargetSynced(tt.cfg.Alias, "cl", false)
 					syncResults <- false
 					return
 				}
@@ -197,39 +336,49 @@ func (s *SnapShotter) VerifyTargetsAreSynced() (bool, uint64) {
 				if sd > 1 {
 					log.WithFields(log.Fields{
 						"alias":         tt.cfg.Alias,
-						"host":          cl.TargetConfig.Alias,
+						"host":          cl.Alias(),
 						"sync_distance": status.SyncDistance,
 						"head_slot":     status.HeadSlot,
 					}).Warn("CL sync distance is > 1")
+					s.metrics.SetTargetSynced(tt.cfg.Alias, "cl", false)
 					syncResults <- false
 					return
 				}
+				s.metrics.SetTargetSynced(tt.cfg.Alias, "cl", true)
 				syncResults <- true
 			}()
 
 			// EL sync status
 			go func() {
 				defer wg.Done()
-				syncing, err := cl.GetSyncStatusEL()
+				syncing, err := cl.GetSyncStatusEL(ctx)
 				if err != nil {
-					log.Error("failed getting EL sync status")
+					log.WithError(err).WithField("host", cl.Alias()).Error("failed getting EL sync status")
+					s.metrics.SetTargetSynced(tt.cfg.Alias, "el", false)
 					syncResults <- false
 					return
 				}
 				log.WithFields(log.Fields{
 					"alias": tt.cfg.Alias,
-					"host":  cl.TargetConfig.Alias,
+					"host":  cl.Alias(),
 					"sync":  syncing,
 				}).Debug("got EL sync status")
-				syncResults <- true
+				if syncing {
+					log.WithFields(log.Fields{
+						"alias": tt.cfg.Alias,
+						"host":  cl.Alias(),
+					}).Warn("EL is syncing")
+				}
+				s.metrics.SetTargetSynced(tt.cfg.Alias, "el", !syncing)
+				syncResults <- !syncing
 			}()
 
 			// EL block
 			go func() {
 				defer wg.Done()
-				elBlockNumberHex, err := cl.GetELBlockNumber()
+				elBlockNumberHex, err := cl.GetELBlockNumber(ctx)
 				if err != nil {
-					log.Error("failed getting EL block")
+					log.WithError(err).WithField("host", cl.Alias()).Error("failed getting EL block")
 					syncResults <- false
 					blockResults <- 0
 					return
@@ -238,13 +387,14 @@ func (s *SnapShotter) VerifyTargetsAreSynced() (bool, uint64) {
 
 				log.WithFields(log.Fields{
 					"alias":        tt.cfg.Alias,
-					"host":         cl.TargetConfig.Alias,
+					"host":         cl.Alias(),
 					"el_block_hex": elBlockNumberHex,
 					"el_block_dec": elBlockNumberDec,
 				}).Debug("got EL block number")
 				if err != nil {
 					log.Error("failed getting EL block number")
 				}
+				s.metrics.SetTargetBlockHeight(tt.cfg.Alias, elBlockNumberDec)
 				syncResults <- true
 				blockResults <- elBlockNumberDec
 			}()
@@ -267,6 +417,8 @@ func (s *SnapShotter) VerifyTargetsAreSynced() (bool, uint64) {
 		allSynced = false
 	}
 
+	s.metrics.ObserveSyncCheck(g.name, allSynced)
+
 	return allSynced, block
 }
 
@@ -288,191 +440,232 @@ func checkIfAllSameResults(ch chan uint64) (bool, uint64) {
 	return isFirstValueSet, firstValue
 }
 
-// Add function that receives a block number and returns how many blocks are left to the next snapshot
-func (s *SnapShotter) BlocksLeftToNextSnapshot(blockNumber uint64) uint64 {
-	b := uint64(s.cfg.Global.Snapshots.BlockInterval) - (blockNumber % uint64(s.cfg.Global.Snapshots.BlockInterval))
-	if b == uint64(s.cfg.Global.Snapshots.BlockInterval) {
-		return 0
-	}
-	return b
-}
-
-func (s *SnapShotter) StartPeriodicPolling() {
-	ticker := time.NewTicker(time.Duration(s.cfg.Global.Snapshots.CheckIntervalSeconds) * time.Second)
-	quit := make(chan struct{})
+// CreateSnapshot runs a full snapshot cycle across the targets of a group at the group's last
+// processed block. If the context is cancelled mid-run, the current phase is aborted, the containers
+// on the targets are always restored and the run is marked as interrupted.
+func (s *SnapShotter) CreateSnapshot(ctx context.Context, g *snapshotGroup) error {
+	g.status.Lock()
+	block := g.status.ProcessedBlockHeight
+	g.status.Unlock()
 
-	for {
-		select {
-		case <-ticker.C:
-			t1 := time.Now()
-			allSynced, blockNumber := s.VerifyTargetsAreSynced()
-			if allSynced {
-				blocksLeft := s.BlocksLeftToNextSnapshot(blockNumber)
-				log.WithFields(log.Fields{
-					"block_current":  blockNumber,
-					"block_next":     blockNumber + blocksLeft,
-					"blocks_left":    blocksLeft,
-					"block_interval": s.cfg.Global.Snapshots.BlockInterval,
-					"eta":            time.Duration(blocksLeft) * 12 * time.Second,
-					"took":           time.Since(t1),
-				}).Info("all targets are synced")
-				s.status.Lock()
-				s.status.ProcessedBlockHeight = blockNumber
-				s.status.NextSnapshotBlockHeight = blockNumber + blocksLeft
-				s.status.Unlock()
-
-				run, err := s.db.GetMostRecentRun()
-				if err != nil {
-					log.WithError(err).Error("failed to get most recent run")
-				}
-
-				// If the most recent run is far away from the current block number, we need to create a new snapshot
-				lastRunIsTooOld := false
-				if run != nil && blockNumber > run.BlockHeight+uint64(s.cfg.Global.Snapshots.BlockInterval) {
-					lastRunIsTooOld = true
-					log.WithFields(log.Fields{
-						"run_id":            run.ID,
-						"block_current":     blockNumber,
-						"block_last_run":    run.BlockHeight,
-						"should_have_block": run.BlockHeight + uint64(s.cfg.Global.Snapshots.BlockInterval),
-					}).Warn("most recent run is too old")
-				}
-
-				if blocksLeft == 0 || lastRunIsTooOld {
-					log.WithFields(log.Fields{
-						"block":          blockNumber,
-						"block_interval": s.cfg.Global.Snapshots.BlockInterval,
-					}).Info("reached block to be snapshotted")
-					if err := s.CreateSnapshot(); err != nil {
-						log.WithError(err).Error("failed to create snapshot")
-					}
-
-					if s.cfg.Global.Snapshots.RunOnce {
-						log.Info("snapshot.run_once is true. shutting down")
-						os.Exit(0)
-					}
-
-					waitSecs := 60
-					log.Infof("waiting %d seconds for next run", waitSecs)
-					time.Sleep(time.Duration(waitSecs) * time.Second)
-				}
-			}
-
-		case <-quit:
-			ticker.Stop()
-			return
-		}
+	run, err := s.beginRun(g, block)
+	if err != nil {
+		return err
 	}
+	return s.executeRun(ctx, g, run)
 }
 
-func (s *SnapShotter) CreateSnapshot() error {
-	s.status.Lock()
-	if s.status.SnapshotInProgress {
-		s.status.Unlock()
-		return fmt.Errorf("there's already a snapshot in progress")
+// beginRun marks the group as taking a snapshot and records the run. Every successful call has to be
+// followed by executeRun, which releases the group again.
+func (s *SnapShotter) beginRun(g *snapshotGroup, block uint64) (*db.SnapshotRun, error) {
+	g.status.Lock()
+	if err := g.busy(); err != nil {
+		g.status.Unlock()
+		return nil, err
 	}
-	s.status.SnapshotInProgress = true
-	s.status.Unlock()
-	defer func() {
-		s.status.Lock()
-		s.status.SnapshotInProgress = false
-		s.status.Unlock()
-	}()
+	g.status.SnapshotInProgress = true
+	g.status.Unlock()
+	s.metrics.SetSnapshotInProgress(g.name, true)
 
 	// Create snapshot run record
-	run, err := s.db.CreateSnapshotRun(s.status.ProcessedBlockHeight, s.cfg.Global.Snapshots.DryRun)
+	run, err := s.db.CreateSnapshotRun(g.name, block, g.dryRun)
 	if err != nil {
 		log.WithError(err).Error("failed to create snapshot run record")
-		return err
+		s.metrics.ObserveRun("failed")
+		s.releaseGroup(g)
+		return nil, err
 	}
+	return run, nil
+}
+
+func (s *SnapShotter) releaseGroup(g *snapshotGroup) {
+	g.status.Lock()
+	g.status.SnapshotInProgress = false
+	g.status.Unlock()
+	s.metrics.SetSnapshotInProgress(g.name, false)
+}
 
+// executeRun takes the snapshot of a run created by beginRun. The run can be cancelled through CancelRun.
+func (s *SnapShotter) executeRun(ctx context.Context, g *snapshotGroup, run *db.SnapshotRun) (err error) {
+	// Deferred first so it runs once the group is released
+	defer s.checkContainers(ctx, g, triggerRun, run.ID)
+	defer s.releaseGroup(g)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	s.trackRun(run.ID, cancel)
+	defer s.untrackRun(run.ID)
+
+	block := run.BlockHeight
 	log.WithFields(log.Fields{
+		"group":   g.name,
+		"targets": len(g.targets),
 		"run_id":  run.ID,
 		"block":   run.BlockHeight,
 		"dry_run": run.DryRun,
 	}).Info("starting snapshot")
-
-	if err := s.PrepareForSnapshot(); err != nil {
-		if errDB := s.db.UpdateSnapshotRunStatus(run.ID, "failed", err.Error()); errDB != nil {
+	aliases := make([]string, 0, len(g.targets))
+	for _, t := range g.targets {
+		aliases = append(aliases, t.cfg.Alias)
+	}
+	s.events.Publish(events.TypeSnapshotStarted, events.SnapshotStarted{
+		RunID:       run.ID,
+		Group:       g.name,
+		BlockNumber: run.BlockHeight,
+		Targets:     aliases,
+		DryRun:      run.DryRun,
+	})
+
+	// Record the final outcome of the run, whichever way we leave this function
+	defer func() {
+		status, errMsg := "success", ""
+		if err != nil {
+			status, errMsg = "failed", err.Error()
+			if ctx.Err() != nil {
+				status = "interrupted"
+				if errors.Is(context.Cause(ctx), types.ErrRunCancelled) {
+					status, errMsg = "cancelled", types.ErrRunCancelled.Error()
+				}
+			}
+		}
+		if errDB := s.db.UpdateRunningTargetSnapshotsStatus(run.ID, status, errMsg); errDB != nil {
+			log.WithError(errDB).Error("failed to update target snapshot statuses")
+		}
+		if errDB := s.db.UpdateSnapshotRunStatus(run.ID, status, errMsg); errDB != nil {
 			log.WithError(errDB).Error("failed to update snapshot run status")
 		}
+		s.metrics.ObserveRun(status)
+		s.notifyRun(run.ID, g.name, run.BlockHeight, run.DryRun, status, errMsg)
+		s.events.Publish(events.TypeRunFinished, events.RunFinished{
+			RunID:       run.ID,
+			Group:       g.name,
+			BlockNumber: run.BlockHeight,
+			Status:      status,
+			Error:       errMsg,
+		})
+		if !run.DryRun {
+			// Targets of a failed run may still have uploaded their snapshot
+			s.publishIndex()
+		}
+		if status == "success" && !run.DryRun {
+			s.verifyRunInBackground(run.ID)
+		}
+	}()
+
+	phases, err := s.createTargetSnapshots(g, run)
+	if err != nil {
 		return err
 	}
 
-	if err := s.UploadSnapshot(run.ID); err != nil {
-		if errDB := s.db.UpdateSnapshotRunStatus(run.ID, "failed", err.Error()); errDB != nil {
-			log.WithError(errDB).Error("failed to update snapshot run status")
+	// Whatever happens after we start touching the targets, the containers have to be brought back up,
+	// except for targets held after a failed upload so the upload can be retried.
+	// This uses a context that is not cancelled on shutdown so the restore always completes.
+	defer func() {
+		restore := g
+		if held := phases.heldTargets(); len(held) > 0 {
+			var targets []*target
+			for _, t := range g.targets {
+				if !phases.isHeld(t) {
+					targets = append(targets, t)
+				}
+			}
+			log.WithFields(log.Fields{
+				"group":   g.name,
+				"run_id":  run.ID,
+				"targets": held,
+			}).Warn("keeping targets stopped after failed upload, retry or restore them through the API")
+			restore = g.subset(targets, g.dryRun)
 		}
-		log.WithError(err).Error("failed to upload snapshot data")
+		if errPost := s.PostSnapshotStart(context.WithoutCancel(ctx), restore, phases); errPost != nil {
+			log.WithError(errPost).Error("failed to restore service after snapshot")
+			if err == nil {
+				err = errPost
+			}
+		}
+	}()
+
+	if err := s.PrepareForSnapshot(ctx, g, phases); err != nil {
 		return err
 	}
 
-	if err := s.PostSnapshotStart(); err != nil {
-		if errDB := s.db.UpdateSnapshotRunStatus(run.ID, "failed", err.Error()); errDB != nil {
-			log.WithError(errDB).Error("failed to update snapshot run status")
-		}
-		log.WithError(err).Error("failed to restore service after snapshot")
+	if err := s.UploadSnapshot(ctx, g, phases, block); err != nil {
+		log.WithError(err).Error("failed to upload snapshot data")
 		return err
 	}
 
-	if s.cfg.Global.Snapshots.DryRun {
+	if g.dryRun {
 		log.WithFields(log.Fields{
 			"run_id": run.ID,
 		}).Warn("dry run mode enabled - waiting 60s to update run status")
-		time.Sleep(60 * time.Second)
-	}
-	if err := s.db.UpdateSnapshotRunStatus(run.ID, "success", ""); err != nil {
-		log.WithError(err).Error("failed to update snapshot run status")
+		select {
+		case <-time.After(60 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	return err
+	return nil
 }
 
-func (s *SnapShotter) PrepareForSnapshot() error {
-	if s.cfg.Global.Snapshots.DryRun {
+func (s *SnapShotter) PrepareForSnapshot(ctx context.Context, g *snapshotGroup, phases *targetPhases) error {
+	if g.dryRun {
 		log.Warn("dry run mode enabled - skipping snapshot preparation")
 		return nil
 	}
 
-	// Stop snooper
-	log.Info("stopping snooper container across targets")
+	if err := s.runHooksAcross(ctx, g, phases, config.HookPreStop, phaseSnooperStopped); err != nil {
+		return err
+	}
+
+	// Stop the components before the execution client, usually the engine snooper, so the EL stops following the chain
+	stopped := false
+	for _, t := range g.targets {
+		stopped = stopped || len(componentsBeforeExecution(t.cfg)) > 0
+	}
+	log.WithField("group", g.name).Info("stopping components before the execution client across targets")
 	group := errgroup.Group{}
-	for _, t := range s.sshTargets {
-		cl := t.client
+	for _, t := range g.targets {
 		group.Go(func() error {
-			err := cl.StopSnooper()
-			if err != nil {
-				log.WithError(err).Errorf("could not stop snooper  %s", cl.TargetConfig.Alias)
+			if err := stopComponents(ctx, t, componentsBeforeExecution(t.cfg)); err != nil {
+				log.WithError(err).WithField("alias", t.cfg.Alias).Error("could not stop components before the execution client")
+				phases.fail(ctx, t, phaseSnooperStopped, err)
 				return err
 			}
+			phases.complete(t, phaseSnooperStopped)
 			return nil
 		})
 	}
 	if err := group.Wait(); err != nil {
 		return err
 	}
-	log.Info("stopped snooper across targets")
+	log.WithField("group", g.name).Info("stopped components before the execution client across targets")
 
-	log.Info("waiting to start checking if all nodes are still on the same block ")
-	time.Sleep(30 * time.Second)
+	if stopped {
+		log.Info("waiting to start checking if all nodes are still on the same block ")
+		select {
+		case <-time.After(30 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
 	// Check if EL blocks are really all the same
-	blockResults := make(chan uint64, len(s.sshTargets))
+	blockResults := make(chan uint64, len(g.targets))
 	var wg sync.WaitGroup
-	for _, t := range s.sshTargets {
-		cl := t.client
+	for _, t := range g.targets {
+		cl := t.driver
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			elBlockNumberHex, err := cl.GetELBlockNumber()
+			elBlockNumberHex, err := cl.GetELBlockNumber(ctx)
 			if err != nil {
-				log.Error("failed getting EL block")
+				log.WithError(err).WithField("host", cl.Alias()).Error("failed getting EL block")
 				blockResults <- 0
 				return
 			}
 			elBlockNumberDec, _ := hexutil.DecodeUint64(elBlockNumberHex)
 
 			log.WithFields(log.Fields{
-				"host":         cl.TargetConfig.Alias,
+				"host":         cl.Alias(),
 				"el_block_hex": elBlockNumberHex,
 				"el_block_dec": elBlockNumberDec,
 			}).Debug("got EL block number")
@@ -496,23 +689,20 @@ func (s *SnapShotter) PrepareForSnapshot() error {
 
 	log.WithField("block", block).Info("all target ELs are at the same block")
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Dump block info to file
 	log.Info("dumping snapshot metadata to files")
 	group = errgroup.Group{}
-	for _, t := range s.sshTargets {
-		cl := t.client
-		tt := t
+	for _, t := range g.targets {
 		group.Go(func() error {
-			err := cl.DumpExecutionRPCRequestToFile(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["latest",true],"id":1}`, tt.cfg.DataDir+"/_snapshot_eth_getBlockByNumber.json")
-			if err != nil {
-				log.WithError(err).Errorf("could not dump eth_getBlockByNumber to file %s", cl.TargetConfig.Alias)
-				return err
-			}
-			err = cl.DumpExecutionRPCRequestToFile(`{"jsonrpc":"2.0","method":"web3_clientVersion","params":[],"id":1}`, tt.cfg.DataDir+"/_snapshot_web3_clientVersion.json")
-			if err != nil {
-				log.WithError(err).Errorf("could not dump web3_clientVersion to file %s", cl.TargetConfig.Alias)
+			if err := dumpMetadata(ctx, t); err != nil {
+				phases.fail(ctx, t, phaseMetadataDumped, err)
 				return err
 			}
+			phases.complete(t, phaseMetadataDumped)
 			return nil
 		})
 	}
@@ -520,120 +710,87 @@ func (s *SnapShotter) PrepareForSnapshot() error {
 		return err
 	}
 
-	// Stop EL
-	log.Info("stopping EL container across targets")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Stop the execution client and the components after it
+	log.WithField("group", g.name).Info("stopping execution components across targets")
 	group = errgroup.Group{}
-	for _, t := range s.sshTargets {
-		cl := t.client
+	for _, t := range g.targets {
 		group.Go(func() error {
-			err := cl.StopEL()
-			if err != nil {
-				log.WithError(err).Errorf("could not stop EL %s", cl.TargetConfig.Alias)
+			if err := stopComponents(ctx, t, componentsFromExecution(t.cfg)); err != nil {
+				log.WithError(err).WithField("alias", t.cfg.Alias).Error("could not stop execution components")
+				phases.fail(ctx, t, phaseELStopped, err)
 				return err
 			}
+			phases.complete(t, phaseELStopped)
 			return nil
 		})
 	}
 	if err := group.Wait(); err != nil {
 		return err
 	}
-	log.Info("stopped EL across targets")
+	log.WithField("group", g.name).Info("stopped execution components across targets")
 
-	return nil
+	return s.runHooksAcross(ctx, g, phases, config.HookPostStop, phaseUploading)
 }
 
-func (s *SnapShotter) PostSnapshotStart() error {
-	if s.cfg.Global.Snapshots.DryRun {
+func (s *SnapShotter) PostSnapshotStart(ctx context.Context, g *snapshotGroup, phases *targetPhases) error {
+	if g.dryRun {
 		log.Warn("dry run mode enabled - skipping post snapshot sequence")
 		return nil
 	}
 
-	// Start snooper
-	log.Info("starting snooper container across targets")
+	log.WithField("group", g.name).Info("starting components across targets")
 	group := errgroup.Group{}
-	for _, t := range s.sshTargets {
-		cl := t.client
+	for _, t := range g.targets {
 		group.Go(func() error {
-			err := cl.StartSnooper()
-			if err != nil {
-				log.WithError(err).Errorf("could not start snooper  %s", cl.TargetConfig.Alias)
+			if err := startComponents(ctx, t); err != nil {
+				log.WithError(err).WithField("alias", t.cfg.Alias).Error("could not start components")
+				phases.fail(ctx, t, phaseRestarted, err)
 				return err
 			}
-			return nil
-		})
-	}
-	if err := group.Wait(); err != nil {
-		return err
-	}
-	log.Info("started snooper across targets")
-
-	// Start EL
-	log.Info("starting EL container across targets")
-	group = errgroup.Group{}
-	for _, t := range s.sshTargets {
-		cl := t.client
-		group.Go(func() error {
-			err := cl.StartEL()
-			if err != nil {
-				log.WithError(err).Errorf("could not start EL  %s", cl.TargetConfig.Alias)
-				return err
-			}
-			return nil
-		})
-	}
-	if err := group.Wait(); err != nil {
-		return err
-	}
-	log.Info("started EL across targets")
-
-	// Restart beacon
-	log.Info("restarting beacon container across targets")
-	group = errgroup.Group{}
-	for _, t := range s.sshTargets {
-		cl := t.client
-		group.Go(func() error {
-			err := cl.RestartBeacon()
-			if err != nil {
-				log.WithError(err).Errorf("could not restart beacon %s", cl.TargetConfig.Alias)
+			if err := s.runHooks(ctx, t, phases, config.HookPostStart); err != nil {
+				phases.fail(ctx, t, phaseRestarted, err)
 				return err
 			}
+			phases.complete(t, phaseRestarted)
 			return nil
 		})
 	}
 	if err := group.Wait(); err != nil {
 		return err
 	}
-	log.Info("restarted beacon across targets")
+	log.WithField("group", g.name).Info("started components across targets")
 	return nil
 }
 
-func (s *SnapShotter) UploadSnapshot(runID int64) error {
+func (s *SnapShotter) UploadSnapshot(ctx context.Context, g *snapshotGroup, phases *targetPhases, block uint64) error {
 	t1 := time.Now()
 	log.Info("starting uploading data snapshots")
 	group := errgroup.Group{}
 
-	for _, t := range s.sshTargets {
-		cl := t.client
-		tt := t
-
-		// Append the block number to the upload prefix
-		uploadPrefix := fmt.Sprintf("%s/%d", tt.cfg.UploadPrefix, s.status.ProcessedBlockHeight)
-
-		targetSnapshot, err := s.db.CreateTargetSnapshot(runID, tt.cfg.Alias, uploadPrefix, s.cfg.Global.Snapshots.DryRun)
-		if err != nil {
-			log.WithError(err).Error("failed to create target snapshot record")
+	for _, t := range g.targets {
+		id, ok := phases.id(t)
+		if !ok {
 			continue
 		}
 
-		if s.cfg.Global.Snapshots.DryRun {
+		if g.dryRun {
 			log.WithFields(log.Fields{
-				"alias":         tt.cfg.Alias,
-				"upload_prefix": tt.cfg.UploadPrefix,
-				"block":         s.status.ProcessedBlockHeight,
+				"alias":         t.cfg.Alias,
+				"upload_prefix": t.cfg.UploadPrefix,
+				"block":         block,
 			}).Warn("dry run mode enabled - skipping snapshot upload and waiting 60s to mark as success")
 			go func() {
-				time.Sleep(60 * time.Second)
-				if err := s.db.UpdateTargetSnapshotStatus(targetSnapshot.ID, "success", ""); err != nil {
+				status := "success"
+				select {
+				case <-time.After(60 * time.Second):
+				case <-ctx.Done():
+					status = "interrupted"
+				}
+				if err := s.db.UpdateTargetSnapshotStatus(id, status, ""); err != nil {
 					log.WithError(err).Error("failed to update target snapshot status")
 				}
 			}()
@@ -641,25 +798,7 @@ func (s *SnapShotter) UploadSnapshot(runID int64) error {
 		}
 
 		group.Go(func() error {
-			err := cl.RCloneSyncLocalToRemote(tt.cfg.DataDir, tt.cfg.UploadPrefix, s.status.ProcessedBlockHeight)
-			if err != nil {
-				if errDB := s.db.UpdateTargetSnapshotStatus(targetSnapshot.ID, "failed", err.Error()); errDB != nil {
-					log.WithError(errDB).Error("failed to update target snapshot status")
-				}
-				log.WithError(err).Errorf("could not upload via rclone %s", cl.TargetConfig.Alias)
-				return err
-			}
-
-			if err := s.db.UpdateTargetSnapshotStatus(targetSnapshot.ID, "success", ""); err != nil {
-				log.WithError(err).Error("failed to update target snapshot status")
-			}
-			log.WithFields(log.Fields{
-				"alias":       tt.cfg.Alias,
-				"uploaded_to": uploadPrefix,
-				"height":      s.status.ProcessedBlockHeight,
-				"took":        time.Since(t1),
-			}).Info("uploaded data snapshot")
-			return nil
+			return s.uploadTarget(ctx, t, phases, block)
 		})
 	}
 
@@ -671,12 +810,103 @@ func (s *SnapShotter) UploadSnapshot(runID int64) error {
 		"took": time.Since(t1),
 	}).Info("finished uploading all data snapshots")
 
-	// Create or update the "latest" file in S3 with the block number
-	if err := s.updateLatestFile(); err != nil {
-		log.WithError(err).Error("failed to update latest file in S3")
-		// Don't return error here as the snapshots were uploaded successfully
+	// Create or update the "latest" file in S3 with the block number, unless it has to wait for the verification.
+	// Only runs of every target move it, so it never points at a block some clients have no snapshot of.
+	switch {
+	case !s.coversAllTargets(g.aliases()):
+		log.WithField("group", g.name).Debug("group doesn't snapshot every target, root latest file not updated")
+	case s.gatesLatest(g):
+		log.WithField("block", block).Info("latest file is updated once the snapshots are verified")
+	default:
+		if err := s.updateLatestFile(block, g.dryRun); err != nil {
+			log.WithError(err).Error("failed to update latest file in S3")
+			// Don't return error here as the snapshots were uploaded successfully
+		}
+	}
+
+	return nil
+}
+
+// uploadTarget uploads the data directory of a stopped target and records the outcome. If the upload
+// fails and hold_failed_uploads is set, the target is held so it isn't restarted.
+func (s *SnapShotter) uploadTarget(ctx context.Context, t *target, phases *targetPhases, block uint64) error {
+	t1 := time.Now()
+	id, _ := phases.id(t)
+	phases.complete(t, phaseUploading)
+
+	// The hooks around the upload are part of it, if they fail the upload is failed and retried with them
+	var manifest *types.SnapshotManifest
+	err := s.runHooks(ctx, t, phases, config.HookPreUpload)
+	if err == nil {
+		report, done := s.trackUpload(t.cfg.Alias, id)
+		manifest, err = t.driver.UploadSnapshot(ctx, t.cfg.DataDir, t.cfg.UploadPrefix, block, report)
+		done()
+	}
+	if err == nil {
+		err = s.runHooks(ctx, t, phases, config.HookPostUpload)
+	}
+	if err != nil {
+		status := "failed"
+		if ctx.Err() != nil {
+			status = "interrupted"
+		} else if s.cfg.Global.Snapshots.HoldFailedUploads {
+			phases.hold(t)
+		}
+		s.metrics.ObserveUpload(t.cfg.Alias, status, time.Since(t1))
+		if status == "failed" {
+			s.notifyUpload(t.cfg.Alias, id, block, time.Since(t1), err)
+		}
+		phases.fail(ctx, t, phaseUploaded, err)
+		if errDB := s.db.UpdateTargetSnapshotStatus(id, status, err.Error()); errDB != nil {
+			log.WithError(errDB).Error("failed to update target snapshot status")
+		}
+		log.WithError(err).Errorf("could not upload via rclone %s", t.driver.Alias())
+		return err
 	}
 
+	phases.complete(t, phaseUploaded)
+	if s.verifyConfig(t.cfg.Alias) != nil {
+		if err := s.db.SetTargetSnapshotVerification(id, verificationPending, ""); err != nil {
+			log.WithError(err).Error("failed to record verification state")
+		}
+	}
+	if manifest != nil {
+		if err := s.db.SetTargetSnapshotArchive(id, manifest.SHA256, manifest.Size); err != nil {
+			log.WithError(err).Error("failed to record snapshot archive checksum")
+		}
+	} else {
+		log.WithField("alias", t.cfg.Alias).Warn("upload command did not report a snapshot manifest, no checksum recorded")
+	}
+	s.recordStorageUsage(ctx, id, fmt.Sprintf("%s/%d", t.cfg.UploadPrefix, block))
+	s.recordSnapshotDetails(ctx, id)
+	if err := s.db.UpdateTargetSnapshotStatus(id, "success", ""); err != nil {
+		log.WithError(err).Error("failed to update target snapshot status")
+	}
+	uploaded := events.TargetUploaded{
+		TargetSnapshotID: id,
+		Alias:            t.cfg.Alias,
+		BlockNumber:      block,
+		UploadPrefix:     fmt.Sprintf("%s/%d", t.cfg.UploadPrefix, block),
+		DurationSeconds:  time.Since(t1).Seconds(),
+	}
+	if manifest != nil {
+		uploaded.SHA256, uploaded.Size = manifest.SHA256, manifest.Size
+	}
+	s.events.Publish(events.TypeTargetUploaded, uploaded)
+	s.notifyUpload(t.cfg.Alias, id, block, time.Since(t1), nil)
+	s.replicateInBackground(id)
+	// Snapshots that are verified only become the latest of their target once verified
+	if s.verifyConfig(t.cfg.Alias) == nil {
+		s.publishTargetLatest(ctx, id, block)
+	}
+	s.metrics.ObserveUpload(t.cfg.Alias, "success", time.Since(t1))
+	s.metrics.SetLastSuccessfulSnapshot(t.cfg.Alias, block, time.Now())
+	log.WithFields(log.Fields{
+		"alias":       t.cfg.Alias,
+		"uploaded_to": fmt.Sprintf("%s/%d", t.cfg.UploadPrefix, block),
+		"height":      block,
+		"took":        time.Since(t1),
+	}).Info("uploaded data snapshot")
 	return nil
 }
 
@@ -684,14 +914,32 @@ func (s *SnapShotter) GetDB() *db.DB {
 	return s.db
 }
 
-// updateLatestFile creates or updates the "latest" file in S3 with the current block number
-func (s *SnapShotter) updateLatestFile() error {
-	if s.cfg.Global.Snapshots.DryRun {
-		log.WithField("block", s.status.ProcessedBlockHeight).Warn("dry run mode enabled - skipping latest file update")
+// Events returns the broker the events of the snapshotter are published on
+func (s *SnapShotter) Events() *events.Broker {
+	return s.events
+}
+
+// updateLatestFile points the root "latest" file in S3 at the given block number, unless it already points at
+// a newer block
+func (s *SnapShotter) updateLatestFile(block uint64, dryRun bool) error {
+	if dryRun {
+		log.WithField("block", block).Warn("dry run mode enabled - skipping latest file update")
 		return nil
 	}
 
 	ctx := context.Background()
+	if current, ok := s.readLatest(ctx, s.s3Client.GetRootPrefix()+"latest"); ok && current > block {
+		log.WithFields(log.Fields{
+			"block":  block,
+			"latest": current,
+		}).Info("latest file already points at a newer block")
+		return nil
+	}
+	return s.writeLatestFile(ctx, block)
+}
+
+// writeLatestFile creates or updates the root "latest" file in S3 with the given block number
+func (s *SnapShotter) writeLatestFile(ctx context.Context, block uint64) error {
 	bucketName := s.s3Client.GetBucketName()
 	if bucketName == "" {
 		return fmt.Errorf("bucket name not configured in S3 settings")
@@ -702,7 +950,7 @@ func (s *SnapShotter) updateLatestFile() error {
 	key := rootPrefix + "latest"
 
 	// Convert block number to string
-	blockNumberStr := fmt.Sprintf("%d", s.status.ProcessedBlockHeight)
+	blockNumberStr := fmt.Sprintf("%d", block)
 
 	// Upload the latest file
 	err := s.s3Client.PutObject(ctx, bucketName, key, []byte(blockNumberStr))
@@ -713,8 +961,9 @@ func (s *SnapShotter) updateLatestFile() error {
 	log.WithFields(log.Fields{
 		"bucket": bucketName,
 		"key":    key,
-		"block":  s.status.ProcessedBlockHeight,
+		"block":  block,
 	}).Info("updated latest file in S3")
+	s.events.Publish(events.TypeLatestUpdated, events.LatestUpdated{Key: key, BlockNumber: block})
 
 	return nil
 }
@@ -2,10 +2,17 @@ package snapshotter
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	s3Client "github.com/ethpandaops/eth-snapshotter/internal/clients/s3"
 	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
 	"github.com/ethpandaops/eth-snapshotter/internal/types"
 )
 
@@ -14,6 +21,8 @@ type MockS3Client struct {
 	bucketName    string
 	rootPrefix    string
 	uploadedFiles map[string]string
+	// multipartUploads holds the keys of incomplete multipart uploads
+	multipartUploads []string
 }
 
 func (m *MockS3Client) Initialize() error {
@@ -51,11 +60,63 @@ func (m *MockS3Client) PutObject(ctx context.Context, bucket, key string, conten
 	return nil
 }
 
+func (m *MockS3Client) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	content, ok := m.uploadedFiles[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	return []byte(content), nil
+}
+
+func (m *MockS3Client) PresignGetObject(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return "https://test-endpoint/" + bucket + "/" + key, nil
+}
+
+func (m *MockS3Client) DeleteObject(ctx context.Context, bucket, key string) error {
+	delete(m.uploadedFiles, key)
+	return nil
+}
+
 func (m *MockS3Client) DeleteDirectory(ctx context.Context, bucket, prefix string) error {
-	// Mock implementation - not needed for these tests
+	for key := range m.uploadedFiles {
+		if strings.HasPrefix(key, prefix+"/") {
+			delete(m.uploadedFiles, key)
+		}
+	}
 	return nil
 }
 
+func (m *MockS3Client) AbortMultipartUploads(ctx context.Context, bucket, prefix string) (int, error) {
+	var kept []string
+	for _, key := range m.multipartUploads {
+		if !strings.HasPrefix(key, prefix+"/") {
+			kept = append(kept, key)
+		}
+	}
+	aborted := len(m.multipartUploads) - len(kept)
+	m.multipartUploads = kept
+	return aborted, nil
+}
+
+func (m *MockS3Client) ListObjects(ctx context.Context, bucket, prefix string) ([]s3Client.Object, error) {
+	var objects []s3Client.Object
+	for key, content := range m.uploadedFiles {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, s3Client.Object{Key: key, Size: int64(len(content))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *MockS3Client) OpenObject(ctx context.Context, bucket, key string) (io.ReadCloser, int64, error) {
+	content, ok := m.uploadedFiles[key]
+	if !ok {
+		return nil, 0, fmt.Errorf("object %s not found", key)
+	}
+	return io.NopCloser(strings.NewReader(content)), int64(len(content)), nil
+}
+
 func TestUpdateLatestFile(t *testing.T) {
 	// Create a mock S3 client
 	mockS3 := &MockS3Client{
@@ -72,7 +133,7 @@ func TestUpdateLatestFile(t *testing.T) {
 	}
 
 	// Test updateLatestFile
-	err := ss.updateLatestFile()
+	err := ss.updateLatestFile(12345, false)
 	if err != nil {
 		t.Fatalf("updateLatestFile failed: %v", err)
 	}
@@ -102,7 +163,7 @@ func TestUpdateLatestFileWithRootPrefix(t *testing.T) {
 	}
 
 	// Test updateLatestFile
-	err := ss.updateLatestFile()
+	err := ss.updateLatestFile(67890, false)
 	if err != nil {
 		t.Fatalf("updateLatestFile failed: %v", err)
 	}
@@ -140,7 +201,7 @@ func TestUpdateLatestFileDryRun(t *testing.T) {
 	}
 
 	// Test updateLatestFile in dry run mode
-	err := ss.updateLatestFile()
+	err := ss.updateLatestFile(12345, cfg.Global.Snapshots.DryRun)
 	if err != nil {
 		t.Fatalf("updateLatestFile failed in dry run: %v", err)
 	}
@@ -168,7 +229,7 @@ func TestUpdateLatestFileWithRootPrefixAlreadyHasSlash(t *testing.T) {
 	}
 
 	// Test updateLatestFile
-	err := ss.updateLatestFile()
+	err := ss.updateLatestFile(11111, false)
 	if err != nil {
 		t.Fatalf("updateLatestFile failed: %v", err)
 	}
@@ -188,3 +249,141 @@ func TestUpdateLatestFileWithRootPrefixAlreadyHasSlash(t *testing.T) {
 		}
 	}
 }
+
+func TestRecoverInterruptedRuns(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer func() {
+		if err := database.Close(); err != nil {
+			t.Logf("failed to close database: %v", err)
+		}
+	}()
+
+	// A dry run left in running state, plus one that already finished
+	run, err := database.CreateSnapshotRun("geth", 100, true)
+	if err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+	target, err := database.CreateTargetSnapshot(run.ID, "geth", "test/geth/100", true)
+	if err != nil {
+		t.Fatalf("failed to create target snapshot: %v", err)
+	}
+	finished, err := database.CreateSnapshotRun("geth", 90, true)
+	if err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+	if err := database.UpdateSnapshotRunStatus(finished.ID, "success", ""); err != nil {
+		t.Fatalf("failed to update run: %v", err)
+	}
+
+	ss := &SnapShotter{
+		cfg: &config.Config{},
+		db:  database,
+	}
+
+	if err := ss.RecoverInterruptedRuns(context.Background()); err != nil {
+		t.Fatalf("RecoverInterruptedRuns failed: %v", err)
+	}
+
+	got, err := database.GetSnapshotRunByID(run.ID)
+	if err != nil {
+		t.Fatalf("failed to get run: %v", err)
+	}
+	if got.Status != "interrupted" {
+		t.Errorf("expected run status 'interrupted', got '%s'", got.Status)
+	}
+
+	gotTarget, err := database.GetTargetSnapshotByID(target.ID)
+	if err != nil {
+		t.Fatalf("failed to get target snapshot: %v", err)
+	}
+	if gotTarget.Status != "interrupted" {
+		t.Errorf("expected target snapshot status 'interrupted', got '%s'", gotTarget.Status)
+	}
+
+	gotFinished, err := database.GetSnapshotRunByID(finished.ID)
+	if err != nil {
+		t.Fatalf("failed to get run: %v", err)
+	}
+	if gotFinished.Status != "success" {
+		t.Errorf("expected finished run to stay 'success', got '%s'", gotFinished.Status)
+	}
+}
+
+func TestBuildGroups(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Global.Snapshots.BlockInterval = 100
+
+	newTarget := func(alias, group string, blockInterval int) *target {
+		tc := &config.TargetConfig{Alias: alias, Group: group, BlockInterval: blockInterval}
+		return &target{cfg: tc}
+	}
+	targets := []*target{
+		newTarget("geth", "el", 0),
+		newTarget("besu", "", 50),
+		newTarget("nethermind", "el", 0),
+	}
+
+	groups := buildGroups(cfg, targets)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+
+	el := groups[0]
+	if el.name != "el" || !el.lockstep || len(el.targets) != 2 || el.blockInterval != 100 {
+		t.Errorf("unexpected lockstep group %+v", el)
+	}
+
+	besu := groups[1]
+	if besu.name != "besu" || besu.lockstep || len(besu.targets) != 1 || besu.blockInterval != 50 {
+		t.Errorf("unexpected independent group %+v", besu)
+	}
+	if left := besu.BlocksLeftToNextSnapshot(120); left != 30 {
+		t.Errorf("expected 30 blocks left, got %d", left)
+	}
+	if left := besu.BlocksLeftToNextSnapshot(150); left != 0 {
+		t.Errorf("expected 0 blocks left, got %d", left)
+	}
+}
+
+// syncDriver reports a synced CL, the given EL sync state and block 0x10
+type syncDriver struct {
+	TargetDriver
+	alias     string
+	elSyncing bool
+}
+
+func (d *syncDriver) Alias() string {
+	return d.alias
+}
+
+func (d *syncDriver) GetSyncStatusCL(ctx context.Context) (*types.BeaconV1NodeSyncing, error) {
+	return &types.BeaconV1NodeSyncing{SyncDistance: "0"}, nil
+}
+
+func (d *syncDriver) GetSyncStatusEL(ctx context.Context) (bool, error) {
+	return d.elSyncing, nil
+}
+
+func (d *syncDriver) GetELBlockNumber(ctx context.Context) (string, error) {
+	return "0x10", nil
+}
+
+func TestVerifyTargetsAreSynced(t *testing.T) {
+	ss := &SnapShotter{cfg: &config.Config{}}
+	group := func(elSyncing bool) *snapshotGroup {
+		return &snapshotGroup{name: "hoodi", targets: []*target{
+			{driver: &syncDriver{alias: "geth"}, cfg: &config.TargetConfig{Alias: "geth"}},
+			{driver: &syncDriver{alias: "besu", elSyncing: elSyncing}, cfg: &config.TargetConfig{Alias: "besu"}},
+		}}
+	}
+
+	if synced, block := ss.VerifyTargetsAreSynced(context.Background(), group(false)); !synced || block != 16 {
+		t.Errorf("expected targets to be synced at block 16, got %v at %d", synced, block)
+	}
+	if synced, _ := ss.VerifyTargetsAreSynced(context.Background(), group(true)); synced {
+		t.Error("expected a syncing EL to fail the check")
+	}
+}
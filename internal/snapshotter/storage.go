@@ -0,0 +1,287 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultGrowthDays is the number of days the growth of the storage is reported for by default
+	defaultGrowthDays = 30
+	// averageSnapshots is the number of recent measured snapshots the average size of a target is taken over
+	averageSnapshots = 5
+	// intervalSnapshots is the number of recent snapshots the pace of a target is taken over
+	intervalSnapshots = 10
+	// projectionHorizon is how far back snapshots are simulated to project the number the retention rules keep
+	projectionHorizon = 3 * 366 * 24 * time.Hour
+	// maxProjectedSnapshots bounds the simulated snapshots of targets taking snapshots very often
+	maxProjectedSnapshots = 20000
+)
+
+// recordStorageUsage lists the objects stored under the upload prefix of a target snapshot and records their
+// number and total size
+func (s *SnapShotter) recordStorageUsage(ctx context.Context, id int64, prefix string) {
+	logger := log.WithFields(log.Fields{
+		"target_snapshot_id": id,
+		"upload_prefix":      prefix,
+	})
+	objects, err := s.s3Client.ListObjects(ctx, s.s3Client.GetBucketName(), prefix+"/")
+	if err != nil {
+		logger.WithError(err).Warn("failed to list snapshot objects, storage usage not recorded")
+		return
+	}
+	if len(objects) == 0 {
+		return
+	}
+	var size int64
+	for _, obj := range objects {
+		size += obj.Size
+	}
+	if err := s.db.SetTargetSnapshotUsage(id, len(objects), size); err != nil {
+		logger.WithError(err).Error("failed to record storage usage")
+	}
+}
+
+// StorageUsage returns the space the snapshots take, how much was uploaded on each of the last days and the
+// space they are projected to take under the current cleanup policy
+func (s *SnapShotter) StorageUsage(days int) (*types.StorageUsage, error) {
+	if days <= 0 {
+		days = defaultGrowthDays
+	}
+	stored, err := s.db.GetStoredTargetSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target snapshots: %w", err)
+	}
+	runs, err := s.db.GetSuccessfulRunsForCleanup()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshots for cleanup: %w", err)
+	}
+	return storageUsage(s.cfg.Global.Snapshots.Cleanup, stored, runs, days, time.Now().UTC()), nil
+}
+
+// aliasHistory collects what is known about the snapshots of a target, most recent first
+type aliasHistory struct {
+	network string
+	sizes   []int64
+	times   []time.Time
+}
+
+// averageBytes returns the average size of the most recent measured snapshots
+func (h *aliasHistory) averageBytes() int64 {
+	if len(h.sizes) == 0 {
+		return 0
+	}
+	var total int64
+	for _, size := range h.sizes {
+		total += size
+	}
+	return total / int64(len(h.sizes))
+}
+
+// interval returns the average time between the most recent snapshots, or zero if there are less than two
+func (h *aliasHistory) interval() time.Duration {
+	n := min(len(h.times), intervalSnapshots)
+	if n < 2 {
+		return 0
+	}
+	return h.times[0].Sub(h.times[n-1]) / time.Duration(n-1)
+}
+
+// storageUsage aggregates the stored target snapshots, most recent first, and projects their usage with the
+// cleanup plan for the given runs
+func storageUsage(cfg config.CleanupConfig, stored []db.StoredTargetSnapshot, runs []db.SnapshotRun, days int, now time.Time) *types.StorageUsage {
+	usage := &types.StorageUsage{
+		Time:    now,
+		Aliases: []types.AliasStorage{},
+		Runs:    []types.RunStorage{},
+		Growth:  make([]types.StorageGrowth, days),
+	}
+
+	start := now.Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	for i := range usage.Growth {
+		usage.Growth[i].Date = start.AddDate(0, 0, i).Format(time.DateOnly)
+	}
+
+	aliases := make(map[string]*types.AliasStorage)
+	histories := make(map[string]*aliasHistory)
+	runIndex := make(map[int64]int)
+	bytesByID := make(map[int64]int64)
+	var uploaded int64
+	for _, ts := range stored {
+		h := histories[ts.Alias]
+		if h == nil {
+			network := path.Dir(targetLatestPrefix(&ts.TargetSnapshot))
+			if network == "." {
+				network = ""
+			}
+			h = &aliasHistory{network: network}
+			histories[ts.Alias] = h
+			aliases[ts.Alias] = &types.AliasStorage{Alias: ts.Alias}
+		}
+		h.times = append(h.times, ts.RunTime)
+		if ts.Objects > 0 {
+			bytesByID[ts.ID] = ts.Bytes
+			if len(h.sizes) < averageSnapshots {
+				h.sizes = append(h.sizes, ts.Bytes)
+			}
+		}
+
+		uploadedAt := ts.EndTime
+		if uploadedAt.IsZero() {
+			uploadedAt = ts.RunTime
+		}
+		if day := int(uploadedAt.UTC().Sub(start) / (24 * time.Hour)); !uploadedAt.Before(start) && day < days {
+			usage.Growth[day].Snapshots++
+			usage.Growth[day].Bytes += ts.Bytes
+			uploaded += ts.Bytes
+		}
+
+		if ts.Deleted {
+			continue
+		}
+		i, ok := runIndex[ts.SnapshotRunID]
+		if !ok {
+			i = len(usage.Runs)
+			runIndex[ts.SnapshotRunID] = i
+			usage.Runs = append(usage.Runs, types.RunStorage{
+				RunID:       ts.SnapshotRunID,
+				Group:       ts.Group,
+				BlockNumber: ts.BlockHeight,
+				Time:        ts.RunTime,
+			})
+		}
+		a, run := aliases[ts.Alias], &usage.Runs[i]
+		usage.Totals.TargetSnapshots++
+		a.TargetSnapshots++
+		run.TargetSnapshots++
+		if ts.Objects == 0 {
+			usage.Totals.Unmeasured++
+			a.Unmeasured++
+			continue
+		}
+		usage.Totals.Objects += ts.Objects
+		usage.Totals.Bytes += ts.Bytes
+		a.Objects += ts.Objects
+		a.Bytes += ts.Bytes
+		run.Objects += ts.Objects
+		run.Bytes += ts.Bytes
+	}
+	usage.Totals.DailyUploadedBytes = uploaded / int64(days)
+
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		aliases[name].AverageBytes = histories[name].averageBytes()
+		usage.Aliases = append(usage.Aliases, *aliases[name])
+	}
+
+	usage.Projection = projectStorage(cfg, usage.Aliases, histories, bytesByID, runs, now)
+	return usage
+}
+
+// projectStorage estimates the space the snapshots take once the cleanup ran, and once the cleanup policy keeps
+// as many snapshots as it can at the current pace of every target. Snapshots that weren't measured are counted
+// at the average size of their target.
+func projectStorage(cfg config.CleanupConfig, aliases []types.AliasStorage, histories map[string]*aliasHistory, bytesByID map[int64]int64, runs []db.SnapshotRun, now time.Time) types.StorageProjection {
+	plan := planCleanup(cfg, runs, now)
+	projection := types.StorageProjection{
+		CleanupEnabled: cfg.Enabled,
+		Policy:         plan.Policy,
+		Aliases:        []types.AliasProjection{},
+	}
+
+	byAlias := make(map[string][]types.CleanupDecision)
+	for _, d := range plan.Snapshots {
+		byAlias[d.Alias] = append(byAlias[d.Alias], d)
+	}
+	for _, a := range aliases {
+		average := a.AverageBytes
+		sizeOf := func(id int64) int64 {
+			if size, ok := bytesByID[id]; ok {
+				return size
+			}
+			return average
+		}
+
+		p := types.AliasProjection{
+			Alias:             a.Alias,
+			Keep:              a.TargetSnapshots,
+			AfterCleanupBytes: a.Bytes + int64(a.Unmeasured)*average,
+		}
+		if cfg.Enabled {
+			pinned := 0
+			for _, d := range byAlias[a.Alias] {
+				switch {
+				case d.Delete:
+					p.Keep--
+					p.AfterCleanupBytes -= sizeOf(d.TargetSnapshotID)
+				case d.Pinned:
+					pinned++
+				}
+			}
+			p.SteadyStateKeep = steadyStateKeep(cfg, a.Alias, histories[a.Alias], now)
+			if p.SteadyStateKeep == 0 {
+				// Without a pace to project, the snapshots kept now are what is kept
+				p.SteadyStateKeep = p.Keep
+			} else {
+				p.SteadyStateKeep += pinned
+			}
+			p.SteadyStateBytes = int64(p.SteadyStateKeep) * average
+		}
+		projection.AfterCleanupBytes += p.AfterCleanupBytes
+		projection.SteadyStateBytes += p.SteadyStateBytes
+		projection.Aliases = append(projection.Aliases, p)
+	}
+	return projection
+}
+
+// steadyStateKeep returns the number of snapshots of a target the cleanup policy keeps once there are enough of
+// them, if they are taken at the current pace. Retention rules are applied to simulated snapshots for that. It
+// returns zero if the pace isn't known.
+func steadyStateKeep(cfg config.CleanupConfig, alias string, h *aliasHistory, now time.Time) int {
+	keepCount := cfg.KeepCount
+	if keepCount <= 0 {
+		keepCount = defaultKeepCount
+	}
+	if !cfg.Retention.Enabled() {
+		return keepCount
+	}
+	rules := cfg.Retention.RulesFor(alias, h.network)
+	if rules.IsZero() {
+		rules.KeepLast = keepCount
+	}
+
+	interval := h.interval()
+	if interval <= 0 {
+		return 0
+	}
+	n := min(int(projectionHorizon/interval)+1, maxProjectedSnapshots)
+	runs := make([]db.SnapshotRun, n)
+	snapshots := make([]cleanupCandidate, n)
+	for i := range runs {
+		runs[i] = db.SnapshotRun{
+			BlockHeight: uint64(n - i),
+			StartTime:   now.Add(-time.Duration(i) * interval),
+		}
+		snapshots[i] = cleanupCandidate{run: &runs[i]}
+	}
+
+	keep := 0
+	for _, d := range applyRetention(rules, nil, snapshots, now) {
+		if !d.Delete {
+			keep++
+		}
+	}
+	return keep
+}
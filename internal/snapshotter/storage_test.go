@@ -0,0 +1,99 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+)
+
+// storedSnapshots returns the target snapshots of the runs as stored, uploaded an hour after their run started
+func storedSnapshots(runs []db.SnapshotRun, bytes map[string]int64) []db.StoredTargetSnapshot {
+	var stored []db.StoredTargetSnapshot
+	for _, run := range runs {
+		for _, ts := range run.TargetsSnapshot {
+			ts.EndTime = run.StartTime.Add(time.Hour)
+			ts.Objects, ts.Bytes = 2, bytes[ts.Alias]
+			stored = append(stored, db.StoredTargetSnapshot{
+				TargetSnapshot: ts,
+				Group:          run.Group,
+				BlockHeight:    run.BlockHeight,
+				RunTime:        run.StartTime,
+			})
+		}
+	}
+	return stored
+}
+
+func TestStorageUsage(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	runs := dailyRuns(now, 11, "geth", "besu")
+	stored := storedSnapshots(runs, map[string]int64{"geth": 1000, "besu": 2000})
+	// The oldest run was deleted, the two before it weren't measured for besu
+	for i := range stored {
+		switch {
+		case stored[i].SnapshotRunID == 11:
+			stored[i].Deleted = true
+		case stored[i].SnapshotRunID >= 9 && stored[i].Alias == "besu":
+			stored[i].Objects, stored[i].Bytes = 0, 0
+		}
+	}
+	runs = runs[:10]
+
+	usage := storageUsage(config.CleanupConfig{Enabled: true, KeepCount: 3}, stored, runs, 7, now)
+	totals := usage.Totals
+	if totals.TargetSnapshots != 20 || totals.Objects != 36 || totals.Bytes != 26000 || totals.Unmeasured != 2 {
+		t.Errorf("unexpected totals %+v", totals)
+	}
+	if len(usage.Runs) != 10 || usage.Runs[0].RunID != 1 || usage.Runs[0].Bytes != 3000 || usage.Runs[9].Bytes != 1000 {
+		t.Errorf("unexpected runs %+v", usage.Runs)
+	}
+	if len(usage.Aliases) != 2 || usage.Aliases[0].Alias != "besu" || usage.Aliases[0].AverageBytes != 2000 || usage.Aliases[0].Unmeasured != 2 {
+		t.Errorf("unexpected aliases %+v", usage.Aliases)
+	}
+
+	// The days from the 10th to today, the uploads of the last 6 days fall in
+	if len(usage.Growth) != 7 || usage.Growth[0].Date != "2026-10-10" || usage.Growth[6].Date != "2026-10-16" {
+		t.Fatalf("unexpected growth %+v", usage.Growth)
+	}
+	if g := usage.Growth[0]; g.Snapshots != 2 || g.Bytes != 3000 {
+		t.Errorf("unexpected growth on %s: %+v", g.Date, g)
+	}
+	if g := usage.Growth[6]; g.Snapshots != 0 {
+		t.Errorf("expected no uploads today, got %+v", g)
+	}
+	if totals.DailyUploadedBytes != 18000/7 {
+		t.Errorf("expected %d uploaded per day, got %d", 18000/7, totals.DailyUploadedBytes)
+	}
+
+	// keep_count keeps 3 snapshots of each, unmeasured ones count at the average size
+	p := usage.Projection
+	if p.Policy != "keep_count" || p.AfterCleanupBytes != 9000 || p.SteadyStateBytes != 9000 {
+		t.Errorf("unexpected projection %+v", p)
+	}
+	if besu := p.Aliases[0]; besu.Keep != 3 || besu.AfterCleanupBytes != 6000 || besu.SteadyStateKeep != 3 {
+		t.Errorf("unexpected besu projection %+v", besu)
+	}
+
+	// Daily snapshots under the retention rules: besu keeps its last 7 days, geth the 15 up to 14 days old and
+	// a persisted one on top
+	runs[9].TargetsSnapshot[0].Persisted = true
+	cfg := config.CleanupConfig{Enabled: true, Retention: config.RetentionConfig{
+		RetentionRules: config.RetentionRules{KeepDaily: 7},
+		Aliases:        map[string]config.RetentionRules{"geth": {MaxAgeDays: 14}},
+	}}
+	p = storageUsage(cfg, stored, runs, 7, now).Projection
+	if besu := p.Aliases[0]; besu.Keep != 7 || besu.SteadyStateKeep != 7 || besu.SteadyStateBytes != 14000 {
+		t.Errorf("unexpected besu projection %+v", besu)
+	}
+	if geth := p.Aliases[1]; geth.Keep != 10 || geth.SteadyStateKeep != 16 || geth.SteadyStateBytes != 16000 {
+		t.Errorf("unexpected geth projection %+v", geth)
+	}
+
+	// Without the cleanup everything is kept and the usage keeps growing
+	p = storageUsage(config.CleanupConfig{KeepCount: 3}, stored, runs, 7, now).Projection
+	if p.CleanupEnabled || p.AfterCleanupBytes != 30000 || p.SteadyStateBytes != 0 {
+		t.Errorf("unexpected projection without cleanup %+v", p)
+	}
+}
@@ -0,0 +1,130 @@
+package snapshotter
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/agent"
+	dockerClient "github.com/ethpandaops/eth-snapshotter/internal/clients/docker"
+	kubernetesClient "github.com/ethpandaops/eth-snapshotter/internal/clients/kubernetes"
+	sshClient "github.com/ethpandaops/eth-snapshotter/internal/clients/ssh"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// TargetDriver defines the operations the snapshotter needs to take a snapshot of a node,
+// regardless of how the node is run (docker over SSH, local docker, kubernetes, ...)
+type TargetDriver interface {
+	Alias() string
+	GetELChainID(ctx context.Context) (string, error)
+	GetSyncStatusCL(ctx context.Context) (*types.BeaconV1NodeSyncing, error)
+	GetSyncStatusEL(ctx context.Context) (bool, error)
+	GetELBlockNumber(ctx context.Context) (string, error)
+	DumpExecutionRPCRequestToFile(ctx context.Context, payload, filePath string) error
+	// StopComponent, StartComponent and RestartComponent control a component of the node, see config.ComponentConfig
+	StopComponent(ctx context.Context, c config.ComponentConfig) error
+	StartComponent(ctx context.Context, c config.ComponentConfig) error
+	RestartComponent(ctx context.Context, c config.ComponentConfig) error
+	// EnsureContainersRunning starts any of the node's components that are not running
+	EnsureContainersRunning(ctx context.Context) error
+	// UploadSnapshot uploads the data dir of the stopped node to <uploadPrefix>/<blockNumber>. It returns
+	// the manifest of the uploaded archive, or nil if the upload command doesn't produce one. The progress
+	// reported by the upload command is passed to progress while the upload is running.
+	UploadSnapshot(ctx context.Context, srcDir, uploadPrefix string, blockNumber uint64, progress func(types.UploadProgress)) (*types.SnapshotManifest, error)
+}
+
+var (
+	_ TargetDriver = (*sshClient.SSHClient)(nil)
+	_ TargetDriver = (*dockerClient.LocalClient)(nil)
+	_ TargetDriver = (*kubernetesClient.KubectlClient)(nil)
+
+	_ commandRunner       = (*sshClient.SSHClient)(nil)
+	_ containerController = (*sshClient.SSHClient)(nil)
+	_ containerController = (*dockerClient.LocalClient)(nil)
+)
+
+// connectionHealthReporter is implemented by drivers that keep a persistent connection to their target
+type connectionHealthReporter interface {
+	ConnectionHealth() types.ConnectionHealth
+}
+
+// commandRunner is implemented by drivers that run shell commands on a remote target. Hooks of targets
+// whose driver doesn't are run on the snapshotter host.
+type commandRunner interface {
+	RunCommandContext(ctx context.Context, cmd string) (string, error)
+}
+
+// containerController is implemented by drivers that run the node in docker containers the watchdog can
+// inspect and bring back up
+type containerController interface {
+	InspectContainers(ctx context.Context) ([]types.ContainerState, error)
+	StartContainer(ctx context.Context, name string) error
+	RestartContainer(ctx context.Context, name string) error
+}
+
+type target struct {
+	driver TargetDriver
+	cfg    *config.TargetConfig
+}
+
+// buildTargets creates a driver for every target configured, in the order ssh, local, kubernetes
+func buildTargets(cfg *config.Config) []*target {
+	targets := make([]*target, 0, len(cfg.Targets.SSH)+len(cfg.Targets.Local)+len(cfg.Targets.Kubernetes))
+
+	for i := range cfg.Targets.SSH {
+		t := &cfg.Targets.SSH[i]
+		targets = append(targets, &target{
+			driver: sshClient.NewSSHClient(
+				cfg.Global.SSH.PrivateKeyPath,
+				cfg.Global.SSH.PrivateKeyPassphrasePath,
+				cfg.Global.SSH.KnownHostsPath,
+				cfg.Global.SSH.InsecureIgnoreHostKey,
+				cfg.Global.SSH.UseAgent,
+				time.Duration(cfg.Global.SSH.KeepaliveIntervalSeconds)*time.Second,
+				time.Duration(cfg.Global.SSH.MaxReconnectBackoffSeconds)*time.Second,
+				&cfg.Global.Snapshots.RClone,
+				t,
+			),
+			cfg: &t.TargetConfig,
+		})
+	}
+
+	for i := range cfg.Targets.Local {
+		t := &cfg.Targets.Local[i]
+		targets = append(targets, &target{
+			driver: dockerClient.NewLocalClient(&cfg.Global.Snapshots.RClone, t),
+			cfg:    &t.TargetConfig,
+		})
+	}
+
+	for i := range cfg.Targets.Kubernetes {
+		t := &cfg.Targets.Kubernetes[i]
+		targets = append(targets, &target{
+			driver: kubernetesClient.NewKubectlClient(&cfg.Global.Snapshots.RClone, t),
+			cfg:    &t.TargetConfig,
+		})
+	}
+
+	return targets
+}
+
+// useUploadAgent makes the SSH targets upload through the upload agent, with the S3 settings and credentials
+// of the snapshotter. The other drivers keep using rclone.
+func (s *SnapShotter) useUploadAgent() {
+	dest := agent.Destination{
+		Endpoint:        s.s3Client.GetEndpoint(),
+		Region:          s.s3Client.GetRegion(),
+		Bucket:          s.s3Client.GetBucketName(),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	for _, t := range s.targets {
+		if c, ok := t.driver.(*sshClient.SSHClient); ok {
+			c.UseAgent(s.cfg.Global.Snapshots.Uploader.Agent, dest)
+			continue
+		}
+		log.WithField("alias", t.cfg.Alias).Info("upload agent is only supported for ssh targets, uploading with rclone")
+	}
+}
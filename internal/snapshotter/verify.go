@@ -0,0 +1,464 @@
+package snapshotter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	sshClient "github.com/ethpandaops/eth-snapshotter/internal/clients/ssh"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Verification states of a target snapshot
+const (
+	verificationPending  = "pending"
+	verificationRunning  = "running"
+	verificationVerified = "verified"
+	verificationFailed   = "failed"
+)
+
+const (
+	defaultVerifyWorkDir = "/tmp/snapshot-verification"
+	defaultVerifyDataDir = "/data"
+	defaultVerifyRPCPort = 8545
+	verifyPollInterval   = 15 * time.Second
+)
+
+// verifierHost runs the commands restoring and booting snapshots on the verifier host
+type verifierHost interface {
+	RunCommandContext(ctx context.Context, cmd string) (string, error)
+}
+
+// newVerifierHost connects to the verifier host with the global SSH settings
+func newVerifierHost(cfg *config.Config) verifierHost {
+	v := cfg.Global.Snapshots.Verification
+	port := v.Port
+	if port == 0 {
+		port = 22
+	}
+	return sshClient.NewSSHClient(
+		cfg.Global.SSH.PrivateKeyPath,
+		cfg.Global.SSH.PrivateKeyPassphrasePath,
+		cfg.Global.SSH.KnownHostsPath,
+		cfg.Global.SSH.InsecureIgnoreHostKey,
+		cfg.Global.SSH.UseAgent,
+		time.Duration(cfg.Global.SSH.KeepaliveIntervalSeconds)*time.Second,
+		time.Duration(cfg.Global.SSH.MaxReconnectBackoffSeconds)*time.Second,
+		&cfg.Global.Snapshots.RClone,
+		&config.SSHTargetConfig{
+			TargetConfig: config.TargetConfig{Alias: "verifier"},
+			Host:         v.Host,
+			User:         v.User,
+			Port:         port,
+		},
+	)
+}
+
+// snapshotBlock is the block a snapshot was taken at, as recorded in _snapshot_eth_getBlockByNumber.json
+type snapshotBlock struct {
+	Number string `json:"number"`
+	Hash   string `json:"hash"`
+}
+
+// parseBlockResponse extracts the block from an eth_getBlockByNumber response. It returns nil if the
+// node doesn't know the block (yet).
+func parseBlockResponse(body []byte) (*snapshotBlock, error) {
+	var resp struct {
+		Result *snapshotBlock `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid eth_getBlockByNumber response: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("eth_getBlockByNumber failed: %s", resp.Error.Message)
+	}
+	if resp.Result != nil && (resp.Result.Number == "" || resp.Result.Hash == "") {
+		return nil, fmt.Errorf("eth_getBlockByNumber response without block number or hash")
+	}
+	return resp.Result, nil
+}
+
+// shellQuote quotes s as a single word for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// verifyConfig returns the verification settings of the target with the given alias, or nil if it isn't verified
+func (s *SnapShotter) verifyConfig(alias string) *config.VerifyConfig {
+	if s.verifier == nil {
+		return nil
+	}
+	for _, t := range s.targets {
+		if t.cfg.Alias == alias && t.cfg.Verify.Image != "" {
+			return &t.cfg.Verify
+		}
+	}
+	return nil
+}
+
+// gatesLatest reports whether the latest pointer has to wait for the verification of some target of the group
+func (s *SnapShotter) gatesLatest(g *snapshotGroup) bool {
+	if !s.cfg.Global.Snapshots.Verification.GateLatest {
+		return false
+	}
+	for _, t := range g.targets {
+		if s.verifyConfig(t.cfg.Alias) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// StartVerificationRoutine verifies pending snapshots until the context is cancelled: on the configured
+// cron schedule, or otherwise right after every successful run. Verifications left pending by a
+// previous process are picked up on start.
+func (s *SnapShotter) StartVerificationRoutine(ctx context.Context) {
+	if s.verifier == nil {
+		log.Info("Snapshot verification is disabled")
+		return
+	}
+
+	s.runsMu.Lock()
+	s.verifyCtx = ctx
+	s.runsMu.Unlock()
+
+	if n, err := s.db.ResetRunningVerifications(); err != nil {
+		log.WithError(err).Error("failed to reset interrupted verifications")
+	} else if n > 0 {
+		log.WithField("count", n).Warn("verifications were interrupted by a previous shutdown, marked as pending again")
+	}
+
+	if s.verifyCron == nil {
+		log.Info("starting snapshot verification after every run")
+		s.verifyPending(ctx)
+		return
+	}
+
+	log.WithField("cron", s.cfg.Global.Snapshots.Verification.Cron).Info("starting scheduled snapshot verification")
+	go func() {
+		for {
+			next := s.verifyCron.Next(time.Now().UTC())
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+				log.Info("stopping snapshot verification routine")
+				return
+			}
+			s.verifyPending(ctx)
+		}
+	}()
+}
+
+// verifyPending verifies the most recent pending snapshot of every target in the background
+func (s *SnapShotter) verifyPending(ctx context.Context) {
+	pending, err := s.db.GetPendingVerifications()
+	if err != nil {
+		log.WithError(err).Error("failed to get pending verifications")
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	s.verifications.Add(1)
+	go func() {
+		defer s.verifications.Done()
+		for _, ts := range pending {
+			if ctx.Err() != nil {
+				return
+			}
+			s.verifyTargetSnapshot(ctx, ts)
+			s.advanceLatest(ts.SnapshotRunID)
+		}
+	}()
+}
+
+// verifyRunInBackground verifies the pending target snapshots of a run that just succeeded,
+// unless verification is scheduled
+func (s *SnapShotter) verifyRunInBackground(runID int64) {
+	s.runsMu.Lock()
+	ctx := s.verifyCtx
+	s.runsMu.Unlock()
+	if s.verifier == nil || s.verifyCron != nil || ctx == nil {
+		return
+	}
+
+	s.verifications.Add(1)
+	go func() {
+		defer s.verifications.Done()
+		targets, err := s.db.GetTargetSnapshotsForRun(runID)
+		if err != nil {
+			log.WithError(err).WithField("run_id", runID).Error("failed to get target snapshots to verify")
+			return
+		}
+		for _, ts := range targets {
+			if ts.Verification != verificationPending || ctx.Err() != nil {
+				continue
+			}
+			s.verifyTargetSnapshot(ctx, ts)
+		}
+		s.advanceLatest(runID)
+	}()
+}
+
+// VerifyTarget verifies a successful target snapshot now, regardless of its verification state.
+// The verification runs in the background once the verifier host is free.
+func (s *SnapShotter) VerifyTarget(id int64) (*db.TargetSnapshot, error) {
+	if s.verifier == nil {
+		return nil, types.ErrVerificationDisabled
+	}
+	s.runsMu.Lock()
+	ctx := s.verifyCtx
+	s.runsMu.Unlock()
+	if ctx == nil || ctx.Err() != nil {
+		return nil, types.ErrSnapshotterNotRunning
+	}
+
+	ts, err := s.db.GetTargetSnapshotByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if ts == nil {
+		return nil, fmt.Errorf("target snapshot %d not found", id)
+	}
+	switch {
+	case ts.Status != "success" || ts.DryRun:
+		return nil, fmt.Errorf("%w: only successful uploads can be verified", types.ErrTargetNotVerifiable)
+	case ts.Deleted:
+		return nil, fmt.Errorf("%w: snapshot was deleted", types.ErrTargetNotVerifiable)
+	case ts.Verification == verificationRunning:
+		return nil, fmt.Errorf("%w: snapshot is being verified", types.ErrTargetNotVerifiable)
+	}
+	if s.verifyConfig(ts.Alias) == nil {
+		return nil, fmt.Errorf("%w: target %s has no verify image configured", types.ErrTargetNotVerifiable, ts.Alias)
+	}
+
+	if err := s.db.SetTargetSnapshotVerification(ts.ID, verificationPending, ""); err != nil {
+		return nil, err
+	}
+	ts.Verification, ts.VerificationError = verificationPending, ""
+
+	s.verifications.Add(1)
+	go func() {
+		defer s.verifications.Done()
+		s.verifyTargetSnapshot(ctx, *ts)
+		s.advanceLatest(ts.SnapshotRunID)
+	}()
+	return ts, nil
+}
+
+// verifyTargetSnapshot restores and boots a target snapshot on the verifier host and records the outcome.
+// Only one snapshot is verified at a time. If the context is cancelled, the snapshot stays pending.
+func (s *SnapShotter) verifyTargetSnapshot(ctx context.Context, ts db.TargetSnapshot) {
+	s.verifyMu.Lock()
+	defer s.verifyMu.Unlock()
+
+	logger := log.WithFields(log.Fields{
+		"alias":              ts.Alias,
+		"target_snapshot_id": ts.ID,
+		"upload_prefix":      ts.UploadPrefix,
+	})
+
+	vc := s.verifyConfig(ts.Alias)
+	if vc == nil {
+		logger.Warn("target is no longer configured for verification, skipping")
+		return
+	}
+
+	if err := s.db.SetTargetSnapshotVerification(ts.ID, verificationRunning, ""); err != nil {
+		logger.WithError(err).Error("failed to record verification state")
+	}
+	logger.Info("verifying snapshot")
+	t1 := time.Now()
+
+	verifyCtx, cancel := context.WithTimeout(ctx, s.cfg.Global.Snapshots.Verification.Timeout())
+	defer cancel()
+	err := s.restoreAndBoot(verifyCtx, vc, ts)
+
+	state, errMsg := verificationVerified, ""
+	switch {
+	case err != nil && ctx.Err() != nil:
+		state = verificationPending
+		logger.WithError(err).Warn("verification interrupted, snapshot stays pending")
+	case err != nil:
+		state, errMsg = verificationFailed, err.Error()
+		logger.WithError(err).Error("snapshot verification failed")
+	default:
+		logger.WithField("took", time.Since(t1)).Info("snapshot verified")
+	}
+	if errDB := s.db.SetTargetSnapshotVerification(ts.ID, state, errMsg); errDB != nil {
+		logger.WithError(errDB).Error("failed to record verification state")
+	}
+	if state == verificationPending {
+		return
+	}
+	s.metrics.ObserveVerification(ts.Alias, state)
+	if state == verificationVerified {
+		run, err := s.db.GetSnapshotRunByID(ts.SnapshotRunID)
+		if err != nil || run == nil {
+			logger.WithError(err).Error("failed to get run of verified snapshot")
+		} else {
+			s.publishTargetLatest(ctx, ts.ID, run.BlockHeight)
+		}
+	}
+	// Verified snapshots are added to the index, failed ones left out
+	s.publishIndex()
+}
+
+// restoreAndBoot downloads and extracts the snapshot on the verifier host, starts the client against it
+// and waits until the client serves the block the snapshot was taken at
+func (s *SnapShotter) restoreAndBoot(ctx context.Context, vc *config.VerifyConfig, ts db.TargetSnapshot) error {
+	bucket := s.s3Client.GetBucketName()
+	body, err := s.s3Client.GetObject(ctx, bucket, ts.UploadPrefix+"/_snapshot_eth_getBlockByNumber.json")
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot block: %w", err)
+	}
+	expected, err := parseBlockResponse(body)
+	if err != nil {
+		return err
+	}
+	if expected == nil {
+		return fmt.Errorf("snapshot block file has no block")
+	}
+
+	url, err := s.s3Client.PresignGetObject(ctx, bucket, ts.UploadPrefix+"/snapshot.tar.zst", s.cfg.Global.Snapshots.Verification.Timeout())
+	if err != nil {
+		return err
+	}
+
+	workDir := s.cfg.Global.Snapshots.Verification.WorkDir
+	if workDir == "" {
+		workDir = defaultVerifyWorkDir
+	}
+	dir := path.Join(workDir, strconv.FormatInt(ts.ID, 10))
+	container := fmt.Sprintf("snapshot-verify-%d", ts.ID)
+	dataDir := vc.DataDir
+	if dataDir == "" {
+		dataDir = defaultVerifyDataDir
+	}
+	rpcPort := vc.RPCPort
+	if rpcPort == 0 {
+		rpcPort = defaultVerifyRPCPort
+	}
+
+	// Whatever happens, don't leave the client or the extracted data behind on the verifier host
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
+		defer cancel()
+		out, err := s.verifier.RunCommandContext(cleanupCtx, fmt.Sprintf("docker rm -f %s; sudo rm -rf %s", shellQuote(container), shellQuote(dir)))
+		if err != nil {
+			log.WithError(err).WithField("output", out).Warn("failed to clean up after snapshot verification")
+		}
+	}()
+
+	out, err := s.verifier.RunCommandContext(ctx, fmt.Sprintf(
+		"sudo rm -rf %[1]s && sudo mkdir -p %[1]s && curl -fsSL %[2]s | sudo tar -I zstd -xf - -C %[1]s",
+		shellQuote(dir), shellQuote(url),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to download and extract snapshot: %w: %s", err, strings.TrimSpace(out))
+	}
+
+	args := []string{"docker", "run", "-d", "--name", container,
+		"-p", fmt.Sprintf("127.0.0.1::%d", rpcPort),
+		"-v", dir + ":" + dataDir,
+		vc.Image,
+	}
+	args = append(args, vc.Command...)
+	for i := range args {
+		args[i] = shellQuote(args[i])
+	}
+	if out, err := s.verifier.RunCommandContext(ctx, strings.Join(args, " ")); err != nil {
+		return fmt.Errorf("failed to start client: %w: %s", err, strings.TrimSpace(out))
+	}
+
+	out, err = s.verifier.RunCommandContext(ctx, fmt.Sprintf("docker port %s %d/tcp", shellQuote(container), rpcPort))
+	if err != nil {
+		return fmt.Errorf("failed to get client RPC port: %w: %s", err, strings.TrimSpace(out))
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+
+	payload := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["%s",false],"id":1}`, expected.Number)
+	query := fmt.Sprintf("curl -fsS -X POST -H 'Content-Type: application/json' --data %s http://%s", shellQuote(payload), addr)
+	for {
+		out, err := s.verifier.RunCommandContext(ctx, query)
+		if err == nil {
+			got, err := parseBlockResponse([]byte(out))
+			if err != nil {
+				return err
+			}
+			if got != nil {
+				if !strings.EqualFold(got.Hash, expected.Hash) {
+					return fmt.Errorf("client reports block %s with hash %s, snapshot was taken at %s", got.Number, got.Hash, expected.Hash)
+				}
+				return nil
+			}
+		}
+
+		// Fail early if the client exited instead of waiting for the timeout
+		running, _ := s.verifier.RunCommandContext(ctx, fmt.Sprintf("docker inspect -f '{{.State.Running}}' %s", shellQuote(container)))
+		if strings.TrimSpace(running) == "false" {
+			logs, _ := s.verifier.RunCommandContext(ctx, fmt.Sprintf("docker logs --tail 20 %s", shellQuote(container)))
+			return fmt.Errorf("client exited before serving block %s: %s", expected.Number, strings.TrimSpace(logs))
+		}
+
+		select {
+		case <-time.After(verifyPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("client did not serve block %s in time: %w", expected.Number, ctx.Err())
+		}
+	}
+}
+
+// advanceLatest points latest at the block of a run of every target once all of its snapshots are verified,
+// if latest is gated on verification. Latest only moves forward, as runs can be verified out of order.
+func (s *SnapShotter) advanceLatest(runID int64) {
+	if !s.cfg.Global.Snapshots.Verification.GateLatest {
+		return
+	}
+	run, err := s.db.GetSnapshotRunByID(runID)
+	if err != nil || run == nil {
+		log.WithError(err).WithField("run_id", runID).Error("failed to get run to update latest file")
+		return
+	}
+	if run.Status != "success" || run.DryRun || run.Deleted {
+		return
+	}
+	aliases := make([]string, 0, len(run.TargetsSnapshot))
+	for _, ts := range run.TargetsSnapshot {
+		if ts.Verification != "" && ts.Verification != verificationVerified {
+			return
+		}
+		aliases = append(aliases, ts.Alias)
+	}
+	if !s.coversAllTargets(aliases) {
+		return
+	}
+
+	if err := s.updateLatestFile(run.BlockHeight, false); err != nil {
+		log.WithError(err).Error("failed to update latest file in S3")
+	}
+}
+
+// parseVerificationCron parses the verification schedule, returning nil if verification runs after every run
+func parseVerificationCron(spec string) (cron.Schedule, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	c, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification cron %q: %w", spec, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,136 @@
+package snapshotter
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+)
+
+// fakeVerifierHost answers the commands of a verification with a client serving the given block response
+type fakeVerifierHost struct {
+	mu        sync.Mutex
+	commands  []string
+	blockResp string
+}
+
+func (f *fakeVerifierHost) RunCommandContext(ctx context.Context, cmd string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, cmd)
+	switch {
+	case strings.HasPrefix(cmd, "docker port"):
+		return "127.0.0.1:32768\n", nil
+	case strings.HasPrefix(cmd, "curl -fsS"):
+		return f.blockResp, nil
+	}
+	return "", nil
+}
+
+func TestParseBlockResponse(t *testing.T) {
+	block, err := parseBlockResponse([]byte(`{"jsonrpc":"2.0","id":1,"result":{"number":"0x64","hash":"0xabc","transactions":[]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block == nil || block.Number != "0x64" || block.Hash != "0xabc" {
+		t.Errorf("unexpected block %+v", block)
+	}
+
+	block, err = parseBlockResponse([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	if err != nil || block != nil {
+		t.Errorf("expected no block and no error for an unknown block, got %+v, %v", block, err)
+	}
+
+	if _, err := parseBlockResponse([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"boom"}}`)); err == nil {
+		t.Error("expected an error for an RPC error response")
+	}
+	if _, err := parseBlockResponse([]byte(`not json`)); err == nil {
+		t.Error("expected an error for an invalid response")
+	}
+}
+
+func TestVerifyTargetSnapshot(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	const snapshotBlock = `{"jsonrpc":"2.0","id":1,"result":{"number":"0x64","hash":"0xabc"}}`
+
+	tests := []struct {
+		name          string
+		blockResp     string
+		expectedState string
+		expectLatest  bool
+	}{
+		{"client serves the snapshot block", snapshotBlock, verificationVerified, true},
+		{"client serves a different block", `{"jsonrpc":"2.0","id":1,"result":{"number":"0x64","hash":"0xdef"}}`, verificationFailed, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			run, err := database.CreateSnapshotRun("geth", 100, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts, err := database.CreateTargetSnapshot(run.ID, "geth", "geth/100", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := database.UpdateTargetSnapshotStatus(ts.ID, "success", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.UpdateSnapshotRunStatus(run.ID, "success", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.SetTargetSnapshotVerification(ts.ID, verificationPending, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &config.Config{}
+			cfg.Global.Snapshots.Verification.GateLatest = true
+			targetCfg := &config.TargetConfig{Alias: "geth", Verify: config.VerifyConfig{Image: "ethereum/client-go"}}
+			host := &fakeVerifierHost{blockResp: tc.blockResp}
+			mockS3 := &MockS3Client{
+				bucketName:    "test-bucket",
+				uploadedFiles: map[string]string{"geth/100/_snapshot_eth_getBlockByNumber.json": snapshotBlock},
+			}
+			ss := &SnapShotter{
+				cfg:      cfg,
+				db:       database,
+				targets:  []*target{{cfg: targetCfg}},
+				s3Client: mockS3,
+				verifier: host,
+			}
+
+			ts, err = database.GetTargetSnapshotByID(ts.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ss.verifyTargetSnapshot(context.Background(), *ts)
+			ss.advanceLatest(run.ID)
+
+			ts, err = database.GetTargetSnapshotByID(ts.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ts.Verification != tc.expectedState {
+				t.Errorf("expected verification %q, got %q (%s)", tc.expectedState, ts.Verification, ts.VerificationError)
+			}
+			if ts.VerificationTime == nil {
+				t.Error("expected the verification time to be recorded")
+			}
+			if _, ok := mockS3.uploadedFiles["latest"]; ok != tc.expectLatest {
+				t.Errorf("expected latest file to be written: %v, got %v", tc.expectLatest, ok)
+			}
+			if last := host.commands[len(host.commands)-1]; !strings.HasPrefix(last, "docker rm -f 'snapshot-verify-") {
+				t.Errorf("expected the verification to be cleaned up, last command was %q", last)
+			}
+		})
+	}
+}
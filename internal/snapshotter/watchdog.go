@@ -0,0 +1,290 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/events"
+	"github.com/ethpandaops/eth-snapshotter/internal/notify"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Triggers of a container check
+const (
+	triggerRun      = "run"
+	triggerPeriodic = "periodic"
+)
+
+const (
+	// watchdogBackoff is the wait before the second attempt to bring up a container, it doubles with every attempt
+	watchdogBackoff = time.Minute
+	// watchdogTimeout bounds checking and remediating the containers of a single target
+	watchdogTimeout = 5 * time.Minute
+)
+
+// StartWatchdogRoutine periodically checks that the containers of all targets are running until the context is cancelled
+func (s *SnapShotter) StartWatchdogRoutine(ctx context.Context) {
+	if !s.cfg.Global.Watchdog.Enabled {
+		log.Info("Container watchdog is disabled")
+		return
+	}
+
+	interval := s.cfg.Global.Watchdog.Interval()
+	log.WithFields(log.Fields{
+		"interval":          interval,
+		"restart_unhealthy": s.cfg.Global.Watchdog.RestartUnhealthy,
+	}).Info("starting container watchdog")
+
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				log.Info("stopping container watchdog")
+				return
+			}
+			for _, g := range s.groups {
+				s.checkContainers(ctx, g, triggerPeriodic, 0)
+			}
+		}
+	}()
+}
+
+// checkContainers brings up the stopped, and if configured unhealthy, containers of the targets of a group.
+// The group is held meanwhile so no run starts, groups that are busy are skipped. runID is the run the check
+// follows, 0 for periodic checks.
+func (s *SnapShotter) checkContainers(ctx context.Context, g *snapshotGroup, trigger string, runID int64) {
+	if !s.cfg.Global.Watchdog.Enabled || ctx.Err() != nil {
+		return
+	}
+
+	g.status.Lock()
+	if err := g.busy(); err != nil {
+		g.status.Unlock()
+		log.WithError(err).WithField("group", g.name).Debug("skipping container check")
+		return
+	}
+	g.status.HealthCheckInProgress = true
+	g.status.Unlock()
+	defer func() {
+		g.status.Lock()
+		g.status.HealthCheckInProgress = false
+		g.status.Unlock()
+	}()
+
+	for _, t := range g.targets {
+		ctl, ok := t.driver.(containerController)
+		if !ok {
+			continue
+		}
+		if s.isHeldTarget(t) {
+			log.WithField("alias", t.cfg.Alias).Debug("skipping container check of target held after failed upload")
+			continue
+		}
+		s.checkTargetContainers(ctx, t, ctl, trigger, runID)
+	}
+}
+
+// isHeldTarget reports whether the target was intentionally kept stopped after a failed upload,
+// so the upload can be retried or the target restored through the API
+func (s *SnapShotter) isHeldTarget(t *target) bool {
+	_, held := s.heldTargetSnapshot(t.cfg.Alias)
+	return held
+}
+
+// heldTargetSnapshot returns the latest target snapshot of a target and whether the target is held after its
+// failed upload. If the latest target snapshot can't be read, the target is reported as held without it.
+func (s *SnapShotter) heldTargetSnapshot(alias string) (*db.TargetSnapshot, bool) {
+	if !s.cfg.Global.Snapshots.HoldFailedUploads {
+		return nil, false
+	}
+	latest, err := s.db.GetTargetSnapshotsByAlias(alias, 1, 0, true, false)
+	if err != nil {
+		log.WithError(err).WithField("alias", alias).Error("failed to get latest target snapshot")
+		// Rather leave a target alone than start it in the middle of an upload retry
+		return nil, true
+	}
+	if len(latest) == 0 {
+		return nil, false
+	}
+	ts := &latest[0]
+	return ts, resumable(ts) == nil && phaseDone(ts.Phase, phaseELStopped) && !phaseDone(ts.Phase, phaseUploaded)
+}
+
+func (s *SnapShotter) checkTargetContainers(ctx context.Context, t *target, ctl containerController, trigger string, runID int64) {
+	ctx, cancel := context.WithTimeout(ctx, watchdogTimeout)
+	defer cancel()
+
+	states, err := ctl.InspectContainers(ctx)
+	if err != nil {
+		log.WithError(err).WithField("alias", t.cfg.Alias).Warn("failed to inspect containers")
+		return
+	}
+	for _, cs := range states {
+		s.checkContainer(ctx, t, ctl, cs, trigger, runID)
+	}
+}
+
+// checkContainer records the state of a container and brings it back up if it isn't healthy and the
+// backoff since the last attempt has passed
+func (s *SnapShotter) checkContainer(ctx context.Context, t *target, ctl containerController, cs types.ContainerState, trigger string, runID int64) {
+	now := time.Now()
+	s.containersMu.Lock()
+	if s.containers == nil {
+		s.containers = make(map[string]*types.ContainerHealth)
+	}
+	key := t.cfg.Alias + "/" + cs.Name
+	h, ok := s.containers[key]
+	if !ok {
+		h = &types.ContainerHealth{Alias: t.cfg.Alias}
+		s.containers[key] = h
+	}
+	h.ContainerState = cs
+	h.CheckedAt = now
+
+	action := "start"
+	switch {
+	case cs.Healthy():
+		h.Attempts, h.NextAttempt = 0, nil
+		s.containersMu.Unlock()
+		return
+	case cs.Running && !s.cfg.Global.Watchdog.RestartUnhealthy:
+		s.containersMu.Unlock()
+		log.WithFields(log.Fields{
+			"alias":     t.cfg.Alias,
+			"container": cs.Name,
+		}).Warn("container is unhealthy")
+		return
+	case cs.Running:
+		action = "restart"
+	}
+	if h.NextAttempt != nil && now.Before(*h.NextAttempt) {
+		s.containersMu.Unlock()
+		return
+	}
+	h.Attempts++
+	attempt := h.Attempts
+	next := now.Add(watchdogBackoffFor(attempt, s.cfg.Global.Watchdog.MaxBackoff()))
+	h.NextAttempt = &next
+	s.containersMu.Unlock()
+
+	state := containerStateDescription(cs)
+	log.WithFields(log.Fields{
+		"alias":     t.cfg.Alias,
+		"container": cs.Name,
+		"state":     state,
+		"action":    action,
+		"attempt":   attempt,
+	}).Warn("container is down, bringing it back up")
+
+	var err error
+	if action == "restart" {
+		err = ctl.RestartContainer(ctx, cs.Name)
+	} else {
+		err = ctl.StartContainer(ctx, cs.Name)
+	}
+	s.recordRemediation(t, cs, trigger, runID, state, action, attempt, err)
+}
+
+// watchdogBackoffFor returns the wait after the given attempt to bring up a container before the next one
+func watchdogBackoffFor(attempt int, limit time.Duration) time.Duration {
+	backoff := watchdogBackoff
+	for i := 1; i < attempt && backoff < limit; i++ {
+		backoff *= 2
+	}
+	if backoff > limit {
+		return limit
+	}
+	return backoff
+}
+
+// containerStateDescription describes why a container needs to be brought back up
+func containerStateDescription(cs types.ContainerState) string {
+	if cs.Running {
+		return cs.Health
+	}
+	return fmt.Sprintf("%s (exit code %d)", cs.Status, cs.ExitCode)
+}
+
+// recordRemediation stores, publishes and notifies the outcome of an attempt to bring up a container
+func (s *SnapShotter) recordRemediation(t *target, cs types.ContainerState, trigger string, runID int64, state, action string, attempt int, err error) {
+	r := &db.ContainerRemediation{
+		Alias:         t.cfg.Alias,
+		Container:     cs.Name,
+		Role:          cs.Role,
+		Trigger:       trigger,
+		SnapshotRunID: runID,
+		State:         state,
+		Action:        action,
+		Attempt:       attempt,
+		Success:       err == nil,
+	}
+	fields := log.Fields{
+		"alias":     t.cfg.Alias,
+		"container": cs.Name,
+		"action":    action,
+		"attempt":   attempt,
+	}
+	if err != nil {
+		r.ErrorMessage = err.Error()
+		log.WithError(err).WithFields(fields).Error("failed to bring container back up")
+	} else {
+		log.WithFields(fields).Info("brought container back up")
+	}
+	if errDB := s.db.RecordContainerRemediation(r); errDB != nil {
+		log.WithError(errDB).Error("failed to record container remediation")
+	}
+	s.metrics.ObserveContainerRemediation(t.cfg.Alias, cs.Name, err == nil)
+	s.events.Publish(events.TypeContainerRemediated, events.ContainerRemediated{
+		Alias:     t.cfg.Alias,
+		Container: cs.Name,
+		Role:      cs.Role,
+		State:     state,
+		Action:    action,
+		Attempt:   attempt,
+		Success:   err == nil,
+		Error:     r.ErrorMessage,
+	})
+
+	n := notify.Notification{
+		Alias:     t.cfg.Alias,
+		Container: cs.Name,
+		RunID:     runID,
+		Message:   fmt.Sprintf("The %s container %s was %s, attempt %d to %s it", cs.Role, cs.Name, state, attempt, action),
+	}
+	if err != nil {
+		n.Event = notify.ContainerRemediationFailed
+		n.Title = fmt.Sprintf("Failed to %s container %s of %s", action, cs.Name, t.cfg.Alias)
+		n.Error = err.Error()
+	} else {
+		n.Event = notify.ContainerRemediated
+		n.Title = fmt.Sprintf("Brought container %s of %s back up", cs.Name, t.cfg.Alias)
+	}
+	s.notifications.Notify(n)
+}
+
+// containerHealth returns the state of the containers found by the watchdog
+func (s *SnapShotter) containerHealth() []types.ContainerHealth {
+	s.containersMu.Lock()
+	defer s.containersMu.Unlock()
+	containers := make([]types.ContainerHealth, 0, len(s.containers))
+	for _, h := range s.containers {
+		c := *h
+		if h.NextAttempt != nil {
+			next := *h.NextAttempt
+			c.NextAttempt = &next
+		}
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Alias != containers[j].Alias {
+			return containers[i].Alias < containers[j].Alias
+		}
+		return containers[i].Name < containers[j].Name
+	})
+	return containers
+}
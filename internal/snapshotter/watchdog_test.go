@@ -0,0 +1,150 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	"github.com/ethpandaops/eth-snapshotter/internal/db"
+	"github.com/ethpandaops/eth-snapshotter/internal/types"
+)
+
+// fakeContainers is a target driver whose containers are kept in memory
+type fakeContainers struct {
+	TargetDriver
+	states    []types.ContainerState
+	startErr  error
+	inspected int
+	started   []string
+	restarted []string
+}
+
+func (f *fakeContainers) InspectContainers(ctx context.Context) ([]types.ContainerState, error) {
+	f.inspected++
+	return f.states, nil
+}
+
+func (f *fakeContainers) StartContainer(ctx context.Context, name string) error {
+	f.started = append(f.started, name)
+	return f.startErr
+}
+
+func (f *fakeContainers) RestartContainer(ctx context.Context, name string) error {
+	f.restarted = append(f.restarted, name)
+	return nil
+}
+
+func TestCheckContainers(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	cfg := &config.Config{}
+	cfg.Global.Watchdog.Enabled = true
+	cfg.Global.Snapshots.HoldFailedUploads = true
+	ss := &SnapShotter{cfg: cfg, db: database}
+
+	driver := &fakeContainers{
+		states: []types.ContainerState{
+			{Role: config.RoleExecution, Name: "execution", Status: "exited", ExitCode: 137},
+			{Role: config.RoleBeacon, Name: "beacon", Status: "running", Running: true, Health: "healthy"},
+			{Role: config.RoleEngineSnooper, Name: "snooper", Status: "running", Running: true, Health: "unhealthy"},
+		},
+		startErr: errors.New("no such image"),
+	}
+	g := &snapshotGroup{
+		name:    "geth",
+		targets: []*target{{driver: driver, cfg: &config.TargetConfig{Alias: "geth"}}},
+		status:  &types.GroupStatus{Name: "geth"},
+	}
+	ctx := context.Background()
+
+	// The stopped container is started, the unhealthy one left alone without restart_unhealthy
+	ss.checkContainers(ctx, g, triggerPeriodic, 0)
+	if len(driver.started) != 1 || driver.started[0] != "execution" || len(driver.restarted) != 0 {
+		t.Fatalf("unexpected remediations: started %v, restarted %v", driver.started, driver.restarted)
+	}
+	if g.status.HealthCheckInProgress {
+		t.Error("expected group to be released after the check")
+	}
+
+	// The failed start is retried only after the backoff
+	ss.checkContainers(ctx, g, triggerPeriodic, 0)
+	if len(driver.started) != 1 {
+		t.Fatalf("expected no attempt during the backoff, got %v", driver.started)
+	}
+	ss.containersMu.Lock()
+	past := time.Now().Add(-time.Second)
+	ss.containers["geth/execution"].NextAttempt = &past
+	ss.containersMu.Unlock()
+	driver.startErr = nil
+	ss.checkContainers(ctx, g, triggerRun, 7)
+
+	remediations, err := database.GetContainerRemediations("geth", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remediations) != 2 {
+		t.Fatalf("expected 2 remediations, got %+v", remediations)
+	}
+	if r := remediations[1]; r.Success || r.Attempt != 1 || r.State != "exited (exit code 137)" || r.ErrorMessage != "no such image" || r.Trigger != triggerPeriodic {
+		t.Errorf("unexpected first remediation %+v", r)
+	}
+	if r := remediations[0]; !r.Success || r.Attempt != 2 || r.Action != "start" || r.SnapshotRunID != 7 {
+		t.Errorf("unexpected second remediation %+v", r)
+	}
+
+	containers := ss.containerHealth()
+	if len(containers) != 3 || containers[0].Name != "beacon" || containers[1].Attempts != 2 || containers[1].NextAttempt == nil {
+		t.Errorf("unexpected container health %+v", containers)
+	}
+
+	// Busy groups are skipped
+	inspected := driver.inspected
+	g.status.SnapshotInProgress = true
+	ss.checkContainers(ctx, g, triggerPeriodic, 0)
+	g.status.SnapshotInProgress = false
+	if driver.inspected != inspected {
+		t.Error("expected busy group not to be checked")
+	}
+
+	// Targets held after a failed upload are skipped
+	run, err := database.CreateSnapshotRun("geth", 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := database.CreateTargetSnapshot(run.ID, "geth", "geth/100", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range []string{phaseSnooperStopped, phaseMetadataDumped, phaseELStopped, phaseUploading} {
+		if err := database.RecordTargetSnapshotPhase(ts.ID, phase); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.UpdateTargetSnapshotStatus(ts.ID, "failed", "upload failed"); err != nil {
+		t.Fatal(err)
+	}
+	ss.checkContainers(ctx, g, triggerPeriodic, 0)
+	if driver.inspected != inspected {
+		t.Error("expected held target not to be checked")
+	}
+}
+
+func TestWatchdogBackoffFor(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		4:  8 * time.Minute,
+		10: time.Hour,
+	} {
+		if got := watchdogBackoffFor(attempt, time.Hour); got != want {
+			t.Errorf("attempt %d: expected backoff %s, got %s", attempt, want, got)
+		}
+	}
+}
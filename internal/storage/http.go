@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+// HTTP stores objects with PUT requests to <url>/<key> and deletes them with DELETE requests, as supported
+// by e.g. WebDAV servers and nginx with the dav module
+type HTTP struct {
+	name    string
+	baseURL string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTP(cfg config.DestinationConfig) *HTTP {
+	return &HTTP{
+		name:    cfg.Name,
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		headers: cfg.Headers,
+		client:  &http.Client{},
+	}
+}
+
+func (h *HTTP) Name() string {
+	return h.name
+}
+
+func (h *HTTP) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+"/"+strings.Join(segments, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	return h.client.Do(req)
+}
+
+func (h *HTTP) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	// The request body is closed by the client, which r must not be
+	resp, err := h.do(ctx, http.MethodPut, key, io.NopCloser(r), size)
+	if err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to put %s: %s", key, responseError(resp))
+	}
+	return nil
+}
+
+func (h *HTTP) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		resp, err := h.do(ctx, http.MethodDelete, key, nil, 0)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			return fmt.Errorf("failed to delete %s: %s", key, resp.Status)
+		}
+	}
+	return nil
+}
+
+// responseError returns the status of a failed response with the start of its body
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return resp.Status + ": " + msg
+	}
+	return resp.Status
+}
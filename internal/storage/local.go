@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files below a directory, e.g. an NFS mount
+type Local struct {
+	name string
+	root string
+}
+
+func (l *Local) Name() string {
+	return l.name
+}
+
+// path returns the file of a key, refusing keys that would escape the root
+func (l *Local) path(key string) (string, error) {
+	p := filepath.Join(l.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(l.root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return p, nil
+}
+
+// Put writes the object to a temporary file first, so readers never see a partial object
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	n, err := io.Copy(f, contextReader{ctx: ctx, r: r})
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if n != size {
+		return fmt.Errorf("failed to write %s: got %d of %d bytes", key, n, size)
+	}
+	// Temporary files are only readable by their owner, the objects are served by others, e.g. a web server
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Delete removes the files of the keys and the directories left empty
+func (l *Local) Delete(ctx context.Context, keys []string) error {
+	dirs := make(map[string]bool)
+	for _, key := range keys {
+		p, err := l.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		dirs[filepath.Dir(p)] = true
+	}
+	root := filepath.Clean(l.root)
+	for dir := range dirs {
+		// Removing fails once a directory isn't empty
+		for ; dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// contextReader stops reading once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// gcsEndpoint is the S3 compatible endpoint of Google Cloud Storage
+	gcsEndpoint = "https://storage.googleapis.com"
+	// partSize is the size of the parts of multipart uploads, objects smaller than it are put at once
+	partSize = 64 << 20
+	// maxParts is the maximum number of parts of a multipart upload
+	maxParts = 10000
+)
+
+// objectAPI is the part of the S3 API the S3 destination uses
+type objectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// S3 stores objects in an S3 compatible bucket, including Google Cloud Storage with HMAC keys
+type S3 struct {
+	name   string
+	bucket string
+	client objectAPI
+}
+
+func newS3(cfg config.DestinationConfig) *S3 {
+	endpoint, region := cfg.Endpoint, cfg.Region
+	if cfg.Type == config.StorageGCS {
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+		if region == "" {
+			region = "auto"
+		}
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		name:   cfg.Name,
+		bucket: cfg.BucketName,
+		client: s3.New(s3.Options{
+			Region:       region,
+			Credentials:  credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+			BaseEndpoint: aws.String(endpoint),
+			UsePathStyle: true,
+		}),
+	}
+}
+
+func (d *S3) Name() string {
+	return d.name
+}
+
+func (d *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	var contentType *string
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		contentType = aws.String(t)
+	}
+	if size < partSize {
+		_, err := d.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(d.bucket),
+			Key:           aws.String(key),
+			Body:          r,
+			ContentLength: aws.Int64(size),
+			ContentType:   contentType,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put %s: %w", key, err)
+		}
+		return nil
+	}
+	return d.putMultipart(ctx, key, r, size, contentType)
+}
+
+// putMultipart uploads a large object part by part. On failure the upload is aborted, so no parts are left behind.
+func (d *S3) putMultipart(ctx context.Context, key string, r io.Reader, size int64, contentType *string) error {
+	created, err := d.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(d.bucket),
+		Key:         aws.String(key),
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload of %s: %w", key, err)
+	}
+
+	part := int64(partSize)
+	if minPart := size/(maxParts-1) + 1; part < minPart {
+		part = minPart
+	}
+	buf := make([]byte, part)
+	var parts []s3types.CompletedPart
+	for number, remaining := int32(1), size; remaining > 0; number++ {
+		n := min(part, remaining)
+		if _, err = io.ReadFull(r, buf[:n]); err != nil {
+			err = fmt.Errorf("failed to read part %d of %s: %w", number, key, err)
+			break
+		}
+		var out *s3.UploadPartOutput
+		out, err = d.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(d.bucket),
+			Key:           aws.String(key),
+			UploadId:      created.UploadId,
+			PartNumber:    aws.Int32(number),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(n),
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to upload part %d of %s: %w", number, key, err)
+			break
+		}
+		parts = append(parts, s3types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+		remaining -= n
+	}
+	if err == nil {
+		_, err = d.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(d.bucket),
+			Key:             aws.String(key),
+			UploadId:        created.UploadId,
+			MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+		}
+	}
+	if err != nil {
+		// Aborted independently of ctx, which may be cancelled
+		_, errAbort := d.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(d.bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		if errAbort != nil {
+			log.WithError(errAbort).WithFields(log.Fields{
+				"destination": d.name,
+				"key":         key,
+			}).Error("failed to abort multipart upload")
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the keys in batches of 1000, the limit of the S3 API
+func (d *S3) Delete(ctx context.Context, keys []string) error {
+	for i := 0; i < len(keys); i += 1000 {
+		batch := keys[i:min(i+1000, len(keys))]
+		objects := make([]s3types.ObjectIdentifier, 0, len(batch))
+		for _, key := range batch {
+			objects = append(objects, s3types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := d.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(d.bucket),
+			Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("failed to delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+	return nil
+}
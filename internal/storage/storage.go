@@ -0,0 +1,33 @@
+// Package storage implements the destinations snapshots are replicated to
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+// Destination stores copies of snapshot objects under the same keys as the bucket the targets upload to
+type Destination interface {
+	// Name returns the configured name of the destination
+	Name() string
+	// Put stores size bytes read from r as key, replacing the object if it exists
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Delete removes the given keys, keys that don't exist are ignored
+	Delete(ctx context.Context, keys []string) error
+}
+
+// New creates the destination configured by cfg
+func New(cfg config.DestinationConfig) (Destination, error) {
+	switch cfg.Type {
+	case config.StorageS3, config.StorageGCS:
+		return newS3(cfg), nil
+	case config.StorageLocal:
+		return &Local{name: cfg.Name, root: cfg.Path}, nil
+	case config.StorageHTTP:
+		return newHTTP(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ethpandaops/eth-snapshotter/internal/config"
+)
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	dest, err := New(config.DestinationConfig{Name: "nfs", Type: config.StorageLocal, Path: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"hoodi/geth/100/snapshot.tar.zst", "hoodi/geth/100/_snapshot_manifest.json", "hoodi/geth/200/snapshot.tar.zst"} {
+		if err := dest.Put(ctx, key, strings.NewReader(key), int64(len(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(root, "hoodi/geth/100/snapshot.tar.zst"))
+	if err != nil || string(content) != "hoodi/geth/100/snapshot.tar.zst" {
+		t.Fatalf("unexpected content %q (%v)", content, err)
+	}
+	info, err := os.Stat(filepath.Join(root, "hoodi/geth/100/snapshot.tar.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected the object to be readable by everyone, got %v", info.Mode())
+	}
+
+	// A short read is not stored
+	if err := dest.Put(ctx, "hoodi/geth/300/snapshot.tar.zst", strings.NewReader("short"), 100); err == nil {
+		t.Error("expected short read to fail")
+	}
+	if _, err := os.Stat(filepath.Join(root, "hoodi/geth/300/snapshot.tar.zst")); err == nil {
+		t.Error("expected partial object not to be stored")
+	}
+	if err := dest.Put(ctx, "../escape", strings.NewReader("x"), 1); err == nil {
+		t.Error("expected key outside of the root to fail")
+	}
+
+	// Deleting removes the directories left empty, and ignores missing keys
+	if err := dest.Delete(ctx, []string{"hoodi/geth/100/snapshot.tar.zst", "hoodi/geth/100/_snapshot_manifest.json", "hoodi/geth/100/missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "hoodi/geth/100")); !os.IsNotExist(err) {
+		t.Errorf("expected snapshot directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "hoodi/geth/200/snapshot.tar.zst")); err != nil {
+		t.Errorf("expected other snapshot to be kept, got %v", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(body)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if _, ok := objects[r.URL.Path]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	dest, err := New(config.DestinationConfig{
+		Name:    "mirror",
+		Type:    config.StorageHTTP,
+		URL:     srv.URL + "/snapshots/",
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := dest.Put(ctx, "hoodi/geth/100/snapshot.tar.zst", strings.NewReader("archive"), 7); err != nil {
+		t.Fatal(err)
+	}
+	if objects["/snapshots/hoodi/geth/100/snapshot.tar.zst"] != "archive" {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+	if err := dest.Delete(ctx, []string{"hoodi/geth/100/snapshot.tar.zst", "hoodi/geth/100/missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("expected objects to be deleted, got %v", objects)
+	}
+
+	unauthorized := newHTTP(config.DestinationConfig{Name: "mirror", URL: srv.URL})
+	err = unauthorized.Put(ctx, "latest", strings.NewReader("1"), 1)
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden: forbidden") {
+		t.Errorf("expected forbidden error, got %v", err)
+	}
+}
+
+// fakeBucket records the multipart uploads made to it
+type fakeBucket struct {
+	objectAPI
+	parts     []int64
+	completed bool
+	aborted   bool
+	failPart  int32
+}
+
+func (f *fakeBucket) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+}
+
+func (f *fakeBucket) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if *params.PartNumber == f.failPart {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n, _ := io.Copy(io.Discard, params.Body)
+	f.parts = append(f.parts, n)
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (f *fakeBucket) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.completed = len(params.MultipartUpload.Parts) == len(f.parts)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeBucket) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestS3Multipart(t *testing.T) {
+	size := int64(partSize*2 + 10)
+	bucket := &fakeBucket{}
+	dest := &S3{name: "backup", bucket: "snapshots", client: bucket}
+	if err := dest.Put(context.Background(), "hoodi/geth/100/snapshot.tar.zst", io.LimitReader(zeros{}, size), size); err != nil {
+		t.Fatal(err)
+	}
+	if len(bucket.parts) != 3 || bucket.parts[2] != 10 || !bucket.completed || bucket.aborted {
+		t.Errorf("unexpected upload: parts %v, completed %t, aborted %t", bucket.parts, bucket.completed, bucket.aborted)
+	}
+
+	// A failing part aborts the upload
+	bucket = &fakeBucket{failPart: 2}
+	dest.client = bucket
+	if err := dest.Put(context.Background(), "hoodi/geth/100/snapshot.tar.zst", io.LimitReader(zeros{}, size), size); err == nil {
+		t.Fatal("expected failing part to fail the upload")
+	}
+	if !bucket.aborted || bucket.completed {
+		t.Errorf("expected upload to be aborted, completed %t", bucket.completed)
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
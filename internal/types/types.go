@@ -1,11 +1,336 @@
 package types
 
-import "sync"
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors returned when triggering, cancelling, resuming, verifying or replicating snapshot runs on demand
+var (
+	ErrSnapshotterNotRunning = errors.New("snapshotter is not running")
+	ErrUnknownAlias          = errors.New("unknown target alias")
+	ErrSnapshotInProgress    = errors.New("snapshot already in progress")
+	ErrTargetsNotSynced      = errors.New("targets are not synced")
+	ErrRunNotActive          = errors.New("snapshot run is not in progress")
+	ErrRunCancelled          = errors.New("snapshot run cancelled")
+	ErrTargetNotResumable    = errors.New("target snapshot can't be resumed")
+	ErrTargetNotVerifiable   = errors.New("target snapshot can't be verified")
+	ErrVerificationDisabled  = errors.New("snapshot verification is disabled")
+	ErrTargetNotReplicable   = errors.New("target snapshot can't be replicated")
+	ErrReplicationDisabled   = errors.New("no replication destinations are configured")
+	ErrReconcileInProgress   = errors.New("reconciliation already in progress")
+)
 
 type SnapshotterStatus struct {
 	BlockInterval           uint64 `json:"blockInterval"`
 	ProcessedBlockHeight    uint64 `json:"processedBlockHeight"`
 	NextSnapshotBlockHeight uint64 `json:"nextPeriodSnapshotBlockHeight"`
 	SnapshotInProgress      bool   `json:"snapshotInProgress"`
+	// Groups holds the schedule state of every group of targets
+	Groups []*GroupStatus `json:"groups"`
+	// Connections holds the health of the persistent connections to targets that keep one open
+	Connections []ConnectionHealth `json:"connections,omitempty"`
+	// Uploads holds the progress of the uploads that are running
+	Uploads []TargetUploadProgress `json:"uploads,omitempty"`
+	// Containers holds the state of the containers of every target found by the last watchdog check
+	Containers []ContainerHealth `json:"containers,omitempty"`
+	sync.Mutex
+}
+
+// GroupStatus is the schedule state of a group of targets that are snapshotted together.
+// Independent targets are a group of their own, named after their alias.
+type GroupStatus struct {
+	Name                    string   `json:"name"`
+	Lockstep                bool     `json:"lockstep"`
+	Targets                 []string `json:"targets"`
+	BlockInterval           uint64   `json:"blockInterval"`
+	ProcessedBlockHeight    uint64   `json:"processedBlockHeight"`
+	NextSnapshotBlockHeight uint64   `json:"nextPeriodSnapshotBlockHeight"`
+	SnapshotInProgress      bool     `json:"snapshotInProgress"`
+	// HealthCheckInProgress is set while the watchdog checks the containers of the group, no run starts meanwhile
+	HealthCheckInProgress bool `json:"healthCheckInProgress,omitempty"`
+	// NextScheduledSnapshot is the next cron trigger, if a cron schedule is configured
+	NextScheduledSnapshot *time.Time `json:"nextScheduledSnapshot,omitempty"`
+	// MaintenanceUntil is set while a maintenance window is active
+	MaintenanceUntil *time.Time `json:"maintenanceUntil,omitempty"`
 	sync.Mutex
 }
+
+// SnapshotMetadata represents metadata about a snapshot
+type SnapshotMetadata struct {
+	DockerImage string            `json:"docker_image,omitempty"`
+	Static      map[string]string `json:"static,omitempty"`
+}
+
+// SnapshotManifest describes the archive of a target snapshot. It is uploaded as _snapshot_manifest.json
+// next to the archive so downloads can be verified.
+type SnapshotManifest struct {
+	BlockNumber uint64 `json:"block_number"`
+	File        string `json:"file"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+}
+
+// UploadProgress reports how far the upload of a target snapshot got
+type UploadProgress struct {
+	// BytesRead is the number of bytes of the data dir archived so far, out of BytesTotal
+	BytesRead  int64 `json:"bytesRead"`
+	BytesTotal int64 `json:"bytesTotal"`
+	// BytesUploaded is the number of compressed bytes uploaded so far
+	BytesUploaded int64 `json:"bytesUploaded"`
+}
+
+// TargetUploadProgress is the progress of the running upload of a target snapshot. UpdatedAt is the time of
+// the last report of the upload process, so an upload that is hung can be told from a slow one.
+type TargetUploadProgress struct {
+	Alias            string `json:"alias"`
+	TargetSnapshotID int64  `json:"targetSnapshotId"`
+	UploadProgress
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// BytesPerSecond is the recent upload throughput of compressed bytes
+	BytesPerSecond int64 `json:"bytesPerSecond"`
+	// ETASeconds is estimated from the recent read rate, it is only set if the size of the data dir is known
+	ETASeconds *int64 `json:"etaSeconds,omitempty"`
+}
+
+// LatestSnapshot describes the most recent usable snapshot of a target. It is uploaded as latest.json
+// next to the plain latest file, under the upload prefix of the target.
+type LatestSnapshot struct {
+	BlockNumber   uint64    `json:"block_number"`
+	BlockHash     string    `json:"block_hash,omitempty"`
+	File          string    `json:"file"`
+	SHA256        string    `json:"sha256,omitempty"`
+	Size          int64     `json:"size,omitempty"`
+	ClientVersion string    `json:"client_version,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// SnapshotIndex lists every available snapshot by network and client. It is uploaded as index.json
+// at the root prefix of the bucket.
+type SnapshotIndex struct {
+	GeneratedAt time.Time                                  `json:"generated_at"`
+	Networks    map[string]map[string]*SnapshotIndexClient `json:"networks"`
+}
+
+// SnapshotIndexClient holds the available snapshots of a client on a network, most recent first
+type SnapshotIndexClient struct {
+	Latest    uint64               `json:"latest"`
+	Snapshots []SnapshotIndexEntry `json:"snapshots"`
+}
+
+// SnapshotIndexEntry describes a single available snapshot
+type SnapshotIndexEntry struct {
+	BlockNumber   uint64    `json:"block_number"`
+	BlockHash     string    `json:"block_hash,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	File          string    `json:"file"`
+	SHA256        string    `json:"sha256,omitempty"`
+	Size          int64     `json:"size,omitempty"`
+	ClientVersion string    `json:"client_version,omitempty"`
+	Verified      bool      `json:"verified,omitempty"`
+}
+
+// ConnectionHealth describes the state of the persistent connection to a target
+type ConnectionHealth struct {
+	Alias               string     `json:"alias"`
+	Connected           bool       `json:"connected"`
+	ConnectedSince      *time.Time `json:"connectedSince,omitempty"`
+	LastKeepalive       *time.Time `json:"lastKeepalive,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	Reconnects          uint64     `json:"reconnects"`
+	NextReconnect       *time.Time `json:"nextReconnect,omitempty"`
+}
+
+// ContainerState is the state of a container of a target as reported by docker inspect
+type ContainerState struct {
+	// Role is the part of the node the container runs: engine_snooper, execution or beacon
+	Role    string `json:"role"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Running bool   `json:"running"`
+	// Health is the status of the container's health check, empty if it has none
+	Health   string `json:"health,omitempty"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// Healthy reports whether the container is running and not reported unhealthy by its health check
+func (c ContainerState) Healthy() bool {
+	return c.Running && c.Health != "unhealthy"
+}
+
+// ContainerHealth is the state of a container found by the last watchdog check, with the remediation state
+type ContainerHealth struct {
+	Alias string `json:"alias"`
+	ContainerState
+	CheckedAt time.Time `json:"checkedAt"`
+	// Attempts is the number of remediations since the container was last found healthy
+	Attempts int `json:"attempts"`
+	// NextAttempt is the earliest time the next remediation is attempted, while the container doesn't recover
+	NextAttempt *time.Time `json:"nextAttempt,omitempty"`
+}
+
+// TriggerRunRequest asks for a snapshot to be taken now
+type TriggerRunRequest struct {
+	// Aliases limits the snapshot to these targets. All targets are snapshotted if empty.
+	Aliases []string `json:"aliases,omitempty"`
+	// DryRun overrides global.snapshots.dry_run for the triggered runs
+	DryRun *bool `json:"dryRun,omitempty"`
+}
+
+// Cleanup policies
+const (
+	// CleanupKeepCount keeps the most recent keep_count runs of every group
+	CleanupKeepCount = "keep_count"
+	// CleanupRetention applies the retention rules to the snapshots of every target
+	CleanupRetention = "retention"
+)
+
+// CleanupPlan lists the snapshots the cleanup would keep and delete if it ran now
+type CleanupPlan struct {
+	Enabled bool      `json:"enabled"`
+	Policy  string    `json:"policy"`
+	Time    time.Time `json:"time"`
+	Keep    int       `json:"keep"`
+	Delete  int       `json:"delete"`
+	// Snapshots are the successful target snapshots that weren't deleted, by alias and most recent first
+	Snapshots []CleanupDecision `json:"snapshots"`
+}
+
+// CleanupDecision is whether a target snapshot is kept or deleted, and why
+type CleanupDecision struct {
+	TargetSnapshotID int64     `json:"targetSnapshotId"`
+	RunID            int64     `json:"runId"`
+	Alias            string    `json:"alias"`
+	Group            string    `json:"group"`
+	BlockNumber      uint64    `json:"blockNumber"`
+	Time             time.Time `json:"time"`
+	UploadPrefix     string    `json:"uploadPrefix"`
+	Delete           bool      `json:"delete"`
+	// Pinned is set if the snapshot is only kept because it is persisted or the last one before a fork block,
+	// so it is kept whatever the number of snapshots the rules keep
+	Pinned  bool     `json:"pinned"`
+	Reasons []string `json:"reasons"`
+}
+
+// ReconcileRequest asks for the bucket to be reconciled now
+type ReconcileRequest struct {
+	// DeleteOrphans and MarkMissing override global.snapshots.reconcile for this reconciliation
+	DeleteOrphans *bool `json:"deleteOrphans,omitempty"`
+	MarkMissing   *bool `json:"markMissing,omitempty"`
+}
+
+// ReconcileReport is the outcome of comparing the snapshots in the bucket with the target snapshots in the database
+type ReconcileReport struct {
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// Prefixes, Objects and Bytes count the snapshot prefixes found in the bucket and their objects
+	Prefixes int               `json:"prefixes"`
+	Objects  int               `json:"objects"`
+	Bytes    int64             `json:"bytes"`
+	Orphans  []OrphanPrefix    `json:"orphans"`
+	Missing  []MissingSnapshot `json:"missing"`
+	// Measured counts the target snapshots whose storage usage was recorded or corrected
+	Measured int    `json:"measured"`
+	Error    string `json:"error,omitempty"`
+}
+
+// OrphanPrefix is a snapshot prefix in the bucket that no successful target snapshot points at
+type OrphanPrefix struct {
+	Prefix       string    `json:"prefix"`
+	Objects      int       `json:"objects"`
+	Bytes        int64     `json:"bytes"`
+	LastModified time.Time `json:"lastModified"`
+	// TargetSnapshotID is the most recent target snapshot with the prefix, if any
+	TargetSnapshotID int64  `json:"targetSnapshotId,omitempty"`
+	Reason           string `json:"reason"`
+	// Recent is set if objects were modified within the grace period, the prefix isn't deleted then
+	Recent  bool `json:"recent,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// MissingSnapshot is a successful target snapshot whose objects aren't in the bucket
+type MissingSnapshot struct {
+	TargetSnapshotID int64    `json:"targetSnapshotId"`
+	Alias            string   `json:"alias"`
+	UploadPrefix     string   `json:"uploadPrefix"`
+	MissingKeys      []string `json:"missingKeys"`
+	// Marked is set if the target snapshot was marked as missing
+	Marked bool `json:"marked,omitempty"`
+}
+
+// StorageUsage is the space the snapshots take in the bucket, counted from the objects listed after each upload
+type StorageUsage struct {
+	Time   time.Time     `json:"time"`
+	Totals StorageTotals `json:"totals"`
+	// Aliases and Runs break down the snapshots that weren't deleted
+	Aliases []AliasStorage `json:"aliases"`
+	Runs    []RunStorage   `json:"runs"`
+	// Growth is what was uploaded per day, including snapshots deleted since, oldest first
+	Growth     []StorageGrowth   `json:"growth"`
+	Projection StorageProjection `json:"projection"`
+}
+
+// StorageTotals sums the snapshots that weren't deleted
+type StorageTotals struct {
+	TargetSnapshots int   `json:"targetSnapshots"`
+	Objects         int   `json:"objects"`
+	Bytes           int64 `json:"bytes"`
+	// Unmeasured counts the target snapshots whose objects weren't listed yet, they aren't in Objects and Bytes
+	Unmeasured int `json:"unmeasured"`
+	// DailyUploadedBytes is the average uploaded per day over the growth period
+	DailyUploadedBytes int64 `json:"dailyUploadedBytes"`
+}
+
+// AliasStorage is the space the snapshots of a target take
+type AliasStorage struct {
+	Alias           string `json:"alias"`
+	TargetSnapshots int    `json:"targetSnapshots"`
+	Objects         int    `json:"objects"`
+	Bytes           int64  `json:"bytes"`
+	Unmeasured      int    `json:"unmeasured"`
+	// AverageBytes is the average size of the most recent measured snapshots, including deleted ones
+	AverageBytes int64 `json:"averageBytes"`
+}
+
+// RunStorage is the space the target snapshots of a run take
+type RunStorage struct {
+	RunID           int64     `json:"runId"`
+	Group           string    `json:"group"`
+	BlockNumber     uint64    `json:"blockNumber"`
+	Time            time.Time `json:"time"`
+	TargetSnapshots int       `json:"targetSnapshots"`
+	Objects         int       `json:"objects"`
+	Bytes           int64     `json:"bytes"`
+}
+
+// StorageGrowth is what was uploaded on a day (UTC)
+type StorageGrowth struct {
+	Date      string `json:"date"`
+	Snapshots int    `json:"snapshots"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// StorageProjection estimates the space the snapshots take under the current cleanup policy
+type StorageProjection struct {
+	CleanupEnabled bool   `json:"cleanupEnabled"`
+	Policy         string `json:"policy"`
+	// AfterCleanupBytes is what is left once the cleanup ran, see GET /api/v1/cleanup/plan
+	AfterCleanupBytes int64 `json:"afterCleanupBytes"`
+	// SteadyStateBytes is what the snapshots take once the policy keeps as many as it can, if snapshots keep
+	// being taken at the current pace and size. It is zero if the cleanup is disabled.
+	SteadyStateBytes int64             `json:"steadyStateBytes"`
+	Aliases          []AliasProjection `json:"aliases"`
+}
+
+// AliasProjection estimates the space the snapshots of a target take under the current cleanup policy
+type AliasProjection struct {
+	Alias             string `json:"alias"`
+	Keep              int    `json:"keep"`
+	AfterCleanupBytes int64  `json:"afterCleanupBytes"`
+	// SteadyStateKeep is the number of snapshots the policy keeps at the current pace
+	SteadyStateKeep  int   `json:"steadyStateKeep"`
+	SteadyStateBytes int64 `json:"steadyStateBytes"`
+}